@@ -0,0 +1,176 @@
+package main
+
+import (
+	"common/config"
+	"common/lifecycle"
+	"common/logging"
+	"common/tracing"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	history "location_history/service"
+	"log"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	users "users/service"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// SERVICE_NAME identifies the process in traces, the spans of the routes keep the name of their service
+const SERVICE_NAME = "combined"
+
+// LINK_BUFFER_SIZE is the size in bytes of the in-process buffer of the gRPC link between the services
+const LINK_BUFFER_SIZE = 1 << 20
+
+// USAGE describes the single binary
+const USAGE = `Usage: combined [-print-config]
+
+Runs the users and location history services in one process, the users service reaching the gRPC server of the
+location history service through an in-process connection. The gRPC server keeps listening on its port for the
+other clients, and the link is encrypted like on the network when TLS is enabled.
+
+Each service reads its configuration like when it runs alone: from its defaults, the file of USERS_CONFIG or
+LOCATION_HISTORY_CONFIG, then the USERS_* or LOCATION_HISTORY_* environment variables. The settings of the process,
+log_url, logging, tracing, geodesic and shutdown_timeout, are those of the location history service, and the
+gRPC host and port of the users service are unused.
+
+Flags:
+`
+
+// main function runs both services and exits with their status, non-zero if they failed to start or a component failed
+func main() {
+	os.Exit(execute())
+}
+
+// execute loads the configurations of both services, initializes logging and tracing, opens the services,
+// runs their servers, and drains them on a termination signal
+// It returns the exit status of the process, once the deferred cleanups are done
+func execute() int {
+	fs := flag.NewFlagSet("combined", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), USAGE)
+		fs.PrintDefaults()
+	}
+	printConfig := fs.Bool("print-config", false, "Print the resolved configurations and exit")
+	if err := fs.Parse(os.Args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "Unexpected argument %q, the migrate command is run by each service\n", fs.Arg(0))
+		return 2
+	}
+
+	// Load the configurations, reporting every invalid value of both services at once
+	usersCfg, historyCfg, err := loadConfigs()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid configuration:")
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	// Print the resolved configurations instead of starting the services
+	if *printConfig {
+		if err := printConfigs(os.Stdout, usersCfg, historyCfg); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return 0
+	}
+
+	// Initialize logging to the log file or a standard stream
+	logFile, err := logging.Setup(historyCfg.LogURL, historyCfg.Logging)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to set up logging:", err)
+		return 1
+	}
+	defer logFile.Close()
+
+	// Redirect Gin's default writer and error writer to the logger
+	gin.DefaultWriter = log.Writer()
+	gin.DefaultErrorWriter = log.Writer()
+
+	// Install the tracer provider, flushing the pending spans on exit
+	shutdownTracing, err := tracing.Setup(context.Background(), SERVICE_NAME, historyCfg.Tracing)
+	if err != nil {
+		slog.Error("Failed to set up tracing", "error", err)
+		return 1
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), historyCfg.ShutdownTimeout)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			slog.Error("Failed to flush spans", "error", err)
+		}
+	}()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := run(ctx, usersCfg, historyCfg); err != nil {
+		slog.Error("Stopped with errors", "error", err)
+		return 1
+	}
+	slog.Info("All services down")
+	return 0
+}
+
+// loadConfigs resolves the configurations of the users and location history services, see USAGE
+func loadConfigs() (config.Config, config.Config, error) {
+	usersCfg, _, usersErr := users.LoadConfig(nil)
+	historyCfg, _, historyErr := history.LoadConfig(nil)
+
+	var errs []error
+	if usersErr != nil {
+		errs = append(errs, fmt.Errorf("users service:\n%w", usersErr))
+	}
+	if historyErr != nil {
+		errs = append(errs, fmt.Errorf("location history service:\n%w", historyErr))
+	}
+	return usersCfg, historyCfg, errors.Join(errs...)
+}
+
+// printConfigs writes the configurations of both services as two YAML documents
+func printConfigs(w io.Writer, usersCfg config.Config, historyCfg config.Config) error {
+	fmt.Fprintln(w, "# users")
+	if err := config.Print(w, usersCfg); err != nil {
+		return err
+	}
+	fmt.Fprintln(w, "---\n# location_history")
+	return config.Print(w, historyCfg)
+}
+
+// run opens both services, links them in process and runs their servers until ctx is done or a server fails
+// The users service is stopped first, so that its pending updates still reach the location history service
+func run(ctx context.Context, usersCfg config.Config, historyCfg config.Config) error {
+	lis := bufconn.Listen(LINK_BUFFER_SIZE)
+	dialer := grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+		return lis.DialContext(ctx)
+	})
+
+	historySvc, err := history.Open(historyCfg)
+	if err != nil {
+		return fmt.Errorf("location history service: %w", err)
+	}
+	defer historySvc.Close()
+	usersSvc, err := users.Open(usersCfg, dialer)
+	if err != nil {
+		return fmt.Errorf("users service: %w", err)
+	}
+	defer usersSvc.Close()
+
+	manager := lifecycle.New(historyCfg.ShutdownTimeout)
+	for _, component := range append(usersSvc.Components(), historySvc.Components(lis)...) {
+		manager.Add(component)
+	}
+	return manager.Run(ctx)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// freePort returns a local port that is currently free
+func freePort(t *testing.T) string {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	return strings.Split(lis.Addr().String(), ":")[1]
+}
+
+// setTestEnv points both services at free ports and at databases in a temporary directory
+func setTestEnv(t *testing.T) (string, string) {
+	dir := t.TempDir()
+	usersPort, historyPort := freePort(t), freePort(t)
+	t.Setenv("USERS_REST_HOST", "localhost")
+	t.Setenv("USERS_REST_PORT", usersPort)
+	t.Setenv("USERS_DATABASE_URL", filepath.Join(dir, "users.db"))
+	t.Setenv("USERS_TLS_ENABLED", "false")
+	t.Setenv("LOCATION_HISTORY_REST_HOST", "localhost")
+	t.Setenv("LOCATION_HISTORY_REST_PORT", historyPort)
+	t.Setenv("LOCATION_HISTORY_GRPC_PORT", freePort(t))
+	t.Setenv("LOCATION_HISTORY_DATABASE_URL", filepath.Join(dir, "location_history.db"))
+	t.Setenv("LOCATION_HISTORY_TLS_ENABLED", "false")
+
+	// Nothing listens on the gRPC address of the users service, only the in-process link reaches the location history service
+	t.Setenv("USERS_GRPC_PORT", freePort(t))
+	return "http://localhost:" + usersPort, "http://localhost:" + historyPort
+}
+
+// TestRun tests that the locations posted to the users service are recorded by the location history service
+// running in the same process
+func TestRun(t *testing.T) {
+	usersURL, historyURL := setTestEnv(t)
+	usersCfg, historyCfg, err := loadConfigs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	historyCfg.ShutdownTimeout = 5 * time.Second
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	go func() { result <- run(ctx, usersCfg, historyCfg) }()
+
+	// The users service is ready once the location history service answers its health checks over the link
+	ready := false
+	for deadline := time.Now().Add(5 * time.Second); !ready && time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		if resp, err := http.Get(usersURL + "/readyz"); err == nil {
+			ready = resp.StatusCode == http.StatusOK
+			resp.Body.Close()
+		}
+	}
+	if !ready {
+		cancel()
+		t.Fatal("the users service did not become ready: ", <-result)
+	}
+
+	resp, err := http.Post(usersURL+"/v1/update/alice", "application/json", strings.NewReader(`{"longitude": 2.3522, "latitude": 48.8566}`))
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	resp, err = http.Get(historyURL + "/v1/history/alice")
+	if assert.NoError(t, err) {
+		var reply struct {
+			History []struct{ Longitude, Latitude float64 }
+		}
+		assert.NoError(t, json.NewDecoder(resp.Body).Decode(&reply))
+		resp.Body.Close()
+		if assert.Len(t, reply.History, 1) {
+			assert.Equal(t, 2.3522, reply.History[0].Longitude)
+			assert.Equal(t, 48.8566, reply.History[0].Latitude)
+		}
+	}
+
+	cancel()
+	assert.NoError(t, <-result)
+}
+
+// TestPrintConfigs tests that the configurations of both services are printed as two YAML documents
+func TestPrintConfigs(t *testing.T) {
+	setTestEnv(t)
+	t.Setenv("USERS_REST_PORT", "18001")
+	t.Setenv("LOCATION_HISTORY_REST_PORT", "18000")
+	usersCfg, historyCfg, err := loadConfigs()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	assert.NoError(t, printConfigs(&out, usersCfg, historyCfg))
+	documents := strings.Split(out.String(), "---\n")
+	if assert.Len(t, documents, 2) {
+		assert.True(t, strings.HasPrefix(documents[0], "# users\n"))
+		assert.Contains(t, documents[0], `rest_port: "18001"`)
+		assert.True(t, strings.HasPrefix(documents[1], "# location_history\n"))
+		assert.Contains(t, documents[1], `rest_port: "18000"`)
+	}
+
+	// The errors of both services are reported together
+	t.Setenv("USERS_REST_PORT", "0")
+	t.Setenv("LOCATION_HISTORY_GEODESIC", "flat")
+	_, _, err = loadConfigs()
+	assert.ErrorContains(t, err, "users service:\nrest_port")
+	assert.ErrorContains(t, err, "location history service:\ngeodesic")
+}
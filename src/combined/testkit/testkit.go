@@ -0,0 +1,200 @@
+package testkit
+
+import (
+	"common/api"
+	"common/client"
+	"common/config"
+	"common/database"
+	"common/lifecycle"
+	pb "common/protobuff"
+	"context"
+	history "location_history/service"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+	users "users/service"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const (
+	LINK_BUFFER_SIZE = 1 << 20         // Size in bytes of the in-process buffer of the gRPC link to the location history service
+	START_TIMEOUT    = 5 * time.Second // Time given to a service to answer its liveness probe
+	STOP_TIMEOUT     = 5 * time.Second // Time given to the servers of a service to drain when the test ends
+)
+
+// Dialer connects the users service to the location history service in place of the network
+type Dialer func(ctx context.Context, address string) (net.Conn, error)
+
+// Options changes the configuration of the services started by the testkit
+type Options struct {
+	AdminToken string // Bearer token of the administration routes, which are disabled if empty
+}
+
+// Users is a users service running in the test process on an in-memory database
+type Users struct {
+	URL string // Base URL of the REST API, e.g. http://127.0.0.1:41234
+
+	client *client.Client
+}
+
+// History is a location history service running in the test process on an in-memory database
+type History struct {
+	URL    string                          // Base URL of the REST API
+	Client pb.LocationHistoryServiceClient // Client of the gRPC server, connected through the in-process link
+
+	lis    *bufconn.Listener
+	client *client.Client
+}
+
+// New starts a location history service and a users service notifying it over the in-process link
+// Both services are stopped when the test ends, and every call starts services of their own, so that tests may run
+// in parallel
+func New(t testing.TB, opts ...Options) (*Users, *History) {
+	h := NewHistory(t, opts...)
+	return NewUsers(t, h.Dial, opts...), h
+}
+
+// NewHistory starts a location history service, stopped when the test ends
+func NewHistory(t testing.TB, opts ...Options) *History {
+	t.Helper()
+	cfg := testConfig(t, history.DefaultConfig(), opts)
+	svc, err := history.Open(cfg)
+	if err != nil {
+		t.Fatal("testkit: location history service: ", err)
+	}
+	t.Cleanup(svc.Close)
+
+	h := &History{URL: "http://" + cfg.RestHost + ":" + cfg.RestPort, lis: bufconn.Listen(LINK_BUFFER_SIZE)}
+	start(t, h.URL, svc.Components(h.lis))
+	h.client = newClient(t, client.Config{HistoryURL: h.URL})
+
+	conn, err := grpc.Dial("bufconn", grpc.WithContextDialer(h.Dial), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal("testkit: ", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	h.Client = pb.NewLocationHistoryServiceClient(conn)
+	return h
+}
+
+// NewUsers starts a users service reaching the location history service through dial, stopped when the test ends
+// dial is usually the Dial method of a History, or of a fake gRPC server of the test
+func NewUsers(t testing.TB, dial Dialer, opts ...Options) *Users {
+	t.Helper()
+	cfg := testConfig(t, users.DefaultConfig(), opts)
+	svc, err := users.Open(cfg, grpc.WithContextDialer(dial))
+	if err != nil {
+		t.Fatal("testkit: users service: ", err)
+	}
+	t.Cleanup(svc.Close)
+
+	u := &Users{URL: "http://" + cfg.RestHost + ":" + cfg.RestPort}
+	start(t, u.URL, svc.Components())
+	u.client = newClient(t, client.Config{UsersURL: u.URL})
+	return u
+}
+
+// Dial connects to the gRPC server of the location history service through the in-process link
+func (h *History) Dial(ctx context.Context, _ string) (net.Conn, error) {
+	return h.lis.DialContext(ctx)
+}
+
+// UpdateLocation sets the current location of a user, creating the user if needed
+func (u *Users) UpdateLocation(ctx context.Context, username string, longitude float64, latitude float64) error {
+	return u.client.UpdateLocation(ctx, username, longitude, latitude)
+}
+
+// Nearby lists a page of the users within radius kilometers of a location, pages start at 1
+func (u *Users) Nearby(ctx context.Context, longitude float64, latitude float64, radius float64, page int) ([]api.User, error) {
+	return u.client.Nearby(ctx, longitude, latitude, radius, page)
+}
+
+// Distance returns the distance in kilometers traveled by a user between start and end
+// Zero times select the last 24 hours, like the REST API without time bounds
+func (h *History) Distance(ctx context.Context, username string, start time.Time, end time.Time) (float64, error) {
+	return h.client.Distance(ctx, username, start, end)
+}
+
+// History lists the locations recorded for a user between start and end in chronological order
+// Zero times select the last 24 hours, like the REST API without time bounds
+func (h *History) History(ctx context.Context, username string, start time.Time, end time.Time) ([]api.Location, error) {
+	return h.client.History(ctx, username, start, end)
+}
+
+// testConfig returns the configuration of a service on an in-memory database, free local ports and without TLS
+func testConfig(t testing.TB, cfg config.Config, opts []Options) config.Config {
+	cfg.RestHost = "127.0.0.1"
+	cfg.RestPort = freePort(t)
+	cfg.GrpcHost = "127.0.0.1"
+	cfg.GrpcPort = freePort(t)
+	cfg.DatabaseURL = database.MEMORY_URL
+	cfg.TLS = config.TLSConfig{ReloadInterval: cfg.TLS.ReloadInterval}
+	cfg.ShutdownTimeout = STOP_TIMEOUT
+	for _, opt := range opts {
+		cfg.AdminToken = opt.AdminToken
+	}
+	return cfg
+}
+
+// newClient returns a client of a started service, which does not retry so that the failures reach the test
+// Its connections are closed before the service is stopped, since the server waits for those that never carried
+// a request, which the transport may open while another connection is released
+func newClient(t testing.TB, cfg client.Config) *client.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	t.Cleanup(transport.CloseIdleConnections)
+	cfg.HTTPClient = &http.Client{Transport: transport}
+	cfg.MaxAttempts = 1
+	return client.New(cfg)
+}
+
+// freePort returns a local port that is currently free
+func freePort(t testing.TB) string {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("testkit: ", err)
+	}
+	defer lis.Close()
+	return strconv.Itoa(lis.Addr().(*net.TCPAddr).Port)
+}
+
+// start runs the components of an opened service until the test ends, and waits for the REST API at baseURL
+// to answer its liveness probe
+func start(t testing.TB, baseURL string, components []lifecycle.Component) {
+	t.Helper()
+	manager := lifecycle.New(STOP_TIMEOUT)
+	for _, component := range components {
+		manager.Add(component)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	go func() { result <- manager.Run(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-result; err != nil {
+			t.Error("testkit: ", err)
+		}
+	})
+
+	// The probes do not keep their connections, which would delay the shutdown of the service
+	probe := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	for deadline := time.Now().Add(START_TIMEOUT); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		select {
+		case err := <-result:
+			t.Fatal("testkit: the service stopped while starting: ", err)
+		default:
+		}
+		if resp, err := probe.Get(baseURL + "/healthz"); err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return
+			}
+		}
+	}
+	t.Fatal("testkit: the service at ", baseURL, " did not start")
+}
@@ -0,0 +1,110 @@
+package testkit
+
+import (
+	"common/api"
+	"common/apierror"
+	"common/client"
+	pb "common/protobuff"
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestNew tests that the locations posted to the users service are recorded by the location history service
+func TestNew(t *testing.T) {
+	ctx := context.Background()
+	u, h := New(t)
+
+	assert.NoError(t, u.UpdateLocation(ctx, "alice", 2.3522, 48.8566))
+	assert.NoError(t, u.UpdateLocation(ctx, "alice", 2.3532, 48.8566))
+	assert.NoError(t, u.UpdateLocation(ctx, "bobby", 2.3530, 48.8570))
+
+	nearby, err := u.Nearby(ctx, 2.3530, 48.8566, 1, 1)
+	assert.NoError(t, err)
+	assert.Len(t, nearby, 2)
+
+	locations, err := h.History(ctx, "alice", time.Time{}, time.Time{})
+	if assert.NoError(t, err) && assert.Len(t, locations, 2) {
+		assert.Equal(t, 2.3522, locations[0].Longitude)
+		assert.Equal(t, 2.3532, locations[1].Longitude)
+	}
+	distance, err := h.Distance(ctx, "alice", time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	assert.NoError(t, err)
+	assert.InDelta(t, 0.073, distance, 0.001)
+
+	// The gRPC client reaches the same service
+	_, err = h.Client.UpdateHistory(ctx, &pb.LocationUpdateRequest{Username: "carol", Longitude: 1, Latitude: 1})
+	assert.NoError(t, err)
+	locations, err = h.History(ctx, "carol", time.Time{}, time.Time{})
+	assert.NoError(t, err)
+	assert.Len(t, locations, 1)
+
+	// Problems are returned as errors of the catalogue
+	err = u.UpdateLocation(ctx, "alice", 2.3522, 91)
+	var apiErr *apierror.Error
+	if assert.True(t, errors.As(err, &apiErr)) {
+		assert.Equal(t, apierror.INVALID_COORDINATES, apiErr.Code)
+	}
+}
+
+// TestClient tests the client package against the services, with coordinates on the equator and the prime meridian
+func TestClient(t *testing.T) {
+	ctx := context.Background()
+	u, h := New(t)
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	t.Cleanup(transport.CloseIdleConnections)
+	c := client.New(client.Config{UsersURL: u.URL, HistoryURL: h.URL, HTTPClient: &http.Client{Transport: transport}})
+
+	// The updates carry idempotency keys since they may be retried
+	assert.NoError(t, c.UpdateLocation(ctx, "nullisland", 0, 0))
+	assert.NoError(t, c.UpdateLocation(ctx, "nullisland", 0, 0.001))
+
+	closeby, err := c.Nearby(ctx, 0, 0, 1, 1)
+	if assert.NoError(t, err) && assert.Len(t, closeby, 1) {
+		assert.Equal(t, api.User{ID: 1, Name: "nullisland", Longitude: 0, Latitude: 0.001}, closeby[0])
+	}
+
+	locations, err := c.History(ctx, "nullisland", time.Time{}, time.Time{})
+	if assert.NoError(t, err) && assert.Len(t, locations, 2) {
+		assert.Equal(t, 0.0, locations[0].Latitude)
+		assert.Equal(t, 0.001, locations[1].Latitude)
+	}
+	distance, err := c.Distance(ctx, "nullisland", time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	assert.NoError(t, err)
+	assert.InDelta(t, 0.111, distance, 0.001)
+
+	_, err = c.History(ctx, "x", time.Time{}, time.Time{})
+	assert.True(t, client.IsCode(err, apierror.INVALID_USERNAME))
+}
+
+// TestRestart tests that every test starts from empty databases
+func TestRestart(t *testing.T) {
+	_, h := New(t)
+	locations, err := h.History(context.Background(), "alice", time.Time{}, time.Time{})
+	assert.NoError(t, err)
+	assert.Empty(t, locations)
+}
+
+// TestParallel tests that the services started by parallel tests keep their own state
+func TestParallel(t *testing.T) {
+	for _, username := range []string{"alice", "bobby"} {
+		t.Run(username, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+			u, h := New(t)
+			assert.NoError(t, u.UpdateLocation(ctx, username, 1.0, 2.0))
+
+			closeby, err := u.Nearby(ctx, 1.0, 2.0, 1, 1)
+			if assert.NoError(t, err) && assert.Len(t, closeby, 1) {
+				assert.Equal(t, username, closeby[0].Name)
+			}
+			locations, err := h.History(ctx, username, time.Time{}, time.Time{})
+			assert.NoError(t, err)
+			assert.Len(t, locations, 1)
+		})
+	}
+}
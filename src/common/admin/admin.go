@@ -0,0 +1,119 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"common/apierror"
+	"common/database"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// MigrationsReply is the body answered by the migration endpoints
+type MigrationsReply struct {
+	Version    int                        // Version of the latest applied migration, 0 if none
+	Changed    int                        // Number of migrations applied or reverted by the request, 0 for a status
+	Migrations []database.MigrationStatus // Every known migration with the time it was applied
+}
+
+// MigrateRequest is the body of a request applying or reverting migrations
+type MigrateRequest struct {
+	Direction string `json:"direction" binding:"required,oneof=up down"` // up applies the pending migrations, down reverts
+	Target    *int   `json:"target"`                                     // Version reverted down to, the previous version if unset
+}
+
+// RequireToken returns a middleware rejecting the requests that do not carry token as bearer token
+// The comparison runs in constant time. Every request is rejected when token is empty, so that the
+// administration endpoints are disabled unless a token is configured
+func RequireToken(token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if token == "" {
+			apierror.Abort(c, apierror.New(apierror.UNAUTHENTICATED, "administration is disabled, no admin token is configured"))
+			return
+		}
+
+		given, found := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !found || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			c.Header("WWW-Authenticate", `Bearer realm="admin"`)
+			apierror.Abort(c, apierror.New(apierror.UNAUTHENTICATED, "missing or invalid bearer token"))
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// MigrationStatus answers with the status of the migrations of db
+func MigrationStatus(c *gin.Context, db *gorm.DB, migrations []database.Migration) {
+	reply, err := migrationsReply(db, migrations, 0)
+	if err != nil {
+		apierror.Abort(c, apierror.Wrap(apierror.INTERNAL, "could not read the migration status", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, reply)
+}
+
+// Migrate applies or reverts the migrations of db as described by the body of the request,
+// then answers with their status
+func Migrate(c *gin.Context, db *gorm.DB, migrations []database.Migration) {
+	var req MigrateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Abort(c, apierror.New(apierror.INVALID_ARGUMENT, "direction must be up or down"))
+		return
+	}
+
+	var changed int
+	var err error
+	if req.Direction == "up" {
+		changed, err = database.MigrateUp(db, migrations)
+	} else {
+		// Revert the latest migration only, unless a target is given
+		var target int
+		if req.Target != nil {
+			target = *req.Target
+		} else {
+			current, err := database.CurrentVersion(db)
+			if err != nil {
+				apierror.Abort(c, apierror.Wrap(apierror.INTERNAL, "could not read the schema version", err))
+				return
+			}
+			target = max(current-1, 0)
+		}
+		if target < 0 || target > len(migrations) {
+			apierror.Abort(c, apierror.New(apierror.INVALID_ARGUMENT, "target is not a known version"))
+			return
+		}
+		changed, err = database.MigrateDown(db, migrations, target)
+	}
+	if err != nil {
+		apierror.Abort(c, apierror.Wrap(apierror.INTERNAL, "migration failed", err))
+		return
+	}
+
+	reply, err := migrationsReply(db, migrations, changed)
+	if err != nil {
+		apierror.Abort(c, apierror.Wrap(apierror.INTERNAL, "could not read the migration status", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, reply)
+}
+
+// migrationsReply describes the migrations of db after changed migrations were applied or reverted
+func migrationsReply(db *gorm.DB, migrations []database.Migration, changed int) (MigrationsReply, error) {
+	statuses, err := database.Status(db, migrations)
+	if err != nil {
+		return MigrationsReply{}, err
+	}
+
+	version, err := database.CurrentVersion(db)
+	if err != nil {
+		return MigrationsReply{}, err
+	}
+
+	return MigrationsReply{Version: version, Changed: changed, Migrations: statuses}, nil
+}
@@ -0,0 +1,135 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"common/apierror"
+	"common/database"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+// testMigrations creates a table and then adds a column to it
+var testMigrations = []database.Migration{
+	{
+		Version:     1,
+		Description: "Create notes",
+		Up: func(tx *gorm.DB) error {
+			return tx.Exec("CREATE TABLE notes (id INTEGER PRIMARY KEY, text TEXT)").Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Exec("DROP TABLE notes").Error
+		},
+	},
+	{
+		Version:     2,
+		Description: "Add author to notes",
+		Up: func(tx *gorm.DB) error {
+			return tx.Exec("ALTER TABLE notes ADD COLUMN author TEXT").Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Exec("ALTER TABLE notes DROP COLUMN author").Error
+		},
+	},
+}
+
+// TestRequireToken tests that only the requests carrying the configured bearer token are let through
+func TestRequireToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name          string
+		token         string
+		authorization string
+		expected      int
+	}{
+		{"Valid Token", "s3cr3t", "Bearer s3cr3t", http.StatusOK},
+		{"Invalid Token", "s3cr3t", "Bearer guess", http.StatusUnauthorized},
+		{"Missing Token", "s3cr3t", "", http.StatusUnauthorized},
+		{"Other Scheme", "s3cr3t", "Basic s3cr3t", http.StatusUnauthorized},
+		{"Disabled", "", "Bearer ", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := gin.New()
+			engine.GET("/admin", RequireToken(tt.token), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/admin", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			engine.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expected, w.Code)
+			if tt.expected != http.StatusOK {
+				var problem apierror.Problem
+				assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &problem))
+				assert.Equal(t, apierror.UNAUTHENTICATED, problem.Code)
+			}
+		})
+	}
+}
+
+// TestMigrations tests reading the status of the migrations and applying and reverting them
+func TestMigrations(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db, err := database.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.Close(db) })
+
+	engine := gin.New()
+	engine.GET("/migrations", func(c *gin.Context) { MigrationStatus(c, db, testMigrations) })
+	engine.POST("/migrations", func(c *gin.Context) { Migrate(c, db, testMigrations) })
+
+	// serve sends a request and decodes the reply
+	serve := func(method string, body string) (int, MigrationsReply) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, "/migrations", strings.NewReader(body))
+		engine.ServeHTTP(w, req)
+
+		var reply MigrationsReply
+		if w.Code == http.StatusOK {
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &reply))
+		}
+		return w.Code, reply
+	}
+
+	code, reply := serve("GET", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 0, reply.Version)
+	if assert.Len(t, reply.Migrations, 2) {
+		assert.Nil(t, reply.Migrations[0].AppliedAt)
+	}
+
+	code, reply = serve("POST", `{"direction": "up"}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 2, reply.Version)
+	assert.Equal(t, 2, reply.Changed)
+	assert.NotNil(t, reply.Migrations[1].AppliedAt)
+
+	// Without a target only the latest migration is reverted
+	code, reply = serve("POST", `{"direction": "down"}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 1, reply.Version)
+	assert.Equal(t, 1, reply.Changed)
+
+	code, reply = serve("POST", `{"direction": "down", "target": 0}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 0, reply.Version)
+
+	code, _ = serve("POST", `{"direction": "sideways"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	code, _ = serve("POST", `{"direction": "down", "target": 3}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+}
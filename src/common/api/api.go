@@ -0,0 +1,83 @@
+// Package api holds the types of the REST APIs of the services, shared by the services and their clients
+// Version 1 gives the radius of the nearby search and the traveled distances in kilometers and the other distances in
+// meters. Version 2 gives every distance in meters, and names the unit of the parameters and fields that changed
+package api
+
+import "time"
+
+// UpdateLocationRequest is the body of the location update route of the users service, POST /v1/update/:username
+// The coordinates are pointers so that a missing coordinate is told apart from a coordinate of 0,
+// on the equator or the prime meridian
+type UpdateLocationRequest struct {
+	Longitude *float64 `json:"longitude" binding:"required"`
+	Latitude  *float64 `json:"latitude" binding:"required"`
+}
+
+// UpdateLocationResponse is the reply of the location update route, the recorded location of the user
+type UpdateLocationResponse struct {
+	Username  string
+	Longitude float64
+	Latitude  float64
+}
+
+// NearbyQuery is the query of the nearby search of the users service, GET /v1/nearby
+// The coordinates are pointers for the same reason as in UpdateLocationRequest
+type NearbyQuery struct {
+	Longitude *float64 `form:"longitude" binding:"required"`
+	Latitude  *float64 `form:"latitude" binding:"required"`
+	Radius    float64  `form:"radius" binding:"required"` // Radius in kilometers
+	Page      int      `form:"page" binding:"required"`   // Page number, starting at 1
+}
+
+// NearbyQueryV2 is the query of the nearby search in version 2 of the API, GET /v2/nearby
+type NearbyQueryV2 struct {
+	Longitude    *float64 `form:"longitude" binding:"required"`
+	Latitude     *float64 `form:"latitude" binding:"required"`
+	RadiusMeters float64  `form:"radius_meters" binding:"required"` // Radius in meters
+	Page         int      `form:"page" binding:"required"`          // Page number, starting at 1
+}
+
+// NearbyResponse is the reply of the nearby search, a page of the users within the radius ordered by user ID
+type NearbyResponse struct {
+	Closeby []User
+}
+
+// User is a user of the users service and their current location
+type User struct {
+	ID        uint
+	Name      string
+	Longitude float64
+	Latitude  float64
+}
+
+// DistanceResponse is the reply of the distance route of the location history service, GET /v1/distance/:username
+type DistanceResponse struct {
+	Distance float64 `json:"Traveled distance"` // Distance in kilometers
+}
+
+// DistanceResponseV2 is the reply of the distance route in version 2 of the API, GET /v2/distance/:username
+type DistanceResponseV2 struct {
+	DistanceMeters float64 `json:"distance_meters"` // Distance in meters
+}
+
+// HistoryResponse is the reply of the history route of the location history service, GET /v1/history/:username
+type HistoryResponse struct {
+	History []Location // Locations in chronological order
+}
+
+// ImportLocationRequest is the body of the history import route of the location history service,
+// POST /v1/admin/history/:username. The coordinates are pointers for the same reason as in UpdateLocationRequest
+type ImportLocationRequest struct {
+	Longitude *float64  `json:"longitude" binding:"required"`
+	Latitude  *float64  `json:"latitude" binding:"required"`
+	Time      time.Time `json:"time"` // Time the location was recorded, required
+}
+
+// Location is a location of a user recorded by the location history service
+type Location struct {
+	ID        uint
+	Username  string
+	Longitude float64
+	Latitude  float64
+	Time      time.Time
+}
@@ -0,0 +1,165 @@
+package apierror
+
+import (
+	"common/utils"
+	"errors"
+	"net/http"
+	"sort"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Code identifies a kind of error in a stable, machine readable way
+// Clients branch on the code rather than on the message, which is meant for humans and may change
+type Code string
+
+const (
+	INVALID_ARGUMENT        Code = "INVALID_ARGUMENT"        // A parameter of the request is missing or invalid
+	INVALID_USERNAME        Code = "INVALID_USERNAME"        // The username is too short, too long or not alphanumeric
+	INVALID_COORDINATES     Code = "INVALID_COORDINATES"     // The longitude or the latitude is out of range
+	INVALID_IDEMPOTENCY_KEY Code = "INVALID_IDEMPOTENCY_KEY" // The idempotency key is not a UUID
+	INVALID_TIME_RANGE      Code = "INVALID_TIME_RANGE"      // A time is malformed or the time bounds are inconsistent
+	INVALID_AREA            Code = "INVALID_AREA"            // The polygon or the circle of a query is malformed
+	IDEMPOTENCY_KEY_REUSED  Code = "IDEMPOTENCY_KEY_REUSED"  // The idempotency key was already used for a different request
+	IDEMPOTENCY_KEY_IN_USE  Code = "IDEMPOTENCY_KEY_IN_USE"  // A request with the same idempotency key is still being processed
+	NOT_FOUND               Code = "NOT_FOUND"               // The requested resource does not exist
+	UNAUTHENTICATED         Code = "UNAUTHENTICATED"         // The credentials of the request are missing or invalid
+	UPSTREAM_UNAVAILABLE    Code = "UPSTREAM_UNAVAILABLE"    // A service the request depends on failed or could not be reached
+	INTERNAL                Code = "INTERNAL"                // The request could not be handled, the details are only logged
+)
+
+const DOMAIN = "capstone" // Domain of the ErrorInfo details of the gRPC errors
+
+// kind describes how the errors of a code are reported over REST and gRPC
+type kind struct {
+	title  string     // Summary of the code, the same for every occurrence
+	status int        // HTTP status of the problem
+	grpc   codes.Code // gRPC status code
+}
+
+// catalogue maps every code to its title, HTTP status and gRPC status code
+var catalogue = map[Code]kind{
+	INVALID_ARGUMENT:        {"Invalid argument", http.StatusBadRequest, codes.InvalidArgument},
+	INVALID_USERNAME:        {"Invalid username", http.StatusBadRequest, codes.InvalidArgument},
+	INVALID_COORDINATES:     {"Invalid coordinates", http.StatusBadRequest, codes.InvalidArgument},
+	INVALID_IDEMPOTENCY_KEY: {"Invalid idempotency key", http.StatusBadRequest, codes.InvalidArgument},
+	INVALID_TIME_RANGE:      {"Invalid time range", http.StatusBadRequest, codes.InvalidArgument},
+	INVALID_AREA:            {"Invalid area", http.StatusBadRequest, codes.InvalidArgument},
+	IDEMPOTENCY_KEY_REUSED:  {"Idempotency key reused", http.StatusUnprocessableEntity, codes.FailedPrecondition},
+	IDEMPOTENCY_KEY_IN_USE:  {"Idempotency key in use", http.StatusConflict, codes.Aborted},
+	NOT_FOUND:               {"Not found", http.StatusNotFound, codes.NotFound},
+	UNAUTHENTICATED:         {"Unauthenticated", http.StatusUnauthorized, codes.Unauthenticated},
+	UPSTREAM_UNAVAILABLE:    {"Upstream service unavailable", http.StatusServiceUnavailable, codes.Unavailable},
+	INTERNAL:                {"Internal error", http.StatusInternalServerError, codes.Internal},
+}
+
+// validations maps the errors of the validations of utils to their codes
+var validations = map[error]Code{
+	utils.ErrInvalidUsername:       INVALID_USERNAME,
+	utils.ErrInvalidCoordinates:    INVALID_COORDINATES,
+	utils.ErrInvalidIdempotencyKey: INVALID_IDEMPOTENCY_KEY,
+}
+
+// Codes returns the codes of the catalogue in alphabetical order
+func Codes() []Code {
+	list := make([]Code, 0, len(catalogue))
+	for code := range catalogue {
+		list = append(list, code)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i] < list[j] })
+	return list
+}
+
+// Error is an error of the catalogue
+// It is returned as is by gRPC handlers and written as a problem by REST handlers, see Abort
+type Error struct {
+	Code   Code   // Code of the catalogue
+	Detail string // Explanation of this occurrence, returned to the client
+	Err    error  // Cause of the error, logged but never returned to the client
+}
+
+// New creates an error of the catalogue
+func New(code Code, detail string) *Error {
+	return &Error{Code: code, Detail: detail}
+}
+
+// Wrap creates an error of the catalogue caused by err
+func Wrap(code Code, detail string, err error) *Error {
+	return &Error{Code: code, Detail: detail, Err: err}
+}
+
+// Error returns the detail of the error followed by its cause
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Detail + ": " + e.Err.Error()
+	}
+	return e.Detail
+}
+
+// Unwrap returns the cause of the error
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Title returns the summary of the code of the error
+func (e *Error) Title() string {
+	return catalogue[e.Code].title
+}
+
+// HTTPStatus returns the HTTP status of the code of the error
+func (e *Error) HTTPStatus() int {
+	return catalogue[e.Code].status
+}
+
+// GRPCStatus returns the gRPC status of the error, carrying the code in an ErrorInfo detail
+// grpc-go calls it for the errors returned by handlers, so that clients receive the matching status code
+func (e *Error) GRPCStatus() *status.Status {
+	st := status.New(catalogue[e.Code].grpc, e.Detail)
+	if withDetails, err := st.WithDetails(&errdetails.ErrorInfo{Reason: string(e.Code), Domain: DOMAIN}); err == nil {
+		return withDetails
+	}
+	return st
+}
+
+// From returns the error of the catalogue found in the chain of err
+// Failed validations of utils get the code of their kind, their message being the detail.
+// gRPC errors are converted from their ErrorInfo detail, or from their status code when they have none.
+// Any other error is internal, its message is kept as the cause only
+func From(err error) *Error {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+	for sentinel, code := range validations {
+		if errors.Is(err, sentinel) {
+			return New(code, err.Error())
+		}
+	}
+
+	st, ok := status.FromError(err)
+	if !ok {
+		return Wrap(INTERNAL, "internal error", err)
+	}
+	for _, detail := range st.Details() {
+		if info, isInfo := detail.(*errdetails.ErrorInfo); isInfo && info.GetDomain() == DOMAIN {
+			if _, known := catalogue[Code(info.GetReason())]; known {
+				return Wrap(Code(info.GetReason()), st.Message(), err)
+			}
+		}
+	}
+
+	switch st.Code() {
+	case codes.InvalidArgument, codes.OutOfRange:
+		return Wrap(INVALID_ARGUMENT, st.Message(), err)
+	case codes.NotFound:
+		return Wrap(NOT_FOUND, st.Message(), err)
+	case codes.Unauthenticated:
+		return Wrap(UNAUTHENTICATED, st.Message(), err)
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return Wrap(UPSTREAM_UNAVAILABLE, st.Message(), err)
+	default:
+		return Wrap(INTERNAL, "internal error", err)
+	}
+}
@@ -0,0 +1,98 @@
+package apierror
+
+import (
+	"common/utils"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// TestCatalogue tests that every code of the catalogue is described
+func TestCatalogue(t *testing.T) {
+	assert.Len(t, Codes(), 12)
+	for _, code := range Codes() {
+		err := New(code, "detail")
+		assert.NotEmpty(t, err.Title(), code)
+		assert.GreaterOrEqual(t, err.HTTPStatus(), 400, code)
+		assert.NotEqual(t, codes.OK, err.GRPCStatus().Code(), code)
+	}
+}
+
+// TestGRPC tests that the errors keep their code through a gRPC status
+func TestGRPC(t *testing.T) {
+	cause := errors.New("connection refused")
+	sent := Wrap(IDEMPOTENCY_KEY_REUSED, "idempotency key was already used for a different request", cause)
+	assert.ErrorIs(t, sent, cause)
+	assert.Equal(t, "idempotency key was already used for a different request: connection refused", sent.Error())
+
+	// The status is taken from the error by grpc-go, the cause is not sent
+	st, ok := status.FromError(sent)
+	assert.True(t, ok)
+	assert.Equal(t, codes.FailedPrecondition, st.Code())
+	assert.Equal(t, "idempotency key was already used for a different request", st.Message())
+
+	received := From(st.Err())
+	assert.Equal(t, IDEMPOTENCY_KEY_REUSED, received.Code)
+	assert.Equal(t, sent.Detail, received.Detail)
+
+	tests := []struct {
+		name     string
+		err      error
+		expected Code
+	}{
+		{"Wrapped", errors.Join(errors.New("context"), New(INVALID_AREA, "bad polygon")), INVALID_AREA},
+		{"Validation", utils.CheckCoordinates(200, 0), INVALID_COORDINATES},
+		{"Wrapped Validation", fmt.Errorf("invalid prefix: %w", utils.CheckUsername("a")), INVALID_USERNAME},
+		{"Status Without Details", status.Error(codes.InvalidArgument, "bad request"), INVALID_ARGUMENT},
+		{"Unavailable", status.Error(codes.Unavailable, "connection refused"), UPSTREAM_UNAVAILABLE},
+		{"Unauthenticated", status.Error(codes.Unauthenticated, "missing credentials"), UNAUTHENTICATED},
+		{"Unknown Status", status.Error(codes.Unknown, "database is locked"), INTERNAL},
+		{"Plain Error", errors.New("database is locked"), INTERNAL},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, From(tt.err).Code)
+		})
+	}
+}
+
+// TestAbort tests that the errors are written as RFC 7807 problems without their cause
+func TestAbort(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.GET("/users/:username", func(c *gin.Context) {
+		Abort(c, utils.CheckUsername(c.Param("username")))
+	})
+	engine.GET("/failure", func(c *gin.Context) {
+		Abort(c, errors.New("password authentication failed"))
+	})
+
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, httptest.NewRequest("GET", "/users/ab", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, CONTENT_TYPE, w.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{
+		"type": "urn:problem-type:invalid-username",
+		"title": "Invalid username",
+		"status": 400,
+		"detail": "username must be between 4 and 16 characters long",
+		"instance": "/users/ab",
+		"code": "INVALID_USERNAME"
+	}`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	engine.ServeHTTP(w, httptest.NewRequest("GET", "/failure", nil))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	var problem Problem
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &problem))
+	assert.Equal(t, INTERNAL, problem.Code)
+	assert.NotContains(t, w.Body.String(), "password")
+}
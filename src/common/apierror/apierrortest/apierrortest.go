@@ -0,0 +1,23 @@
+// Package apierrortest checks the problems answered by the REST routes in the tests of the services
+package apierrortest
+
+import (
+	"common/apierror"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// AssertProblem checks that a response is a problem with the given code and detail
+func AssertProblem(t *testing.T, w *httptest.ResponseRecorder, code apierror.Code, detail string) {
+	t.Helper()
+	var problem apierror.Problem
+	assert.Equal(t, apierror.CONTENT_TYPE, w.Header().Get("Content-Type"))
+	if assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &problem)) {
+		assert.Equal(t, code, problem.Code)
+		assert.Equal(t, detail, problem.Detail)
+		assert.Equal(t, w.Code, problem.Status)
+	}
+}
@@ -0,0 +1,51 @@
+package apierror
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	CONTENT_TYPE = "application/problem+json" // Media type of the problems, RFC 7807
+	TYPE_PREFIX  = "urn:problem-type:"        // Prefix of the type URI of the problems, followed by the code
+)
+
+// Problem is the body of the REST errors, an RFC 7807 problem with the code as extension member
+type Problem struct {
+	Type     string `json:"type"`     // URI identifying the code, e.g. urn:problem-type:invalid-username
+	Title    string `json:"title"`    // Summary of the code
+	Status   int    `json:"status"`   // HTTP status
+	Detail   string `json:"detail"`   // Explanation of this occurrence
+	Instance string `json:"instance"` // Path of the request
+	Code     Code   `json:"code"`     // Code of the catalogue
+}
+
+// NewProblem describes an error as a problem that occurred while handling the request to path
+func NewProblem(err error, path string) Problem {
+	apiErr := From(err)
+	return Problem{
+		Type:     TYPE_PREFIX + strings.ReplaceAll(strings.ToLower(string(apiErr.Code)), "_", "-"),
+		Title:    apiErr.Title(),
+		Status:   apiErr.HTTPStatus(),
+		Detail:   apiErr.Detail,
+		Instance: path,
+		Code:     apiErr.Code,
+	}
+}
+
+// Write answers a request with an error as a problem
+func Write(w http.ResponseWriter, r *http.Request, err error) {
+	problem := NewProblem(err, r.URL.Path)
+	w.Header().Set("Content-Type", CONTENT_TYPE)
+	w.WriteHeader(problem.Status)
+	json.NewEncoder(w).Encode(problem)
+}
+
+// Abort answers a request with an error as a problem and stops the chain of handlers
+func Abort(c *gin.Context, err error) {
+	c.Abort()
+	Write(c.Writer, c.Request, err)
+}
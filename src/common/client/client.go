@@ -0,0 +1,215 @@
+package client
+
+import (
+	"bytes"
+	"common/api"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DEFAULT_ATTEMPTS = 3                      // Number of attempts of a request when Config.MaxAttempts is 0
+	DEFAULT_BACKOFF  = 100 * time.Millisecond // Delay before the first retry when Config.Backoff is 0
+	MAX_BACKOFF      = 2 * time.Second        // Longest delay between two attempts, Retry-After included
+)
+
+// Config sets the services reached by a Client and how it sends its requests
+type Config struct {
+	UsersURL    string        // Base URL of the REST API of the users service, e.g. http://localhost:8001
+	HistoryURL  string        // Base URL of the REST API of the location history service, e.g. http://localhost:8000
+	Token       string        // Bearer token sent with every request, none if empty
+	HTTPClient  *http.Client  // Client sending the requests, http.DefaultClient if nil
+	MaxAttempts int           // Number of attempts of a request, DEFAULT_ATTEMPTS if 0, 1 to disable the retries
+	Backoff     time.Duration // Delay before the first retry, doubled at every retry up to MAX_BACKOFF
+}
+
+// Client calls the REST APIs of the users and location history services
+// Requests failing on the network or answered by a temporarily unavailable service are retried, see Error.Temporary.
+// A Client is safe for concurrent use
+type Client struct {
+	cfg Config
+}
+
+// New creates a client of the services, filling the unset fields of cfg with their defaults
+func New(cfg Config) *Client {
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = http.DefaultClient
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = DEFAULT_ATTEMPTS
+	}
+	if cfg.Backoff <= 0 {
+		cfg.Backoff = DEFAULT_BACKOFF
+	}
+	return &Client{cfg: cfg}
+}
+
+// UpdateLocation sets the current location of a user, creating the user if needed, and records it in their history
+// When retries are enabled every call carries its own idempotency key, so that a retried update is applied once
+func (c *Client) UpdateLocation(ctx context.Context, username string, longitude float64, latitude float64) error {
+	header := http.Header{}
+	if c.cfg.MaxAttempts > 1 {
+		key, err := newIdempotencyKey()
+		if err != nil {
+			return err
+		}
+		header.Set("Idempotency-Key", key)
+	}
+
+	body := api.UpdateLocationRequest{Longitude: &longitude, Latitude: &latitude}
+	return c.do(ctx, http.MethodPost, c.cfg.UsersURL, "/v1/update/"+url.PathEscape(username), nil, header, body, nil)
+}
+
+// Nearby lists a page of the users within radius kilometers of a location, ordered by user ID, pages start at 1
+func (c *Client) Nearby(ctx context.Context, longitude float64, latitude float64, radius float64, page int) ([]api.User, error) {
+	query := url.Values{
+		"longitude": {strconv.FormatFloat(longitude, 'f', -1, 64)},
+		"latitude":  {strconv.FormatFloat(latitude, 'f', -1, 64)},
+		"radius":    {strconv.FormatFloat(radius, 'f', -1, 64)},
+		"page":      {strconv.Itoa(page)},
+	}
+	var reply api.NearbyResponse
+	err := c.do(ctx, http.MethodGet, c.cfg.UsersURL, "/v1/nearby", query, nil, nil, &reply)
+	return reply.Closeby, err
+}
+
+// Distance returns the distance in kilometers traveled by a user between start and end
+// Zero times select the last 24 hours, like the REST API without time bounds
+func (c *Client) Distance(ctx context.Context, username string, start time.Time, end time.Time) (float64, error) {
+	var reply api.DistanceResponse
+	err := c.do(ctx, http.MethodGet, c.cfg.HistoryURL, "/v1/distance/"+url.PathEscape(username), timeBounds(start, end), nil, nil, &reply)
+	return reply.Distance, err
+}
+
+// History lists the locations recorded for a user between start and end in chronological order
+// Zero times select the last 24 hours, like the REST API without time bounds
+func (c *Client) History(ctx context.Context, username string, start time.Time, end time.Time) ([]api.Location, error) {
+	var reply api.HistoryResponse
+	err := c.do(ctx, http.MethodGet, c.cfg.HistoryURL, "/v1/history/"+url.PathEscape(username), timeBounds(start, end), nil, nil, &reply)
+	return reply.History, err
+}
+
+// do sends a request to the REST API at baseURL, retrying it while it fails temporarily, and decodes the JSON reply
+// into reply, unless it is nil
+func (c *Client) do(ctx context.Context, method string, baseURL string, path string, query url.Values, header http.Header, body any, reply any) error {
+	if baseURL == "" {
+		return fmt.Errorf("no base URL configured for %s %s", method, path)
+	}
+	target := strings.TrimSuffix(baseURL, "/") + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+
+	// The body is encoded once and sent again by every attempt
+	var encoded []byte
+	if body != nil {
+		var err error
+		if encoded, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+
+	backoff := c.cfg.Backoff
+	for attempt := 1; ; attempt++ {
+		delay, err := c.send(ctx, method, target, path, header, encoded, reply)
+		if err == nil || attempt >= c.cfg.MaxAttempts || !retryable(ctx, err) {
+			return err
+		}
+
+		// Wait for the delay asked by the service, or back off exponentially
+		if delay <= 0 {
+			delay = backoff
+			backoff = min(2*backoff, MAX_BACKOFF)
+		}
+		timer := time.NewTimer(min(delay, MAX_BACKOFF))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		}
+	}
+}
+
+// send makes one attempt of a request and returns the delay asked by the Retry-After header of a failed reply
+func (c *Client) send(ctx context.Context, method string, target string, path string, header http.Header, body []byte, reply any) (time.Duration, error) {
+	var content io.Reader
+	if body != nil {
+		content = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, target, content)
+	if err != nil {
+		return 0, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.cfg.Token)
+	}
+
+	resp, err := c.cfg.HTTPClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		delay, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+		return time.Duration(delay) * time.Second, newError(method, path, resp)
+	}
+
+	if reply != nil {
+		if err := json.NewDecoder(resp.Body).Decode(reply); err != nil {
+			return 0, err
+		}
+	}
+
+	// Drain the body so that the connection is reused
+	_, err = io.Copy(io.Discard, resp.Body)
+	return 0, err
+}
+
+// retryable tells whether a failed attempt may succeed if sent again
+// Errors answered by the services are retried if they are temporary, network errors unless ctx is done,
+// and malformed replies never
+func retryable(ctx context.Context, err error) bool {
+	var clientErr *Error
+	if errors.As(err, &clientErr) {
+		return clientErr.Temporary()
+	}
+	var urlErr *url.Error
+	return errors.As(err, &urlErr) && ctx.Err() == nil
+}
+
+// timeBounds returns the query selecting the time window of the location history routes, nil for zero times
+func timeBounds(start time.Time, end time.Time) url.Values {
+	if start.IsZero() && end.IsZero() {
+		return nil
+	}
+	return url.Values{"start": {start.Format(time.RFC3339Nano)}, "end": {end.Format(time.RFC3339Nano)}}
+}
+
+// newIdempotencyKey returns a random UUID, in the canonical form expected by the services
+func newIdempotencyKey() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40 // Version 4
+	b[8] = b[8]&0x3f | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
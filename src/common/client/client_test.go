@@ -0,0 +1,180 @@
+package client
+
+import (
+	"common/api"
+	"common/apierror"
+	"common/utils"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// recorder is a fake service answering the requests it receives with the given handlers in turn
+type recorder struct {
+	mu       sync.Mutex
+	requests []*http.Request
+	bodies   []string
+	replies  []http.HandlerFunc
+}
+
+// ServeHTTP records the request and answers it with the next reply, the last one being repeated
+func (r *recorder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	r.mu.Lock()
+	r.requests = append(r.requests, req)
+	r.bodies = append(r.bodies, string(body))
+	reply := r.replies[min(len(r.requests), len(r.replies))-1]
+	r.mu.Unlock()
+	reply(w, req)
+}
+
+// problem replies with an error of the catalogue
+func problem(code apierror.Code, detail string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		apierror.Write(w, r, apierror.New(code, detail))
+	}
+}
+
+// reply replies with a JSON body
+func reply(body any) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(body)
+	}
+}
+
+// newTestClient starts a fake service and returns a client of it, retrying without delay
+func newTestClient(t *testing.T, replies ...http.HandlerFunc) (*Client, *recorder) {
+	rec := &recorder{replies: replies}
+	server := httptest.NewServer(rec)
+	t.Cleanup(server.Close)
+	return New(Config{UsersURL: server.URL, HistoryURL: server.URL + "/", Token: "secret", Backoff: time.Millisecond}), rec
+}
+
+// TestUpdateLocation tests that the coordinates at 0 are sent and that a retried update keeps its idempotency key
+func TestUpdateLocation(t *testing.T) {
+	c, rec := newTestClient(t, problem(apierror.UPSTREAM_UNAVAILABLE, "could not update location history"), reply(api.UpdateLocationResponse{}))
+
+	assert.NoError(t, c.UpdateLocation(context.Background(), "alice", 0, 0))
+	if assert.Len(t, rec.requests, 2) {
+		for i, req := range rec.requests {
+			assert.Equal(t, http.MethodPost, req.Method)
+			assert.Equal(t, "/v1/update/alice", req.URL.Path)
+			assert.Equal(t, "Bearer secret", req.Header.Get("Authorization"))
+			assert.JSONEq(t, `{"longitude": 0, "latitude": 0}`, rec.bodies[i])
+		}
+		key := rec.requests[0].Header.Get("Idempotency-Key")
+		assert.NoError(t, utils.CheckIdempotencyKey(key))
+		assert.Equal(t, key, rec.requests[1].Header.Get("Idempotency-Key"))
+	}
+
+	// Every call has its own key, and none is sent when the update is not retried
+	assert.NoError(t, c.UpdateLocation(context.Background(), "alice", 1, 2))
+	assert.NotEqual(t, rec.requests[0].Header.Get("Idempotency-Key"), rec.requests[2].Header.Get("Idempotency-Key"))
+	c.cfg.MaxAttempts = 1
+	assert.NoError(t, c.UpdateLocation(context.Background(), "alice", 1, 2))
+	assert.Empty(t, rec.requests[3].Header.Get("Idempotency-Key"))
+}
+
+// TestQueries tests the queries of the read routes and the decoding of their replies
+func TestQueries(t *testing.T) {
+	ctx := context.Background()
+	at := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	c, rec := newTestClient(t,
+		reply(api.NearbyResponse{Closeby: []api.User{{ID: 1, Name: "alice", Longitude: 0, Latitude: 0}}}),
+		reply(api.DistanceResponse{Distance: 1.5}),
+		reply(api.HistoryResponse{History: []api.Location{{ID: 1, Username: "alice", Time: at}}}),
+	)
+
+	users, err := c.Nearby(ctx, 0, -0.5, 10, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []api.User{{ID: 1, Name: "alice"}}, users)
+	assert.Equal(t, "/v1/nearby", rec.requests[0].URL.Path)
+	assert.Equal(t, "latitude=-0.5&longitude=0&page=1&radius=10", rec.requests[0].URL.RawQuery)
+
+	distance, err := c.Distance(ctx, "alice", at, at.Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, 1.5, distance)
+	assert.Equal(t, "/v1/distance/alice", rec.requests[1].URL.Path)
+	assert.Equal(t, "2024-03-01T12:00:00Z", rec.requests[1].URL.Query().Get("start"))
+	assert.Equal(t, "2024-03-01T13:00:00Z", rec.requests[1].URL.Query().Get("end"))
+
+	locations, err := c.History(ctx, "alice", time.Time{}, time.Time{})
+	assert.NoError(t, err)
+	if assert.Len(t, locations, 1) {
+		assert.True(t, locations[0].Time.Equal(at))
+	}
+	assert.Equal(t, "/v1/history/alice", rec.requests[2].URL.Path)
+	assert.Empty(t, rec.requests[2].URL.RawQuery)
+}
+
+// TestErrors tests that the failures are returned as typed errors and that only the temporary ones are retried
+func TestErrors(t *testing.T) {
+	ctx := context.Background()
+
+	// A refused request is not retried and keeps the code of its problem
+	c, rec := newTestClient(t, problem(apierror.INVALID_COORDINATES, "longitude must be between -180 and 180"))
+	err := c.UpdateLocation(ctx, "alice", 200, 0)
+	assert.Len(t, rec.requests, 1)
+	assert.True(t, IsCode(err, apierror.INVALID_COORDINATES))
+	assert.Equal(t, apierror.INVALID_COORDINATES, apierror.From(err).Code)
+	var clientErr *Error
+	if assert.True(t, errors.As(err, &clientErr)) {
+		assert.Equal(t, http.StatusBadRequest, clientErr.StatusCode)
+		assert.False(t, clientErr.Temporary())
+		assert.Equal(t, "POST /v1/update/alice: INVALID_COORDINATES: longitude must be between -180 and 180", err.Error())
+	}
+
+	// The replies that are not problems get the code of their status, and an unavailable service is retried
+	c, rec = newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad gateway", http.StatusBadGateway)
+	})
+	_, err = c.Nearby(ctx, 0, 0, 1, 1)
+	assert.Len(t, rec.requests, DEFAULT_ATTEMPTS)
+	assert.True(t, IsCode(err, apierror.UPSTREAM_UNAVAILABLE))
+	if assert.True(t, errors.As(err, &clientErr)) {
+		assert.Equal(t, http.StatusBadGateway, clientErr.StatusCode)
+		assert.True(t, clientErr.Temporary())
+	}
+
+	// A request whose idempotency key is still in use is retried
+	c, rec = newTestClient(t, problem(apierror.IDEMPOTENCY_KEY_IN_USE, "a request with the same idempotency key is still being processed"), reply(api.UpdateLocationResponse{}))
+	assert.NoError(t, c.UpdateLocation(ctx, "alice", 0, 0))
+	assert.Len(t, rec.requests, 2)
+
+	// Malformed replies are not retried
+	c, rec = newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<html>"))
+	})
+	_, err = c.Distance(ctx, "alice", time.Time{}, time.Time{})
+	assert.Error(t, err)
+	assert.Len(t, rec.requests, 1)
+
+	// The retries stop with the context
+	c, rec = newTestClient(t, problem(apierror.UPSTREAM_UNAVAILABLE, "unavailable"))
+	c.cfg.Backoff = time.Minute
+	cancelCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	_, err = c.History(cancelCtx, "alice", time.Time{}, time.Time{})
+	assert.True(t, IsCode(err, apierror.UPSTREAM_UNAVAILABLE))
+	assert.Len(t, rec.requests, 1)
+
+	// Network errors are retried and returned as is
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+	c = New(Config{UsersURL: server.URL, Backoff: time.Millisecond})
+	err = c.UpdateLocation(ctx, "alice", 0, 0)
+	assert.Error(t, err)
+	assert.False(t, errors.As(err, &clientErr))
+
+	// A service without URL is refused before sending anything
+	assert.EqualError(t, New(Config{}).UpdateLocation(ctx, "alice", 0, 0), "no base URL configured for POST /v1/update/alice")
+}
@@ -0,0 +1,77 @@
+package client
+
+import (
+	"common/apierror"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Error is a request refused or failed by a service
+// It unwraps to the *apierror.Error of its code, so that errors.As and apierror.From find the code of the catalogue
+type Error struct {
+	Method     string        // Method of the request
+	Path       string        // Path of the request, without the base URL and the query
+	StatusCode int           // HTTP status of the reply
+	Code       apierror.Code // Code of the problem, derived from the status when the reply is not a problem
+	Detail     string        // Explanation of the problem, or the status line of the reply
+}
+
+// newError decodes the problem answered to a failed request
+func newError(method string, path string, resp *http.Response) *Error {
+	e := &Error{Method: method, Path: path, StatusCode: resp.StatusCode, Code: statusCode(resp.StatusCode), Detail: resp.Status}
+
+	var problem apierror.Problem
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), apierror.CONTENT_TYPE) && json.NewDecoder(resp.Body).Decode(&problem) == nil && problem.Code != "" {
+		e.Code = problem.Code
+		e.Detail = problem.Detail
+	}
+	return e
+}
+
+// Error returns the request followed by the code and the detail of the problem
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s %s: %s: %s", e.Method, e.Path, e.Code, e.Detail)
+}
+
+// Unwrap returns the error of the catalogue carried by the reply
+func (e *Error) Unwrap() error {
+	return apierror.New(e.Code, e.Detail)
+}
+
+// Temporary tells whether the request may succeed if sent again, when the service or one it depends on
+// is overloaded or unavailable, or when a request with the same idempotency key is still being processed
+func (e *Error) Temporary() bool {
+	switch e.StatusCode {
+	case http.StatusConflict, http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+// IsCode tells whether err was answered by a service with a problem of the given code
+func IsCode(err error, code apierror.Code) bool {
+	var apiErr *apierror.Error
+	return errors.As(err, &apiErr) && apiErr.Code == code
+}
+
+// statusCode returns the code of the catalogue matching an HTTP status, for the replies that are not problems,
+// e.g. those of a proxy in front of the services
+func statusCode(status int) apierror.Code {
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return apierror.UNAUTHENTICATED
+	case status == http.StatusNotFound:
+		return apierror.NOT_FOUND
+	case status == http.StatusTooManyRequests || status == http.StatusBadGateway ||
+		status == http.StatusServiceUnavailable || status == http.StatusGatewayTimeout:
+		return apierror.UPSTREAM_UNAVAILABLE
+	case status < http.StatusInternalServerError:
+		return apierror.INVALID_ARGUMENT
+	default:
+		return apierror.INTERNAL
+	}
+}
@@ -0,0 +1,29 @@
+package client
+
+import (
+	"common/config"
+	"common/tlsutil"
+	"net"
+
+	"google.golang.org/grpc"
+)
+
+// DialHistory connects to the gRPC server of the location history service at address, e.g. localhost:50051,
+// with TLS if it is enabled. The certificate of the server must carry tls.ServerName, or the host of address if empty.
+// The connection is used by pb.NewLocationHistoryServiceClient and closed by the caller
+func DialHistory(address string, tls config.TLSConfig, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	certificates, err := tlsutil.NewReloader(tls)
+	if err != nil {
+		return nil, err
+	}
+
+	serverName := tls.ServerName
+	if serverName == "" {
+		serverName, _, err = net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return grpc.NewClient(address, append([]grpc.DialOption{tlsutil.DialOption(certificates, serverName)}, opts...)...)
+}
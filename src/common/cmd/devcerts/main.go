@@ -0,0 +1,23 @@
+package main
+
+import (
+	"common/tlsutil"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// main generates a development CA with a server and a client certificate for the gRPC link between the services
+// Usage: go run ./cmd/devcerts -dir ../../data/certs -hosts localhost,127.0.0.1
+func main() {
+	dir := flag.String("dir", "certs", "Directory the certificates are written to")
+	hosts := flag.String("hosts", "localhost,127.0.0.1", "Comma separated names and IP addresses of the gRPC server")
+	flag.Parse()
+
+	if err := tlsutil.GenerateDevCertificates(*dir, strings.Split(*hosts, ",")...); err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to generate certificates:", err)
+		os.Exit(1)
+	}
+	fmt.Println("Certificates written to", *dir)
+}
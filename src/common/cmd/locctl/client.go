@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"common/apierror"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/tabwriter"
+)
+
+// cli runs the commands of locctl
+type cli struct {
+	opts options   // Global flags
+	in   io.Reader // Source of the imported history when no file is given
+	out  io.Writer // Destination of the output
+}
+
+// call sends a request to the REST API at baseURL with the headers and decodes the JSON reply into reply, unless it is nil
+// Problems answered by the services are returned as errors of the catalogue, prefixed by their code
+func (c *cli) call(ctx context.Context, method string, baseURL string, path string, query url.Values, header http.Header, body any, reply any) error {
+	ctx, cancel := context.WithTimeout(ctx, c.opts.timeout)
+	defer cancel()
+
+	target := strings.TrimSuffix(baseURL, "/") + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+
+	var content io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		content = bytes.NewReader(encoded)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, target, content)
+	if err != nil {
+		return err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.opts.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.opts.token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		var problem apierror.Problem
+		if resp.Header.Get("Content-Type") == apierror.CONTENT_TYPE && json.NewDecoder(resp.Body).Decode(&problem) == nil {
+			return fmt.Errorf("%s: %w", problem.Code, apierror.New(problem.Code, problem.Detail))
+		}
+		return fmt.Errorf("%s %s answered %s", method, path, resp.Status)
+	}
+
+	if reply == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(reply)
+}
+
+// print writes the reply as indented JSON, or as a table of the rows under the header
+func (c *cli) print(reply any, header []string, rows [][]string) error {
+	if c.opts.output == "json" {
+		encoder := json.NewEncoder(c.out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(reply)
+	}
+
+	w := tabwriter.NewWriter(c.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	return w.Flush()
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"common/admin"
+	"common/api"
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// user is a user of the users service with their current location
+type user struct {
+	ID        uint
+	Name      string
+	Longitude float64
+	Latitude  float64
+}
+
+// location is a location recorded by the location history service
+type location struct {
+	ID        uint
+	Username  string
+	Longitude float64
+	Latitude  float64
+	Time      time.Time
+}
+
+// parseArgs parses the flags of a command and checks that the expected positional arguments are given
+func parseArgs(fs *flag.FlagSet, args []string, names ...string) ([]string, error) {
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() != len(names) {
+		return nil, fmt.Errorf("%s expects %d argument(s): %s", fs.Name(), len(names), strings.Join(names, " "))
+	}
+	return fs.Args(), nil
+}
+
+// timeWindow adds the start and end flags of a time window to a command
+func timeWindow(fs *flag.FlagSet) func() url.Values {
+	start := fs.String("start", "", "Lower bound of the time window, RFC 3339")
+	end := fs.String("end", "", "Upper bound of the time window, RFC 3339")
+	return func() url.Values {
+		query := url.Values{}
+		if *start != "" {
+			query.Set("start", *start)
+		}
+		if *end != "" {
+			query.Set("end", *end)
+		}
+		return query
+	}
+}
+
+// formatFloat formats a coordinate or a distance without trailing zeros
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// user runs the user get, list and delete commands against the administration routes of the users service
+func (c *cli) user(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("user expects a subcommand: get, list or delete")
+	}
+
+	switch args[0] {
+	case "get":
+		fs := flag.NewFlagSet("user get", flag.ContinueOnError)
+		positional, err := parseArgs(fs, args[1:], "<username>")
+		if err != nil {
+			return err
+		}
+
+		var reply user
+		if err := c.call(ctx, http.MethodGet, c.opts.usersURL, "/v1/admin/users/"+url.PathEscape(positional[0]), nil, nil, nil, &reply); err != nil {
+			return err
+		}
+		return c.print(reply, []string{"ID", "NAME", "LONGITUDE", "LATITUDE"}, [][]string{userRow(reply)})
+	case "list":
+		fs := flag.NewFlagSet("user list", flag.ContinueOnError)
+		page := fs.Int("page", 1, "Page number, starting at 1")
+		all := fs.Bool("all", false, "List every page")
+		if _, err := parseArgs(fs, args[1:]); err != nil {
+			return err
+		}
+
+		// Read the pages until an empty one when listing every user
+		var users []user
+		for number := *page; ; number++ {
+			var reply struct{ Users []user }
+			query := url.Values{"page": {strconv.Itoa(number)}}
+			if err := c.call(ctx, http.MethodGet, c.opts.usersURL, "/v1/admin/users", query, nil, nil, &reply); err != nil {
+				return err
+			}
+			users = append(users, reply.Users...)
+			if !*all || len(reply.Users) == 0 {
+				break
+			}
+		}
+
+		rows := make([][]string, 0, len(users))
+		for _, u := range users {
+			rows = append(rows, userRow(u))
+		}
+		return c.print(map[string][]user{"Users": users}, []string{"ID", "NAME", "LONGITUDE", "LATITUDE"}, rows)
+	case "delete":
+		fs := flag.NewFlagSet("user delete", flag.ContinueOnError)
+		history := fs.Bool("history", false, "Also erase the location history of the user")
+		positional, err := parseArgs(fs, args[1:], "<username>")
+		if err != nil {
+			return err
+		}
+		username := url.PathEscape(positional[0])
+
+		if err := c.call(ctx, http.MethodDelete, c.opts.usersURL, "/v1/admin/users/"+username, nil, nil, nil, nil); err != nil {
+			return err
+		}
+		if !*history {
+			return c.print(map[string]string{"Deleted": positional[0]}, []string{"DELETED"}, [][]string{{positional[0]}})
+		}
+
+		var reply struct{ Deleted int64 }
+		if err := c.call(ctx, http.MethodDelete, c.opts.historyURL, "/v1/admin/history/"+username, nil, nil, nil, &reply); err != nil {
+			return fmt.Errorf("user deleted but not their history: %w", err)
+		}
+		return c.print(map[string]any{"Deleted": positional[0], "DeletedLocations": reply.Deleted},
+			[]string{"DELETED", "DELETED LOCATIONS"}, [][]string{{positional[0], strconv.FormatInt(reply.Deleted, 10)}})
+	default:
+		return fmt.Errorf("unknown user subcommand %q, expected get, list or delete", args[0])
+	}
+}
+
+// userRow formats a user as a table row
+func userRow(u user) []string {
+	return []string{strconv.FormatUint(uint64(u.ID), 10), u.Name, formatFloat(u.Longitude), formatFloat(u.Latitude)}
+}
+
+// history runs the history show, export and import commands against the location history service
+func (c *cli) history(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("history expects a subcommand: show, export or import")
+	}
+
+	switch args[0] {
+	case "show", "export":
+		fs := flag.NewFlagSet("history "+args[0], flag.ContinueOnError)
+		window := timeWindow(fs)
+		format, file := new(string), new(string)
+		if args[0] == "export" {
+			fs.StringVar(format, "format", "jsonl", "Format of the exported file: jsonl or csv")
+			fs.StringVar(file, "file", "", "File the history is exported to, stdout if empty")
+		}
+		positional, err := parseArgs(fs, args[1:], "<username>")
+		if err != nil {
+			return err
+		}
+
+		var reply struct{ History []location }
+		if err := c.call(ctx, http.MethodGet, c.opts.historyURL, "/v1/history/"+url.PathEscape(positional[0]), window(), nil, nil, &reply); err != nil {
+			return err
+		}
+
+		if args[0] == "show" {
+			rows := make([][]string, 0, len(reply.History))
+			for _, loc := range reply.History {
+				rows = append(rows, []string{loc.Time.Format(time.RFC3339), formatFloat(loc.Longitude), formatFloat(loc.Latitude)})
+			}
+			return c.print(reply, []string{"TIME", "LONGITUDE", "LATITUDE"}, rows)
+		}
+
+		records := make([]record, 0, len(reply.History))
+		for _, loc := range reply.History {
+			records = append(records, record{Time: loc.Time, Longitude: loc.Longitude, Latitude: loc.Latitude})
+		}
+		if *file == "" {
+			return writeRecords(c.out, *format, records)
+		}
+
+		out, err := os.Create(*file)
+		if err != nil {
+			return err
+		}
+		if err := writeRecords(out, *format, records); err != nil {
+			out.Close()
+			return err
+		}
+		if err := out.Close(); err != nil {
+			return err
+		}
+		fmt.Fprintf(c.out, "Exported %d location(s) to %s\n", len(records), *file)
+		return nil
+	case "import":
+		fs := flag.NewFlagSet("history import", flag.ContinueOnError)
+		format := fs.String("format", "jsonl", "Format of the imported file: jsonl or csv")
+		file := fs.String("file", "", "File the history is imported from, stdin if empty")
+		positional, err := parseArgs(fs, args[1:], "<username>")
+		if err != nil {
+			return err
+		}
+
+		in := c.in
+		if *file != "" {
+			f, err := os.Open(*file)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			in = f
+		}
+
+		records, err := readRecords(in, *format)
+		if err != nil {
+			return err
+		}
+
+		imported, err := c.importRecords(ctx, positional[0], records)
+		if err != nil {
+			return fmt.Errorf("imported %d of %d location(s): %w", imported, len(records), err)
+		}
+		return c.print(map[string]int{"Imported": imported}, []string{"IMPORTED"}, [][]string{{strconv.Itoa(imported)}})
+	default:
+		return fmt.Errorf("unknown history subcommand %q, expected show, export or import", args[0])
+	}
+}
+
+// importRecords records the locations of a user with their time through the admin route of the location history
+// service, in order, and returns how many were recorded
+// Every location carries an idempotency key derived from its content, so that an interrupted import can be run again
+func (c *cli) importRecords(ctx context.Context, username string, records []record) (int, error) {
+	for i, rec := range records {
+		header := http.Header{"Idempotency-Key": {importKey(username, rec)}}
+		body := api.ImportLocationRequest{Longitude: &rec.Longitude, Latitude: &rec.Latitude, Time: rec.Time}
+		if err := c.call(ctx, http.MethodPost, c.opts.historyURL, "/v1/admin/history/"+url.PathEscape(username), nil, header, body, nil); err != nil {
+			return i, fmt.Errorf("location %d: %w", i+1, err)
+		}
+	}
+	return len(records), nil
+}
+
+// distance runs the distance command against the location history service
+func (c *cli) distance(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("distance", flag.ContinueOnError)
+	window := timeWindow(fs)
+	positional, err := parseArgs(fs, args, "<username>")
+	if err != nil {
+		return err
+	}
+
+	var reply map[string]float64
+	if err := c.call(ctx, http.MethodGet, c.opts.historyURL, "/v1/distance/"+url.PathEscape(positional[0]), window(), nil, nil, &reply); err != nil {
+		return err
+	}
+	return c.print(reply, []string{"USERNAME", "DISTANCE (KM)"}, [][]string{{positional[0], formatFloat(reply["Traveled distance"])}})
+}
+
+// nearby runs the nearby command against the users service
+func (c *cli) nearby(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("nearby", flag.ContinueOnError)
+	longitude := fs.Float64("longitude", 0, "Longitude of the point")
+	latitude := fs.Float64("latitude", 0, "Latitude of the point")
+	radius := fs.Float64("radius", 0, "Radius in kilometers")
+	page := fs.Int("page", 1, "Page number, starting at 1")
+	if _, err := parseArgs(fs, args); err != nil {
+		return err
+	}
+
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	if !set["longitude"] || !set["latitude"] || !set["radius"] {
+		return fmt.Errorf("nearby expects -longitude, -latitude and -radius")
+	}
+
+	query := url.Values{
+		"longitude": {formatFloat(*longitude)},
+		"latitude":  {formatFloat(*latitude)},
+		"radius":    {formatFloat(*radius)},
+		"page":      {strconv.Itoa(*page)},
+	}
+	var reply struct{ Closeby []user }
+	if err := c.call(ctx, http.MethodGet, c.opts.usersURL, "/v1/nearby", query, nil, nil, &reply); err != nil {
+		return err
+	}
+
+	rows := make([][]string, 0, len(reply.Closeby))
+	for _, u := range reply.Closeby {
+		rows = append(rows, userRow(u))
+	}
+	return c.print(reply, []string{"ID", "NAME", "LONGITUDE", "LATITUDE"}, rows)
+}
+
+// migrate runs the migrate command against the administration routes of a service
+func (c *cli) migrate(ctx context.Context, args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("migrate expects a service and a subcommand: migrate users|history up|down [version]|status")
+	}
+
+	var baseURL string
+	switch args[0] {
+	case "users":
+		baseURL = c.opts.usersURL
+	case "history":
+		baseURL = c.opts.historyURL
+	default:
+		return fmt.Errorf("unknown service %q, expected users or history", args[0])
+	}
+
+	var reply admin.MigrationsReply
+	switch args[1] {
+	case "status":
+		if err := c.call(ctx, http.MethodGet, baseURL, "/v1/admin/migrations", nil, nil, nil, &reply); err != nil {
+			return err
+		}
+	case "up", "down":
+		req := admin.MigrateRequest{Direction: args[1]}
+		if len(args) > 2 {
+			target, err := strconv.Atoi(args[2])
+			if err != nil {
+				return fmt.Errorf("version %q is not a number", args[2])
+			}
+			req.Target = &target
+		}
+		if err := c.call(ctx, http.MethodPost, baseURL, "/v1/admin/migrations", nil, nil, req, &reply); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown migrate subcommand %q, expected up, down or status", args[1])
+	}
+
+	rows := make([][]string, 0, len(reply.Migrations))
+	for _, migration := range reply.Migrations {
+		applied := "pending"
+		if migration.AppliedAt != nil {
+			applied = migration.AppliedAt.Format(time.RFC3339)
+		}
+		rows = append(rows, []string{strconv.Itoa(migration.Version), migration.Description, applied})
+	}
+	if c.opts.output == "table" && args[1] != "status" {
+		fmt.Fprintf(c.out, "%d migration(s) changed, now at version %d\n", reply.Changed, reply.Version)
+	}
+	return c.print(reply, []string{"VERSION", "DESCRIPTION", "APPLIED"}, rows)
+}
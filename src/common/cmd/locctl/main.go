@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"time"
+)
+
+// USAGE describes the commands of locctl
+const USAGE = `Usage: locctl [flags] <command> [command flags] [arguments]
+
+Commands:
+  user get <username>                         Show a user and their current location
+  user list [-page N | -all]                  List the users
+  user delete [-history] <username>           Delete a user, and their location history with -history
+  history show [-start T -end T] <username>   Show the locations recorded for a user
+  history export [-start T -end T] [-format jsonl|csv] [-file F] <username>
+                                              Write the locations of a user to a file or stdout
+  history import [-format jsonl|csv] [-file F] <username>
+                                              Record the locations of a file or stdin with their time
+  distance [-start T -end T] <username>       Show the distance traveled by a user in kilometers
+  nearby -longitude X -latitude Y -radius R [-page N]
+                                              List the users around a point
+  migrate users|history up|down [version]|status
+                                              Apply, revert or list the schema migrations of a service
+
+Times are RFC 3339, the time windows default to the last 24 hours. The user, migrate and history
+import commands require the admin token of the services, given by -token or LOCCTL_TOKEN.
+
+Flags:
+`
+
+// options holds the global flags of locctl
+type options struct {
+	usersURL   string        // Base URL of the REST API of the users service
+	historyURL string        // Base URL of the REST API of the location history service
+	token      string        // Admin token of the services
+	output     string        // Output format, table or json
+	timeout    time.Duration // Timeout of every request
+}
+
+// main parses the global flags and runs the command given by the arguments
+func main() {
+	if err := run(os.Args[1:], os.Stdin, os.Stdout); err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+		}
+		os.Exit(1)
+	}
+}
+
+// run runs locctl with its command line arguments, reading the imported history from in and writing to out
+func run(args []string, in io.Reader, out io.Writer) error {
+	var opts options
+	fs := flag.NewFlagSet("locctl", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), USAGE)
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&opts.usersURL, "users-url", envOr("LOCCTL_USERS_URL", "http://localhost:8001"), "Base URL of the users service, or LOCCTL_USERS_URL")
+	fs.StringVar(&opts.historyURL, "history-url", envOr("LOCCTL_HISTORY_URL", "http://localhost:8000"), "Base URL of the location history service, or LOCCTL_HISTORY_URL")
+	fs.StringVar(&opts.token, "token", os.Getenv("LOCCTL_TOKEN"), "Admin token of the services, or LOCCTL_TOKEN")
+	fs.StringVar(&opts.output, "output", "table", "Output format: table or json")
+	fs.DurationVar(&opts.timeout, "timeout", 10*time.Second, "Timeout of every request")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if opts.output != "table" && opts.output != "json" {
+		return fmt.Errorf("unknown output %q, expected table or json", opts.output)
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return flag.ErrHelp
+	}
+
+	// Interrupting locctl cancels the pending request, e.g. to stop an import
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	cli := &cli{opts: opts, in: in, out: out}
+
+	command, rest := fs.Arg(0), fs.Args()[1:]
+	switch command {
+	case "user":
+		return cli.user(ctx, rest)
+	case "history":
+		return cli.history(ctx, rest)
+	case "distance":
+		return cli.distance(ctx, rest)
+	case "nearby":
+		return cli.nearby(ctx, rest)
+	case "migrate":
+		return cli.migrate(ctx, rest)
+	default:
+		return fmt.Errorf("unknown command %q, see locctl -h", command)
+	}
+}
+
+// envOr returns the value of an environment variable, or fallback if it is not set
+func envOr(name string, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"common/api"
+	"common/apierror"
+	"common/utils"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testRecords are two locations recorded a minute apart
+var testRecords = []record{
+	{Time: time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC), Longitude: 2.3522, Latitude: 48.8566},
+	{Time: time.Date(2024, time.March, 1, 12, 1, 0, 0, time.UTC), Longitude: 2.3530, Latitude: -48.8570},
+}
+
+// TestRecords tests that exported records are read back identically
+func TestRecords(t *testing.T) {
+	for _, format := range []string{"jsonl", "csv"} {
+		t.Run(format, func(t *testing.T) {
+			var buf bytes.Buffer
+			assert.NoError(t, writeRecords(&buf, format, testRecords))
+
+			records, err := readRecords(&buf, format)
+			assert.NoError(t, err)
+			assert.Equal(t, testRecords, records)
+		})
+	}
+
+	_, err := readRecords(strings.NewReader("time,longitude,latitude\n2024-03-01T12:00:00Z,east,48.8566\n"), "csv")
+	assert.EqualError(t, err, "line 2: expected an RFC 3339 time, a longitude and a latitude")
+
+	_, err = readRecords(strings.NewReader(`{"longitude": 2.3522, "latitude": 48.8566}`+"\n"), "jsonl")
+	assert.EqualError(t, err, "line 1: time is missing")
+
+	assert.Error(t, writeRecords(&bytes.Buffer{}, "xml", testRecords))
+}
+
+// TestImportKey tests that the import keys are valid idempotency keys identifying a location
+func TestImportKey(t *testing.T) {
+	key := importKey("alice", testRecords[0])
+	assert.NoError(t, utils.CheckIdempotencyKey(key))
+	assert.Equal(t, key, importKey("alice", testRecords[0]))
+	assert.NotEqual(t, key, importKey("alice", testRecords[1]))
+	assert.NotEqual(t, key, importKey("bob", testRecords[0]))
+}
+
+// TestRun tests running commands against a fake users service
+func TestRun(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer s3cr3t" {
+			apierror.Write(w, r, apierror.New(apierror.UNAUTHENTICATED, "missing or invalid bearer token"))
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/admin/users/alice":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"ID": 1, "Name": "alice", "Longitude": 2.3522, "Latitude": 48.8566}`))
+		default:
+			apierror.Write(w, r, apierror.New(apierror.NOT_FOUND, "user not found"))
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name     string
+		args     []string
+		expected string
+		code     apierror.Code
+	}{
+		{"Table", []string{"-token", "s3cr3t", "user", "get", "alice"}, "ID  NAME   LONGITUDE  LATITUDE\n1   alice  2.3522     48.8566\n", ""},
+		{"JSON", []string{"-token", "s3cr3t", "-output", "json", "user", "get", "alice"}, "{\n  \"ID\": 1,\n  \"Name\": \"alice\",\n  \"Longitude\": 2.3522,\n  \"Latitude\": 48.8566\n}\n", ""},
+		{"Not Found", []string{"-token", "s3cr3t", "user", "get", "bob"}, "", apierror.NOT_FOUND},
+		{"Missing Token", []string{"user", "get", "alice"}, "", apierror.UNAUTHENTICATED},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("LOCCTL_TOKEN", "")
+			var out bytes.Buffer
+			err := run(append([]string{"-users-url", server.URL}, tt.args...), nil, &out)
+			if tt.code == "" {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expected, out.String())
+				return
+			}
+
+			var apiErr *apierror.Error
+			if assert.True(t, errors.As(err, &apiErr), err) {
+				assert.Equal(t, tt.code, apiErr.Code)
+			}
+			assert.True(t, strings.HasPrefix(err.Error(), string(tt.code)+": "), err.Error())
+		})
+	}
+
+	// Invalid invocations are refused before any request
+	assert.Error(t, run([]string{"-output", "xml", "user", "get", "alice"}, nil, &bytes.Buffer{}))
+	assert.EqualError(t, run([]string{"user", "get"}, nil, &bytes.Buffer{}), "user get expects 1 argument(s): <username>")
+	assert.EqualError(t, run([]string{"nearby", "-longitude", "1"}, nil, &bytes.Buffer{}), "nearby expects -longitude, -latitude and -radius")
+}
+
+// TestImport tests that the history is imported through the admin route, with the time and an idempotency key
+func TestImport(t *testing.T) {
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body api.ImportLocationRequest
+		if r.Method != http.MethodPost || r.URL.Path != "/v1/admin/history/alice" || r.Header.Get("Authorization") != "Bearer s3cr3t" {
+			apierror.Write(w, r, apierror.New(apierror.NOT_FOUND, "unexpected request"))
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			apierror.Write(w, r, apierror.New(apierror.INVALID_ARGUMENT, err.Error()))
+			return
+		}
+		received = append(received, body.Time.Format(time.RFC3339)+" "+r.Header.Get("Idempotency-Key"))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	var in bytes.Buffer
+	assert.NoError(t, writeRecords(&in, "jsonl", testRecords))
+	var out bytes.Buffer
+	assert.NoError(t, run([]string{"-history-url", server.URL, "-token", "s3cr3t", "history", "import", "alice"}, &in, &out))
+	assert.Equal(t, "IMPORTED\n2\n", out.String())
+	assert.Equal(t, []string{
+		"2024-03-01T12:00:00Z " + importKey("alice", testRecords[0]),
+		"2024-03-01T12:01:00Z " + importKey("alice", testRecords[1]),
+	}, received)
+}
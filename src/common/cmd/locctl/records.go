@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// CSV_HEADER is the first row of the exported CSV files
+var CSV_HEADER = []string{"time", "longitude", "latitude"}
+
+// record is a location of an exported or imported history
+type record struct {
+	Time      time.Time `json:"time"`
+	Longitude float64   `json:"longitude"`
+	Latitude  float64   `json:"latitude"`
+}
+
+// writeRecords writes the records in the jsonl format, one JSON object per line, or in the csv format with a header
+func writeRecords(w io.Writer, format string, records []record) error {
+	switch format {
+	case "jsonl":
+		encoder := json.NewEncoder(w)
+		for _, rec := range records {
+			if err := encoder.Encode(rec); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "csv":
+		writer := csv.NewWriter(w)
+		writer.Write(CSV_HEADER)
+		for _, rec := range records {
+			writer.Write([]string{rec.Time.Format(time.RFC3339Nano), formatFloat(rec.Longitude), formatFloat(rec.Latitude)})
+		}
+		writer.Flush()
+		return writer.Error()
+	default:
+		return fmt.Errorf("unknown format %q, expected jsonl or csv", format)
+	}
+}
+
+// readRecords reads the records written by writeRecords, reporting the line of the first invalid record
+func readRecords(r io.Reader, format string) ([]record, error) {
+	var records []record
+	switch format {
+	case "jsonl":
+		scanner := bufio.NewScanner(r)
+		for line := 1; scanner.Scan(); line++ {
+			if len(scanner.Bytes()) == 0 {
+				continue
+			}
+			var rec record
+			if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			if rec.Time.IsZero() {
+				return nil, fmt.Errorf("line %d: time is missing", line)
+			}
+			records = append(records, rec)
+		}
+		return records, scanner.Err()
+	case "csv":
+		reader := csv.NewReader(r)
+		reader.FieldsPerRecord = len(CSV_HEADER)
+		rows, err := reader.ReadAll()
+		if err != nil {
+			return nil, err
+		}
+		for i, row := range rows {
+			// Skip the header
+			if i == 0 && row[0] == CSV_HEADER[0] {
+				continue
+			}
+
+			at, errTime := time.Parse(time.RFC3339Nano, row[0])
+			longitude, errLon := strconv.ParseFloat(row[1], 64)
+			latitude, errLat := strconv.ParseFloat(row[2], 64)
+			if errTime != nil || errLon != nil || errLat != nil {
+				return nil, fmt.Errorf("line %d: expected an RFC 3339 time, a longitude and a latitude", i+1)
+			}
+			records = append(records, record{Time: at, Longitude: longitude, Latitude: latitude})
+		}
+		return records, nil
+	default:
+		return nil, fmt.Errorf("unknown format %q, expected jsonl or csv", format)
+	}
+}
+
+// importKey derives the idempotency key of an imported location from the user and the location
+// The key is a UUID holding a hash of the location, so that a location imported again within the idempotency
+// TTL of the service is recorded once
+func importKey(username string, rec record) string {
+	sum := sha256.Sum256([]byte(username + "|" + rec.Time.UTC().Format(time.RFC3339Nano) + "|" +
+		formatFloat(rec.Longitude) + "|" + formatFloat(rec.Latitude)))
+	sum[6] = sum[6]&0x0f | 0x80 // Version 8, custom layout
+	sum[8] = sum[8]&0x3f | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16])
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"common/utils"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// gpxPoint is a track, route or way point of a GPX file
+type gpxPoint struct {
+	Latitude  float64 `xml:"lat,attr"`
+	Longitude float64 `xml:"lon,attr"`
+	Time      string  `xml:"time"`
+}
+
+// gpxFile holds the points of a GPX 1.1 file, the other elements are ignored
+type gpxFile struct {
+	Tracks []struct {
+		Segments []struct {
+			Points []gpxPoint `xml:"trkpt"`
+		} `xml:"trkseg"`
+	} `xml:"trk"`
+	Routes []struct {
+		Points []gpxPoint `xml:"rtept"`
+	} `xml:"rte"`
+}
+
+// parseGPX reads the tracks of a GPX file, or its routes if it has no track, as a single track
+// The points are timed by their time elements when every point has one, otherwise they are spaced by interval
+func parseGPX(r io.Reader, interval time.Duration) ([]trackPoint, error) {
+	var file gpxFile
+	if err := xml.NewDecoder(r).Decode(&file); err != nil {
+		return nil, fmt.Errorf("could not parse GPX file: %w", err)
+	}
+
+	var points []gpxPoint
+	for _, trk := range file.Tracks {
+		for _, segment := range trk.Segments {
+			points = append(points, segment.Points...)
+		}
+	}
+	if len(points) == 0 {
+		for _, rte := range file.Routes {
+			points = append(points, rte.Points...)
+		}
+	}
+	if len(points) == 0 {
+		return nil, errors.New("GPX file has no track or route point")
+	}
+
+	// Use the recorded times only if they are complete and in order
+	timed := true
+	times := make([]time.Time, len(points))
+	for i, point := range points {
+		at, err := time.Parse(time.RFC3339, point.Time)
+		if err != nil || (i > 0 && at.Before(times[i-1])) {
+			timed = false
+			break
+		}
+		times[i] = at
+	}
+
+	track := make([]trackPoint, 0, len(points))
+	for i, point := range points {
+		if err := utils.CheckCoordinates(point.Longitude, point.Latitude); err != nil {
+			return nil, fmt.Errorf("GPX point %d: %w", i+1, err)
+		}
+
+		offset := time.Duration(i) * interval
+		if timed {
+			offset = times[i].Sub(times[0])
+		}
+		track = append(track, trackPoint{longitude: point.Longitude, latitude: point.Latitude, offset: offset})
+	}
+	return track, nil
+}
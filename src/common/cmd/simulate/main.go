@@ -0,0 +1,296 @@
+package main
+
+import (
+	"common/client"
+	"common/config"
+	pb "common/protobuff"
+	"common/utils"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+)
+
+// USAGE describes simulate
+const USAGE = `Usage: simulate [flags]
+
+Moves virtual users around an area and sends their locations, to the users service over REST or to the
+location history service over gRPC, then reports the latency percentiles and the error rate of the updates.
+Every update is its own request: a REST call, or a unary UpdateHistory call over one gRPC connection.
+A GPX file given by -gpx is replayed as the movement of one more user.
+
+Flags:
+`
+
+// MAX_RATE is the highest number of updates per second of a user, so that the interval between two updates
+// stays well above the resolution of the timers
+const MAX_RATE float64 = 1000
+
+// options holds the flags of simulate
+type options struct {
+	users       int              // Number of virtual users moving in the area
+	duration    time.Duration    // Time the simulation runs
+	rate        float64          // Updates sent per second by every user
+	route       string           // Kind of route of the virtual users, random or waypoints
+	mode        string           // Mode of transport setting the speed of the virtual users
+	area        area             // Area the virtual users move in
+	prefix      string           // Prefix of the usernames of the virtual users
+	gpx         string           // GPX file replayed as the movement of a user
+	gpxUser     string           // Username of the user replaying the GPX file
+	speedup     float64          // Factor by which the GPX file is replayed faster than recorded
+	target      string           // Service the locations are sent to, rest or grpc
+	usersURL    string           // Base URL of the REST API of the users service
+	historyGRPC string           // Address of the gRPC server of the location history service
+	output      string           // Output format of the report, table or json
+	timeout     time.Duration    // Timeout of every update
+	seed        int64            // Seed of the routes, random if 0
+	tls         config.TLSConfig // Certificates of the gRPC link, plaintext unless enabled
+}
+
+// virtualUser is a simulated user moving along a route
+type virtualUser struct {
+	name  string        // Username of the user
+	route route         // Route the user moves along
+	delay time.Duration // Delay of the first update, so that the users do not all send at once
+}
+
+// main runs a simulation with the flags of the command line
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+		}
+		os.Exit(1)
+	}
+}
+
+// run runs a simulation with its command line arguments and writes the report to out
+func run(args []string, out io.Writer) error {
+	var opts options
+	fs := flag.NewFlagSet("simulate", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), USAGE)
+		fs.PrintDefaults()
+	}
+	fs.IntVar(&opts.users, "users", 10, "Number of virtual users")
+	fs.DurationVar(&opts.duration, "duration", time.Minute, "Time the simulation runs")
+	fs.Float64Var(&opts.rate, "rate", 1, "Updates sent per second by every user, at most 1000")
+	fs.StringVar(&opts.route, "route", "random", "Route of the virtual users: random (random walk) or waypoints (loop through random waypoints)")
+	fs.StringVar(&opts.mode, "mode", "walk", "Mode of transport setting the speed of the virtual users: walk, cycle or drive")
+	fs.Float64Var(&opts.area.longitude, "longitude", 2.3522, "Longitude of the center of the area")
+	fs.Float64Var(&opts.area.latitude, "latitude", 48.8566, "Latitude of the center of the area")
+	fs.Float64Var(&opts.area.radius, "radius", 5, "Radius of the area in kilometers")
+	fs.StringVar(&opts.prefix, "prefix", "sim", "Prefix of the usernames of the virtual users, followed by their number")
+	fs.StringVar(&opts.gpx, "gpx", "", "GPX file replayed as the movement of a user")
+	fs.StringVar(&opts.gpxUser, "gpx-user", "gpx0001", "Username of the user replaying the GPX file")
+	fs.Float64Var(&opts.speedup, "speedup", 1, "Factor by which the GPX file is replayed faster than recorded")
+	fs.StringVar(&opts.target, "target", "rest", "Where the locations are sent: rest (users service) or grpc (location history service)")
+	fs.StringVar(&opts.usersURL, "users-url", envOr("SIMULATE_USERS_URL", "http://localhost:8001"), "Base URL of the users service, or SIMULATE_USERS_URL")
+	fs.StringVar(&opts.historyGRPC, "history-grpc", envOr("SIMULATE_HISTORY_GRPC", "localhost:50051"), "gRPC address of the location history service, or SIMULATE_HISTORY_GRPC")
+	fs.StringVar(&opts.output, "output", "table", "Output format of the report: table or json")
+	fs.DurationVar(&opts.timeout, "timeout", 5*time.Second, "Timeout of every update")
+	fs.Int64Var(&opts.seed, "seed", 0, "Seed of the routes, to replay a simulation, random if 0")
+	fs.BoolVar(&opts.tls.Enabled, "tls", false, "Whether the gRPC link is encrypted with TLS")
+	fs.StringVar(&opts.tls.CAFile, "tls-ca-file", "", "PEM certificates of the CAs trusted to sign the certificate of the gRPC server")
+	fs.StringVar(&opts.tls.CertFile, "tls-cert-file", "", "PEM client certificate presented to a gRPC server requiring mutual TLS")
+	fs.StringVar(&opts.tls.KeyFile, "tls-key-file", "", "PEM private key of the client certificate")
+	fs.StringVar(&opts.tls.ServerName, "tls-server-name", "", "Name expected in the certificate of the gRPC server, its host if empty")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected argument %q, see simulate -h", fs.Arg(0))
+	}
+	if err := opts.validate(); err != nil {
+		return err
+	}
+
+	users, err := opts.virtualUsers()
+	if err != nil {
+		return err
+	}
+
+	send, closeSender, err := opts.sender()
+	if err != nil {
+		return err
+	}
+	defer closeSender()
+
+	// Interrupting simulate stops the simulation early, the report covers the updates sent so far
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	ctx, cancel := context.WithTimeout(ctx, opts.duration)
+	defer cancel()
+
+	start := time.Now()
+	s := simulate(ctx, users, send, time.Duration(float64(time.Second)/opts.rate), opts.timeout)
+	return s.report(len(users), time.Since(start)).write(out, opts.output)
+}
+
+// validate checks the flags before anything is sent
+func (opts options) validate() error {
+	if opts.users < 0 || (opts.users == 0 && opts.gpx == "") {
+		return errors.New("expected a positive number of users or a GPX file")
+	}
+	if opts.duration <= 0 || opts.rate <= 0 || opts.timeout <= 0 || opts.speedup <= 0 {
+		return errors.New("duration, rate, timeout and speedup must be positive")
+	}
+	if opts.rate > MAX_RATE {
+		return fmt.Errorf("rate must be at most %g updates per second", MAX_RATE)
+	}
+	if opts.route != "random" && opts.route != "waypoints" {
+		return fmt.Errorf("unknown route %q, expected random or waypoints", opts.route)
+	}
+	if _, ok := SPEEDS[opts.mode]; !ok {
+		return fmt.Errorf("unknown mode %q, expected walk, cycle or drive", opts.mode)
+	}
+	if opts.target != "rest" && opts.target != "grpc" {
+		return fmt.Errorf("unknown target %q, expected rest or grpc", opts.target)
+	}
+	if opts.output != "table" && opts.output != "json" {
+		return fmt.Errorf("unknown output %q, expected table or json", opts.output)
+	}
+	if err := utils.CheckCoordinates(opts.area.longitude, opts.area.latitude); err != nil {
+		return err
+	}
+	if opts.area.radius <= 0 {
+		return errors.New("radius must be positive")
+	}
+
+	// The numbered usernames are all valid if the longest one is
+	if opts.users > 0 {
+		if err := utils.CheckUsername(username(opts.prefix, opts.users)); err != nil {
+			return fmt.Errorf("invalid prefix: %w", err)
+		}
+	}
+	if opts.gpx != "" {
+		if err := utils.CheckUsername(opts.gpxUser); err != nil {
+			return fmt.Errorf("invalid GPX user: %w", err)
+		}
+	}
+	return nil
+}
+
+// virtualUsers creates the users of the simulation, with their routes and the delays of their first updates
+func (opts options) virtualUsers() ([]virtualUser, error) {
+	seed := opts.seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	rng := rand.New(rand.NewSource(seed))
+	interval := time.Duration(float64(time.Second) / opts.rate)
+	speeds := SPEEDS[opts.mode]
+
+	users := make([]virtualUser, 0, opts.users+1)
+	for i := 1; i <= opts.users; i++ {
+		// Every route has its own source, the routes are advanced concurrently
+		routeRng := rand.New(rand.NewSource(rng.Int63()))
+		speed := speeds[0] + rng.Float64()*(speeds[1]-speeds[0])
+
+		var r route
+		if opts.route == "waypoints" {
+			r = newWaypoints(opts.area, speed, routeRng)
+		} else {
+			r = newRandomWalk(opts.area, speed, routeRng)
+		}
+		users = append(users, virtualUser{name: username(opts.prefix, i), route: r, delay: time.Duration(rng.Int63n(int64(interval) + 1))})
+	}
+
+	if opts.gpx != "" {
+		file, err := os.Open(opts.gpx)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+
+		points, err := parseGPX(file, interval)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, virtualUser{name: opts.gpxUser, route: &track{points: points, speedup: opts.speedup}})
+	}
+	return users, nil
+}
+
+// sender connects to the target service, the returned function closes the connection
+func (opts options) sender() (sender, func(), error) {
+	if opts.target == "rest" {
+		httpClient := &http.Client{Transport: &http.Transport{MaxIdleConnsPerHost: opts.users + 1}}
+		return restSender(httpClient, opts.usersURL), httpClient.CloseIdleConnections, nil
+	}
+
+	conn, err := client.DialHistory(opts.historyGRPC, opts.tls)
+	if err != nil {
+		return nil, nil, err
+	}
+	return grpcSender(pb.NewLocationHistoryServiceClient(conn)), func() { conn.Close() }, nil
+}
+
+// simulate moves the users and sends their locations every interval, until ctx is done or every route is over
+func simulate(ctx context.Context, users []virtualUser, send sender, interval time.Duration, timeout time.Duration) *stats {
+	s := newStats()
+	var wg sync.WaitGroup
+	for _, user := range users {
+		wg.Add(1)
+		go func(user virtualUser) {
+			defer wg.Done()
+
+			select {
+			case <-time.After(user.delay):
+			case <-ctx.Done():
+				return
+			}
+
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			last := time.Now()
+			for {
+				now := time.Now()
+				longitude, latitude, done := user.route.advance(now.Sub(last))
+				last = now
+
+				updateCtx, cancel := context.WithTimeout(ctx, timeout)
+				err := send(updateCtx, user.name, longitude, latitude)
+				cancel()
+
+				// The updates cut short by the end of the simulation are not counted
+				if ctx.Err() != nil {
+					return
+				}
+				s.record(time.Since(now), err)
+				if done {
+					return
+				}
+
+				select {
+				case <-ticker.C:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(user)
+	}
+	wg.Wait()
+	return s
+}
+
+// username returns the username of the i-th virtual user
+func username(prefix string, i int) string {
+	return fmt.Sprintf("%s%04d", prefix, i)
+}
+
+// envOr returns the value of an environment variable, or fallback if it is not set
+func envOr(name string, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
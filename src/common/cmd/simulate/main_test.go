@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"common/apierror"
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testGPX is a track of three timed points followed by a route, which is ignored
+const testGPX = `<?xml version="1.0" encoding="UTF-8"?>
+<gpx version="1.1" creator="test" xmlns="http://www.topografix.com/GPX/1/1">
+  <trk><trkseg>
+    <trkpt lat="48.8566" lon="2.3522"><time>2024-03-01T12:00:00Z</time></trkpt>
+    <trkpt lat="48.8576" lon="2.3532"><time>2024-03-01T12:00:30Z</time></trkpt>
+  </trkseg><trkseg>
+    <trkpt lat="48.8586" lon="2.3542"><time>2024-03-01T12:01:30Z</time></trkpt>
+  </trkseg></trk>
+  <rte><rtept lat="0" lon="0"/></rte>
+</gpx>`
+
+// TestParseGPX tests reading the points of tracks and routes
+func TestParseGPX(t *testing.T) {
+	points, err := parseGPX(strings.NewReader(testGPX), time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, []trackPoint{
+		{longitude: 2.3522, latitude: 48.8566, offset: 0},
+		{longitude: 2.3532, latitude: 48.8576, offset: 30 * time.Second},
+		{longitude: 2.3542, latitude: 48.8586, offset: 90 * time.Second},
+	}, points)
+
+	// Untimed points are spaced by the interval
+	points, err = parseGPX(strings.NewReader(`<gpx><rte><rtept lat="1" lon="2"/><rtept lat="3" lon="4"/></rte></gpx>`), 5*time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, []trackPoint{{longitude: 2, latitude: 1}, {longitude: 4, latitude: 3, offset: 5 * time.Second}}, points)
+
+	_, err = parseGPX(strings.NewReader(`<gpx><trk><trkseg><trkpt lat="91" lon="0"/></trkseg></trk></gpx>`), time.Second)
+	assert.ErrorContains(t, err, "GPX point 1")
+	_, err = parseGPX(strings.NewReader(`<gpx></gpx>`), time.Second)
+	assert.EqualError(t, err, "GPX file has no track or route point")
+	_, err = parseGPX(strings.NewReader(`not xml`), time.Second)
+	assert.Error(t, err)
+}
+
+// TestRoutes tests that the virtual users move at their speed and stay in the area
+func TestRoutes(t *testing.T) {
+	a := area{longitude: 2.3522, latitude: 48.8566, radius: 1}
+	rng := rand.New(rand.NewSource(1))
+
+	t.Run("Random Walk", func(t *testing.T) {
+		walk := newRandomWalk(a, 5, rng)
+		for i := 0; i < 1000; i++ {
+			fromLon, fromLat := walk.longitude, walk.latitude
+			longitude, latitude, done := walk.advance(10 * time.Second)
+			assert.False(t, done)
+			assert.InDelta(t, 5000*10/3600.0, sphere.Distance(fromLon, fromLat, longitude, latitude), 1e-3)
+			assert.True(t, a.contains(longitude, latitude), "step %d left the area", i)
+		}
+	})
+
+	t.Run("Waypoints", func(t *testing.T) {
+		loop := newWaypoints(a, 20, rng)
+		first := loop.points[0]
+		leg := sphere.Distance(first[0], first[1], loop.points[1][0], loop.points[1][1])
+
+		// Half of the first leg
+		longitude, latitude, done := loop.advance(time.Duration(leg / 2 / 20000 * float64(time.Hour)))
+		assert.False(t, done)
+		assert.InDelta(t, leg/2, sphere.Distance(first[0], first[1], longitude, latitude), 1e-3)
+
+		// A move covers at most one lap
+		loop.advance(1000 * time.Hour)
+		assert.True(t, a.contains(loop.longitude, loop.latitude))
+	})
+
+	t.Run("Track", func(t *testing.T) {
+		replay := &track{points: []trackPoint{{longitude: 0, latitude: 0}, {longitude: 0, latitude: 1, offset: time.Minute}}, speedup: 2}
+		longitude, latitude, done := replay.advance(15 * time.Second)
+		assert.False(t, done)
+		assert.InDelta(t, 0, longitude, 1e-9)
+		assert.InDelta(t, 0.5, latitude, 1e-9)
+
+		longitude, latitude, done = replay.advance(15 * time.Second)
+		assert.True(t, done)
+		assert.Equal(t, [2]float64{0, 1}, [2]float64{longitude, latitude})
+	})
+}
+
+// TestReport tests the percentiles and the error rate of the report
+func TestReport(t *testing.T) {
+	s := newStats()
+	for i := 1; i <= 100; i++ {
+		var err error
+		if i%10 == 0 {
+			err = apierror.New(apierror.INVALID_COORDINATES, "latitude out of range")
+		}
+		s.record(time.Duration(i)*time.Millisecond, err)
+	}
+
+	r := s.report(2, 10*time.Second)
+	assert.Equal(t, 100, r.Updates)
+	assert.Equal(t, 10, r.Errors)
+	assert.Equal(t, 0.1, r.ErrorRate)
+	assert.Equal(t, 10.0, r.Throughput)
+	assert.Equal(t, latencies{P50: 50, P90: 90, P99: 99, Max: 100}, r.LatencyMs)
+	assert.Equal(t, map[string]int{"INVALID_COORDINATES": 10}, r.ErrorsByCode)
+
+	var out bytes.Buffer
+	assert.NoError(t, r.write(&out, "table"))
+	assert.Regexp(t, `\nErrors +10 \(10\.00%\)\n`, out.String())
+	assert.Contains(t, out.String(), "  INVALID_COORDINATES  10\n")
+
+	assert.Equal(t, 0.0, percentile(nil, 50))
+}
+
+// TestRun tests a simulation against a fake users service refusing the updates of one user
+func TestRun(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct{ Longitude, Latitude float64 }
+		if r.Method != http.MethodPost || json.NewDecoder(r.Body).Decode(&body) != nil {
+			apierror.Write(w, r, apierror.New(apierror.INVALID_ARGUMENT, "invalid request"))
+			return
+		}
+		if r.URL.Path == "/v1/update/test0002" {
+			apierror.Write(w, r, apierror.New(apierror.INVALID_USERNAME, "username is reserved"))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	var out bytes.Buffer
+	err := run([]string{"-users-url", server.URL, "-users", "2", "-prefix", "test", "-rate", "50", "-duration", "300ms", "-seed", "1", "-output", "json"}, &out)
+	assert.NoError(t, err)
+
+	var r report
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &r))
+	assert.Equal(t, 2, r.Users)
+	assert.Greater(t, r.Updates, 4)
+	assert.Greater(t, r.Errors, 0)
+	assert.Less(t, r.Errors, r.Updates)
+	assert.Equal(t, r.Errors, r.ErrorsByCode["INVALID_USERNAME"])
+
+	// An unreachable service is reported, not returned
+	server.Close()
+	out.Reset()
+	assert.NoError(t, run([]string{"-users-url", server.URL, "-users", "1", "-rate", "50", "-duration", "100ms", "-output", "json"}, &out))
+	var unreachable report
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &unreachable))
+	assert.Equal(t, map[string]int{UNREACHABLE: unreachable.Updates}, unreachable.ErrorsByCode)
+
+	// Invalid invocations are refused before any update
+	assert.EqualError(t, run([]string{"-users", "0"}, &out), "expected a positive number of users or a GPX file")
+	assert.EqualError(t, run([]string{"-rate", "2e9"}, &out), "rate must be at most 1000 updates per second")
+	assert.EqualError(t, run([]string{"-mode", "fly"}, &out), `unknown mode "fly", expected walk, cycle or drive`)
+	assert.ErrorContains(t, run([]string{"-prefix", "a-b"}, &out), "invalid prefix")
+	assert.Error(t, run([]string{"-gpx", "does-not-exist.gpx"}, &out))
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"common/apierror"
+	"common/client"
+	pb "common/protobuff"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	TIMEOUT     = "TIMEOUT"     // Error code of the updates not answered in time
+	UNREACHABLE = "UNREACHABLE" // Error code of the updates that could not be sent
+)
+
+// sender sends the location of a virtual user to a service
+type sender func(ctx context.Context, username string, longitude float64, latitude float64) error
+
+// restSender posts the locations to the update route of the users service, without retrying the failed updates
+// Problems answered by the service are returned as errors of the catalogue
+func restSender(httpClient *http.Client, usersURL string) sender {
+	users := client.New(client.Config{UsersURL: usersURL, HTTPClient: httpClient, MaxAttempts: 1})
+	return users.UpdateLocation
+}
+
+// grpcSender records the locations with the UpdateHistory RPC of the location history service
+func grpcSender(client pb.LocationHistoryServiceClient) sender {
+	return func(ctx context.Context, username string, longitude float64, latitude float64) error {
+		_, err := client.UpdateHistory(ctx, &pb.LocationUpdateRequest{Username: username, Longitude: longitude, Latitude: latitude})
+		return err
+	}
+}
+
+// errorCode classifies a failed update: by the code of the catalogue answered by the service, TIMEOUT when
+// no answer came in time, or UNREACHABLE when the update could not be sent
+func errorCode(err error) string {
+	if errors.Is(err, context.DeadlineExceeded) || status.Code(err) == codes.DeadlineExceeded {
+		return TIMEOUT
+	}
+
+	var apiErr *apierror.Error
+	if errors.As(err, &apiErr) {
+		return string(apiErr.Code)
+	}
+	if st, ok := status.FromError(err); ok {
+		if st.Code() == codes.Unavailable && len(st.Details()) == 0 {
+			return UNREACHABLE
+		}
+		return string(apierror.From(err).Code)
+	}
+	return UNREACHABLE
+}
+
+// stats collects the outcome of the updates, it is safe for concurrent use
+type stats struct {
+	mu        sync.Mutex
+	latencies []time.Duration // Latency of every update, failed or not
+	errors    map[string]int  // Number of failed updates by error code
+}
+
+// newStats creates empty statistics
+func newStats() *stats {
+	return &stats{errors: make(map[string]int)}
+}
+
+// record adds the outcome of an update
+func (s *stats) record(latency time.Duration, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.latencies = append(s.latencies, latency)
+	if err != nil {
+		s.errors[errorCode(err)]++
+	}
+}
+
+// report summarizes the updates sent during a simulation
+type report struct {
+	Users        int            // Number of virtual users
+	Duration     string         // Time the simulation ran
+	Updates      int            // Number of updates sent
+	Errors       int            // Number of failed updates
+	ErrorRate    float64        // Fraction of the updates that failed
+	Throughput   float64        // Updates per second
+	LatencyMs    latencies      // Latency percentiles of the updates, in milliseconds
+	ErrorsByCode map[string]int // Number of failed updates by error code
+}
+
+// latencies holds latency percentiles in milliseconds
+type latencies struct {
+	P50 float64
+	P90 float64
+	P99 float64
+	Max float64
+}
+
+// report summarizes the recorded updates of a simulation of users that ran for elapsed
+func (s *stats) report(users int, elapsed time.Duration) report {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sorted := append([]time.Duration(nil), s.latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	r := report{
+		Users:        users,
+		Duration:     elapsed.Round(time.Millisecond).String(),
+		Updates:      len(sorted),
+		ErrorsByCode: make(map[string]int, len(s.errors)),
+		LatencyMs: latencies{
+			P50: percentile(sorted, 50),
+			P90: percentile(sorted, 90),
+			P99: percentile(sorted, 99),
+			Max: percentile(sorted, 100),
+		},
+	}
+	for code, count := range s.errors {
+		r.ErrorsByCode[code] = count
+		r.Errors += count
+	}
+	if r.Updates > 0 {
+		r.ErrorRate = float64(r.Errors) / float64(r.Updates)
+	}
+	if elapsed > 0 {
+		r.Throughput = float64(r.Updates) / elapsed.Seconds()
+	}
+	return r
+}
+
+// percentile returns the nearest rank percentile of sorted latencies in milliseconds, 0 if there is none
+func percentile(sorted []time.Duration, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return float64(sorted[rank-1]) / float64(time.Millisecond)
+}
+
+// write writes the report as a table or as indented JSON
+func (r report) write(w io.Writer, output string) error {
+	if output == "json" {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(r)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Virtual users\t%d\n", r.Users)
+	fmt.Fprintf(tw, "Duration\t%s\n", r.Duration)
+	fmt.Fprintf(tw, "Updates\t%d\n", r.Updates)
+	fmt.Fprintf(tw, "Throughput\t%.1f/s\n", r.Throughput)
+	fmt.Fprintf(tw, "Errors\t%d (%.2f%%)\n", r.Errors, 100*r.ErrorRate)
+	fmt.Fprintf(tw, "Latency p50\t%.1fms\n", r.LatencyMs.P50)
+	fmt.Fprintf(tw, "Latency p90\t%.1fms\n", r.LatencyMs.P90)
+	fmt.Fprintf(tw, "Latency p99\t%.1fms\n", r.LatencyMs.P99)
+	fmt.Fprintf(tw, "Latency max\t%.1fms\n", r.LatencyMs.Max)
+
+	codes := make([]string, 0, len(r.ErrorsByCode))
+	for code := range r.ErrorsByCode {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	for _, code := range codes {
+		fmt.Fprintf(tw, "  %s\t%d\n", code, r.ErrorsByCode[code])
+	}
+	return tw.Flush()
+}
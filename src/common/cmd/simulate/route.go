@@ -0,0 +1,153 @@
+package main
+
+import (
+	"common/utils"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// SPEEDS holds the range of the speeds of the virtual users of every mode of transport, in kilometers per hour
+var SPEEDS = map[string][2]float64{
+	"walk":  {4, 6},
+	"cycle": {12, 25},
+	"drive": {25, 90},
+}
+
+// sphere measures the distances of the routes, on the sphere utils.Destination and utils.InterpolateGreatCircle move on
+var sphere = utils.NewHaversine()
+
+const (
+	WAYPOINTS    int     = 5  // Number of waypoints of the waypoint routes
+	WALK_TURNING float64 = 30 // Standard deviation of the change of bearing between two steps of a random walk, in degrees
+)
+
+// route moves a virtual user
+type route interface {
+	// advance moves the user by the time elapsed since the last move and returns their position,
+	// and whether the route is over
+	advance(elapsed time.Duration) (longitude float64, latitude float64, done bool)
+}
+
+// area is a circle the virtual users start and move in
+type area struct {
+	longitude float64 // Longitude of the center
+	latitude  float64 // Latitude of the center
+	radius    float64 // Radius in kilometers
+}
+
+// randomPoint returns a point drawn uniformly in the area
+func (a area) randomPoint(rng *rand.Rand) (float64, float64) {
+	return utils.Destination(a.longitude, a.latitude, rng.Float64()*360, a.radius*1000*math.Sqrt(rng.Float64()))
+}
+
+// contains reports whether a point is in the area
+func (a area) contains(longitude, latitude float64) bool {
+	return sphere.Distance(a.longitude, a.latitude, longitude, latitude) <= a.radius*1000
+}
+
+// randomWalk moves in a direction changing a little at every step, turning back at the border of the area
+type randomWalk struct {
+	longitude float64    // Current longitude
+	latitude  float64    // Current latitude
+	bearing   float64    // Current direction in degrees clockwise from north
+	speed     float64    // Speed in kilometers per hour
+	area      area       // Area the walk stays in
+	rng       *rand.Rand // Source of the changes of direction
+}
+
+// newRandomWalk starts a random walk at a random point of the area
+func newRandomWalk(a area, speed float64, rng *rand.Rand) *randomWalk {
+	longitude, latitude := a.randomPoint(rng)
+	return &randomWalk{longitude: longitude, latitude: latitude, bearing: rng.Float64() * 360, speed: speed, area: a, rng: rng}
+}
+
+// advance moves the walk, it never ends
+func (w *randomWalk) advance(elapsed time.Duration) (float64, float64, bool) {
+	w.bearing = math.Mod(w.bearing+w.rng.NormFloat64()*WALK_TURNING+360, 360)
+	distance := w.speed * 1000 * elapsed.Hours() // Meters
+
+	longitude, latitude := utils.Destination(w.longitude, w.latitude, w.bearing, distance)
+	if !w.area.contains(longitude, latitude) {
+		// Turn back into the area
+		w.bearing = math.Mod(w.bearing+180, 360)
+		longitude, latitude = utils.Destination(w.longitude, w.latitude, w.bearing, distance)
+	}
+
+	w.longitude, w.latitude = longitude, latitude
+	return w.longitude, w.latitude, false
+}
+
+// waypoints moves along great circles between points, going back to the first point after the last one
+type waypoints struct {
+	points    [][2]float64 // Longitude and latitude of the waypoints
+	next      int          // Index of the waypoint the user is heading to
+	longitude float64      // Current longitude
+	latitude  float64      // Current latitude
+	speed     float64      // Speed in kilometers per hour
+}
+
+// newWaypoints starts a loop through random waypoints of the area, at the first one
+func newWaypoints(a area, speed float64, rng *rand.Rand) *waypoints {
+	points := make([][2]float64, WAYPOINTS)
+	for i := range points {
+		points[i][0], points[i][1] = a.randomPoint(rng)
+	}
+	return &waypoints{points: points, next: 1, longitude: points[0][0], latitude: points[0][1], speed: speed}
+}
+
+// advance moves towards the next waypoints, the loop never ends
+// A move covers at most one lap, so that waypoints at the same place can't keep the user going around
+func (w *waypoints) advance(elapsed time.Duration) (float64, float64, bool) {
+	distance := w.speed * 1000 * elapsed.Hours() // Meters
+	for reached := 0; distance > 0 && reached < len(w.points); reached++ {
+		target := w.points[w.next]
+		remaining := sphere.Distance(w.longitude, w.latitude, target[0], target[1])
+
+		// Stop between the waypoints
+		if remaining > distance {
+			w.longitude, w.latitude = utils.InterpolateGreatCircle(w.longitude, w.latitude, target[0], target[1], distance/remaining)
+			break
+		}
+
+		// Reach the waypoint and head to the next one
+		w.longitude, w.latitude = target[0], target[1]
+		w.next = (w.next + 1) % len(w.points)
+		distance -= remaining
+	}
+	return w.longitude, w.latitude, false
+}
+
+// trackPoint is a point of a recorded track
+type trackPoint struct {
+	longitude float64       // Longitude of the point
+	latitude  float64       // Latitude of the point
+	offset    time.Duration // Time since the first point of the track
+}
+
+// track replays a recorded track, interpolating between its points
+type track struct {
+	points  []trackPoint  // Points of the track, in chronological order
+	elapsed time.Duration // Time of the track replayed so far
+	speedup float64       // Factor by which the replay is faster than the recording
+}
+
+// advance moves along the track, which is over once its last point is reached
+func (t *track) advance(elapsed time.Duration) (float64, float64, bool) {
+	t.elapsed += time.Duration(float64(elapsed) * t.speedup)
+
+	last := t.points[len(t.points)-1]
+	if t.elapsed >= last.offset {
+		return last.longitude, last.latitude, true
+	}
+
+	// Find the segment of the track containing the elapsed time
+	i := 1
+	for t.points[i].offset <= t.elapsed {
+		i++
+	}
+	from, to := t.points[i-1], t.points[i]
+	fraction := float64(t.elapsed-from.offset) / float64(to.offset-from.offset)
+	longitude, latitude := utils.InterpolateGreatCircle(from.longitude, from.latitude, to.longitude, to.latitude, fraction)
+	return longitude, latitude, false
+}
@@ -0,0 +1,408 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"common/database"
+	"common/utils"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds the configuration of a service
+// Every field can be set, in increasing order of precedence, by the service defaults, the configuration file
+// (key from the yaml tag), an environment variable (service prefix + "_" + env tag) and a command line flag (flag tag)
+// Fields tagged secret are redacted when the configuration is printed
+type Config struct {
+	RestHost    string `yaml:"rest_host" env:"REST_HOST" flag:"rest-host" usage:"Host for the REST server"`
+	RestPort    string `yaml:"rest_port" env:"REST_PORT" flag:"rest-port" usage:"Port for the REST server"`
+	GrpcHost    string `yaml:"grpc_host" env:"GRPC_HOST" flag:"grpc-host" usage:"Host of the gRPC server"`
+	GrpcPort    string `yaml:"grpc_port" env:"GRPC_PORT" flag:"grpc-port" usage:"Port of the gRPC server"`
+	DatabaseURL string `yaml:"database_url" env:"DATABASE_URL" flag:"database-url" usage:"URL for the database connection, sqlite:// (or a file path) or postgres://"`
+	LogURL      string `yaml:"log_url" env:"LOG_URL" flag:"log-url" usage:"Path of the log file, or stdout or stderr"`
+	Geodesic    string `yaml:"geodesic" env:"GEODESIC" flag:"geodesic" usage:"Geodesic model used for distance calculations (haversine, vincenty or karney)"`
+	AdminToken  string `yaml:"admin_token" env:"ADMIN_TOKEN" flag:"admin-token" secret:"true" usage:"Bearer token required by the administration endpoints under /v1/admin, which are disabled if empty"`
+
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT" flag:"shutdown-timeout" usage:"Time given to the servers to drain on shutdown, e.g. 10s"`
+
+	Logging LoggingConfig `yaml:"logging" env:"LOGGING" flag:"logging"`
+	Tracing TracingConfig `yaml:"tracing" env:"TRACING" flag:"tracing"`
+	TLS     TLSConfig     `yaml:"tls" env:"TLS" flag:"tls"`
+}
+
+// TLSConfig holds the certificates securing the gRPC link between the services
+// The same fields are used by the gRPC server and its clients, the certificate files are reloaded when they change
+type TLSConfig struct {
+	Enabled        bool          `yaml:"enabled" env:"ENABLED" flag:"enabled" usage:"Whether the gRPC link is encrypted with TLS"`
+	CertFile       string        `yaml:"cert_file" env:"CERT_FILE" flag:"cert-file" usage:"PEM certificate presented by the service, required for the gRPC server and for mutual TLS"`
+	KeyFile        string        `yaml:"key_file" env:"KEY_FILE" flag:"key-file" usage:"PEM private key of the certificate"`
+	CAFile         string        `yaml:"ca_file" env:"CA_FILE" flag:"ca-file" usage:"PEM certificates of the CAs trusted to sign the certificate of the peer, the system roots if empty"`
+	ClientAuth     bool          `yaml:"client_auth" env:"CLIENT_AUTH" flag:"client-auth" usage:"Whether the gRPC server requires a client certificate signed by the CA (mutual TLS)"`
+	ServerName     string        `yaml:"server_name" env:"SERVER_NAME" flag:"server-name" usage:"Name expected in the certificate of the gRPC server, the gRPC host if empty"`
+	ReloadInterval time.Duration `yaml:"reload_interval" env:"RELOAD_INTERVAL" flag:"reload-interval" usage:"Interval between the checks for changed certificate files"`
+}
+
+// LoggingConfig holds the level of the logs and the rotation of the log file
+type LoggingConfig struct {
+	Level       string        `yaml:"level" env:"LEVEL" flag:"level" usage:"Minimum level of the logged messages: debug, info, warn or error"`
+	MaxSize     int           `yaml:"max_size" env:"MAX_SIZE" flag:"max-size" usage:"Size in megabytes at which the log file is rotated"`
+	RotateEvery time.Duration `yaml:"rotate_every" env:"ROTATE_EVERY" flag:"rotate-every" usage:"Age at which the log file is rotated, e.g. 24h, 0 to rotate by size only"`
+	MaxAge      time.Duration `yaml:"max_age" env:"MAX_AGE" flag:"max-age" usage:"Age after which rotated log files are removed, rounded up to days, 0 to keep them"`
+	MaxBackups  int           `yaml:"max_backups" env:"MAX_BACKUPS" flag:"max-backups" usage:"Number of rotated log files kept, 0 to keep them all"`
+	Compress    bool          `yaml:"compress" env:"COMPRESS" flag:"compress" usage:"Whether rotated log files are compressed with gzip"`
+}
+
+// TracingConfig holds the configuration of the span exporter
+type TracingConfig struct {
+	Exporter    string  `yaml:"exporter" env:"EXPORTER" flag:"exporter" usage:"Span exporter: none, stdout, file or otlp"`
+	Endpoint    string  `yaml:"endpoint" env:"ENDPOINT" flag:"endpoint" usage:"Address of the OTLP gRPC collector used by the otlp exporter, e.g. localhost:4317"`
+	File        string  `yaml:"file" env:"FILE" flag:"file" usage:"Path of the file the spans are appended to by the file exporter"`
+	SampleRatio float64 `yaml:"sample_ratio" env:"SAMPLE_RATIO" flag:"sample-ratio" usage:"Fraction of the traces started by the service that are sampled, between 0 and 1"`
+}
+
+// REDACTED replaces the values of the secret fields in the printed configuration
+const REDACTED = "REDACTED"
+
+// Options holds the command line options that control how the configuration is loaded
+type Options struct {
+	ConfigFile  string   // Path of the configuration file, empty if none is used
+	PrintConfig bool     // Whether the resolved configuration should be printed instead of starting the service
+	Args        []string // Positional arguments left after the flags
+}
+
+// Validate checks every field of the configuration and returns all problems found at once
+func (cfg *Config) Validate() error {
+	var errs []error
+
+	if err := checkPort(cfg.RestPort); err != nil {
+		errs = append(errs, fmt.Errorf("rest_port: %w", err))
+	}
+
+	if err := checkPort(cfg.GrpcPort); err != nil {
+		errs = append(errs, fmt.Errorf("grpc_port: %w", err))
+	}
+
+	if _, err := database.ParseURL(cfg.DatabaseURL); err != nil {
+		errs = append(errs, fmt.Errorf("database_url: %w", err))
+	}
+
+	if cfg.LogURL == "" {
+		errs = append(errs, errors.New("log_url: must be set"))
+	}
+
+	if _, err := utils.NewGeodesic(cfg.Geodesic); err != nil {
+		errs = append(errs, fmt.Errorf("geodesic: %w", err))
+	}
+
+	if cfg.ShutdownTimeout <= 0 {
+		errs = append(errs, errors.New("shutdown_timeout: must be positive"))
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(cfg.Logging.Level)); err != nil {
+		errs = append(errs, fmt.Errorf("logging.level: unknown level %q, expected debug, info, warn or error", cfg.Logging.Level))
+	}
+
+	if cfg.Logging.MaxSize <= 0 {
+		errs = append(errs, errors.New("logging.max_size: must be positive"))
+	}
+
+	if cfg.Logging.RotateEvery < 0 || cfg.Logging.MaxAge < 0 || cfg.Logging.MaxBackups < 0 {
+		errs = append(errs, errors.New("logging: rotate_every, max_age and max_backups must not be negative"))
+	}
+
+	switch cfg.Tracing.Exporter {
+	case "none", "stdout":
+	case "file":
+		if cfg.Tracing.File == "" {
+			errs = append(errs, errors.New("tracing.file: must be set for the file exporter"))
+		}
+	case "otlp":
+		if cfg.Tracing.Endpoint == "" {
+			errs = append(errs, errors.New("tracing.endpoint: must be set for the otlp exporter"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("tracing.exporter: unknown exporter %q, expected none, stdout, file or otlp", cfg.Tracing.Exporter))
+	}
+
+	if cfg.Tracing.SampleRatio < 0 || cfg.Tracing.SampleRatio > 1 {
+		errs = append(errs, fmt.Errorf("tracing.sample_ratio: %v is not between 0 and 1", cfg.Tracing.SampleRatio))
+	}
+
+	if cfg.TLS.Enabled {
+		if (cfg.TLS.CertFile == "") != (cfg.TLS.KeyFile == "") {
+			errs = append(errs, errors.New("tls: cert_file and key_file must be set together"))
+		}
+		if cfg.TLS.ClientAuth && cfg.TLS.CAFile == "" {
+			errs = append(errs, errors.New("tls.ca_file: must be set to verify client certificates"))
+		}
+		if cfg.TLS.ReloadInterval <= 0 {
+			errs = append(errs, errors.New("tls.reload_interval: must be positive"))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// checkPort validates a TCP port number
+func checkPort(port string) error {
+	number, err := strconv.Atoi(port)
+	if err != nil || number < 1 || number > 65535 {
+		return fmt.Errorf("%q is not a port between 1 and 65535", port)
+	}
+	return nil
+}
+
+// Load resolves the configuration of a service from its defaults, the configuration file, the environment
+// variables starting with envPrefix and the command line arguments, in that order of precedence
+// The configuration file is given by the -config flag or the envPrefix_CONFIG variable, in YAML or TOML format
+// depending on its extension, keys of the file that match no field are reported. All parsing and validation errors are
+// returned together
+func Load(envPrefix string, defaults Config, args []string) (Config, Options, error) {
+	cfg := defaults
+	var opts Options
+	var errs []error
+
+	// Register a flag for every field, the values are applied after the file and environment variables
+	fs := flag.NewFlagSet(strings.ToLower(envPrefix), flag.ContinueOnError)
+	fs.StringVar(&opts.ConfigFile, "config", os.Getenv(envPrefix+"_CONFIG"), "Path of a YAML or TOML configuration file")
+	fs.BoolVar(&opts.PrintConfig, "print-config", false, "Print the resolved configuration and exit")
+	fields := collectFields(reflect.ValueOf(&cfg).Elem(), nil)
+	flagValues := make(map[string]*string, len(fields))
+	for _, f := range fields {
+		flagValues[f.flag] = fs.String(f.flag, "", f.usage)
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return cfg, opts, err
+	}
+	opts.Args = fs.Args()
+
+	// Configuration file
+	if opts.ConfigFile != "" {
+		values, err := readFile(opts.ConfigFile)
+		if err != nil {
+			return cfg, opts, err
+		}
+
+		for _, f := range fields {
+			if value, ok := values[f.key]; ok {
+				if err := f.set(value); err != nil {
+					errs = append(errs, fmt.Errorf("%s in %s: %w", f.key, opts.ConfigFile, err))
+				}
+				delete(values, f.key)
+			}
+		}
+
+		// The keys left match no field, most likely misspelled ones that would otherwise be ignored silently
+		unknown := make([]string, 0, len(values))
+		for key := range values {
+			unknown = append(unknown, key)
+		}
+		sort.Strings(unknown)
+		for _, key := range unknown {
+			errs = append(errs, fmt.Errorf("%s in %s: unknown key", key, opts.ConfigFile))
+		}
+	}
+
+	// Environment variables
+	for _, f := range fields {
+		name := envPrefix + "_" + f.env
+		if value, ok := os.LookupEnv(name); ok && value != "" {
+			if err := f.set(value); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			}
+		}
+	}
+
+	// Command line flags, only the ones that were explicitly set
+	setFlags := make(map[string]bool)
+	fs.Visit(func(fl *flag.Flag) { setFlags[fl.Name] = true })
+	for _, f := range fields {
+		if setFlags[f.flag] {
+			if err := f.set(*flagValues[f.flag]); err != nil {
+				errs = append(errs, fmt.Errorf("-%s: %w", f.flag, err))
+			}
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	return cfg, opts, errors.Join(errs...)
+}
+
+// Print writes the configuration to w in YAML format, in the format read from configuration files
+// The values of the secret fields are replaced by REDACTED
+func Print(w io.Writer, cfg Config) error {
+	root := &yaml.Node{Kind: yaml.MappingNode}
+	for _, f := range collectFields(reflect.ValueOf(&cfg).Elem(), nil) {
+		// Nested fields are written under the mapping of their struct
+		keys := strings.Split(f.key, ".")
+		node := root
+		for _, key := range keys[:len(keys)-1] {
+			node = mappingChild(node, key)
+		}
+
+		value := f.value.Interface()
+		if duration, ok := value.(time.Duration); ok {
+			value = duration.String()
+		}
+		if f.secret && !f.value.IsZero() {
+			value = REDACTED
+		}
+
+		valueNode := &yaml.Node{}
+		if err := valueNode.Encode(value); err != nil {
+			return err
+		}
+		node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: keys[len(keys)-1]}, valueNode)
+	}
+
+	encoder := yaml.NewEncoder(w)
+	if err := encoder.Encode(root); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+// mappingChild returns the mapping stored under key in a YAML mapping node, adding it if missing
+func mappingChild(node *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+
+	child := &yaml.Node{Kind: yaml.MappingNode}
+	node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, child)
+	return child
+}
+
+// field describes a configurable field and where its value is read from
+type field struct {
+	value  reflect.Value // Settable value of the field
+	key    string        // Dotted key in the configuration file
+	env    string        // Environment variable name, without the service prefix
+	flag   string        // Command line flag name
+	usage  string        // Description shown in the command line help
+	secret bool          // Whether the value is redacted when printed
+}
+
+// set parses the textual value and stores it in the field
+func (f field) set(value string) error {
+	switch f.value.Interface().(type) {
+	case string:
+		f.value.SetString(value)
+	case time.Duration:
+		duration, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a duration", value)
+		}
+		f.value.SetInt(int64(duration))
+	case bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", value)
+		}
+		f.value.SetBool(b)
+	case int:
+		number, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", value)
+		}
+		f.value.SetInt(int64(number))
+	case float64:
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", value)
+		}
+		f.value.SetFloat(number)
+	default:
+		return fmt.Errorf("unsupported field type %s", f.value.Type())
+	}
+	return nil
+}
+
+// collectFields lists the configurable fields of a struct, descending into nested structs
+// Names of nested fields are prefixed with the names of the struct containing them
+func collectFields(v reflect.Value, parent *field) []field {
+	var fields []field
+	for i := 0; i < v.NumField(); i++ {
+		structField := v.Type().Field(i)
+		yamlKey := strings.Split(structField.Tag.Get("yaml"), ",")[0]
+		if yamlKey == "" || yamlKey == "-" {
+			continue
+		}
+
+		f := field{
+			value:  v.Field(i),
+			key:    yamlKey,
+			env:    structField.Tag.Get("env"),
+			flag:   structField.Tag.Get("flag"),
+			usage:  structField.Tag.Get("usage"),
+			secret: structField.Tag.Get("secret") == "true",
+		}
+		if parent != nil {
+			f.key = parent.key + "." + f.key
+			f.env = parent.env + "_" + f.env
+			f.flag = parent.flag + "-" + f.flag
+		}
+
+		if structField.Type.Kind() == reflect.Struct && structField.Type != reflect.TypeOf(time.Time{}) {
+			fields = append(fields, collectFields(v.Field(i), &f)...)
+			continue
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+// readFile reads a YAML or TOML configuration file into a map of dotted keys to textual values
+func readFile(path string) (map[string]string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	document := make(map[string]any)
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(content, &document)
+	case ".toml":
+		err = toml.Unmarshal(content, &document)
+	default:
+		return nil, fmt.Errorf("configuration file %s must have a .yaml, .yml or .toml extension", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not parse configuration file %s: %w", path, err)
+	}
+
+	values := make(map[string]string)
+	flatten(document, "", values)
+	return values, nil
+}
+
+// flatten converts nested maps into dotted keys with textual values
+func flatten(document map[string]any, prefix string, values map[string]string) {
+	for key, value := range document {
+		if nested, ok := value.(map[string]any); ok {
+			flatten(nested, prefix+key+".", values)
+			continue
+		}
+		values[prefix+key] = fmt.Sprint(value)
+	}
+}
@@ -0,0 +1,163 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testDefaults is the configuration used as service defaults in the tests
+var testDefaults = Config{
+	RestHost:    "localhost",
+	RestPort:    "8000",
+	GrpcHost:    "localhost",
+	GrpcPort:    "50051",
+	DatabaseURL: "test.db",
+	LogURL:      "test.log",
+	Geodesic:    "haversine",
+
+	ShutdownTimeout: 10 * time.Second,
+	Logging:         LoggingConfig{Level: "info", MaxSize: 100},
+	Tracing:         TracingConfig{Exporter: "none", SampleRatio: 1},
+	TLS:             TLSConfig{ReloadInterval: 10 * time.Second},
+}
+
+// writeFile writes a configuration file into a temporary directory and returns its path
+func writeFile(t *testing.T, name string, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// TestLoadDefaults tests that the defaults are used when no other source sets a value
+func TestLoadDefaults(t *testing.T) {
+	cfg, opts, err := Load("TEST", testDefaults, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, testDefaults, cfg)
+	assert.False(t, opts.PrintConfig)
+}
+
+// TestLoadPrecedence tests that flags override environment variables, which override the file, which overrides the defaults
+func TestLoadPrecedence(t *testing.T) {
+	path := writeFile(t, "config.yaml", "rest_port: 9000\ngrpc_port: 9001\ndatabase_url: file.db\n")
+	t.Setenv("TEST_GRPC_PORT", "9002")
+	t.Setenv("TEST_DATABASE_URL", "env.db")
+
+	cfg, opts, err := Load("TEST", testDefaults, []string{"-config", path, "-database-url", "flag.db", "migrate", "up"})
+	assert.NoError(t, err)
+	assert.Equal(t, path, opts.ConfigFile)
+	assert.Equal(t, []string{"migrate", "up"}, opts.Args)
+
+	assert.Equal(t, "localhost", cfg.RestHost)
+	assert.Equal(t, "9000", cfg.RestPort)
+	assert.Equal(t, "9002", cfg.GrpcPort)
+	assert.Equal(t, "flag.db", cfg.DatabaseURL)
+}
+
+// TestLoadTOML tests loading a TOML configuration file given by an environment variable
+func TestLoadTOML(t *testing.T) {
+	path := writeFile(t, "config.toml", "rest_port = \"9000\"\ngeodesic = \"karney\"\nshutdown_timeout = \"30s\"\n")
+	t.Setenv("TEST_CONFIG", path)
+
+	cfg, _, err := Load("TEST", testDefaults, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "9000", cfg.RestPort)
+	assert.Equal(t, "karney", cfg.Geodesic)
+	assert.Equal(t, 30*time.Second, cfg.ShutdownTimeout)
+}
+
+// TestLoadNested tests setting the fields of a nested struct from every source
+func TestLoadNested(t *testing.T) {
+	path := writeFile(t, "config.yaml", "tracing:\n  exporter: otlp\n  endpoint: collector:4317\n  sample_ratio: 0.5\n")
+	t.Setenv("TEST_TRACING_ENDPOINT", "env:4317")
+
+	cfg, _, err := Load("TEST", testDefaults, []string{"-config", path, "-tracing-sample-ratio", "0.25"})
+	assert.NoError(t, err)
+	assert.Equal(t, TracingConfig{Exporter: "otlp", Endpoint: "env:4317", SampleRatio: 0.25}, cfg.Tracing)
+
+	_, _, err = Load("TEST", testDefaults, []string{"-tracing-exporter", "file"})
+	assert.ErrorContains(t, err, "tracing.file")
+}
+
+// TestLoadValidation tests that all invalid fields are reported at once
+func TestLoadValidation(t *testing.T) {
+	t.Setenv("TEST_REST_PORT", "http")
+	t.Setenv("TEST_GEODESIC", "flat")
+	t.Setenv("TEST_DATABASE_URL", "mysql://localhost/test")
+	t.Setenv("TEST_SHUTDOWN_TIMEOUT", "-1s")
+	t.Setenv("TEST_LOGGING_LEVEL", "verbose")
+	t.Setenv("TEST_TLS_ENABLED", "true")
+	t.Setenv("TEST_TLS_CERT_FILE", "cert.pem")
+
+	_, _, err := Load("TEST", testDefaults, []string{"-grpc-port", "70000"})
+	assert.Error(t, err)
+	assert.ErrorContains(t, err, "rest_port")
+	assert.ErrorContains(t, err, "grpc_port")
+	assert.ErrorContains(t, err, "geodesic")
+	assert.ErrorContains(t, err, "database_url")
+	assert.ErrorContains(t, err, "shutdown_timeout")
+	assert.ErrorContains(t, err, "logging.level")
+	assert.ErrorContains(t, err, "cert_file and key_file")
+
+	t.Setenv("TEST_SHUTDOWN_TIMEOUT", "soon")
+	_, _, err = Load("TEST", testDefaults, nil)
+	assert.ErrorContains(t, err, "not a duration")
+}
+
+// TestLoadUnknownKeys tests that the keys of a configuration file matching no field are reported with the other errors
+func TestLoadUnknownKeys(t *testing.T) {
+	path := writeFile(t, "config.yaml", "rest_prot: 9000\ntracing:\n  exporter: otlp\n  endpiont: collector:4317\n")
+	_, _, err := Load("TEST", testDefaults, []string{"-config", path})
+	assert.ErrorContains(t, err, "rest_prot in "+path+": unknown key")
+	assert.ErrorContains(t, err, "tracing.endpiont in "+path+": unknown key")
+	assert.ErrorContains(t, err, "tracing.endpoint: must be set")
+
+	path = writeFile(t, "config.toml", "geodesic = \"karney\"\n[logging]\nlevle = \"debug\"\n")
+	cfg, _, err := Load("TEST", testDefaults, []string{"-config", path})
+	assert.ErrorContains(t, err, "logging.levle in "+path+": unknown key")
+	assert.Equal(t, "karney", cfg.Geodesic)
+}
+
+// TestLoadInvalidFile tests the errors for unreadable configuration files
+func TestLoadInvalidFile(t *testing.T) {
+	_, _, err := Load("TEST", testDefaults, []string{"-config", "missing.yaml"})
+	assert.Error(t, err)
+
+	path := writeFile(t, "config.json", "{}")
+	_, _, err = Load("TEST", testDefaults, []string{"-config", path})
+	assert.ErrorContains(t, err, "extension")
+}
+
+// TestPrint tests printing the configuration
+func TestPrint(t *testing.T) {
+	_, opts, err := Load("TEST", testDefaults, []string{"-print-config"})
+	assert.NoError(t, err)
+	assert.True(t, opts.PrintConfig)
+
+	var buf bytes.Buffer
+	assert.NoError(t, Print(&buf, testDefaults))
+	assert.Contains(t, buf.String(), "rest_port: \"8000\"")
+	assert.Contains(t, buf.String(), "database_url: test.db")
+	assert.Contains(t, buf.String(), "shutdown_timeout: 10s")
+	assert.Contains(t, buf.String(), "tracing:\n    exporter: none\n")
+
+	// The printed configuration can be loaded back
+	path := writeFile(t, "config.yaml", buf.String())
+	cfg, _, err := Load("TEST", Config{}, []string{"-config", path})
+	assert.NoError(t, err)
+	assert.Equal(t, testDefaults, cfg)
+
+	// Secrets are not printed
+	withToken := testDefaults
+	withToken.AdminToken = "s3cr3t"
+	buf.Reset()
+	assert.NoError(t, Print(&buf, withToken))
+	assert.Contains(t, buf.String(), "admin_token: REDACTED")
+	assert.NotContains(t, buf.String(), "s3cr3t")
+}
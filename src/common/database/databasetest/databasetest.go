@@ -0,0 +1,52 @@
+// Package databasetest provides the PostgreSQL databases of the store tests of the services
+package databasetest
+
+import (
+	"common/database"
+	"fmt"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// POSTGRES_URL_VARIABLE names the environment variable holding the URL of the PostgreSQL server of the tests, see run.sh -p
+const POSTGRES_URL_VARIABLE = "TEST_POSTGRES_URL"
+
+// Postgres returns a connection to a new empty schema of the PostgreSQL server of the tests, dropped when the test ends
+// The test is skipped if no server is configured. The public schema stays on the search path, so that the PostGIS
+// functions installed there can be called
+func Postgres(t testing.TB) *gorm.DB {
+	t.Helper()
+	serverURL := os.Getenv(POSTGRES_URL_VARIABLE)
+	if serverURL == "" {
+		t.Skip(POSTGRES_URL_VARIABLE + " not set")
+	}
+
+	admin, err := database.New(serverURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.Close(admin) })
+	schema := fmt.Sprintf("test_%d", time.Now().UnixNano())
+	if err := admin.Exec("CREATE SCHEMA " + schema).Error; err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { admin.Exec("DROP SCHEMA " + schema + " CASCADE") })
+
+	schemaURL, err := url.Parse(serverURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := schemaURL.Query()
+	query.Set("search_path", schema+",public")
+	schemaURL.RawQuery = query.Encode()
+	db, err := database.New(schemaURL.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.Close(db) })
+	return db
+}
@@ -1,19 +1,59 @@
 package database
 
 import (
+	"errors"
 	"fmt"
 	"log"
-	"os"
+	"strings"
 	"time"
 
+	"gorm.io/driver/postgres"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
 )
 
+// drivers maps the supported URL schemes to functions opening their GORM dialectors
+var drivers = map[string]func(dsn string) gorm.Dialector{
+	"sqlite":     func(dsn string) gorm.Dialector { return sqlite.Open(strings.TrimPrefix(dsn, "sqlite://")) },
+	"file":       sqlite.Open,
+	"postgres":   postgres.Open,
+	"postgresql": postgres.Open,
+}
+
+// MEMORY_URL is the URL of a private in-memory SQLite database, which lives as long as the connection opened by New
+const MEMORY_URL = ":memory:"
+
+// ParseURL returns the dialector for a database URL
+// The URL scheme selects the driver: postgres:// or postgresql:// for PostgreSQL,
+// sqlite:// or file: for SQLite. A URL without a scheme is treated as the path of a SQLite database
+func ParseURL(url string) (gorm.Dialector, error) {
+	if url == "" {
+		return nil, errors.New("database URL is empty")
+	}
+
+	scheme, _, found := strings.Cut(url, ":")
+	if !found || !strings.HasPrefix(url, scheme+"://") && scheme != "file" {
+		return sqlite.Open(url), nil
+	}
+
+	driver, ok := drivers[strings.ToLower(scheme)]
+	if !ok {
+		return nil, fmt.Errorf("unsupported database scheme %q, expected sqlite, file, postgres or postgresql", scheme)
+	}
+
+	return driver(url), nil
+}
+
 // New creates and returns a new GORM database connection
 // It configures a custom logger to log only errors and ignores the ErrRecordNotFound error
-func New(url string) (*gorm.DB) {
+// The driver is selected by the scheme of the URL, see ParseURL
+func New(url string) (*gorm.DB, error) {
+	dialector, err := ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+
 	// Configure the custom logger
 	newLogger := logger.New(
 		log.Default(),
@@ -25,18 +65,41 @@ func New(url string) (*gorm.DB) {
 		},
 	)
 
-	// Open a new SQLite database connection with the custom logger
-	db, err := gorm.Open(sqlite.Open(url), &gorm.Config{
+	// Open a new database connection with the custom logger
+	db, err := gorm.Open(dialector, &gorm.Config{
 		Logger: newLogger,
 	})
-
-	// If there is an error opening the database, log it and exit
 	if err != nil {
-		log.Println("error: ", err.Error())
-		os.Exit(1)
+		return nil, fmt.Errorf("could not open database: %w", err)
+	}
+
+	// An in-memory SQLite database belongs to the connection that created it, so every query must use that one
+	if dialector.Name() == "sqlite" && (url == MEMORY_URL || strings.Contains(url, "mode=memory")) {
+		sqlDB, err := db.DB()
+		if err != nil {
+			return nil, err
+		}
+		sqlDB.SetMaxOpenConns(1)
+		sqlDB.SetConnMaxIdleTime(0)
+		sqlDB.SetConnMaxLifetime(0)
+	}
+
+	return db, nil
+}
+
+// HasPostGIS reports whether the database is PostgreSQL with the PostGIS extension installed
+// Spatial queries can then be evaluated by the database instead of in Go
+func HasPostGIS(db *gorm.DB) bool {
+	if db.Dialector.Name() != "postgres" {
+		return false
+	}
+
+	var count int64
+	if err := db.Raw("SELECT count(*) FROM pg_extension WHERE extname = ?", "postgis").Scan(&count).Error; err != nil {
+		return false
 	}
 
-	return db
+	return count > 0
 }
 
 // Close closes the given GORM database connection
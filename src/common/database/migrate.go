@@ -0,0 +1,234 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrSchemaTooNew is returned when the database was migrated by a newer version of the service
+var ErrSchemaTooNew = errors.New("database schema is newer than the service")
+
+// Migration is a versioned schema change with the steps to apply and to revert it
+// Up and Down run inside a transaction together with the update of the schema_version table
+type Migration struct {
+	Version     int                     // Version reached after applying the migration, strictly increasing from 1
+	Description string                  // Short description shown by the status command
+	Up          func(tx *gorm.DB) error // Applies the change
+	Down        func(tx *gorm.DB) error // Reverts the change, nil if the migration can't be reverted
+}
+
+// SchemaVersion records a migration applied to the database
+type SchemaVersion struct {
+	Version     int       `gorm:"primaryKey;autoIncrement:false"` // Version of the applied migration
+	Description string    // Description of the applied migration
+	AppliedAt   time.Time `gorm:"autoCreateTime"` // Time the migration was applied
+}
+
+// TableName sets the table of the applied migrations to schema_version
+func (SchemaVersion) TableName() string {
+	return "schema_version"
+}
+
+// MigrationStatus describes a known migration and whether it was applied
+type MigrationStatus struct {
+	Version     int        // Version of the migration
+	Description string     // Description of the migration
+	AppliedAt   *time.Time // Time the migration was applied, nil if pending
+}
+
+// checkMigrations verifies that the versions of the migrations start at 1 and increase by one
+func checkMigrations(migrations []Migration) error {
+	for i, migration := range migrations {
+		if migration.Version != i+1 {
+			return fmt.Errorf("migration %q has version %d, expected %d", migration.Description, migration.Version, i+1)
+		}
+		if migration.Up == nil {
+			return fmt.Errorf("migration %d has no up step", migration.Version)
+		}
+	}
+	return nil
+}
+
+// CurrentVersion returns the version of the latest migration applied to the database, 0 if none was applied
+func CurrentVersion(db *gorm.DB) (int, error) {
+	if err := db.AutoMigrate(&SchemaVersion{}); err != nil {
+		return 0, err
+	}
+
+	var version int
+	res := db.Model(&SchemaVersion{}).Select("COALESCE(MAX(Version), 0)").Scan(&version)
+	return version, res.Error
+}
+
+// CheckVersion returns ErrSchemaTooNew if the database has migrations applied that the service doesn't know
+// A service must not start against such a database, since its queries may not match the schema anymore
+func CheckVersion(db *gorm.DB, migrations []Migration) error {
+	current, err := CurrentVersion(db)
+	if err != nil {
+		return err
+	}
+
+	if current > len(migrations) {
+		return fmt.Errorf("%w: database is at version %d, latest known version is %d", ErrSchemaTooNew, current, len(migrations))
+	}
+	return nil
+}
+
+// MigrateUp applies every pending migration in order and returns the number of migrations applied
+// Each migration runs in its own transaction, a failing migration stops the process and leaves the previous ones applied
+func MigrateUp(db *gorm.DB, migrations []Migration) (int, error) {
+	if err := checkMigrations(migrations); err != nil {
+		return 0, err
+	}
+	if err := CheckVersion(db, migrations); err != nil {
+		return 0, err
+	}
+
+	current, err := CurrentVersion(db)
+	if err != nil {
+		return 0, err
+	}
+
+	applied := 0
+	for _, migration := range migrations[current:] {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := migration.Up(tx); err != nil {
+				return err
+			}
+			return tx.Create(&SchemaVersion{Version: migration.Version, Description: migration.Description}).Error
+		})
+		if err != nil {
+			return applied, fmt.Errorf("migration %d (%s) failed: %w", migration.Version, migration.Description, err)
+		}
+		applied++
+	}
+
+	return applied, nil
+}
+
+// MigrateDown reverts the applied migrations newer than the target version, latest first,
+// and returns the number of migrations reverted. A target of 0 reverts every migration
+func MigrateDown(db *gorm.DB, migrations []Migration, target int) (int, error) {
+	if err := checkMigrations(migrations); err != nil {
+		return 0, err
+	}
+	if err := CheckVersion(db, migrations); err != nil {
+		return 0, err
+	}
+	if target < 0 {
+		return 0, fmt.Errorf("target version %d is negative", target)
+	}
+
+	current, err := CurrentVersion(db)
+	if err != nil {
+		return 0, err
+	}
+
+	reverted := 0
+	for version := current; version > target; version-- {
+		migration := migrations[version-1]
+		if migration.Down == nil {
+			return reverted, fmt.Errorf("migration %d (%s) can't be reverted", migration.Version, migration.Description)
+		}
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := migration.Down(tx); err != nil {
+				return err
+			}
+			return tx.Delete(&SchemaVersion{Version: migration.Version}).Error
+		})
+		if err != nil {
+			return reverted, fmt.Errorf("reverting migration %d (%s) failed: %w", migration.Version, migration.Description, err)
+		}
+		reverted++
+	}
+
+	return reverted, nil
+}
+
+// Status lists every known migration with the time it was applied
+func Status(db *gorm.DB, migrations []Migration) ([]MigrationStatus, error) {
+	if err := checkMigrations(migrations); err != nil {
+		return nil, err
+	}
+	if err := CheckVersion(db, migrations); err != nil {
+		return nil, err
+	}
+
+	var versions []SchemaVersion
+	if res := db.Order("Version").Find(&versions); res.Error != nil {
+		return nil, res.Error
+	}
+
+	appliedAt := make(map[int]time.Time, len(versions))
+	for _, version := range versions {
+		appliedAt[version.Version] = version.AppliedAt
+	}
+
+	statuses := make([]MigrationStatus, 0, len(migrations))
+	for _, migration := range migrations {
+		status := MigrationStatus{Version: migration.Version, Description: migration.Description}
+		if at, ok := appliedAt[migration.Version]; ok {
+			status.AppliedAt = &at
+		}
+		statuses = append(statuses, status)
+	}
+
+	return statuses, nil
+}
+
+// RunMigrateCommand runs a migrate subcommand given by its arguments and writes the result to w
+// The subcommands are "up", "down [version]" reverting the latest migration or down to a version, and "status"
+func RunMigrateCommand(db *gorm.DB, migrations []Migration, args []string, w io.Writer) error {
+	if len(args) == 0 {
+		return errors.New("usage: migrate up|down [version]|status")
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := MigrateUp(db, migrations)
+		fmt.Fprintf(w, "Applied %d migration(s)\n", applied)
+		return err
+	case "down":
+		current, err := CurrentVersion(db)
+		if err != nil {
+			return err
+		}
+
+		target := current - 1
+		if len(args) > 1 {
+			target, err = strconv.Atoi(args[1])
+			if err != nil {
+				return fmt.Errorf("version %q is not a number", args[1])
+			}
+		}
+		if target < 0 {
+			target = 0
+		}
+
+		reverted, err := MigrateDown(db, migrations, target)
+		fmt.Fprintf(w, "Reverted %d migration(s)\n", reverted)
+		return err
+	case "status":
+		statuses, err := Status(db, migrations)
+		if err != nil {
+			return err
+		}
+
+		for _, status := range statuses {
+			applied := "pending"
+			if status.AppliedAt != nil {
+				applied = "applied " + status.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%4d  %-40s  %s\n", status.Version, status.Description, applied)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown migrate subcommand %q, expected up, down or status", args[0])
+	}
+}
@@ -0,0 +1,147 @@
+package database
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+// testMigrations creates a table and then adds a column to it
+var testMigrations = []Migration{
+	{
+		Version:     1,
+		Description: "Create notes",
+		Up: func(tx *gorm.DB) error {
+			return tx.Exec("CREATE TABLE notes (id INTEGER PRIMARY KEY, text TEXT)").Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Exec("DROP TABLE notes").Error
+		},
+	},
+	{
+		Version:     2,
+		Description: "Add author to notes",
+		Up: func(tx *gorm.DB) error {
+			return tx.Exec("ALTER TABLE notes ADD COLUMN author TEXT").Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Exec("ALTER TABLE notes DROP COLUMN author").Error
+		},
+	},
+}
+
+// newTestDB opens an empty SQLite database in a temporary directory
+func newTestDB(t *testing.T) *gorm.DB {
+	db, err := New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { Close(db) })
+	return db
+}
+
+// TestMigrateUpDown tests applying and reverting migrations
+func TestMigrateUpDown(t *testing.T) {
+	db := newTestDB(t)
+
+	applied, err := MigrateUp(db, testMigrations)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, applied)
+	assert.True(t, db.Migrator().HasColumn("notes", "author"))
+
+	// Applying again is a no-op
+	applied, err = MigrateUp(db, testMigrations)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, applied)
+
+	reverted, err := MigrateDown(db, testMigrations, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, reverted)
+	assert.False(t, db.Migrator().HasColumn("notes", "author"))
+
+	version, err := CurrentVersion(db)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, version)
+
+	reverted, err = MigrateDown(db, testMigrations, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, reverted)
+	assert.False(t, db.Migrator().HasTable("notes"))
+}
+
+// TestMigrateFailure tests that a failing migration is rolled back and not recorded
+func TestMigrateFailure(t *testing.T) {
+	db := newTestDB(t)
+
+	failing := append([]Migration{}, testMigrations...)
+	failing[1].Up = func(tx *gorm.DB) error {
+		if err := tx.Exec("ALTER TABLE notes ADD COLUMN author TEXT").Error; err != nil {
+			return err
+		}
+		return errors.New("backfill failed")
+	}
+
+	applied, err := MigrateUp(db, failing)
+	assert.ErrorContains(t, err, "backfill failed")
+	assert.Equal(t, 1, applied)
+	assert.False(t, db.Migrator().HasColumn("notes", "author"))
+
+	version, err := CurrentVersion(db)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, version)
+}
+
+// TestSchemaTooNew tests that a database migrated by a newer service is refused
+func TestSchemaTooNew(t *testing.T) {
+	db := newTestDB(t)
+
+	_, err := MigrateUp(db, testMigrations)
+	assert.NoError(t, err)
+
+	older := testMigrations[:1]
+	assert.ErrorIs(t, CheckVersion(db, older), ErrSchemaTooNew)
+
+	_, err = MigrateUp(db, older)
+	assert.ErrorIs(t, err, ErrSchemaTooNew)
+
+	_, err = MigrateDown(db, older, 0)
+	assert.ErrorIs(t, err, ErrSchemaTooNew)
+}
+
+// TestInvalidMigrations tests that gaps in the versions are rejected
+func TestInvalidMigrations(t *testing.T) {
+	db := newTestDB(t)
+
+	_, err := MigrateUp(db, testMigrations[1:])
+	assert.Error(t, err)
+}
+
+// TestRunMigrateCommand tests the migrate subcommands
+func TestRunMigrateCommand(t *testing.T) {
+	db := newTestDB(t)
+	var out bytes.Buffer
+
+	assert.NoError(t, RunMigrateCommand(db, testMigrations, []string{"status"}, &out))
+	assert.Contains(t, out.String(), "pending")
+
+	out.Reset()
+	assert.NoError(t, RunMigrateCommand(db, testMigrations, []string{"up"}, &out))
+	assert.Contains(t, out.String(), "Applied 2 migration(s)")
+
+	out.Reset()
+	assert.NoError(t, RunMigrateCommand(db, testMigrations, []string{"down"}, &out))
+	assert.Contains(t, out.String(), "Reverted 1 migration(s)")
+
+	out.Reset()
+	assert.NoError(t, RunMigrateCommand(db, testMigrations, []string{"status"}, &out))
+	assert.Contains(t, out.String(), "applied")
+	assert.Contains(t, out.String(), "pending")
+
+	assert.Error(t, RunMigrateCommand(db, testMigrations, []string{"down", "two"}, &out))
+	assert.Error(t, RunMigrateCommand(db, testMigrations, []string{"sideways"}, &out))
+	assert.Error(t, RunMigrateCommand(db, testMigrations, nil, &out))
+}
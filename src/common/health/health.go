@@ -0,0 +1,153 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"gorm.io/gorm"
+)
+
+const CHECK_TIMEOUT time.Duration = 2 * time.Second // Time given to all readiness checks together
+
+// Check is a named test of a dependency the service needs to handle requests
+type Check struct {
+	Name string                          // Name of the dependency, reported in the readiness response
+	Run  func(ctx context.Context) error // Returns an error if the dependency is not usable
+}
+
+// report is the body of the liveness and readiness responses
+type report struct {
+	Status string            // "ok" if the service is alive or ready, "unavailable" otherwise
+	Checks map[string]string `json:",omitempty"` // Result of each readiness check, "ok" or the error
+}
+
+// writeReport writes a report as JSON with the given status code
+func writeReport(w http.ResponseWriter, code int, body report) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(body)
+}
+
+// LiveHandler answers liveness probes, it succeeds as long as the process can serve HTTP requests
+func LiveHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeReport(w, http.StatusOK, report{Status: "ok"})
+	})
+}
+
+// ReadyHandler answers readiness probes by running every check
+// It responds with 200 if all checks pass and 503 otherwise, listing the result of each check
+func ReadyHandler(checks ...Check) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), CHECK_TIMEOUT)
+		defer cancel()
+
+		body := report{Status: "ok", Checks: make(map[string]string, len(checks))}
+		code := http.StatusOK
+		for _, check := range checks {
+			if err := check.Run(ctx); err != nil {
+				body.Checks[check.Name] = err.Error()
+				body.Status = "unavailable"
+				code = http.StatusServiceUnavailable
+				continue
+			}
+			body.Checks[check.Name] = "ok"
+		}
+
+		writeReport(w, code, body)
+	})
+}
+
+// PingDatabase checks that the database connection is usable
+func PingDatabase(ctx context.Context, db *gorm.DB) error {
+	if db == nil {
+		return fmt.Errorf("database not connected")
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.PingContext(ctx)
+}
+
+// CheckGRPCService asks the gRPC server at target for the status of a service using the grpc.health.v1 protocol
+// It returns an error unless the service is SERVING
+// The connection is plaintext unless dial options with transport credentials are given
+func CheckGRPCService(ctx context.Context, target string, service string, opts ...grpc.DialOption) error {
+	if len(opts) == 0 {
+		opts = []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	}
+
+	conn, err := grpc.NewClient(target, opts...)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	res, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+	if err != nil {
+		return err
+	}
+
+	if res.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+		return fmt.Errorf("service %q is %s", service, res.GetStatus())
+	}
+	return nil
+}
+
+// WatchDatabase keeps the grpc.health.v1 status of the services in sync with the health of the database
+// It pings the database every interval until the context is done, then marks every service NOT_SERVING
+// The empty service name stands for the whole server and is always included
+func WatchDatabase(ctx context.Context, db *gorm.DB, server *grpchealth.Server, interval time.Duration, services ...string) error {
+	services = append([]string{""}, services...)
+	setStatus := func(status healthpb.HealthCheckResponse_ServingStatus) {
+		for _, service := range services {
+			server.SetServingStatus(service, status)
+		}
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	healthy := true
+	for {
+		pingCtx, cancel := context.WithTimeout(ctx, CHECK_TIMEOUT)
+		err := PingDatabase(pingCtx, db)
+		cancel()
+
+		// Stop before reporting a ping interrupted by the shutdown as a database failure
+		if ctx.Err() != nil {
+			server.Shutdown()
+			return ctx.Err()
+		}
+
+		if err != nil {
+			if healthy {
+				slog.Warn("Database unhealthy", "error", err)
+			}
+			setStatus(healthpb.HealthCheckResponse_NOT_SERVING)
+		} else {
+			if !healthy {
+				slog.Info("Database healthy again")
+			}
+			setStatus(healthpb.HealthCheckResponse_SERVING)
+		}
+		healthy = err == nil
+
+		select {
+		case <-ctx.Done():
+			server.Shutdown()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"common/database"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// TestLiveHandler tests the liveness response
+func TestLiveHandler(t *testing.T) {
+	w := httptest.NewRecorder()
+	LiveHandler().ServeHTTP(w, httptest.NewRequest("GET", "/healthz", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"Status":"ok"}`, w.Body.String())
+}
+
+// TestReadyHandler tests that the readiness response fails if any check fails
+func TestReadyHandler(t *testing.T) {
+	passing := Check{Name: "database", Run: func(ctx context.Context) error { return nil }}
+	failing := Check{Name: "history", Run: func(ctx context.Context) error { return errors.New("unreachable") }}
+
+	w := httptest.NewRecorder()
+	ReadyHandler(passing).ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"Status":"ok","Checks":{"database":"ok"}}`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	ReadyHandler(passing, failing).ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.JSONEq(t, `{"Status":"unavailable","Checks":{"database":"ok","history":"unreachable"}}`, w.Body.String())
+}
+
+// TestPingDatabase tests the database check on an open and a closed connection
+func TestPingDatabase(t *testing.T) {
+	db, err := database.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.NoError(t, PingDatabase(context.Background(), db))
+	database.Close(db)
+	assert.Error(t, PingDatabase(context.Background(), db))
+	assert.Error(t, PingDatabase(context.Background(), nil))
+}
+
+// TestWatchDatabase tests that the gRPC health status follows the database and that CheckGRPCService reports it
+func TestWatchDatabase(t *testing.T) {
+	db, err := database.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Serve the health service on a local port
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	healthServer := grpchealth.NewServer()
+	healthpb.RegisterHealthServer(server, healthServer)
+	go server.Serve(lis)
+	defer server.Stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- WatchDatabase(ctx, db, healthServer, 10*time.Millisecond, "Service") }()
+
+	target := lis.Addr().String()
+	assert.Eventually(t, func() bool { return CheckGRPCService(context.Background(), target, "Service") == nil },
+		time.Second, 10*time.Millisecond)
+
+	// A closed database makes the service unhealthy
+	database.Close(db)
+	assert.Eventually(t, func() bool { return CheckGRPCService(context.Background(), target, "Service") != nil },
+		time.Second, 10*time.Millisecond)
+	assert.ErrorContains(t, CheckGRPCService(context.Background(), target, ""), "NOT_SERVING")
+
+	// Unknown services are reported as errors
+	assert.Error(t, CheckGRPCService(context.Background(), target, "Unknown"))
+
+	cancel()
+	assert.ErrorIs(t, <-done, context.Canceled)
+}
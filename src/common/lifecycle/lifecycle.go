@@ -0,0 +1,232 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// Component is a long running part of a service, such as a server or a background worker
+type Component interface {
+	Name() string                   // Name used in the logs and errors
+	Start() error                   // Runs the component until it is stopped, returns nil on a clean stop
+	Stop(ctx context.Context) error // Drains the component, giving up when the context is done
+}
+
+// Manager starts the components of a service and stops them on shutdown
+type Manager struct {
+	components []Component
+	timeout    time.Duration // Time given to all components together to drain on shutdown
+}
+
+// New creates a manager that gives its components shutdownTimeout to drain
+func New(shutdownTimeout time.Duration) *Manager {
+	return &Manager{timeout: shutdownTimeout}
+}
+
+// Add registers a component, components are stopped in the order they were added
+func (m *Manager) Add(component Component) {
+	m.components = append(m.components, component)
+}
+
+// Run starts every component and blocks until the context is done or a component fails
+// The components are then stopped one after the other within the shutdown timeout
+// The returned error joins the failure that caused the shutdown, if any, with the errors of the shutdown itself
+func (m *Manager) Run(ctx context.Context) error {
+	failures := make(chan error, len(m.components))
+	var running sync.WaitGroup
+
+	// Start every component, a component returning from Start before the shutdown is a failure
+	stopping := make(chan struct{})
+	for _, component := range m.components {
+		slog.Info("Starting component", "component", component.Name())
+		running.Add(1)
+		go func(component Component) {
+			defer running.Done()
+			err := component.Start()
+			select {
+			case <-stopping:
+				if err != nil {
+					slog.Error("Component stopped with error", "component", component.Name(), "error", err)
+				}
+			default:
+				if err == nil {
+					err = errors.New("stopped unexpectedly")
+				}
+				failures <- fmt.Errorf("%s: %w", component.Name(), err)
+			}
+		}(component)
+	}
+
+	// Wait for a reason to shut down
+	var errs []error
+	select {
+	case <-ctx.Done():
+		slog.Info("Shutdown requested, draining")
+	case err := <-failures:
+		slog.Error("Component failed, shutting down", "error", err)
+		errs = append(errs, err)
+	}
+	close(stopping)
+
+	// Drain the components in order, all of them sharing the shutdown timeout
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), m.timeout)
+	defer cancel()
+	for _, component := range m.components {
+		slog.Info("Stopping component", "component", component.Name())
+		if err := component.Stop(shutdownCtx); err != nil {
+			errs = append(errs, fmt.Errorf("stopping %s: %w", component.Name(), err))
+		}
+	}
+
+	// Wait for the components to return from Start, unless the shutdown already timed out
+	stopped := make(chan struct{})
+	go func() {
+		running.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-shutdownCtx.Done():
+	}
+
+	return errors.Join(errs...)
+}
+
+// httpServer runs an http.Server as a component
+type httpServer struct {
+	name   string
+	server *http.Server
+}
+
+// HTTPServer wraps an http.Server listening on its Addr into a component
+// Stopping it waits for the in-flight requests to complete
+func HTTPServer(name string, server *http.Server) Component {
+	return &httpServer{name: name, server: server}
+}
+
+// Name returns the name of the server
+func (s *httpServer) Name() string {
+	return s.name
+}
+
+// Start listens on the server address and serves requests until the server is shut down
+func (s *httpServer) Start() error {
+	if err := s.server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Stop stops accepting connections and waits for the in-flight requests, closing the remaining connections on timeout
+func (s *httpServer) Stop(ctx context.Context) error {
+	err := s.server.Shutdown(ctx)
+	if err != nil {
+		s.server.Close()
+	}
+	return err
+}
+
+// grpcServer runs a grpc.Server as a component
+type grpcServer struct {
+	name     string
+	server   *grpc.Server
+	address  string
+	listener net.Listener // Listener served instead of listening on the address, if not nil
+}
+
+// GRPCServer wraps a grpc.Server listening on address into a component
+// Stopping it waits for the pending RPCs to complete
+func GRPCServer(name string, server *grpc.Server, address string) Component {
+	return &grpcServer{name: name, server: server, address: address}
+}
+
+// GRPCListener wraps a grpc.Server serving the connections of a listener into a component, e.g. an in-process
+// bufconn listener. A server can serve several listeners, stopping any of its components stops it on all of them
+func GRPCListener(name string, server *grpc.Server, lis net.Listener) Component {
+	return &grpcServer{name: name, server: server, address: lis.Addr().String(), listener: lis}
+}
+
+// Name returns the name of the server
+func (s *grpcServer) Name() string {
+	return s.name
+}
+
+// Start listens on the server address, or takes the listener, and serves RPCs until the server is stopped
+func (s *grpcServer) Start() error {
+	lis := s.listener
+	if lis == nil {
+		var err error
+		if lis, err = net.Listen("tcp", s.address); err != nil {
+			return err
+		}
+	}
+
+	slog.Info("Listening", "component", s.name, "address", lis.Addr().String())
+	return s.server.Serve(lis)
+}
+
+// Stop stops accepting RPCs and waits for the pending ones, cancelling them on timeout
+func (s *grpcServer) Stop(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.server.GracefulStop()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.server.Stop()
+		return ctx.Err()
+	}
+}
+
+// worker runs a function as a component
+type worker struct {
+	name   string
+	run    func(ctx context.Context) error
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// Worker wraps a background function into a component
+// The function must return when its context is cancelled, which happens when the worker is stopped
+func Worker(name string, run func(ctx context.Context) error) Component {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &worker{name: name, run: run, ctx: ctx, cancel: cancel, done: make(chan struct{})}
+}
+
+// Name returns the name of the worker
+func (w *worker) Name() string {
+	return w.name
+}
+
+// Start runs the function until it returns, a cancellation is a clean stop
+func (w *worker) Start() error {
+	defer close(w.done)
+	if err := w.run(w.ctx); err != nil && !errors.Is(err, context.Canceled) {
+		return err
+	}
+	return nil
+}
+
+// Stop cancels the context of the function and waits for it to return
+func (w *worker) Stop(ctx context.Context) error {
+	w.cancel()
+	select {
+	case <-w.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
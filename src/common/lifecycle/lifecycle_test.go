@@ -0,0 +1,180 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// freeAddress returns a local address with a port that is currently free
+func freeAddress(t *testing.T) string {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	return lis.Addr().String()
+}
+
+// recorder collects the names of the stopped workers in order
+type recorder struct {
+	mu    sync.Mutex
+	names []string
+}
+
+// worker returns a worker that records its name when it is stopped
+func (r *recorder) worker(name string) Component {
+	return Worker(name, func(ctx context.Context) error {
+		<-ctx.Done()
+		r.mu.Lock()
+		r.names = append(r.names, name)
+		r.mu.Unlock()
+		return ctx.Err()
+	})
+}
+
+// TestRunStopsInOrder tests that cancelling the context stops every component in the order they were added
+func TestRunStopsInOrder(t *testing.T) {
+	var r recorder
+	manager := New(time.Second)
+	manager.Add(r.worker("first"))
+	manager.Add(r.worker("second"))
+	manager.Add(r.worker("third"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	assert.NoError(t, manager.Run(ctx))
+	assert.Equal(t, []string{"first", "second", "third"}, r.names)
+}
+
+// TestRunComponentFailure tests that a failing component shuts the others down and is reported
+func TestRunComponentFailure(t *testing.T) {
+	var r recorder
+	manager := New(time.Second)
+	manager.Add(r.worker("healthy"))
+	manager.Add(Worker("broken", func(ctx context.Context) error {
+		return errors.New("boom")
+	}))
+
+	err := manager.Run(context.Background())
+	assert.ErrorContains(t, err, "broken: boom")
+	assert.Equal(t, []string{"healthy"}, r.names)
+}
+
+// TestRunShutdownTimeout tests that a component that doesn't drain in time is reported and doesn't block the shutdown
+func TestRunShutdownTimeout(t *testing.T) {
+	manager := New(50 * time.Millisecond)
+	manager.Add(Worker("stuck", func(ctx context.Context) error {
+		time.Sleep(time.Second)
+		return nil
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	start := time.Now()
+	err := manager.Run(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+}
+
+// TestHTTPServerDrains tests that in-flight requests complete during the shutdown
+func TestHTTPServerDrains(t *testing.T) {
+	address := freeAddress(t)
+	started := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(100 * time.Millisecond)
+		io.WriteString(w, "done")
+	})
+
+	manager := New(time.Second)
+	manager.Add(HTTPServer("REST server", &http.Server{Addr: address, Handler: handler}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error)
+	go func() { result <- manager.Run(ctx) }()
+
+	// Send a request and shut down while it is being handled
+	response := make(chan string)
+	go func() {
+		var res *http.Response
+		var err error
+		for i := 0; i < 50; i++ {
+			if res, err = http.Get("http://" + address); err == nil {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if err != nil {
+			response <- err.Error()
+			return
+		}
+		defer res.Body.Close()
+		body, _ := io.ReadAll(res.Body)
+		response <- string(body)
+	}()
+
+	<-started
+	cancel()
+	assert.Equal(t, "done", <-response)
+	assert.NoError(t, <-result)
+}
+
+// TestGRPCServer tests starting and stopping a gRPC server and reporting a listen failure
+func TestGRPCServer(t *testing.T) {
+	manager := New(time.Second)
+	manager.Add(GRPCServer("gRPC server", grpc.NewServer(), freeAddress(t)))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	assert.NoError(t, manager.Run(ctx))
+
+	manager = New(time.Second)
+	manager.Add(GRPCServer("gRPC server", grpc.NewServer(), "256.0.0.1:0"))
+	assert.ErrorContains(t, manager.Run(context.Background()), "gRPC server")
+}
+
+// TestGRPCListener tests serving a gRPC server on an in-process listener next to its network listener
+func TestGRPCListener(t *testing.T) {
+	server := grpc.NewServer()
+	healthServer := health.NewServer()
+	healthpb.RegisterHealthServer(server, healthServer)
+	lis := bufconn.Listen(1 << 20)
+
+	manager := New(time.Second)
+	manager.Add(GRPCServer("gRPC server", server, freeAddress(t)))
+	manager.Add(GRPCListener("in-process gRPC server", server, lis))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error)
+	go func() { result <- manager.Run(ctx) }()
+
+	conn, err := grpc.NewClient("passthrough:///bufnet", grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	reply, err := healthpb.NewHealthClient(conn).Check(context.Background(), &healthpb.HealthCheckRequest{})
+	if assert.NoError(t, err) {
+		assert.Equal(t, healthpb.HealthCheckResponse_SERVING, reply.Status)
+	}
+
+	// Stopping the network server stops the in-process one as well
+	cancel()
+	assert.NoError(t, <-result)
+}
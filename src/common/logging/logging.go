@@ -0,0 +1,280 @@
+package logging
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"regexp"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"common/apierror"
+	"common/config"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+const (
+	REQUEST_ID_HEADER   = "X-Request-ID" // HTTP header carrying the request ID
+	REQUEST_ID_METADATA = "x-request-id" // gRPC metadata key carrying the request ID
+)
+
+// requestIDPattern matches the request IDs accepted from callers, others are replaced to keep the logs clean
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+// requestIDKey is the context key under which the request ID is stored
+type requestIDKey struct{}
+
+// WithRequestID returns a copy of the context carrying the request ID
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID carried by the context, or an empty string if there is none
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// NewRequestID generates a random request ID
+func NewRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// requestIDOrNew returns the request ID received from a caller if it is valid, or a new one
+func requestIDOrNew(id string) string {
+	if requestIDPattern.MatchString(id) {
+		return id
+	}
+	return NewRequestID()
+}
+
+// Setup installs a JSON logger writing to the given output as the default slog logger
+// The output is "stdout", "stderr" or the path of a log file, which is appended to and rotated by size and age
+// Messages of the log package are written through the same logger
+// The returned closer releases the log file, it must be called on shutdown
+func Setup(output string, cfg config.LoggingConfig) (io.Closer, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(cfg.Level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q: %w", cfg.Level, err)
+	}
+
+	var w io.WriteCloser
+	switch output {
+	case "stdout":
+		w = nopCloser{os.Stdout}
+	case "stderr":
+		w = nopCloser{os.Stderr}
+	default:
+		w = NewRotatingFile(output, cfg)
+	}
+
+	handler := slog.NewJSONHandler(w, &slog.HandlerOptions{AddSource: true, Level: level})
+	slog.SetDefault(slog.New(contextHandler{handler}))
+	return w, nil
+}
+
+// nopCloser keeps the standard streams open when the logger is closed
+type nopCloser struct {
+	io.Writer
+}
+
+// Close does nothing
+func (nopCloser) Close() error {
+	return nil
+}
+
+// contextHandler adds the request ID and the trace ID carried by the context to every record
+type contextHandler struct {
+	slog.Handler
+}
+
+// Handle adds the identifiers found in the context and passes the record to the wrapped handler
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		record.AddAttrs(slog.String("request_id", id))
+	}
+	if span := trace.SpanContextFromContext(ctx); span.IsValid() {
+		record.AddAttrs(slog.String("trace_id", span.TraceID().String()))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+// WithAttrs returns a handler adding the attributes, keeping the identifiers of the context
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+// WithGroup returns a handler nesting the next attributes in a group, keeping the identifiers of the context
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// RotatingFile is a log file rotated once it reaches a size or an age, see config.LoggingConfig
+// Rotated files are renamed with a timestamp, optionally compressed, and removed past the retention limits
+type RotatingFile struct {
+	logger   *lumberjack.Logger
+	interval time.Duration // Age at which the file is rotated, 0 to rotate by size only
+
+	mu   sync.Mutex
+	next time.Time // Time of the next rotation by age
+}
+
+// NewRotatingFile creates a rotating log file, the file is opened on the first write
+func NewRotatingFile(path string, cfg config.LoggingConfig) *RotatingFile {
+	// Retention is counted in days by the rotation library, round up to keep at least the configured age
+	maxAge := int((cfg.MaxAge + 24*time.Hour - 1) / (24 * time.Hour))
+
+	return &RotatingFile{
+		logger: &lumberjack.Logger{
+			Filename:   path,
+			MaxSize:    cfg.MaxSize,
+			MaxAge:     maxAge,
+			MaxBackups: cfg.MaxBackups,
+			LocalTime:  true,
+			Compress:   cfg.Compress,
+		},
+		interval: cfg.RotateEvery,
+		next:     startedAt(path).Add(cfg.RotateEvery),
+	}
+}
+
+// startedAt returns the time an existing log file was started, so that a file reopened by a restarted process
+// is rotated once it reaches its age rather than the age of the process
+// It is the time of the first record of the file, or its modification time if the record has none, and now if
+// there is no file yet. The modification time is refreshed by every write, so it is only a fallback
+func startedAt(path string) time.Time {
+	file, err := os.Open(path)
+	if err != nil {
+		return time.Now()
+	}
+	defer file.Close()
+
+	line, _ := bufio.NewReader(file).ReadBytes('\n')
+	var record struct {
+		Time time.Time `json:"time"`
+	}
+	if json.Unmarshal(line, &record) == nil && !record.Time.IsZero() {
+		return record.Time
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		return time.Now()
+	}
+	return info.ModTime()
+}
+
+// Write appends to the log file, rotating it first if it is older than the rotation interval
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	if f.interval > 0 {
+		f.mu.Lock()
+		if now := time.Now(); !now.Before(f.next) {
+			f.next = now.Add(f.interval)
+			if err := f.logger.Rotate(); err != nil {
+				f.mu.Unlock()
+				return 0, err
+			}
+		}
+		f.mu.Unlock()
+	}
+	return f.logger.Write(p)
+}
+
+// Rotate closes the log file, renames it with a timestamp and opens a new one
+func (f *RotatingFile) Rotate() error {
+	return f.logger.Rotate()
+}
+
+// Close closes the log file
+func (f *RotatingFile) Close() error {
+	return f.logger.Close()
+}
+
+// GinMiddleware assigns an ID to every request and logs the request once it is handled
+// The ID sent by the caller in the X-Request-ID header is kept if valid, it is returned in the same header and
+// stored in the request context, see RequestID. Panics are logged with their stack and answered with an INTERNAL problem
+func GinMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		id := requestIDOrNew(c.GetHeader(REQUEST_ID_HEADER))
+		c.Request = c.Request.WithContext(WithRequestID(c.Request.Context(), id))
+		c.Header(REQUEST_ID_HEADER, id)
+
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				slog.ErrorContext(c.Request.Context(), "Panic while handling request",
+					"panic", fmt.Sprint(recovered), "stack", string(debug.Stack()))
+				apierror.Abort(c, apierror.New(apierror.INTERNAL, "internal error"))
+			}
+
+			level := slog.LevelInfo
+			if c.Writer.Status() >= 500 {
+				level = slog.LevelError
+			}
+			slog.Log(c.Request.Context(), level, "Request handled",
+				"method", c.Request.Method,
+				"path", c.Request.URL.Path,
+				"route", c.FullPath(),
+				"status", c.Writer.Status(),
+				"duration", time.Since(start),
+				"client_ip", c.ClientIP(),
+			)
+		}()
+
+		c.Next()
+	}
+}
+
+// UnaryServerInterceptor assigns an ID to every RPC and logs the RPC once it is handled
+// The ID sent by the client in the x-request-id metadata is kept if valid and stored in the context, see RequestID
+// Without metadata, the ID already in the context is kept, e.g. the ID of the route of a REST gateway calling the server
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		start := time.Now()
+		id := RequestID(ctx)
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if values := md.Get(REQUEST_ID_METADATA); len(values) > 0 {
+				id = values[0]
+			}
+		}
+		ctx = WithRequestID(ctx, requestIDOrNew(id))
+
+		res, err := handler(ctx, req)
+
+		level := slog.LevelInfo
+		if err != nil {
+			level = slog.LevelError
+		}
+		slog.Log(ctx, level, "RPC handled",
+			"method", info.FullMethod,
+			"code", status.Code(err).String(),
+			"duration", time.Since(start),
+		)
+		return res, err
+	}
+}
+
+// UnaryClientInterceptor sends the request ID carried by the context to the server in the x-request-id metadata
+func UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if id := RequestID(ctx); id != "" {
+			ctx = metadata.AppendToOutgoingContext(ctx, REQUEST_ID_METADATA, id)
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
@@ -0,0 +1,216 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"common/apierror"
+	"common/config"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// captureLogs installs a default logger writing the records to a buffer, restoring the previous logger after the test
+func captureLogs(t *testing.T) *bytes.Buffer {
+	previous := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(previous) })
+
+	var buf bytes.Buffer
+	slog.SetDefault(slog.New(contextHandler{slog.NewJSONHandler(&buf, nil)}))
+	return &buf
+}
+
+// records decodes the JSON records written to a log
+func records(t *testing.T, content string) []map[string]any {
+	var result []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(content), "\n") {
+		record := make(map[string]any)
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("invalid record %q: %v", line, err)
+		}
+		result = append(result, record)
+	}
+	return result
+}
+
+// TestSetup tests that records are appended to the log file as JSON with the request ID and above the level
+func TestSetup(t *testing.T) {
+	previous := slog.Default()
+	defer slog.SetDefault(previous)
+
+	path := filepath.Join(t.TempDir(), "test.log")
+	assert.NoError(t, os.WriteFile(path, []byte(`{"msg":"previous run"}`+"\n"), 0644))
+
+	closer, err := Setup(path, config.LoggingConfig{Level: "info", MaxSize: 1})
+	assert.NoError(t, err)
+	slog.DebugContext(context.Background(), "Hidden")
+	slog.InfoContext(WithRequestID(context.Background(), "abc"), "Handled", "status", 200)
+	log.Println("From the log package")
+	assert.NoError(t, closer.Close())
+
+	content, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	logged := records(t, string(content))
+	if assert.Len(t, logged, 3) {
+		assert.Equal(t, "previous run", logged[0]["msg"])
+		assert.Equal(t, "Handled", logged[1]["msg"])
+		assert.Equal(t, "INFO", logged[1]["level"])
+		assert.Equal(t, "abc", logged[1]["request_id"])
+		assert.Equal(t, 200.0, logged[1]["status"])
+		assert.Equal(t, "From the log package", logged[2]["msg"])
+	}
+
+	_, err = Setup("stdout", config.LoggingConfig{Level: "verbose"})
+	assert.Error(t, err)
+}
+
+// TestRotatingFileByAge tests that the log file is rotated once it is older than the rotation interval
+func TestRotatingFileByAge(t *testing.T) {
+	dir := t.TempDir()
+	file := NewRotatingFile(filepath.Join(dir, "test.log"), config.LoggingConfig{MaxSize: 1, RotateEvery: 50 * time.Millisecond})
+	defer file.Close()
+
+	_, err := file.Write([]byte("first\n"))
+	assert.NoError(t, err)
+	_, err = file.Write([]byte("second\n"))
+	assert.NoError(t, err)
+	time.Sleep(60 * time.Millisecond)
+	_, err = file.Write([]byte("third\n"))
+	assert.NoError(t, err)
+
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+
+	content, err := os.ReadFile(filepath.Join(dir, "test.log"))
+	assert.NoError(t, err)
+	assert.Equal(t, "third\n", string(content))
+}
+
+// TestRotatingFileReopened tests that a reopened log file is rotated by the age of its first record,
+// not by the time it was reopened
+func TestRotatingFileReopened(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test.log")
+	cfg := config.LoggingConfig{MaxSize: 1, RotateEvery: time.Hour}
+
+	// A file started two hours ago and written a moment ago is rotated by the first write
+	old := `{"time":"` + time.Now().Add(-2*time.Hour).Format(time.RFC3339Nano) + `","level":"INFO","msg":"first"}` + "\n"
+	assert.NoError(t, os.WriteFile(path, []byte(old), 0o644))
+	file := NewRotatingFile(path, cfg)
+	_, err := file.Write([]byte("second\n"))
+	assert.NoError(t, err)
+	assert.NoError(t, file.Close())
+
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+
+	// The new file is younger than the interval and kept when reopened
+	file = NewRotatingFile(path, cfg)
+	_, err = file.Write([]byte("third\n"))
+	assert.NoError(t, err)
+	assert.NoError(t, file.Close())
+
+	entries, err = os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+	content, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "second\nthird\n", string(content))
+}
+
+// TestGinMiddleware tests that requests keep a valid ID from the caller, get a new one otherwise, and are logged
+func TestGinMiddleware(t *testing.T) {
+	buf := captureLogs(t)
+
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.Use(GinMiddleware())
+	engine.GET("/users/:name", func(c *gin.Context) {
+		c.String(http.StatusOK, RequestID(c.Request.Context()))
+	})
+	engine.GET("/panic", func(c *gin.Context) { panic("boom") })
+
+	// The ID of the caller is kept
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/users/alice", nil)
+	req.Header.Set(REQUEST_ID_HEADER, "caller-id")
+	engine.ServeHTTP(w, req)
+	assert.Equal(t, "caller-id", w.Body.String())
+	assert.Equal(t, "caller-id", w.Header().Get(REQUEST_ID_HEADER))
+
+	// An invalid ID is replaced
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest("GET", "/users/bob", nil)
+	req.Header.Set(REQUEST_ID_HEADER, "bad id\n")
+	engine.ServeHTTP(w, req)
+	assert.Len(t, w.Body.String(), 32)
+	assert.Equal(t, w.Body.String(), w.Header().Get(REQUEST_ID_HEADER))
+
+	// Panics are answered with an INTERNAL problem and logged
+	w = httptest.NewRecorder()
+	engine.ServeHTTP(w, httptest.NewRequest("GET", "/panic", nil))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, apierror.CONTENT_TYPE, w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Body.String(), `"code":"INTERNAL"`)
+
+	logged := records(t, buf.String())
+	if assert.Len(t, logged, 4) {
+		assert.Equal(t, "Request handled", logged[0]["msg"])
+		assert.Equal(t, "caller-id", logged[0]["request_id"])
+		assert.Equal(t, "/users/:name", logged[0]["route"])
+		assert.Equal(t, "Panic while handling request", logged[2]["msg"])
+		assert.Equal(t, "boom", logged[2]["panic"])
+		assert.Equal(t, "ERROR", logged[3]["level"])
+		assert.Equal(t, 500.0, logged[3]["status"])
+	}
+}
+
+// TestUnaryInterceptors tests that the request ID of the client is received by the server
+func TestUnaryInterceptors(t *testing.T) {
+	captureLogs(t)
+
+	// The client sends the ID of its context in the metadata
+	var sent metadata.MD
+	invoker := func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		sent, _ = metadata.FromOutgoingContext(ctx)
+		return nil
+	}
+	ctx := WithRequestID(context.Background(), "caller-id")
+	assert.NoError(t, UnaryClientInterceptor()(ctx, "/Service/Method", nil, nil, nil, invoker))
+	assert.Equal(t, []string{"caller-id"}, sent.Get(REQUEST_ID_METADATA))
+
+	// The server stores the received ID in the context of the handler, or a new one
+	var received string
+	handler := func(ctx context.Context, req any) (any, error) {
+		received = RequestID(ctx)
+		return nil, nil
+	}
+	info := &grpc.UnaryServerInfo{FullMethod: "/Service/Method"}
+	_, err := UnaryServerInterceptor()(metadata.NewIncomingContext(context.Background(), sent), nil, info, handler)
+	assert.NoError(t, err)
+	assert.Equal(t, "caller-id", received)
+
+	_, err = UnaryServerInterceptor()(context.Background(), nil, info, handler)
+	assert.NoError(t, err)
+	assert.Len(t, received, 32)
+
+	// A handler called in process, without metadata, keeps the ID of its caller
+	_, err = UnaryServerInterceptor()(WithRequestID(context.Background(), "route-id"), nil, info, handler)
+	assert.NoError(t, err)
+	assert.Equal(t, "route-id", received)
+}
@@ -0,0 +1,264 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "Number of HTTP requests handled, by method, route and status code",
+	}, []string{"method", "route", "code"})
+
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "Time spent handling HTTP requests, by method, route and status code",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route", "code"})
+
+	grpcServerHandled = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "grpc_server_handled_total",
+		Help: "Number of RPCs handled by the server, by method and status code",
+	}, []string{"method", "code"})
+
+	grpcServerDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "grpc_server_handling_seconds",
+		Help:    "Time spent handling RPCs, by method and status code",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "code"})
+
+	grpcClientHandled = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "grpc_client_handled_total",
+		Help: "Number of RPCs completed by the client, by method and status code",
+	}, []string{"method", "code"})
+
+	grpcClientDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "grpc_client_handling_seconds",
+		Help:    "Time spent waiting for RPCs, by method and status code",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "code"})
+
+	dbDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "db_query_duration_seconds",
+		Help:    "Time spent executing database statements, by operation and table",
+		Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"operation", "table"})
+
+	dbErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "db_query_errors_total",
+		Help: "Number of failed database statements, by operation and table",
+	}, []string{"operation", "table"})
+)
+
+// Handler serves the metrics in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// GinMiddleware counts and times every request by method, route template and status code
+// Requests that match no route are reported with the route "unmatched" to keep the number of series bounded
+func GinMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+
+		code := strconv.Itoa(c.Writer.Status())
+		httpRequests.WithLabelValues(c.Request.Method, route, code).Inc()
+		httpDuration.WithLabelValues(c.Request.Method, route, code).Observe(time.Since(start).Seconds())
+	}
+}
+
+// UnaryServerInterceptor counts and times the RPCs handled by a gRPC server by method and status code
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		start := time.Now()
+		res, err := handler(ctx, req)
+
+		code := status.Code(err).String()
+		grpcServerHandled.WithLabelValues(info.FullMethod, code).Inc()
+		grpcServerDuration.WithLabelValues(info.FullMethod, code).Observe(time.Since(start).Seconds())
+		return res, err
+	}
+}
+
+// UnaryClientInterceptor counts and times the RPCs sent by a gRPC client by method and status code
+func UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		start := time.Now()
+		err := invoker(ctx, method, req, reply, cc, opts...)
+
+		code := status.Code(err).String()
+		grpcClientHandled.WithLabelValues(method, code).Inc()
+		grpcClientDuration.WithLabelValues(method, code).Observe(time.Since(start).Seconds())
+		return err
+	}
+}
+
+// ROW_COUNT_INTERVAL is the interval between the counts of the rows of the services, which are too costly for every scrape
+const ROW_COUNT_INTERVAL = 30 * time.Second
+
+// RowCounter reports the number of rows in the table of a model, for every database added to it
+// The rows are counted in the background on an interval and the last counts are sent on every scrape. The gauges are
+// labeled by the address of the instance owning the database, so that the instances of a process, e.g. the services of
+// the combined binary or of the tests, are reported side by side. A gauge is NaN while its rows can't be counted
+type RowCounter struct {
+	desc     *prometheus.Desc
+	model    any
+	interval time.Duration
+	mu       sync.Mutex
+	dbs      map[string]*countedDB // Counted databases by address of their instance
+}
+
+// countedDB is a database added to a RowCounter with its last count
+type countedDB struct {
+	db    *gorm.DB
+	count float64       // Last count of the rows, NaN if it failed, guarded by the mutex of the counter
+	stop  chan struct{} // Closed to stop the counting
+	done  chan struct{} // Closed once the counting has stopped
+}
+
+// NewRowCounter registers a row counter of the table of a model, counting the rows of every database added to it every
+// interval. It counts no database until one is added
+func NewRowCounter(name string, help string, model any, interval time.Duration) *RowCounter {
+	counter := &RowCounter{
+		desc:     prometheus.NewDesc(name, help, []string{"address"}, nil),
+		model:    model,
+		interval: interval,
+		dbs:      make(map[string]*countedDB),
+	}
+	prometheus.MustRegister(counter)
+	return counter
+}
+
+// Add counts the rows of db for the instance at address, e.g. the address of its REST API, until the returned
+// function is called. The rows are counted once before Add returns, then every interval in the background. A database
+// added later for the same address replaces db
+func (c *RowCounter) Add(address string, db *gorm.DB) (remove func()) {
+	entry := &countedDB{db: db, count: c.countRows(db), stop: make(chan struct{}), done: make(chan struct{})}
+	go c.refresh(entry)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.dbs[address] = entry
+	return func() {
+		close(entry.stop)
+		<-entry.done
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.dbs[address] == entry {
+			delete(c.dbs, address)
+		}
+	}
+}
+
+// refresh counts the rows of a database every interval until its counting is stopped
+func (c *RowCounter) refresh(entry *countedDB) {
+	defer close(entry.done)
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-entry.stop:
+			return
+		case <-ticker.C:
+			count := c.countRows(entry.db)
+			c.mu.Lock()
+			entry.count = count
+			c.mu.Unlock()
+		}
+	}
+}
+
+// countRows counts the rows of the table of the model in db, NaN if they can't be counted
+func (c *RowCounter) countRows(db *gorm.DB) float64 {
+	var rows int64
+	if err := db.Model(c.model).Count(&rows).Error; err != nil {
+		return math.NaN()
+	}
+	return float64(rows)
+}
+
+// Describe sends the description of the gauges, see prometheus.Collector
+func (c *RowCounter) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+// Collect sends the gauges of the last counts of every database, see prometheus.Collector
+func (c *RowCounter) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for address, entry := range c.dbs {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, entry.count, address)
+	}
+}
+
+// startKey is the key under which the start time of a statement is stored in the GORM instance
+const startKey = "metrics:start"
+
+// InstrumentGORM times every statement executed through the database connection by operation and table
+// Statements without a model, such as raw SQL, are reported with the table "none"
+func InstrumentGORM(db *gorm.DB) error {
+	callback := db.Callback()
+	return errors.Join(
+		callback.Create().Before("gorm:create").Register("metrics:before_create", startTimer),
+		callback.Create().After("gorm:create").Register("metrics:after_create", observeTimer("create")),
+		callback.Query().Before("gorm:query").Register("metrics:before_query", startTimer),
+		callback.Query().After("gorm:query").Register("metrics:after_query", observeTimer("query")),
+		callback.Update().Before("gorm:update").Register("metrics:before_update", startTimer),
+		callback.Update().After("gorm:update").Register("metrics:after_update", observeTimer("update")),
+		callback.Delete().Before("gorm:delete").Register("metrics:before_delete", startTimer),
+		callback.Delete().After("gorm:delete").Register("metrics:after_delete", observeTimer("delete")),
+		callback.Row().Before("gorm:row").Register("metrics:before_row", startTimer),
+		callback.Row().After("gorm:row").Register("metrics:after_row", observeTimer("row")),
+		callback.Raw().Before("gorm:raw").Register("metrics:before_raw", startTimer),
+		callback.Raw().After("gorm:raw").Register("metrics:after_raw", observeTimer("raw")),
+	)
+}
+
+// startTimer stores the start time of a statement in its GORM instance
+func startTimer(db *gorm.DB) {
+	db.InstanceSet(startKey, time.Now())
+}
+
+// observeTimer returns a callback recording the duration and the failure of a statement
+// A missing record is not counted as a failure
+func observeTimer(operation string) func(db *gorm.DB) {
+	return func(db *gorm.DB) {
+		value, ok := db.InstanceGet(startKey)
+		if !ok {
+			return
+		}
+
+		table := db.Statement.Table
+		if table == "" {
+			table = "none"
+		}
+
+		dbDuration.WithLabelValues(operation, table).Observe(time.Since(value.(time.Time)).Seconds())
+		if db.Error != nil && !errors.Is(db.Error, gorm.ErrRecordNotFound) {
+			dbErrors.WithLabelValues(operation, table).Inc()
+		}
+	}
+}
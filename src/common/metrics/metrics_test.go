@@ -0,0 +1,117 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"common/database"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// TestGinMiddleware tests that requests are counted by route template and status code
+func TestGinMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.Use(GinMiddleware())
+	engine.GET("/users/:name", func(c *gin.Context) { c.Status(http.StatusNoContent) })
+	engine.GET("/metrics", gin.WrapH(Handler()))
+
+	for _, path := range []string{"/users/alice", "/users/bob", "/missing"} {
+		engine.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(httpRequests.WithLabelValues("GET", "/users/:name", "204")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(httpRequests.WithLabelValues("GET", "unmatched", "404")))
+
+	// The metrics are exposed in the Prometheus text format
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `http_request_duration_seconds_bucket{code="204",method="GET",route="/users/:name"`)
+}
+
+// TestUnaryInterceptors tests that RPCs are counted by method and status code on both sides
+func TestUnaryInterceptors(t *testing.T) {
+	info := &grpc.UnaryServerInfo{FullMethod: "/Service/Method"}
+	handler := func(ctx context.Context, req any) (any, error) {
+		return nil, status.Error(codes.InvalidArgument, "bad")
+	}
+	_, err := UnaryServerInterceptor()(context.Background(), nil, info, handler)
+	assert.Error(t, err)
+	assert.Equal(t, 1.0, testutil.ToFloat64(grpcServerHandled.WithLabelValues("/Service/Method", "InvalidArgument")))
+
+	invoker := func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		return nil
+	}
+	assert.NoError(t, UnaryClientInterceptor()(context.Background(), "/Service/Method", nil, nil, nil, invoker))
+	assert.Equal(t, 1.0, testutil.ToFloat64(grpcClientHandled.WithLabelValues("/Service/Method", "OK")))
+}
+
+// TestInstrumentGORM tests that statements are timed by operation and table
+func TestInstrumentGORM(t *testing.T) {
+	db, err := database.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close(db)
+	assert.NoError(t, InstrumentGORM(db))
+
+	type note struct {
+		ID   uint
+		Text string
+	}
+	assert.NoError(t, db.AutoMigrate(&note{}))
+	assert.NoError(t, db.Create(&note{Text: "hello"}).Error)
+
+	var notes []note
+	assert.NoError(t, db.Find(&notes).Error)
+	assert.Error(t, db.Table("missing").Find(&notes).Error)
+
+	var created dto.Metric
+	assert.NoError(t, dbDuration.WithLabelValues("create", "notes").(prometheus.Metric).Write(&created))
+	assert.Equal(t, uint64(1), created.GetHistogram().GetSampleCount())
+	assert.Equal(t, 1.0, testutil.ToFloat64(dbErrors.WithLabelValues("query", "missing")))
+
+	// Row counts follow the table of every added database, labeled by instance, and are NaN while they can't be counted
+	counter := NewRowCounter("test_notes", "Number of notes", &note{}, 10*time.Millisecond)
+	assert.Equal(t, 0, testutil.CollectAndCount(counter))
+	remove := counter.Add("localhost:8000", db)
+	other, err := database.New(filepath.Join(t.TempDir(), "other.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close(other)
+	removeOther := counter.Add("localhost:8001", other)
+	assert.NoError(t, testutil.CollectAndCompare(counter, strings.NewReader(`# HELP test_notes Number of notes
+# TYPE test_notes gauge
+test_notes{address="localhost:8000"} 1
+test_notes{address="localhost:8001"} NaN
+`)))
+
+	// The scrapes report the last count until the rows are counted again
+	assert.NoError(t, db.Create(&note{Text: "again"}).Error)
+	assert.Eventually(t, func() bool {
+		return testutil.CollectAndCompare(counter, strings.NewReader(`# HELP test_notes Number of notes
+# TYPE test_notes gauge
+test_notes{address="localhost:8000"} 2
+test_notes{address="localhost:8001"} NaN
+`)) == nil
+	}, time.Second, 10*time.Millisecond)
+
+	remove()
+	removeOther()
+	assert.Equal(t, 0, testutil.CollectAndCount(counter))
+}
@@ -0,0 +1,162 @@
+package openapi
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/gin-gonic/gin"
+)
+
+// ginParamPattern matches the parameters of a Gin route, ":name" or "*name"
+var ginParamPattern = regexp.MustCompile(`[:*]([A-Za-z0-9_]+)`)
+
+// Load parses an OpenAPI 3 document and checks that it is valid
+func Load(spec []byte) (*openapi3.T, error) {
+	loader := openapi3.NewLoader()
+	doc, err := loader.LoadFromData(spec)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse OpenAPI document: %w", err)
+	}
+
+	if err := doc.Validate(loader.Context); err != nil {
+		return nil, fmt.Errorf("invalid OpenAPI document: %w", err)
+	}
+	return doc, nil
+}
+
+// Handler serves an OpenAPI document
+func Handler(spec []byte) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Data(http.StatusOK, "application/json", spec)
+	}
+}
+
+// ginPathToOpenAPI converts a Gin route such as /distance/:username into an OpenAPI path such as /distance/{username}
+func ginPathToOpenAPI(path string) string {
+	return ginParamPattern.ReplaceAllString(path, "{$1}")
+}
+
+// CheckRoutes compares the routes of a Gin engine with the operations of a document
+// It returns an error listing the routes that are not documented and the operations that are not served
+func CheckRoutes(doc *openapi3.T, routes gin.RoutesInfo) error {
+	served := make(map[string]bool)
+	var errs []string
+	for _, route := range routes {
+		path := ginPathToOpenAPI(route.Path)
+		served[route.Method+" "+path] = true
+
+		if item := doc.Paths.Value(path); item == nil || item.GetOperation(route.Method) == nil {
+			errs = append(errs, fmt.Sprintf("%s %s is not documented", route.Method, path))
+		}
+	}
+
+	for path, item := range doc.Paths.Map() {
+		for method := range item.Operations() {
+			if !served[method+" "+path] {
+				errs = append(errs, fmt.Sprintf("%s %s is documented but not served", method, path))
+			}
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	sort.Strings(errs)
+	return errors.New(strings.Join(errs, "\n"))
+}
+
+// responseRecorder keeps a copy of the response body while writing it to the client
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+// Write writes the data to the client and keeps a copy
+func (r *responseRecorder) Write(data []byte) (int, error) {
+	r.body.Write(data)
+	return r.ResponseWriter.Write(data)
+}
+
+// WriteString writes the string to the client and keeps a copy
+func (r *responseRecorder) WriteString(s string) (int, error) {
+	r.body.WriteString(s)
+	return r.ResponseWriter.WriteString(s)
+}
+
+// ValidationMiddleware checks the requests and the responses of the engine against a document
+// A request rejected by the document must be answered with a client error, and every response must match the
+// responses documented for the operation. Violations, including requests to undocumented routes, are passed to
+// report once the request is handled. The middleware reads every body into memory and is meant for tests
+func ValidationMiddleware(doc *openapi3.T, report func(c *gin.Context, err error)) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.FullPath() == "" {
+			c.Next()
+			return
+		}
+
+		// Find the documented operation of the route
+		path := ginPathToOpenAPI(c.FullPath())
+		item := doc.Paths.Value(path)
+		if item == nil || item.GetOperation(c.Request.Method) == nil {
+			c.Next()
+			report(c, fmt.Errorf("%s %s is not documented", c.Request.Method, path))
+			return
+		}
+		route := &routers.Route{
+			Spec:      doc,
+			Path:      path,
+			PathItem:  item,
+			Method:    c.Request.Method,
+			Operation: item.GetOperation(c.Request.Method),
+		}
+
+		// Validate a copy of the request, the handler reads the original body
+		var body []byte
+		if c.Request.Body != nil {
+			body, _ = io.ReadAll(c.Request.Body)
+			c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		}
+		request := c.Request.Clone(c.Request.Context())
+		request.Body = io.NopCloser(bytes.NewReader(body))
+
+		pathParams := make(map[string]string, len(c.Params))
+		for _, param := range c.Params {
+			pathParams[param.Key] = param.Value
+		}
+		// The credentials are checked by the handlers, not against the security schemes of the document
+		input := &openapi3filter.RequestValidationInput{Request: request, PathParams: pathParams, Route: route,
+			Options: &openapi3filter.Options{AuthenticationFunc: openapi3filter.NoopAuthenticationFunc}}
+		requestErr := openapi3filter.ValidateRequest(c.Request.Context(), input)
+
+		// Handle the request, keeping the response
+		recorder := &responseRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		c.Next()
+		c.Writer = recorder.ResponseWriter
+
+		status := c.Writer.Status()
+		if requestErr != nil && status < http.StatusBadRequest {
+			report(c, fmt.Errorf("%s %s: request violates the specification but was answered with %d: %w",
+				c.Request.Method, path, status, requestErr))
+		}
+
+		err := openapi3filter.ValidateResponse(c.Request.Context(), &openapi3filter.ResponseValidationInput{
+			RequestValidationInput: input,
+			Status:                 status,
+			Header:                 c.Writer.Header(),
+			Body:                   io.NopCloser(bytes.NewReader(recorder.body.Bytes())),
+		})
+		if err != nil {
+			report(c, fmt.Errorf("%s %s: response %d violates the specification: %w", c.Request.Method, path, status, err))
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package openapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// testSpec documents a single operation returning a greeting
+var testSpec = []byte(`{
+  "openapi": "3.0.3",
+  "info": {"title": "Test", "version": "1.0.0"},
+  "paths": {
+    "/hello/{name}": {
+      "get": {
+        "parameters": [
+          {"name": "name", "in": "path", "required": true, "schema": {"type": "string", "minLength": 3}}
+        ],
+        "responses": {
+          "200": {
+            "description": "A greeting",
+            "content": {"application/json": {"schema": {
+              "type": "object", "required": ["Greeting"], "properties": {"Greeting": {"type": "string"}}
+            }}}
+          },
+          "400": {
+            "description": "Invalid name",
+            "content": {"application/json": {"schema": {
+              "type": "object", "required": ["error"], "properties": {"error": {"type": "string"}}
+            }}}
+          }
+        }
+      }
+    }
+  }
+}`)
+
+// TestLoad tests that only valid documents are loaded
+func TestLoad(t *testing.T) {
+	doc, err := Load(testSpec)
+	assert.NoError(t, err)
+	assert.NotNil(t, doc.Paths.Value("/hello/{name}"))
+
+	_, err = Load([]byte(`{"openapi": `))
+	assert.Error(t, err)
+
+	// The info object is required
+	_, err = Load([]byte(`{"openapi": "3.0.3", "paths": {}}`))
+	assert.Error(t, err)
+}
+
+// TestCheckRoutes tests that undocumented routes and unserved operations are reported
+func TestCheckRoutes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	doc, err := Load(testSpec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	engine := gin.New()
+	engine.GET("/hello/:name", func(c *gin.Context) {})
+	assert.NoError(t, CheckRoutes(doc, engine.Routes()))
+
+	engine.POST("/hello/:name", func(c *gin.Context) {})
+	err = CheckRoutes(doc, engine.Routes())
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "POST /hello/{name} is not documented")
+	}
+
+	err = CheckRoutes(doc, gin.New().Routes())
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "GET /hello/{name} is documented but not served")
+	}
+}
+
+// TestValidationMiddleware tests that the requests and responses not matching the document are reported
+func TestValidationMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	doc, err := Load(testSpec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var violations []string
+	engine := gin.New()
+	engine.Use(ValidationMiddleware(doc, func(c *gin.Context, err error) {
+		violations = append(violations, err.Error())
+	}))
+	engine.GET("/hello/:name", func(c *gin.Context) {
+		name := c.Param("name")
+		switch {
+		case name == "nobody":
+			c.JSON(http.StatusOK, gin.H{"Message": "hello"})
+		case name == "ok" || len(name) >= 3:
+			c.JSON(http.StatusOK, gin.H{"Greeting": "hello " + name})
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "name too short"})
+		}
+	})
+	engine.GET("/secret", func(c *gin.Context) {})
+
+	tests := []struct {
+		path      string
+		violation string
+	}{
+		{"/hello/alice", ""},
+		{"/hello/al", ""},
+		{"/hello/ok", "request violates the specification but was answered with 200"},
+		{"/hello/nobody", "response 200 violates the specification"},
+		{"/secret", "GET /secret is not documented"},
+		{"/missing", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			violations = nil
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", tt.path, nil)
+			engine.ServeHTTP(w, req)
+
+			if tt.violation == "" {
+				assert.Empty(t, violations)
+			} else if assert.Len(t, violations, 1) {
+				assert.True(t, strings.Contains(violations[0], tt.violation), violations[0])
+			}
+		})
+	}
+
+	// The response still reaches the client
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/hello/alice", nil)
+	engine.ServeHTTP(w, req)
+	assert.JSONEq(t, `{"Greeting": "hello alice"}`, w.Body.String())
+}
@@ -1,6 +1,13 @@
+// Generate the Go code from this directory with protoc-gen-go, protoc-gen-go-grpc and protoc-gen-grpc-gateway,
+// google/api/annotations.proto comes from https://github.com/googleapis/googleapis
+//
+//   protoc -I . -I <googleapis> --go_out=../src/common/protobuff --go_opt=paths=source_relative \
+//       --go-grpc_out=../src/common/protobuff --go-grpc_opt=paths=source_relative \
+//       --grpc-gateway_out=../src/common/protobuff --grpc-gateway_opt=paths=source_relative spec.proto
+
 // Code generated by protoc-gen-go-grpc. DO NOT EDIT.
 // versions:
-// - protoc-gen-go-grpc v1.4.0
+// - protoc-gen-go-grpc v1.5.1
 // - protoc             v5.27.1
 // source: spec.proto
 
@@ -15,8 +22,8 @@ import (
 
 // This is a compile-time assertion to ensure that this generated file
 // is compatible with the grpc package it is being compiled against.
-// Requires gRPC-Go v1.62.0 or later.
-const _ = grpc.SupportPackageIsVersion8
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
 
 const (
 	LocationHistoryService_UpdateHistory_FullMethodName = "/LocationHistoryService/UpdateHistory"
@@ -26,6 +33,7 @@ const (
 //
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type LocationHistoryServiceClient interface {
+	// Also served over REST/JSON by the location history service, next to its Gin routes
 	UpdateHistory(ctx context.Context, in *LocationUpdateRequest, opts ...grpc.CallOption) (*LocationUpdateReply, error)
 }
 
@@ -49,21 +57,26 @@ func (c *locationHistoryServiceClient) UpdateHistory(ctx context.Context, in *Lo
 
 // LocationHistoryServiceServer is the server API for LocationHistoryService service.
 // All implementations must embed UnimplementedLocationHistoryServiceServer
-// for forward compatibility
+// for forward compatibility.
 type LocationHistoryServiceServer interface {
+	// Also served over REST/JSON by the location history service, next to its Gin routes
 	UpdateHistory(context.Context, *LocationUpdateRequest) (*LocationUpdateReply, error)
 	mustEmbedUnimplementedLocationHistoryServiceServer()
 }
 
-// UnimplementedLocationHistoryServiceServer must be embedded to have forward compatible implementations.
-type UnimplementedLocationHistoryServiceServer struct {
-}
+// UnimplementedLocationHistoryServiceServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedLocationHistoryServiceServer struct{}
 
 func (UnimplementedLocationHistoryServiceServer) UpdateHistory(context.Context, *LocationUpdateRequest) (*LocationUpdateReply, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method UpdateHistory not implemented")
 }
 func (UnimplementedLocationHistoryServiceServer) mustEmbedUnimplementedLocationHistoryServiceServer() {
 }
+func (UnimplementedLocationHistoryServiceServer) testEmbeddedByValue() {}
 
 // UnsafeLocationHistoryServiceServer may be embedded to opt out of forward compatibility for this service.
 // Use of this interface is not recommended, as added methods to LocationHistoryServiceServer will
@@ -73,6 +86,13 @@ type UnsafeLocationHistoryServiceServer interface {
 }
 
 func RegisterLocationHistoryServiceServer(s grpc.ServiceRegistrar, srv LocationHistoryServiceServer) {
+	// If the following call pancis, it indicates UnimplementedLocationHistoryServiceServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
 	s.RegisterService(&LocationHistoryService_ServiceDesc, srv)
 }
 
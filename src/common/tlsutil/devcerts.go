@@ -0,0 +1,134 @@
+package tlsutil
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const DEV_CERTIFICATE_VALIDITY = 365 * 24 * time.Hour // Validity of the certificates generated for development
+
+// Files written by GenerateDevCertificates
+const (
+	DEV_CA_FILE     = "ca.pem"
+	DEV_SERVER_CERT = "server.pem"
+	DEV_SERVER_KEY  = "server-key.pem"
+	DEV_CLIENT_CERT = "client.pem"
+	DEV_CLIENT_KEY  = "client-key.pem"
+	DEV_CLIENT_NAME = "users"
+)
+
+// GenerateDevCertificates writes a local CA with a server and a client certificate signed by it into dir
+// The server certificate is valid for the given hosts, names or IP addresses, the client certificate identifies the
+// users service. The certificates are meant for development and tests only, the CA key is not kept
+func GenerateDevCertificates(dir string, hosts ...string) error {
+	if len(hosts) == 0 {
+		return errors.New("at least one host is required for the server certificate")
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	// Self-signed CA
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	caTemplate, err := newTemplate("Development CA")
+	if err != nil {
+		return err
+	}
+	caTemplate.IsCA = true
+	caTemplate.BasicConstraintsValid = true
+	caTemplate.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, caKey.Public(), caKey)
+	if err != nil {
+		return err
+	}
+	if err := writePEM(filepath.Join(dir, DEV_CA_FILE), "CERTIFICATE", caDER, 0644); err != nil {
+		return err
+	}
+
+	// Server certificate
+	serverTemplate, err := newTemplate(hosts[0])
+	if err != nil {
+		return err
+	}
+	serverTemplate.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			serverTemplate.IPAddresses = append(serverTemplate.IPAddresses, ip)
+		} else {
+			serverTemplate.DNSNames = append(serverTemplate.DNSNames, host)
+		}
+	}
+	if err := writeSigned(dir, DEV_SERVER_CERT, DEV_SERVER_KEY, serverTemplate, caTemplate, caKey); err != nil {
+		return err
+	}
+
+	// Client certificate
+	clientTemplate, err := newTemplate(DEV_CLIENT_NAME)
+	if err != nil {
+		return err
+	}
+	clientTemplate.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	return writeSigned(dir, DEV_CLIENT_CERT, DEV_CLIENT_KEY, clientTemplate, caTemplate, caKey)
+}
+
+// newTemplate returns a certificate template with a random serial number, valid from now on
+func newTemplate(commonName string) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    now.Add(-time.Minute),
+		NotAfter:     now.Add(DEV_CERTIFICATE_VALIDITY),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}, nil
+}
+
+// writeSigned generates a key, signs a certificate for it with the CA and writes both files
+func writeSigned(dir, certFile, keyFile string, template, ca *x509.Certificate, caKey crypto.Signer) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, key.Public(), caKey)
+	if err != nil {
+		return err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	if err := writePEM(filepath.Join(dir, certFile), "CERTIFICATE", der, 0644); err != nil {
+		return err
+	}
+	return writePEM(filepath.Join(dir, keyFile), "PRIVATE KEY", keyDER, 0600)
+}
+
+// writePEM writes a single PEM block to a file, replacing it atomically so that a reloader never reads half a file
+func writePEM(path, blockType string, der []byte, perm os.FileMode) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,232 @@
+package tlsutil
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"common/config"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// Reloader holds the certificates of a service and reloads them when their files change
+// The TLS configurations it creates read the current certificates on every handshake, so renewed certificates
+// are used by new connections without restarting the service
+type Reloader struct {
+	cfg config.TLSConfig
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate // Certificate presented by the service, nil if none is configured
+	pool    *x509.CertPool   // CAs trusted to sign the certificate of the peer, nil for the system roots
+	version string           // Modification times and sizes of the files the certificates were loaded from
+}
+
+// NewReloader loads the certificates of the configuration
+// It returns nil when TLS is disabled, which ServerOption and DialOption treat as plaintext
+func NewReloader(cfg config.TLSConfig) (*Reloader, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+
+	r := &Reloader{cfg: cfg}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// files lists the certificate files of the configuration
+func (r *Reloader) files() []string {
+	var files []string
+	for _, file := range []string{r.cfg.CertFile, r.cfg.KeyFile, r.cfg.CAFile} {
+		if file != "" {
+			files = append(files, file)
+		}
+	}
+	return files
+}
+
+// fileVersion describes the current state of the certificate files, it changes when one of the files is replaced
+func (r *Reloader) fileVersion() (string, error) {
+	var version string
+	for _, file := range r.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			return "", err
+		}
+		version += fmt.Sprintf("%s:%d:%d;", file, info.ModTime().UnixNano(), info.Size())
+	}
+	return version, nil
+}
+
+// Reload reads the certificate files again
+// The previous certificates are kept if one of the files can't be read
+func (r *Reloader) Reload() error {
+	version, err := r.fileVersion()
+	if err != nil {
+		return err
+	}
+
+	var cert *tls.Certificate
+	if r.cfg.CertFile != "" {
+		loaded, err := tls.LoadX509KeyPair(r.cfg.CertFile, r.cfg.KeyFile)
+		if err != nil {
+			return fmt.Errorf("could not load certificate: %w", err)
+		}
+		cert = &loaded
+	}
+
+	var pool *x509.CertPool
+	if r.cfg.CAFile != "" {
+		content, err := os.ReadFile(r.cfg.CAFile)
+		if err != nil {
+			return fmt.Errorf("could not read CA certificates: %w", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(content) {
+			return fmt.Errorf("no CA certificate found in %s", r.cfg.CAFile)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cert, r.pool, r.version = cert, pool, version
+	return nil
+}
+
+// Watch reloads the certificates whenever their files change, checking them at the reload interval
+// Failed reloads are logged and retried at the next check, Watch returns when the context is cancelled
+func (r *Reloader) Watch(ctx context.Context) error {
+	ticker := time.NewTicker(r.cfg.ReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		version, err := r.fileVersion()
+		r.mu.RLock()
+		changed := err != nil || version != r.version
+		r.mu.RUnlock()
+		if !changed {
+			continue
+		}
+
+		if err := r.Reload(); err != nil {
+			slog.Error("Failed to reload certificates", "error", err)
+			continue
+		}
+		slog.Info("Certificates reloaded", "files", r.files())
+	}
+}
+
+// current returns the certificates currently loaded
+func (r *Reloader) current() (*tls.Certificate, *x509.CertPool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, r.pool
+}
+
+// ServerConfig returns the TLS configuration of a gRPC server
+// Client certificates signed by the CA are required when client authentication is enabled
+func (r *Reloader) ServerConfig() (*tls.Config, error) {
+	if cert, _ := r.current(); cert == nil {
+		return nil, errors.New("a certificate is required to serve TLS")
+	}
+
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cert, pool := r.current()
+			clientAuth := tls.NoClientCert
+			if r.cfg.ClientAuth {
+				clientAuth = tls.RequireAndVerifyClientCert
+			}
+			return &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*cert},
+				ClientCAs:    pool,
+				ClientAuth:   clientAuth,
+				NextProtos:   []string{"h2"},
+			}, nil
+		},
+	}, nil
+}
+
+// ClientConfig returns the TLS configuration of a gRPC client connecting to the server with the given name
+// The certificate of the service is presented when the server asks for one, and the certificate of the server is
+// verified against the CAs current at the handshake, so that a long-lived configuration follows the reloads as well
+func (r *Reloader) ClientConfig(serverName string) *tls.Config {
+	if r.cfg.ServerName != "" {
+		serverName = r.cfg.ServerName
+	}
+
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: serverName,
+		// The chain is verified by VerifyConnection instead, since RootCAs would keep the CAs of the creation
+		InsecureSkipVerify: true,
+		VerifyConnection: func(state tls.ConnectionState) error {
+			_, pool := r.current()
+			return verifyServer(state, pool)
+		},
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			if cert, _ := r.current(); cert != nil {
+				return cert, nil
+			}
+			return &tls.Certificate{}, nil
+		},
+	}
+}
+
+// verifyServer verifies the certificate chain presented by a server against the trusted CAs, the system roots if pool
+// is nil, and checks that it was issued for the name the client asked for
+func verifyServer(state tls.ConnectionState, pool *x509.CertPool) error {
+	if len(state.PeerCertificates) == 0 {
+		return errors.New("the server presented no certificate")
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range state.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := state.PeerCertificates[0].Verify(x509.VerifyOptions{
+		Roots:         pool,
+		Intermediates: intermediates,
+		DNSName:       state.ServerName,
+	})
+	return err
+}
+
+// ServerOption returns the transport credentials of a gRPC server, plaintext if the reloader is nil
+func ServerOption(r *Reloader) (grpc.ServerOption, error) {
+	if r == nil {
+		return grpc.Creds(insecure.NewCredentials()), nil
+	}
+
+	tlsConfig, err := r.ServerConfig()
+	if err != nil {
+		return nil, err
+	}
+	return grpc.Creds(credentials.NewTLS(tlsConfig)), nil
+}
+
+// DialOption returns the transport credentials of a gRPC client, plaintext if the reloader is nil
+func DialOption(r *Reloader, serverName string) grpc.DialOption {
+	if r == nil {
+		return grpc.WithTransportCredentials(insecure.NewCredentials())
+	}
+	return grpc.WithTransportCredentials(credentials.NewTLS(r.ClientConfig(serverName)))
+}
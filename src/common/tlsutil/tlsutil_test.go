@@ -0,0 +1,173 @@
+package tlsutil
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"common/config"
+	"common/health"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// devConfigs generates development certificates and returns the configurations of the server and of the client
+func devConfigs(t *testing.T, clientAuth bool) (string, config.TLSConfig, config.TLSConfig) {
+	dir := t.TempDir()
+	if err := GenerateDevCertificates(dir, "localhost", "127.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+
+	server := config.TLSConfig{
+		Enabled:        true,
+		CertFile:       filepath.Join(dir, DEV_SERVER_CERT),
+		KeyFile:        filepath.Join(dir, DEV_SERVER_KEY),
+		CAFile:         filepath.Join(dir, DEV_CA_FILE),
+		ClientAuth:     clientAuth,
+		ReloadInterval: 10 * time.Millisecond,
+	}
+	client := config.TLSConfig{
+		Enabled:        true,
+		CertFile:       filepath.Join(dir, DEV_CLIENT_CERT),
+		KeyFile:        filepath.Join(dir, DEV_CLIENT_KEY),
+		CAFile:         filepath.Join(dir, DEV_CA_FILE),
+		ReloadInterval: 10 * time.Millisecond,
+	}
+	return dir, server, client
+}
+
+// serve starts a gRPC health server with the given reloader and returns its address
+func serve(t *testing.T, r *Reloader) string {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	creds, err := ServerOption(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer(creds)
+	healthpb.RegisterHealthServer(server, grpchealth.NewServer())
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+	return lis.Addr().String()
+}
+
+// check calls the health service at address with the given client reloader
+func check(address string, r *Reloader) error {
+	return checkWith(address, DialOption(r, "localhost"))
+}
+
+// checkWith calls the health service at address with the given transport credentials
+func checkWith(address string, credentials grpc.DialOption) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	return health.CheckGRPCService(ctx, address, "", credentials)
+}
+
+// TestMutualTLS tests that a server requiring client certificates accepts only clients with a certificate of its CA
+func TestMutualTLS(t *testing.T) {
+	_, serverCfg, clientCfg := devConfigs(t, true)
+	server, err := NewReloader(serverCfg)
+	assert.NoError(t, err)
+	address := serve(t, server)
+
+	client, err := NewReloader(clientCfg)
+	assert.NoError(t, err)
+	assert.NoError(t, check(address, client))
+
+	// Without a client certificate
+	anonymous, err := NewReloader(config.TLSConfig{Enabled: true, CAFile: clientCfg.CAFile})
+	assert.NoError(t, err)
+	assert.Error(t, check(address, anonymous))
+
+	// With certificates of another CA
+	_, _, otherCfg := devConfigs(t, true)
+	other, err := NewReloader(otherCfg)
+	assert.NoError(t, err)
+	assert.Error(t, check(address, other))
+
+	// Plaintext
+	assert.Error(t, check(address, nil))
+}
+
+// TestServerTLS tests that a server without client authentication accepts clients without a certificate
+func TestServerTLS(t *testing.T) {
+	_, serverCfg, clientCfg := devConfigs(t, false)
+	server, err := NewReloader(serverCfg)
+	assert.NoError(t, err)
+	address := serve(t, server)
+
+	anonymous, err := NewReloader(config.TLSConfig{Enabled: true, CAFile: clientCfg.CAFile})
+	assert.NoError(t, err)
+	assert.NoError(t, check(address, anonymous))
+
+	// A server needs a certificate
+	noCert, err := NewReloader(config.TLSConfig{Enabled: true, CAFile: clientCfg.CAFile})
+	assert.NoError(t, err)
+	_, err = ServerOption(noCert)
+	assert.Error(t, err)
+
+	// TLS is disabled
+	disabled, err := NewReloader(config.TLSConfig{})
+	assert.NoError(t, err)
+	assert.Nil(t, disabled)
+}
+
+// TestWatch tests that regenerated certificates are picked up by the server and the client without restarting them
+func TestWatch(t *testing.T) {
+	dir, serverCfg, clientCfg := devConfigs(t, true)
+	server, err := NewReloader(serverCfg)
+	assert.NoError(t, err)
+	address := serve(t, server)
+	client, err := NewReloader(clientCfg)
+	assert.NoError(t, err)
+	assert.NoError(t, check(address, client))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.Watch(ctx)
+	go client.Watch(ctx)
+
+	// A new CA replaces the old one on both sides, a client that is not watching keeps the old certificates
+	staleClient, err := NewReloader(clientCfg)
+	assert.NoError(t, err)
+	time.Sleep(20 * time.Millisecond)
+	if err := GenerateDevCertificates(dir, "localhost", "127.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Eventually(t, func() bool { return check(address, client) == nil && check(address, staleClient) != nil },
+		2*time.Second, 20*time.Millisecond)
+}
+
+// TestWatchCredentials tests that transport credentials created before a CA rotation trust the new CA once it is
+// reloaded, as the credentials of a service are created once when it starts
+func TestWatchCredentials(t *testing.T) {
+	dir, serverCfg, clientCfg := devConfigs(t, true)
+	server, err := NewReloader(serverCfg)
+	assert.NoError(t, err)
+	address := serve(t, server)
+	client, err := NewReloader(clientCfg)
+	assert.NoError(t, err)
+	credentials := DialOption(client, "localhost")
+	assert.NoError(t, checkWith(address, credentials))
+
+	if err := GenerateDevCertificates(dir, "localhost", "127.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, server.Reload())
+	assert.Error(t, checkWith(address, credentials), "Expected the old CA to reject the new server certificate")
+
+	assert.NoError(t, client.Reload())
+	assert.NoError(t, checkWith(address, credentials))
+
+	// The name of the server is still checked
+	assert.Error(t, checkWith(address, DialOption(client, "elsewhere")))
+}
@@ -0,0 +1,158 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"common/config"
+	"common/database"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// recordSpans installs a tracer provider keeping the ended spans in memory
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() { provider.Shutdown(context.Background()) })
+	return recorder
+}
+
+// spanNamed returns the first ended span with the given name
+func spanNamed(recorder *tracetest.SpanRecorder, name string) sdktrace.ReadOnlySpan {
+	for _, span := range recorder.Ended() {
+		if span.Name() == name {
+			return span
+		}
+	}
+	return nil
+}
+
+// TestGinAndGORM tests that a request continues the trace of the caller and that statements are children of the request
+func TestGinAndGORM(t *testing.T) {
+	recorder := recordSpans(t)
+
+	db, err := database.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close(db)
+	assert.NoError(t, InstrumentGORM(db))
+
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.Use(GinMiddleware("test"))
+	engine.GET("/users/:name", func(c *gin.Context) {
+		var count int64
+		db.WithContext(c.Request.Context()).Raw("SELECT 1").Scan(&count)
+		c.Status(http.StatusNoContent)
+	})
+
+	req := httptest.NewRequest("GET", "/users/alice", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	engine.ServeHTTP(httptest.NewRecorder(), req)
+
+	request := spanNamed(recorder, "/users/:name")
+	statement := spanNamed(recorder, "gorm.row")
+	if assert.NotNil(t, request) && assert.NotNil(t, statement) {
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", request.SpanContext().TraceID().String())
+		assert.Equal(t, "00f067aa0ba902b7", request.Parent().SpanID().String())
+		assert.Equal(t, request.SpanContext().SpanID(), statement.Parent().SpanID())
+	}
+}
+
+// TestGRPCPropagation tests that the trace context of a client is continued by the server
+func TestGRPCPropagation(t *testing.T) {
+	recorder := recordSpans(t)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer(GRPCServerOption())
+	healthpb.RegisterHealthServer(server, health.NewServer())
+	go server.Serve(lis)
+	defer server.Stop()
+
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()), GRPCDialOption())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	_, err = healthpb.NewHealthClient(conn).Check(context.Background(), &healthpb.HealthCheckRequest{})
+	assert.NoError(t, err)
+	server.GracefulStop()
+
+	var client, handler sdktrace.ReadOnlySpan
+	for _, span := range recorder.Ended() {
+		if span.Name() != "grpc.health.v1.Health/Check" {
+			continue
+		}
+		if span.SpanKind().String() == "client" {
+			client = span
+		} else {
+			handler = span
+		}
+	}
+	if assert.NotNil(t, client) && assert.NotNil(t, handler) {
+		assert.Equal(t, client.SpanContext().TraceID(), handler.SpanContext().TraceID())
+		assert.Equal(t, client.SpanContext().SpanID(), handler.Parent().SpanID())
+	}
+}
+
+// TestUnaryServerInterceptor tests that an RPC called in process is a child of the span of its caller
+func TestUnaryServerInterceptor(t *testing.T) {
+	recorder := recordSpans(t)
+
+	ctx, caller := otel.Tracer("test").Start(context.Background(), "caller")
+	info := &grpc.UnaryServerInfo{FullMethod: "/Service/Method"}
+	_, err := UnaryServerInterceptor()(ctx, nil, info, func(ctx context.Context, req any) (any, error) {
+		return nil, errors.New("failed")
+	})
+	caller.End()
+	assert.EqualError(t, err, "failed")
+
+	handler := spanNamed(recorder, "Service/Method")
+	if assert.NotNil(t, handler) {
+		assert.Equal(t, caller.SpanContext().SpanID(), handler.Parent().SpanID())
+		assert.Equal(t, codes.Error, handler.Status().Code)
+	}
+}
+
+// TestSetupFile tests that the file exporter writes the spans when the provider is shut down
+func TestSetupFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spans.json")
+	shutdown, err := Setup(context.Background(), "test", config.TracingConfig{Exporter: "file", File: path, SampleRatio: 1})
+	assert.NoError(t, err)
+
+	_, span := otel.Tracer("test").Start(context.Background(), "work")
+	span.End()
+	assert.NoError(t, shutdown(context.Background()))
+
+	content, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), `"Name":"work"`)
+	assert.Contains(t, string(content), `"Value":"test"`)
+
+	_, err = Setup(context.Background(), "test", config.TracingConfig{Exporter: "carrier pigeon"})
+	assert.Error(t, err)
+}
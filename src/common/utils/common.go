@@ -2,83 +2,93 @@ package utils
 
 import (
 	"errors"
-	"fmt"
-	"log"
 	"math"
-	"os"
-	"os/signal"
+	"regexp"
 	"unicode"
-
-	"github.com/umahmood/haversine"
 )
 
-const RADIANS_EARTH = 6371000 // Earth's radius in meters
+const EARTH_RADIUS_METERS = 6371000 // Mean radius of the earth in meters, the radius of the Haversine sphere
 
-// InitLogging initializes logging to a specified file
-// It sets the log output to the specified file and configures log flags
-func InitLogging(filename string) *os.File {
-	file, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666)
-	if err != nil {
-		log.Fatalf("Failed to open log file: %s", err)
-	}
-	log.SetFlags(log.Ldate | log.Ltime | log.Lshortfile)
-	log.SetOutput(file)
-	file.Sync()
+// Errors of the validations, matched with errors.Is by the callers to report them with the code of their kind
+var (
+	ErrInvalidUsername       = errors.New("invalid username")
+	ErrInvalidCoordinates    = errors.New("invalid coordinates")
+	ErrInvalidIdempotencyKey = errors.New("invalid idempotency key")
+)
 
-	return file
+// invalidError is a failed validation, its message is the detail shown to the user
+type invalidError struct {
+	kind   error  // Sentinel error of the kind of validation
+	detail string // Explanation of the failure
 }
 
-// WaitForSignal waits for an OS signal or server error to initiate a graceful shutdown
-// It listens for interrupt and kill signals, as well as server errors
-func WaitForSignal() {
-	sigChan := make(chan os.Signal, 1)
-	serverErrChan := make(chan error, 1)
-
-	signal.Notify(sigChan, os.Interrupt, os.Kill)
-
-	select {
-	case sig := <-sigChan:
-		log.Printf("Received signal: %v. Initiating graceful shutdown...\n", sig)
-	case err := <-serverErrChan:
-		log.Printf("Server error: %v. Initiating graceful shutdown...\n", err)
-	}
+// Error returns the detail of the failure
+func (e *invalidError) Error() string {
+	return e.detail
 }
 
-// LoadEnv loads an environment variable and exits if it's not set
-// It prints an error message and exits the program if the variable is not set
-func LoadEnv(variableName string) string {
-	envVar := os.Getenv(variableName)
-	if envVar == "" {
-		fmt.Println(variableName + " env variable not provided. Exiting..")
-		os.Exit(1)
-	}
-
-	return envVar
+// Unwrap returns the sentinel error of the kind of validation
+func (e *invalidError) Unwrap() error {
+	return e.kind
 }
 
+// uuidPattern matches a UUID in its canonical textual form
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
 // RoundToEightDecimals rounds a float64 value to eight decimal places
 func RoundToEightDecimals(val float64) float64 {
 	return math.Round(val*1e8) / 1e8
 }
 
-// CalcDistance calculates the distance between two coordinates using the Haversine formula
-var CalcDistance = func(longitude1, latitude1, longitude2, latitude2 float64) float64 {
-	coord1 := haversine.Coord{Lat: latitude1, Lon: longitude1}
-	coord2 := haversine.Coord{Lat: latitude2, Lon: longitude2}
-	_, km := haversine.Distance(coord1, coord2)
-	return km
+// InterpolateGreatCircle returns the point at the given fraction of the great circle path between two coordinates
+// A fraction of 0 returns the first coordinate and a fraction of 1 returns the second one
+func InterpolateGreatCircle(longitude1, latitude1, longitude2, latitude2, fraction float64) (float64, float64) {
+	lon1, lat1 := longitude1*math.Pi/180, latitude1*math.Pi/180
+	lon2, lat2 := longitude2*math.Pi/180, latitude2*math.Pi/180
+
+	// Angular distance between the two coordinates
+	h := math.Pow(math.Sin((lat2-lat1)/2), 2) + math.Cos(lat1)*math.Cos(lat2)*math.Pow(math.Sin((lon2-lon1)/2), 2)
+	delta := 2 * math.Asin(math.Sqrt(h))
+	if delta == 0 {
+		return longitude1, latitude1
+	}
+
+	a := math.Sin((1-fraction)*delta) / math.Sin(delta)
+	b := math.Sin(fraction*delta) / math.Sin(delta)
+
+	x := a*math.Cos(lat1)*math.Cos(lon1) + b*math.Cos(lat2)*math.Cos(lon2)
+	y := a*math.Cos(lat1)*math.Sin(lon1) + b*math.Cos(lat2)*math.Sin(lon2)
+	z := a*math.Sin(lat1) + b*math.Sin(lat2)
+
+	latitude := math.Atan2(z, math.Sqrt(x*x+y*y))
+	longitude := math.Atan2(y, x)
+
+	return longitude * 180 / math.Pi, latitude * 180 / math.Pi
+}
+
+// Destination returns the coordinates reached from a point by traveling a distance in meters along a great circle
+// The bearing is in degrees clockwise from north, the longitude of the result is normalized to [-180, 180]
+func Destination(longitude, latitude, bearing, distance float64) (float64, float64) {
+	lon1, lat1 := longitude*math.Pi/180, latitude*math.Pi/180
+	theta := bearing * math.Pi / 180
+	delta := distance / EARTH_RADIUS_METERS
+
+	lat2 := math.Asin(math.Sin(lat1)*math.Cos(delta) + math.Cos(lat1)*math.Sin(delta)*math.Cos(theta))
+	lon2 := lon1 + math.Atan2(math.Sin(theta)*math.Sin(delta)*math.Cos(lat1), math.Cos(delta)-math.Sin(lat1)*math.Sin(lat2))
+
+	return math.Remainder(lon2*180/math.Pi, 360), lat2 * 180 / math.Pi
 }
 
 // CheckUsername validates a username
 // It ensures the username is between 4 and 16 characters long and contains only letters and numbers
 var CheckUsername = func(username string) error {
 	if len(username) < 4 || len(username) > 16 {
-		return errors.New("username must be between 4 and 16 characters long")
+		return &invalidError{ErrInvalidUsername, "username must be between 4 and 16 characters long"}
 	}
 
 	for _, r := range username {
 		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
-			return errors.New("username can only contain letters (a-z, A-Z) and numbers (0-9)")
+			return &invalidError{ErrInvalidUsername, "username can only contain letters (a-z, A-Z) and numbers (0-9)"}
 		}
 	}
 
@@ -89,11 +99,21 @@ var CheckUsername = func(username string) error {
 // It ensures the longitude is between -180 and 180, and the latitude is between -90 and 90
 var CheckCoordinates = func(longitude, latitude float64) error {
 	if longitude < -180 || longitude > 180 {
-		return errors.New("longitude must be between -180 and 180")
+		return &invalidError{ErrInvalidCoordinates, "longitude must be between -180 and 180"}
 	}
 
 	if latitude < -90 || latitude > 90 {
-		return errors.New("latitude must be between -90 and 90")
+		return &invalidError{ErrInvalidCoordinates, "latitude must be between -90 and 90"}
+	}
+
+	return nil
+}
+
+// CheckIdempotencyKey validates an idempotency key
+// It ensures the key is a client generated UUID in its canonical form
+var CheckIdempotencyKey = func(key string) error {
+	if !uuidPattern.MatchString(key) {
+		return &invalidError{ErrInvalidIdempotencyKey, "idempotency key must be a UUID"}
 	}
 
 	return nil
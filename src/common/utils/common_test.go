@@ -1,23 +1,13 @@
 package utils
 
 import (
-	"os"
+	"math"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/umahmood/haversine"
 )
 
-// TestLoadEnv tests the LoadEnv function
-// It sets an environment variable, verifies its value using LoadEnv, and then unsets it
-func TestLoadEnv(t *testing.T) {
-	os.Setenv("TEST_ENV", "test_value")
-	defer os.Unsetenv("TEST_ENV")
-
-	value := LoadEnv("TEST_ENV")
-	assert.Equal(t, "test_value", value)
-}
-
 // TestRoundToEightDecimals tests the RoundToEightDecimals function
 // It verifies that a value is correctly rounded to eight decimal places
 func TestRoundToEightDecimals(t *testing.T) {
@@ -27,15 +17,100 @@ func TestRoundToEightDecimals(t *testing.T) {
 	assert.Equal(t, expected, result)
 }
 
-// TestCalcDistance tests the CalcDistance function
+// TestHaversine tests the haversine geodesic model
 // It verifies that the calculated distance between two coordinates matches the expected value
-func TestCalcDistance(t *testing.T) {
+func TestHaversine(t *testing.T) {
 	longitude1, latitude1 := 0.0, 0.0
 	longitude2, latitude2 := 1.0, 1.0
 	_, expectedKm := haversine.Distance(haversine.Coord{Lat: latitude1, Lon: longitude1}, haversine.Coord{Lat: latitude2, Lon: longitude2})
 
-	result := CalcDistance(longitude1, latitude1, longitude2, latitude2)
-	assert.InEpsilon(t, expectedKm, result, 0.0001, "Expected distances to be approximately equal")
+	result := NewHaversine().Distance(longitude1, latitude1, longitude2, latitude2)
+	assert.InEpsilon(t, expectedKm*1000, result, 0.0001, "Expected distances to be approximately equal")
+}
+
+// TestGeodesic tests the geodesic models
+// It verifies the distances in meters against reference values computed with GeographicLib
+func TestGeodesic(t *testing.T) {
+	haversineModel, err := NewGeodesic("haversine")
+	assert.NoError(t, err)
+	vincentyModel, err := NewGeodesic("vincenty")
+	assert.NoError(t, err)
+	karneyModel, err := NewGeodesic("karney")
+	assert.NoError(t, err)
+
+	_, err = NewGeodesic("flat")
+	assert.Error(t, err, "Expected an error for an unknown model")
+
+	// JFK to LHR
+	assert.InDelta(t, 5551759.400319, karneyModel.Distance(-73.8, 40.6, -0.5, 51.6), 1e-6)
+	assert.InDelta(t, 5551759.400319, vincentyModel.Distance(-73.8, 40.6, -0.5, 51.6), 1e-3)
+	assert.InEpsilon(t, 5551759.400319, haversineModel.Distance(-73.8, 40.6, -0.5, 51.6), 0.005)
+
+	// Pole to pole along a meridian
+	assert.InDelta(t, 20003931.458625, karneyModel.Distance(0.0, -90.0, 0.0, 90.0), 1e-6)
+
+	// Nearly antipodal points, where Vincenty's formula falls back to Karney's algorithm
+	assert.InDelta(t, 19936288.578965, karneyModel.Distance(0.0, 0.0, 179.5, 0.5), 1e-6)
+	assert.InDelta(t, 19936288.578965, vincentyModel.Distance(0.0, 0.0, 179.5, 0.5), 1e-3)
+
+	// Identical coordinates
+	assert.Equal(t, 0.0, karneyModel.Distance(15.0, 45.0, 15.0, 45.0))
+	assert.Equal(t, 0.0, vincentyModel.Distance(15.0, 45.0, 15.0, 45.0))
+}
+
+// TestIsSpherical tests the IsSpherical function
+// It verifies that only the haversine model measures on a sphere
+func TestIsSpherical(t *testing.T) {
+	assert.True(t, IsSpherical(NewHaversine()))
+	assert.False(t, IsSpherical(NewVincenty()))
+	assert.False(t, IsSpherical(NewKarney()))
+}
+
+// TestInterpolateGreatCircle tests the InterpolateGreatCircle function
+// It verifies the endpoints, the midpoint along the equator, and the midpoint along a meridian
+func TestInterpolateGreatCircle(t *testing.T) {
+	longitude, latitude := InterpolateGreatCircle(10.0, 20.0, 30.0, 40.0, 0.0)
+	assert.InDelta(t, 10.0, longitude, 1e-9)
+	assert.InDelta(t, 20.0, latitude, 1e-9)
+
+	longitude, latitude = InterpolateGreatCircle(10.0, 20.0, 30.0, 40.0, 1.0)
+	assert.InDelta(t, 30.0, longitude, 1e-9)
+	assert.InDelta(t, 40.0, latitude, 1e-9)
+
+	longitude, latitude = InterpolateGreatCircle(0.0, 0.0, 10.0, 0.0, 0.5)
+	assert.InDelta(t, 5.0, longitude, 1e-9)
+	assert.InDelta(t, 0.0, latitude, 1e-9)
+
+	longitude, latitude = InterpolateGreatCircle(15.0, 10.0, 15.0, 30.0, 0.25)
+	assert.InDelta(t, 15.0, longitude, 1e-9)
+	assert.InDelta(t, 15.0, latitude, 1e-9)
+
+	// Identical coordinates return the same point
+	longitude, latitude = InterpolateGreatCircle(15.0, 10.0, 15.0, 10.0, 0.5)
+	assert.Equal(t, 15.0, longitude)
+	assert.Equal(t, 10.0, latitude)
+}
+
+// TestDestination tests the Destination function
+// It verifies moves along the equator and a meridian, across the antimeridian, and that the traveled distance is kept
+func TestDestination(t *testing.T) {
+	// A degree of great circle is 111.19 kilometers on the Haversine sphere
+	degree := EARTH_RADIUS_METERS * math.Pi / 180
+
+	longitude, latitude := Destination(0.0, 0.0, 90.0, degree)
+	assert.InDelta(t, 1.0, longitude, 1e-9)
+	assert.InDelta(t, 0.0, latitude, 1e-9)
+
+	longitude, latitude = Destination(15.0, 10.0, 0.0, 5*degree)
+	assert.InDelta(t, 15.0, longitude, 1e-9)
+	assert.InDelta(t, 15.0, latitude, 1e-9)
+
+	longitude, latitude = Destination(179.5, 0.0, 90.0, degree)
+	assert.InDelta(t, -179.5, longitude, 1e-9)
+	assert.InDelta(t, 0.0, latitude, 1e-9)
+
+	longitude, latitude = Destination(2.3522, 48.8566, 37.0, 12500)
+	assert.InDelta(t, 12500, NewHaversine().Distance(2.3522, 48.8566, longitude, latitude), 1e-6)
 }
 
 // TestCheckUsername tests the CheckUsername function
@@ -56,6 +131,8 @@ func TestCheckUsername(t *testing.T) {
 	// Test a username with invalid characters
 	err = CheckUsername("test_user")
 	assert.Error(t, err, "Expected an error for invalid characters in username")
+	assert.ErrorIs(t, err, ErrInvalidUsername)
+	assert.EqualError(t, err, "username can only contain letters (a-z, A-Z) and numbers (0-9)")
 }
 
 // TestCheckCoordinates tests the CheckCoordinates function
@@ -72,4 +149,22 @@ func TestCheckCoordinates(t *testing.T) {
 	// Test invalid latitude
 	err = CheckCoordinates(0.0, 100.0)
 	assert.Error(t, err, "Expected an error for invalid latitude")
+	assert.ErrorIs(t, err, ErrInvalidCoordinates)
+}
+
+// TestCheckIdempotencyKey tests the CheckIdempotencyKey function
+// It verifies that only UUIDs are accepted as idempotency keys
+func TestCheckIdempotencyKey(t *testing.T) {
+	// Test a valid key
+	err := CheckIdempotencyKey("3f2b8c1e-9a4d-4e6f-8b7a-1c2d3e4f5a6b")
+	assert.NoError(t, err)
+
+	// Test an empty key
+	err = CheckIdempotencyKey("")
+	assert.Error(t, err, "Expected an error for an empty key")
+
+	// Test a key that is not a UUID
+	err = CheckIdempotencyKey("retry-1")
+	assert.Error(t, err, "Expected an error for a key that is not a UUID")
+	assert.ErrorIs(t, err, ErrInvalidIdempotencyKey)
 }
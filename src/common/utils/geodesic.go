@@ -0,0 +1,700 @@
+package utils
+
+import (
+	"fmt"
+	"math"
+)
+
+const (
+	WGS84_A = 6378137.0         // Equatorial radius of the WGS84 ellipsoid in meters
+	WGS84_F = 1 / 298.257223563 // Flattening of the WGS84 ellipsoid
+)
+
+// Geodesic calculates distances between coordinates on a model of the earth
+type Geodesic interface {
+	// Distance returns the distance in meters between two coordinates given in degrees
+	Distance(longitude1, latitude1, longitude2, latitude2 float64) float64
+}
+
+// NewGeodesic returns the geodesic model with the given name
+// Supported models are "haversine" (spherical earth), "vincenty" and "karney" (both on the WGS84 ellipsoid)
+func NewGeodesic(model string) (Geodesic, error) {
+	switch model {
+	case "haversine":
+		return NewHaversine(), nil
+	case "vincenty":
+		return NewVincenty(), nil
+	case "karney":
+		return NewKarney(), nil
+	}
+
+	return nil, fmt.Errorf("unknown geodesic model %q, expected haversine, vincenty or karney", model)
+}
+
+// IsSpherical reports whether a geodesic model measures distances on a sphere, like the haversine model, rather than
+// on the WGS84 ellipsoid
+func IsSpherical(geodesic Geodesic) bool {
+	_, ok := geodesic.(haversineGeodesic)
+	return ok
+}
+
+// haversineGeodesic calculates great circle distances on a sphere with a radius of EARTH_RADIUS_METERS meters
+type haversineGeodesic struct{}
+
+// NewHaversine returns a geodesic model using the Haversine formula on a spherical earth
+// It is the fastest model, with an error of up to about 0.5% compared to the ellipsoidal models
+func NewHaversine() Geodesic {
+	return haversineGeodesic{}
+}
+
+// Distance returns the great circle distance in meters between two coordinates
+func (haversineGeodesic) Distance(longitude1, latitude1, longitude2, latitude2 float64) float64 {
+	lat1 := latitude1 * math.Pi / 180
+	lon1 := longitude1 * math.Pi / 180
+	lat2 := latitude2 * math.Pi / 180
+	lon2 := longitude2 * math.Pi / 180
+
+	a := math.Pow(math.Sin((lat2-lat1)/2), 2) + math.Cos(lat1)*math.Cos(lat2)*math.Pow(math.Sin((lon2-lon1)/2), 2)
+	c := 2 * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+
+	return c * EARTH_RADIUS_METERS
+}
+
+// vincentyGeodesic calculates distances on the WGS84 ellipsoid using Vincenty's inverse formula
+type vincentyGeodesic struct {
+	fallback Geodesic // Model used for nearly antipodal points, where the formula does not converge
+}
+
+// NewVincenty returns a geodesic model using Vincenty's inverse formula on the WGS84 ellipsoid
+// It is accurate to within a millimeter, nearly antipodal points fall back to the Karney model
+func NewVincenty() Geodesic {
+	return vincentyGeodesic{fallback: NewKarney()}
+}
+
+// Distance returns the distance in meters between two coordinates on the WGS84 ellipsoid
+func (v vincentyGeodesic) Distance(longitude1, latitude1, longitude2, latitude2 float64) float64 {
+	const f = WGS84_F
+	const a = WGS84_A
+	const b = (1 - f) * a
+
+	L := (longitude2 - longitude1) * math.Pi / 180
+	sinU1, cosU1 := math.Sincos(math.Atan((1 - f) * math.Tan(latitude1*math.Pi/180)))
+	sinU2, cosU2 := math.Sincos(math.Atan((1 - f) * math.Tan(latitude2*math.Pi/180)))
+
+	lambda := L
+	var sinSigma, cosSigma, sigma, cosSqAlpha, cos2SigmaM float64
+	converged := false
+	for i := 0; i < 200; i++ {
+		sinLambda, cosLambda := math.Sincos(lambda)
+		sinSigma = math.Hypot(cosU2*sinLambda, cosU1*sinU2-sinU1*cosU2*cosLambda)
+		// Coincident points
+		if sinSigma == 0 {
+			return 0
+		}
+
+		cosSigma = sinU1*sinU2 + cosU1*cosU2*cosLambda
+		sigma = math.Atan2(sinSigma, cosSigma)
+		sinAlpha := cosU1 * cosU2 * sinLambda / sinSigma
+		cosSqAlpha = 1 - sinAlpha*sinAlpha
+
+		// Both points are on the equator
+		cos2SigmaM = 0
+		if cosSqAlpha != 0 {
+			cos2SigmaM = cosSigma - 2*sinU1*sinU2/cosSqAlpha
+		}
+
+		C := f / 16 * cosSqAlpha * (4 + f*(4-3*cosSqAlpha))
+		prevLambda := lambda
+		lambda = L + (1-C)*f*sinAlpha*(sigma+C*sinSigma*(cos2SigmaM+C*cosSigma*(-1+2*cos2SigmaM*cos2SigmaM)))
+		if math.Abs(lambda-prevLambda) < 1e-12 {
+			converged = true
+			break
+		}
+	}
+
+	if !converged {
+		return v.fallback.Distance(longitude1, latitude1, longitude2, latitude2)
+	}
+
+	uSq := cosSqAlpha * (a*a - b*b) / (b * b)
+	A := 1 + uSq/16384*(4096+uSq*(-768+uSq*(320-175*uSq)))
+	B := uSq / 1024 * (256 + uSq*(-128+uSq*(74-47*uSq)))
+	deltaSigma := B * sinSigma * (cos2SigmaM + B/4*(cosSigma*(-1+2*cos2SigmaM*cos2SigmaM)-
+		B/6*cos2SigmaM*(-3+4*sinSigma*sinSigma)*(-3+4*cos2SigmaM*cos2SigmaM)))
+
+	return b * A * (sigma - deltaSigma)
+}
+
+// Parameters of Karney's algorithm, as used by GeographicLib with series of order 6
+const (
+	karneyOrder  = 6                                     // Order of the series expansions
+	karneyNC3x   = (karneyOrder * (karneyOrder - 1)) / 2 // Number of coefficients for C3
+	karneyMaxit1 = 20                                    // Newton iterations before falling back to bisection
+	karneyMaxit2 = karneyMaxit1 + 53 + 10                // Total number of iterations
+)
+
+var (
+	karneyTiny    = math.Sqrt(0x1p-1022) // Square root of the smallest normal float64
+	karneyTol0    = 0x1p-52              // Machine epsilon
+	karneyTol1    = 200 * karneyTol0
+	karneyTol2    = math.Sqrt(karneyTol0)
+	karneyTolb    = karneyTol0 * karneyTol2
+	karneyXthresh = 1000 * karneyTol2
+)
+
+// karneyGeodesic calculates distances on an ellipsoid using Karney's algorithm
+// It is a port of the inverse geodesic solution of GeographicLib, reduced to computing distances
+type karneyGeodesic struct {
+	a, f, f1, e2, ep2, n, b, etol2 float64
+	a3x                            [karneyOrder]float64
+	c3x                            [karneyNC3x]float64
+}
+
+// NewKarney returns a geodesic model using Karney's algorithm on the WGS84 ellipsoid
+// It is accurate to within a few nanometers and converges for all pairs of points, including antipodal ones
+func NewKarney() Geodesic {
+	k := &karneyGeodesic{a: WGS84_A, f: WGS84_F}
+	k.f1 = 1 - k.f
+	k.e2 = k.f * (2 - k.f)
+	k.ep2 = k.e2 / (k.f1 * k.f1)
+	k.n = k.f / (2 - k.f)
+	k.b = k.a * k.f1
+	k.etol2 = 0.1 * karneyTol2 / math.Sqrt(math.Max(0.001, math.Abs(k.f))*math.Min(1, 1-k.f/2)/2)
+	k.initA3x()
+	k.initC3x()
+	return k
+}
+
+// Distance returns the distance in meters between two coordinates on the ellipsoid
+func (k *karneyGeodesic) Distance(longitude1, latitude1, longitude2, latitude2 float64) float64 {
+	// Longitude difference, reduced to [0, 180] since only the distance is needed
+	lon12, lon12s := angDiff(longitude1, longitude2)
+	lonsign := 1.0
+	if lon12 < 0 {
+		lonsign = -1
+	}
+	lon12 = lonsign * angRound(lon12)
+	lon12s = angRound((180 - lon12) - lonsign*lon12s)
+	lam12 := lon12 * math.Pi / 180
+
+	var slam12, clam12 float64
+	if lon12 > 90 {
+		slam12, clam12 = sincosd(lon12s)
+		clam12 = -clam12
+	} else {
+		slam12, clam12 = sincosd(lon12)
+	}
+
+	// Make the first point the one with the larger absolute latitude and put it in the southern hemisphere
+	lat1, lat2 := angRound(latitude1), angRound(latitude2)
+	if math.Abs(lat1) < math.Abs(lat2) {
+		lat1, lat2 = lat2, lat1
+	}
+	if lat1 >= 0 {
+		lat1, lat2 = -lat1, -lat2
+	}
+
+	// Reduced latitudes
+	sbet1, cbet1 := sincosd(lat1)
+	sbet1, cbet1 = norm(k.f1*sbet1, cbet1)
+	cbet1 = math.Max(karneyTiny, cbet1)
+	sbet2, cbet2 := sincosd(lat2)
+	sbet2, cbet2 = norm(k.f1*sbet2, cbet2)
+	cbet2 = math.Max(karneyTiny, cbet2)
+
+	if cbet1 < -sbet1 {
+		if cbet2 == cbet1 {
+			sbet2 = math.Copysign(sbet1, sbet2)
+		}
+	} else if math.Abs(sbet2) == -sbet1 {
+		cbet2 = cbet1
+	}
+
+	dn1 := math.Sqrt(1 + k.ep2*sbet1*sbet1)
+	dn2 := math.Sqrt(1 + k.ep2*sbet2*sbet2)
+
+	// Geodesic along a meridian
+	meridian := lat1 == -90 || slam12 == 0
+	if meridian {
+		ssig1, csig1 := sbet1, clam12*cbet1
+		ssig2, csig2 := sbet2, cbet2
+		sig12 := math.Atan2(math.Max(0, csig1*ssig2-ssig1*csig2), csig1*csig2+ssig1*ssig2)
+		s12x, m12x := k.lengths(k.n, sig12, ssig1, csig1, dn1, ssig2, csig2, dn2)
+		// A meridian is the shortest path unless it passes too close to a pole
+		if sig12 < 1 || m12x >= 0 {
+			if sig12 < 3*karneyTiny || (sig12 < karneyTol0 && (s12x < 0 || m12x < 0)) {
+				return 0
+			}
+			return s12x * k.b
+		}
+	}
+
+	// Geodesic along the equator
+	if sbet1 == 0 && (k.f <= 0 || lon12s >= k.f*180) {
+		return k.a * lam12
+	}
+
+	// General case, solve for the azimuth at the first point
+	sig12, salp1, calp1, dnm := k.inverseStart(sbet1, cbet1, sbet2, cbet2, lam12, slam12, clam12)
+	if sig12 >= 0 {
+		// Short lines are solved directly
+		return sig12 * k.b * dnm
+	}
+
+	var ssig1, csig1, ssig2, csig2, eps float64
+	tripn, tripb := false, false
+	salp1a, calp1a := karneyTiny, 1.0
+	salp1b, calp1b := karneyTiny, -1.0
+	for numit := 0; numit < karneyMaxit2; {
+		var v, dv float64
+		v, sig12, ssig1, csig1, ssig2, csig2, eps, dv = k.lambda12(sbet1, cbet1, dn1, sbet2, cbet2, dn2, salp1, calp1, slam12, clam12, numit < karneyMaxit1)
+		tol := karneyTol0
+		if tripn {
+			tol *= 8
+		}
+		if tripb || !(math.Abs(v) >= tol) {
+			break
+		}
+
+		// Update the bracket of the solution
+		if v > 0 && (numit > karneyMaxit1 || calp1/salp1 > calp1b/salp1b) {
+			salp1b, calp1b = salp1, calp1
+		} else if v < 0 && (numit > karneyMaxit1 || calp1/salp1 < calp1a/salp1a) {
+			salp1a, calp1a = salp1, calp1
+		}
+		numit++
+
+		// Newton's method
+		if numit < karneyMaxit1 && dv > 0 {
+			dalp1 := -v / dv
+			if math.Abs(dalp1) < math.Pi {
+				sdalp1, cdalp1 := math.Sincos(dalp1)
+				nsalp1 := salp1*cdalp1 + calp1*sdalp1
+				if nsalp1 > 0 {
+					calp1 = calp1*cdalp1 - salp1*sdalp1
+					salp1 = nsalp1
+					salp1, calp1 = norm(salp1, calp1)
+					tripn = math.Abs(v) <= 16*karneyTol0
+					continue
+				}
+			}
+		}
+
+		// Bisection when Newton's method fails
+		salp1, calp1 = norm((salp1a+salp1b)/2, (calp1a+calp1b)/2)
+		tripn = false
+		tripb = math.Abs(salp1a-salp1)+(calp1a-calp1) < karneyTolb || math.Abs(salp1-salp1b)+(calp1-calp1b) < karneyTolb
+	}
+
+	s12x, _ := k.lengths(eps, sig12, ssig1, csig1, dn1, ssig2, csig2, dn2)
+	return s12x * k.b
+}
+
+// inverseStart returns a starting point for Newton's method
+// If the line is short enough it is solved directly and a non negative sig12 is returned
+func (k *karneyGeodesic) inverseStart(sbet1, cbet1, sbet2, cbet2, lam12, slam12, clam12 float64) (sig12, salp1, calp1, dnm float64) {
+	sig12 = -1
+	sbet12 := sbet2*cbet1 - cbet2*sbet1
+	cbet12 := cbet2*cbet1 + sbet2*sbet1
+	sbet12a := sbet2*cbet1 + cbet2*sbet1
+
+	shortline := cbet12 >= 0 && sbet12 < 0.5 && cbet2*lam12 < 0.5
+	var somg12, comg12 float64
+	if shortline {
+		sbetm2 := (sbet1 + sbet2) * (sbet1 + sbet2)
+		sbetm2 /= sbetm2 + (cbet1+cbet2)*(cbet1+cbet2)
+		dnm = math.Sqrt(1 + k.ep2*sbetm2)
+		somg12, comg12 = math.Sincos(lam12 / (k.f1 * dnm))
+	} else {
+		somg12, comg12 = slam12, clam12
+	}
+
+	salp1 = cbet2 * somg12
+	if comg12 >= 0 {
+		calp1 = sbet12 + cbet2*sbet1*somg12*somg12/(1+comg12)
+	} else {
+		calp1 = sbet12a - cbet2*sbet1*somg12*somg12/(1-comg12)
+	}
+
+	ssig12 := math.Hypot(salp1, calp1)
+	csig12 := sbet1*sbet2 + cbet1*cbet2*comg12
+
+	if shortline && ssig12 < k.etol2 {
+		// Really short lines
+		sig12 = math.Atan2(ssig12, csig12)
+	} else if math.Abs(k.n) >= 0.1 || csig12 >= 0 || ssig12 >= 6*math.Abs(k.n)*math.Pi*cbet1*cbet1 {
+		// Zeroth order spherical approximation is good enough
+	} else {
+		// Nearly antipodal points, use the astroid solution
+		lam12x := math.Atan2(-slam12, -clam12)
+		k2 := sbet1 * sbet1 * k.ep2
+		eps := k2 / (2*(1+math.Sqrt(1+k2)) + k2)
+		lamscale := k.f * cbet1 * k.a3f(eps) * math.Pi
+		betscale := lamscale * cbet1
+		x := lam12x / lamscale
+		y := sbet12a / betscale
+
+		if y > -karneyTol1 && x > -1-karneyXthresh {
+			salp1 = math.Min(1, -x)
+			calp1 = -math.Sqrt(1 - salp1*salp1)
+		} else {
+			kk := astroid(x, y)
+			omg12a := lamscale * (-x * kk / (1 + kk))
+			somg12, comg12 = math.Sincos(omg12a)
+			comg12 = -comg12
+			salp1 = cbet2 * somg12
+			calp1 = sbet12a - cbet2*sbet1*somg12*somg12/(1-comg12)
+		}
+	}
+
+	if !(salp1 <= 0) {
+		salp1, calp1 = norm(salp1, calp1)
+	} else {
+		salp1, calp1 = 1, 0
+	}
+
+	return sig12, salp1, calp1, dnm
+}
+
+// lambda12 returns the difference between the longitude reached by the geodesic with the given azimuth and the target
+// longitude, together with the quantities needed to compute its length and the derivative used by Newton's method
+func (k *karneyGeodesic) lambda12(sbet1, cbet1, dn1, sbet2, cbet2, dn2, salp1, calp1, slam120, clam120 float64, diffp bool) (
+	lam12, sig12, ssig1, csig1, ssig2, csig2, eps, dlam12 float64) {
+	if sbet1 == 0 && calp1 == 0 {
+		calp1 = -karneyTiny
+	}
+
+	salp0 := salp1 * cbet1
+	calp0 := math.Hypot(calp1, salp1*sbet1)
+
+	somg1 := salp0 * sbet1
+	comg1 := calp1 * cbet1
+	ssig1, csig1 = norm(sbet1, comg1)
+
+	var calp2 float64
+	if cbet2 != cbet1 || math.Abs(sbet2) != -sbet1 {
+		if cbet1 < -sbet1 {
+			calp2 = math.Sqrt(calp1*cbet1*calp1*cbet1+(cbet2-cbet1)*(cbet1+cbet2)) / cbet2
+		} else {
+			calp2 = math.Sqrt(calp1*cbet1*calp1*cbet1+(sbet1-sbet2)*(sbet1+sbet2)) / cbet2
+		}
+	} else {
+		calp2 = math.Abs(calp1)
+	}
+
+	somg2 := salp0 * sbet2
+	comg2 := calp2 * cbet2
+	ssig2, csig2 = norm(sbet2, comg2)
+
+	sig12 = math.Atan2(math.Max(0, csig1*ssig2-ssig1*csig2), csig1*csig2+ssig1*ssig2)
+	somg12 := math.Max(0, comg1*somg2-somg1*comg2)
+	comg12 := comg1*comg2 + somg1*somg2
+	eta := math.Atan2(somg12*clam120-comg12*slam120, comg12*clam120+somg12*slam120)
+
+	k2 := calp0 * calp0 * k.ep2
+	eps = k2 / (2*(1+math.Sqrt(1+k2)) + k2)
+	var c3a [karneyOrder]float64
+	k.c3f(eps, c3a[:])
+	b312 := sinCosSeries(true, ssig2, csig2, c3a[:]) - sinCosSeries(true, ssig1, csig1, c3a[:])
+	lam12 = eta - k.f*k.a3f(eps)*salp0*(sig12+b312)
+
+	if diffp {
+		if calp2 == 0 {
+			dlam12 = -2 * k.f1 * dn1 / sbet1
+		} else {
+			_, dlam12 = k.lengths(eps, sig12, ssig1, csig1, dn1, ssig2, csig2, dn2)
+			dlam12 *= k.f1 / (calp2 * cbet2)
+		}
+	}
+
+	return lam12, sig12, ssig1, csig1, ssig2, csig2, eps, dlam12
+}
+
+// lengths returns the distance and the reduced length of a geodesic, both scaled to an ellipsoid with b = 1
+func (k *karneyGeodesic) lengths(eps, sig12, ssig1, csig1, dn1, ssig2, csig2, dn2 float64) (s12b, m12b float64) {
+	var c1a, c2a [karneyOrder + 1]float64
+	a1 := a1m1f(eps)
+	c1f(eps, c1a[:])
+	a2 := a2m1f(eps)
+	c2f(eps, c2a[:])
+	m0x := a1 - a2
+	a1, a2 = 1+a1, 1+a2
+
+	b1 := sinCosSeries(true, ssig2, csig2, c1a[:]) - sinCosSeries(true, ssig1, csig1, c1a[:])
+	s12b = a1 * (sig12 + b1)
+
+	b2 := sinCosSeries(true, ssig2, csig2, c2a[:]) - sinCosSeries(true, ssig1, csig1, c2a[:])
+	j12 := m0x*sig12 + (a1*b1 - a2*b2)
+	m12b = dn2*(csig1*ssig2) - dn1*(ssig1*csig2) - csig1*csig2*j12
+
+	return s12b, m12b
+}
+
+// initA3x precomputes the coefficients of the A3 series as polynomials in the third flattening
+func (k *karneyGeodesic) initA3x() {
+	coeff := []float64{
+		-3, 128,
+		-2, -3, 64,
+		-1, -3, -1, 16,
+		3, -1, -2, 8,
+		1, -1, 2,
+		1, 1,
+	}
+	o, idx := 0, 0
+	for j := karneyOrder - 1; j >= 0; j-- {
+		m := min(karneyOrder-j-1, j)
+		k.a3x[idx] = polyval(m, coeff[o:], k.n) / coeff[o+m+1]
+		idx++
+		o += m + 2
+	}
+}
+
+// initC3x precomputes the coefficients of the C3 series as polynomials in the third flattening
+func (k *karneyGeodesic) initC3x() {
+	coeff := []float64{
+		3, 128,
+		2, 5, 128,
+		-1, 3, 3, 64,
+		-1, 0, 1, 8,
+		-1, 1, 4,
+		5, 256,
+		1, 3, 128,
+		-3, -2, 3, 64,
+		1, -3, 2, 32,
+		7, 512,
+		-10, 9, 384,
+		5, -9, 5, 192,
+		7, 512,
+		-14, 7, 512,
+		21, 2560,
+	}
+	o, idx := 0, 0
+	for l := 1; l < karneyOrder; l++ {
+		for j := karneyOrder - 1; j >= l; j-- {
+			m := min(karneyOrder-j-1, j)
+			k.c3x[idx] = polyval(m, coeff[o:], k.n) / coeff[o+m+1]
+			idx++
+			o += m + 2
+		}
+	}
+}
+
+// a3f evaluates the A3 series
+func (k *karneyGeodesic) a3f(eps float64) float64 {
+	return polyval(karneyOrder-1, k.a3x[:], eps)
+}
+
+// c3f evaluates the C3 series coefficients into c[1:]
+func (k *karneyGeodesic) c3f(eps float64, c []float64) {
+	mult := 1.0
+	o := 0
+	for l := 1; l < karneyOrder; l++ {
+		m := karneyOrder - l - 1
+		mult *= eps
+		c[l] = mult * polyval(m, k.c3x[o:], eps)
+		o += m + 1
+	}
+}
+
+// a1m1f evaluates the A1 series minus one
+func a1m1f(eps float64) float64 {
+	coeff := []float64{1, 4, 64, 0, 256}
+	m := karneyOrder / 2
+	t := polyval(m, coeff, eps*eps) / coeff[m+1]
+	return (t + eps) / (1 - eps)
+}
+
+// c1f evaluates the C1 series coefficients into c[1:]
+func c1f(eps float64, c []float64) {
+	coeff := []float64{
+		-1, 6, -16, 32,
+		-9, 64, -128, 2048,
+		9, -16, 768,
+		3, -5, 512,
+		-7, 1280,
+		-7, 2048,
+	}
+	seriesCoefficients(eps, coeff, c)
+}
+
+// a2m1f evaluates the A2 series minus one
+func a2m1f(eps float64) float64 {
+	coeff := []float64{-11, -28, -192, 0, 256}
+	m := karneyOrder / 2
+	t := polyval(m, coeff, eps*eps) / coeff[m+1]
+	return (t - eps) / (1 + eps)
+}
+
+// c2f evaluates the C2 series coefficients into c[1:]
+func c2f(eps float64, c []float64) {
+	coeff := []float64{
+		1, 2, 16, 32,
+		35, 64, 384, 2048,
+		15, 80, 768,
+		7, 35, 512,
+		63, 1280,
+		77, 2048,
+	}
+	seriesCoefficients(eps, coeff, c)
+}
+
+// seriesCoefficients evaluates the coefficients of a series in eps whose terms are polynomials in eps squared
+func seriesCoefficients(eps float64, coeff []float64, c []float64) {
+	eps2 := eps * eps
+	d := eps
+	o := 0
+	for l := 1; l <= karneyOrder; l++ {
+		m := (karneyOrder - l) / 2
+		c[l] = d * polyval(m, coeff[o:], eps2) / coeff[o+m+1]
+		o += m + 2
+		d *= eps
+	}
+}
+
+// sinCosSeries evaluates a sine (sinp) or cosine series using Clenshaw summation
+func sinCosSeries(sinp bool, sinx, cosx float64, c []float64) float64 {
+	k := len(c)
+	n := k
+	if sinp {
+		n--
+	}
+
+	ar := 2 * (cosx - sinx) * (cosx + sinx)
+	var y0, y1 float64
+	if n&1 != 0 {
+		k--
+		y0 = c[k]
+	}
+
+	for n /= 2; n > 0; n-- {
+		k--
+		y1 = ar*y0 - y1 + c[k]
+		k--
+		y0 = ar*y1 - y0 + c[k]
+	}
+
+	if sinp {
+		return 2 * sinx * cosx * y0
+	}
+	return cosx * (y0 - y1)
+}
+
+// polyval evaluates the polynomial of degree n with coefficients p, highest degree first
+func polyval(n int, p []float64, x float64) float64 {
+	if n < 0 {
+		return 0
+	}
+
+	y := p[0]
+	for i := 1; i <= n; i++ {
+		y = y*x + p[i]
+	}
+	return y
+}
+
+// astroid solves the astroid equation used for nearly antipodal points
+func astroid(x, y float64) float64 {
+	p := x * x
+	q := y * y
+	r := (p + q - 1) / 6
+	if q == 0 && r <= 0 {
+		return 0
+	}
+
+	S := p * q / 4
+	r2 := r * r
+	r3 := r * r2
+	disc := S * (S + 2*r3)
+	u := r
+	if disc >= 0 {
+		T3 := S + r3
+		if T3 < 0 {
+			T3 -= math.Sqrt(disc)
+		} else {
+			T3 += math.Sqrt(disc)
+		}
+		T := math.Cbrt(T3)
+		u += T
+		if T != 0 {
+			u += r2 / T
+		}
+	} else {
+		ang := math.Atan2(math.Sqrt(-disc), -(S + r3))
+		u += 2 * r * math.Cos(ang/3)
+	}
+
+	v := math.Sqrt(u*u + q)
+	var uv float64
+	if u < 0 {
+		uv = q / (v - u)
+	} else {
+		uv = u + v
+	}
+	w := (uv - q) / (2 * v)
+
+	return uv / (math.Sqrt(uv+w*w) + w)
+}
+
+// angRound rounds tiny angles so that the algorithm behaves symmetrically
+func angRound(x float64) float64 {
+	const z = 1.0 / 16
+	y := math.Abs(x)
+	if y < z {
+		y = z - (z - y)
+	}
+	return math.Copysign(y, x)
+}
+
+// angDiff returns the exact difference y - x of two angles in degrees reduced to [-180, 180], and its rounding error
+func angDiff(x, y float64) (float64, float64) {
+	d, t := twoSum(math.Remainder(-x, 360), math.Remainder(y, 360))
+	d, t2 := twoSum(math.Remainder(d, 360), t)
+	if d == 0 || math.Abs(d) == 180 {
+		if t2 == 0 {
+			d = math.Copysign(d, y-x)
+		} else {
+			d = math.Copysign(d, -t2)
+		}
+	}
+	return d, t2
+}
+
+// twoSum returns the sum of two numbers and the rounding error of that sum
+func twoSum(u, v float64) (float64, float64) {
+	s := u + v
+	up := s - v
+	vpp := s - up
+	up -= u
+	vpp -= v
+	if s == 0 {
+		return s, s
+	}
+	return s, -(up + vpp)
+}
+
+// sincosd returns the sine and cosine of an angle in degrees, exact for multiples of 90 degrees
+func sincosd(x float64) (float64, float64) {
+	r := math.Mod(x, 360)
+	q := 0
+	if !math.IsNaN(r) {
+		q = int(math.Round(r / 90))
+	}
+	r -= 90 * float64(q)
+	s, c := math.Sincos(r * math.Pi / 180)
+
+	switch ((q % 4) + 4) % 4 {
+	case 1:
+		s, c = c, -s
+	case 2:
+		s, c = -s, -c
+	case 3:
+		s, c = -c, s
+	}
+	return s, c + 0
+}
+
+// norm normalizes a two dimensional vector to unit length
+func norm(x, y float64) (float64, float64) {
+	r := math.Hypot(x, y)
+	return x / r, y / r
+}
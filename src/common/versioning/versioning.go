@@ -0,0 +1,45 @@
+package versioning
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const VERSION_KEY = "api_version" // Key of the API version of the matched route in the Gin context
+
+// The unversioned routes of the services are deprecated aliases of their v1 routes, removed at the sunset
+var (
+	UNVERSIONED_DEPRECATION = time.Date(2026, time.October, 19, 0, 0, 0, 0, time.UTC)
+	UNVERSIONED_SUNSET      = time.Date(2027, time.April, 19, 0, 0, 0, 0, time.UTC)
+)
+
+// Group creates the route group of a version of the API, served under /<version>
+// Every version has its own group, so that a route of /v2 can change the response of the same route of /v1
+// while both are served
+func Group(engine *gin.Engine, version string) *gin.RouterGroup {
+	return engine.Group("/"+version, func(c *gin.Context) {
+		c.Set(VERSION_KEY, version)
+	})
+}
+
+// Deprecated creates the route group of the unversioned aliases of a version of the API, served at the root
+// Responses carry the Deprecation (RFC 9745) and Sunset (RFC 8594) headers and a Link to the versioned route,
+// the handlers see the version of the aliased routes
+func Deprecated(engine *gin.Engine, version string, deprecation, sunset time.Time) *gin.RouterGroup {
+	deprecationHeader := fmt.Sprintf("@%d", deprecation.Unix())
+	sunsetHeader := sunset.UTC().Format(http.TimeFormat)
+	return engine.Group("/", func(c *gin.Context) {
+		c.Set(VERSION_KEY, version)
+		c.Header("Deprecation", deprecationHeader)
+		c.Header("Sunset", sunsetHeader)
+		c.Header("Link", fmt.Sprintf(`</%s%s>; rel="successor-version"`, version, c.Request.URL.Path))
+	})
+}
+
+// FromContext returns the API version of the route handling the request, or "" for the routes that are not versioned
+func FromContext(c *gin.Context) string {
+	return c.GetString(VERSION_KEY)
+}
@@ -0,0 +1,60 @@
+package versioning
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestVersioning tests that the versions are served side by side and that the unversioned aliases are deprecated
+func TestVersioning(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	deprecation := time.Date(2026, time.October, 19, 0, 0, 0, 0, time.UTC)
+	sunset := time.Date(2027, time.April, 19, 0, 0, 0, 0, time.UTC)
+
+	// A handler shared by the versions reads the version of the request
+	hello := func(c *gin.Context) {
+		c.String(http.StatusOK, "hello from "+FromContext(c))
+	}
+	engine := gin.New()
+	for _, group := range []*gin.RouterGroup{Group(engine, "v1"), Deprecated(engine, "v1", deprecation, sunset)} {
+		group.GET("/hello/:name", hello)
+	}
+	Group(engine, "v2").GET("/hello/:name", func(c *gin.Context) {
+		c.String(http.StatusOK, "hi from "+FromContext(c))
+	})
+	engine.GET("/healthz", hello)
+
+	tests := []struct {
+		path       string
+		body       string
+		deprecated bool
+	}{
+		{"/v1/hello/alice", "hello from v1", false},
+		{"/v2/hello/alice", "hi from v2", false},
+		{"/hello/alice", "hello from v1", true},
+		{"/healthz", "hello from ", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", tt.path, nil)
+			engine.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, tt.body, w.Body.String())
+			if tt.deprecated {
+				assert.Equal(t, "@1792368000", w.Header().Get("Deprecation"))
+				assert.Equal(t, "Mon, 19 Apr 2027 00:00:00 GMT", w.Header().Get("Sunset"))
+				assert.Equal(t, `</v1/hello/alice>; rel="successor-version"`, w.Header().Get("Link"))
+			} else {
+				assert.Empty(t, w.Header().Get("Deprecation"))
+				assert.Empty(t, w.Header().Get("Sunset"))
+			}
+		})
+	}
+}
@@ -1,71 +1,108 @@
 package main
 
 import (
-	"common/database"
-	"common/utils"
+	"common/config"
+	"common/lifecycle"
+	"common/logging"
+	"common/tracing"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"location_history/service"
 	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/gin-gonic/gin"
-	"gorm.io/gorm"
 )
 
-var (
-	REST_HOST    string // Host for the REST server
-	REST_PORT    string // Port for the REST server
-	GRPC_HOST    string // Host for the gRPC server
-	GRPC_PORT    string // Port for the gRPC server
-	DATABASE_URL string // URL for the database connection
-	LOG_URL      string // URL for the log file
-	db           *gorm.DB // Global database connection
-)
-
-// init function loads environment variables and initializes global variables
-func init() {
-	REST_HOST = utils.LoadEnv("LOCATION_HISTORY_REST_HOST")
-	REST_PORT = utils.LoadEnv("LOCATION_HISTORY_REST_PORT")
-	GRPC_HOST = utils.LoadEnv("LOCATION_HISTORY_GRPC_HOST")
-	GRPC_PORT = utils.LoadEnv("LOCATION_HISTORY_GRPC_PORT")
-	DATABASE_URL = utils.LoadEnv("LOCATION_HISTORY_DATABASE_URL")
-	LOG_URL = utils.LoadEnv("LOCATION_HISTORY_LOG_URL")
+// main function runs the service and exits with its status, non-zero if it failed to start or a component failed
+func main() {
+	os.Exit(run())
 }
 
-// registerRoutes registers the API routes with the Gin engine
-func registerRoutes(engine *gin.Engine) {
-	engine.GET("/distance/:username", getTraveledDistance)
-}
+// run loads the configuration, initializes logging and tracing, opens the service,
+// runs its REST and gRPC servers, and drains them on a termination signal
+// It returns the exit status of the process, once the deferred cleanups are done
+func run() int {
+	// Load the configuration, reporting every invalid value at once
+	cfg, opts, err := service.LoadConfig(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid configuration:")
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
 
-// migrateModels migrates the database models using GORM
-func migrateModels() {
-	db.AutoMigrate(&Location{})
-}
+	// Print the resolved configuration instead of starting the service
+	if opts.PrintConfig {
+		if err := config.Print(os.Stdout, cfg); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return 0
+	}
 
-// main function initializes logging, sets up the Gin engine, connects to the database,
-// registers routes, starts the gRPC and REST servers, and waits for a termination signal
-func main() {
-	// Initialize logging to the specified log file
-	file := utils.InitLogging(LOG_URL)
-	defer file.Close()
+	// Run a subcommand instead of starting the service
+	if len(opts.Args) > 0 {
+		if err := service.RunCommand(cfg, opts.Args); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return 0
+	}
 
-	// Redirect Gin's default writer and error writer to the log file
-	gin.DefaultWriter = file
-	gin.DefaultErrorWriter = file
+	// Initialize logging to the log file or a standard stream
+	logFile, err := logging.Setup(cfg.LogURL, cfg.Logging)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to set up logging:", err)
+		return 1
+	}
+	defer logFile.Close()
 
-	// Create a new Gin engine and register the routes
-	engine := gin.Default()
-	registerRoutes(engine)
+	// Redirect Gin's default writer and error writer to the logger
+	gin.DefaultWriter = log.Writer()
+	gin.DefaultErrorWriter = log.Writer()
 
-	// Connect to the database and migrate models
-	db = database.New(DATABASE_URL)
-	defer database.Close(db)
-	migrateModels()
+	// Install the tracer provider, flushing the pending spans on exit
+	shutdownTracing, err := tracing.Setup(context.Background(), service.SERVICE_NAME, cfg.Tracing)
+	if err != nil {
+		slog.Error("Failed to set up tracing", "error", err)
+		return 1
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			slog.Error("Failed to flush spans", "error", err)
+		}
+	}()
 
-	// Start the gRPC server in a new goroutine
-	go startGRPC()
+	// Load the certificates, connect to the database and apply the pending migrations
+	svc, err := service.Open(cfg)
+	if err != nil {
+		slog.Error("Failed to open the service", "error", err)
+		return 1
+	}
+	defer svc.Close()
 
-	// Start the REST server in a new goroutine
-	go engine.Run(REST_HOST + ":" + REST_PORT)
+	// Run the REST and gRPC servers until a termination signal or a server failure, then drain them in order
+	manager := lifecycle.New(cfg.ShutdownTimeout)
+	for _, component := range svc.Components() {
+		manager.Add(component)
+	}
 
-	// Wait for a termination signal to gracefully shut down the server
-	utils.WaitForSignal()
-	log.Println("All services down")
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := manager.Run(ctx); err != nil {
+		slog.Error("Shutdown with errors", "error", err)
+		return 1
+	}
+	slog.Info("All services down")
+	return 0
 }
@@ -0,0 +1,142 @@
+package service
+
+import (
+	"common/apierror"
+	"common/logging"
+	"common/metrics"
+	pb "common/protobuff"
+	"common/tracing"
+	"common/utils"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// HEALTH_INTERVAL is the interval between the database pings of the gRPC health monitor
+const HEALTH_INTERVAL time.Duration = 5 * time.Second
+
+// server struct implements the gRPC service interface defined in the protobuf
+type server struct {
+	pb.UnimplementedLocationHistoryServiceServer
+	store LocationStore // Store of the recorded locations
+}
+
+// newServer creates the implementation of the LocationHistoryService recording the updates in store
+func newServer(store LocationStore) *server {
+	return &server{store: store}
+}
+
+// newGRPCServer creates the gRPC server with the LocationHistoryService and the grpc.health.v1 service registered
+// The options are added to the instrumentation of the server, e.g. the transport credentials
+func newGRPCServer(srv *server, healthServer *health.Server, opts ...grpc.ServerOption) *grpc.Server {
+	opts = append(opts, grpc.ChainUnaryInterceptor(unaryInterceptors()...), tracing.GRPCServerOption())
+	s := grpc.NewServer(opts...)
+	pb.RegisterLocationHistoryServiceServer(s, srv)
+	healthpb.RegisterHealthServer(s, healthServer)
+	return s
+}
+
+// unaryInterceptors lists the interceptors of the RPCs handled by the gRPC server and by the gateway
+func unaryInterceptors() []grpc.UnaryServerInterceptor {
+	return []grpc.UnaryServerInterceptor{logging.UnaryServerInterceptor(), metrics.UnaryServerInterceptor()}
+}
+
+// newGateway creates the REST/JSON gateway of the LocationHistoryService
+// The routes come from the HTTP annotations of misc/spec.proto and the requests are handled by the same server as the
+// gRPC calls, through the same interceptors, so they go through the same validation, logging and metrics
+func newGateway(srv *server) (http.Handler, error) {
+	mux := runtime.NewServeMux(runtime.WithErrorHandler(gatewayErrorHandler))
+	interceptors := append([]grpc.UnaryServerInterceptor{tracing.UnaryServerInterceptor()}, unaryInterceptors()...)
+	gateway := &gatewayServer{server: srv, interceptor: chainUnaryInterceptors(interceptors...)}
+	if err := pb.RegisterLocationHistoryServiceHandlerServer(context.Background(), mux, gateway); err != nil {
+		return nil, fmt.Errorf("failed to register the gateway: %w", err)
+	}
+	return mux, nil
+}
+
+// gatewayServer calls the server for the gateway through the interceptors of the gRPC server
+// The gateway calls the server in process, where the interceptors and the tracing of the gRPC server do not apply
+type gatewayServer struct {
+	pb.UnimplementedLocationHistoryServiceServer
+	server      *server                     // Server handling the calls
+	interceptor grpc.UnaryServerInterceptor // Chain of the interceptors of the calls
+}
+
+// UpdateHistory handles the UpdateHistory calls of the gateway
+func (g *gatewayServer) UpdateHistory(ctx context.Context, req *pb.LocationUpdateRequest) (*pb.LocationUpdateReply, error) {
+	info := &grpc.UnaryServerInfo{Server: g.server, FullMethod: pb.LocationHistoryService_UpdateHistory_FullMethodName}
+	res, err := g.interceptor(ctx, req, info, func(ctx context.Context, req any) (any, error) {
+		return g.server.UpdateHistory(ctx, req.(*pb.LocationUpdateRequest))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res.(*pb.LocationUpdateReply), nil
+}
+
+// chainUnaryInterceptors combines interceptors into one, the first being the outermost like in grpc.ChainUnaryInterceptor
+func chainUnaryInterceptors(interceptors ...grpc.UnaryServerInterceptor) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, next := interceptors[i], handler
+			handler = func(ctx context.Context, req any) (any, error) {
+				return interceptor(ctx, req, info, next)
+			}
+		}
+		return handler(ctx, req)
+	}
+}
+
+// gatewayErrorHandler writes the errors of the gateway as problems, like the errors of the Gin routes
+// Errors of the catalogue keep their code, the errors of the gateway itself are converted from their status code
+func gatewayErrorHandler(ctx context.Context, mux *runtime.ServeMux, marshaler runtime.Marshaler, w http.ResponseWriter, r *http.Request, err error) {
+	apierror.Write(w, r, err)
+}
+
+// UpdateHistory handles the UpdateHistory RPC call
+// It updates the location history for a given username and coordinates
+// Failures are returned as errors of the catalogue, so that the client receives their code, see apierror.Error.GRPCStatus
+func (s *server) UpdateHistory(ctx context.Context, req *pb.LocationUpdateRequest) (*pb.LocationUpdateReply, error) {
+	username := req.GetUsername()
+	longitude := req.GetLongitude()
+	latitude := req.GetLatitude()
+	idempotencyKey := req.GetIdempotencyKey()
+
+	// Validate the username
+	if err := utils.CheckUsername(username); err != nil {
+		return nil, apierror.From(err)
+	}
+
+	// Validate the coordinates
+	if err := utils.CheckCoordinates(longitude, latitude); err != nil {
+		return nil, apierror.From(err)
+	}
+
+	// Validate the idempotency key, if one is provided
+	if idempotencyKey != "" {
+		if err := utils.CheckIdempotencyKey(idempotencyKey); err != nil {
+			return nil, apierror.From(err)
+		}
+	}
+
+	// Update the location history in the store, retries with a seen idempotency key are not written again
+	// The location is recorded at the time of insertion, recorded history is imported through the admin route,
+	// see Service.importHistory
+	loc := Location{Username: username, Longitude: longitude, Latitude: latitude}
+	if err := s.store.Add(ctx, loc, idempotencyKey); err != nil {
+		if errors.Is(err, errIdempotencyKeyReused) {
+			return nil, err
+		}
+		return nil, apierror.Wrap(apierror.INTERNAL, "could not update location history", err)
+	}
+
+	// Return a successful response
+	return &pb.LocationUpdateReply{Status: pb.Status_SUCCESS}, nil
+}
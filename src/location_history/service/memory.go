@@ -0,0 +1,173 @@
+package service
+
+import (
+	"common/utils"
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryLocationStore is a LocationStore keeping the locations in memory, e.g. for tests or to embed the service
+// without a database
+// It behaves like GormLocationStore without PostGIS: IDs are assigned from 1 and coordinates are rounded to eight decimals
+type MemoryLocationStore struct {
+	mu        sync.Mutex
+	locations []Location                   // Locations ordered by ID
+	nextID    uint                         // ID of the next recorded location
+	records   map[string]IdempotencyRecord // Idempotency records by key
+	geodesic  utils.Geodesic               // Model of the earth measuring the distances of the area tests
+}
+
+// NewMemoryLocationStore returns an empty in-memory store measuring distances with the geodesic model
+func NewMemoryLocationStore(geodesic utils.Geodesic) *MemoryLocationStore {
+	return &MemoryLocationStore{nextID: 1, records: make(map[string]IdempotencyRecord), geodesic: geodesic}
+}
+
+// Add records a location, at loc.Time or now if it is zero, see LocationStore
+func (s *MemoryLocationStore) Add(ctx context.Context, loc Location, idempotencyKey string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if idempotencyKey != "" {
+		// The key was already seen, return the original result without writing again, expired keys are overwritten
+		if record, ok := s.records[idempotencyKey]; ok && !record.CreatedAt.Before(time.Now().Add(-IDEMPOTENCY_TTL)) {
+			if !record.matches(loc.Username, loc.Longitude, loc.Latitude) {
+				return errIdempotencyKeyReused
+			}
+			return nil
+		}
+	}
+
+	loc.ID = s.nextID
+	loc.Longitude, loc.Latitude = utils.RoundToEightDecimals(loc.Longitude), utils.RoundToEightDecimals(loc.Latitude)
+	if loc.Time.IsZero() {
+		loc.Time = time.Now()
+	}
+	s.locations = append(s.locations, loc)
+	s.nextID++
+
+	if idempotencyKey != "" {
+		s.records[idempotencyKey] = IdempotencyRecord{IdempotencyKey: idempotencyKey, Username: loc.Username,
+			Longitude: loc.Longitude, Latitude: loc.Latitude, CreatedAt: time.Now()}
+	}
+	return nil
+}
+
+// Track returns the locations recorded for a user between two timestamps, in chronological order
+func (s *MemoryLocationStore) Track(ctx context.Context, username string, startTime time.Time, endTime time.Time) ([]Location, error) {
+	return s.filter(false, func(loc Location) bool {
+		return loc.Username == username && within(loc.Time, startTime, endTime)
+	}), nil
+}
+
+// Around returns the last location of a user recorded at or before a time and the first one at or after it
+func (s *MemoryLocationStore) Around(ctx context.Context, username string, at time.Time) (*Location, *Location, error) {
+	track := s.filter(false, func(loc Location) bool { return loc.Username == username })
+
+	var before, after *Location
+	for i := range track {
+		if !track[i].Time.After(at) {
+			before = &track[i]
+		}
+		if after == nil && !track[i].Time.Before(at) {
+			after = &track[i]
+		}
+	}
+	return before, after, nil
+}
+
+// InBox returns the locations of the users other than username recorded inside a bounding box between two timestamps
+func (s *MemoryLocationStore) InBox(ctx context.Context, username string, startTime time.Time, endTime time.Time, minLon, minLat, maxLon, maxLat float64) ([]Location, error) {
+	return s.filter(true, func(loc Location) bool {
+		return loc.Username != username && within(loc.Time, startTime, endTime) &&
+			loc.Longitude >= minLon && loc.Longitude <= maxLon && loc.Latitude >= minLat && loc.Latitude <= maxLat
+	}), nil
+}
+
+// InArea returns the locations recorded inside an area between two timestamps
+func (s *MemoryLocationStore) InArea(ctx context.Context, area Area, startTime time.Time, endTime time.Time) ([]Location, error) {
+	minLon, minLat, maxLon, maxLat := area.BoundingBox()
+	return s.filter(true, func(loc Location) bool {
+		return within(loc.Time, startTime, endTime) &&
+			loc.Longitude >= minLon && loc.Longitude <= maxLon && loc.Latitude >= minLat && loc.Latitude <= maxLat &&
+			area.Contains(s.geodesic, loc.Longitude, loc.Latitude)
+	}), nil
+}
+
+// Each calls fn with every location recorded between two timestamps, stopping at the first error
+func (s *MemoryLocationStore) Each(ctx context.Context, startTime time.Time, endTime time.Time, fn func(Location) error) error {
+	locations := s.filter(true, func(loc Location) bool { return within(loc.Time, startTime, endTime) })
+	for _, loc := range locations {
+		if err := fn(loc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete deletes every location recorded for a user and returns the number of deleted locations
+func (s *MemoryLocationStore) Delete(ctx context.Context, username string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	kept := s.locations[:0]
+	for _, loc := range s.locations {
+		if loc.Username != username {
+			kept = append(kept, loc)
+		}
+	}
+	deleted := int64(len(s.locations) - len(kept))
+	s.locations = kept
+	return deleted, nil
+}
+
+// PruneIdempotencyRecords deletes the records of the keys seen before IDEMPOTENCY_TTL
+func (s *MemoryLocationStore) PruneIdempotencyRecords(ctx context.Context) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var pruned int64
+	expiry := time.Now().Add(-IDEMPOTENCY_TTL)
+	for key, record := range s.records {
+		if record.CreatedAt.Before(expiry) {
+			delete(s.records, key)
+			pruned++
+		}
+	}
+	return pruned, nil
+}
+
+// Ping always succeeds, the memory is always usable
+func (s *MemoryLocationStore) Ping(ctx context.Context) error {
+	return nil
+}
+
+// filter returns a copy of the locations matching keep, ordered by time then ID, preceded by username if byUser is set
+func (s *MemoryLocationStore) filter(byUser bool, keep func(Location) bool) []Location {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	locations := make([]Location, 0)
+	for _, loc := range s.locations {
+		if keep(loc) {
+			locations = append(locations, loc)
+		}
+	}
+
+	sort.SliceStable(locations, func(i, j int) bool {
+		if byUser && locations[i].Username != locations[j].Username {
+			return locations[i].Username < locations[j].Username
+		}
+		if !locations[i].Time.Equal(locations[j].Time) {
+			return locations[i].Time.Before(locations[j].Time)
+		}
+		return locations[i].ID < locations[j].ID
+	})
+	return locations
+}
+
+// within reports whether t is between start and end, both included like SQL BETWEEN
+func within(t time.Time, start time.Time, end time.Time) bool {
+	return !t.Before(start) && !t.After(end)
+}
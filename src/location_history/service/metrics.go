@@ -0,0 +1,6 @@
+package service
+
+import "common/metrics"
+
+// historyRows reports the number of location history points stored by every service on a GORM store
+var historyRows = metrics.NewRowCounter("location_history_rows", "Number of stored location history points", &Location{}, metrics.ROW_COUNT_INTERVAL)
@@ -0,0 +1,67 @@
+package service
+
+import (
+	"common/database"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// migrations lists the schema changes of the service in order
+// Every migration declares the models as they were at its version, so that it keeps working when the models change
+// The first migrations use AutoMigrate so that databases created before migrations existed are adopted as they are
+var migrations = []database.Migration{
+	{
+		Version:     1,
+		Description: "Create locations",
+		Up: func(tx *gorm.DB) error {
+			type location struct {
+				ID        uint   `gorm:"primaryKey;autoIncrement"`
+				Username  string `gorm:"index"`
+				Longitude float64
+				Latitude  float64
+				Time      time.Time
+			}
+			return tx.Table("locations").AutoMigrate(&location{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable("locations")
+		},
+	},
+	{
+		Version:     2,
+		Description: "Index locations by time",
+		Up: func(tx *gorm.DB) error {
+			type location struct {
+				Time time.Time `gorm:"index"`
+			}
+			if tx.Table("locations").Migrator().HasIndex(&location{}, "Time") {
+				return nil
+			}
+			return tx.Table("locations").Migrator().CreateIndex(&location{}, "Time")
+		},
+		Down: func(tx *gorm.DB) error {
+			type location struct {
+				Time time.Time `gorm:"index"`
+			}
+			return tx.Table("locations").Migrator().DropIndex(&location{}, "Time")
+		},
+	},
+	{
+		Version:     3,
+		Description: "Create idempotency records",
+		Up: func(tx *gorm.DB) error {
+			type idempotencyRecord struct {
+				IdempotencyKey string `gorm:"primaryKey"`
+				Username       string
+				Longitude      float64
+				Latitude       float64
+				CreatedAt      time.Time `gorm:"index"`
+			}
+			return tx.Table("idempotency_records").AutoMigrate(&idempotencyRecord{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable("idempotency_records")
+		},
+	},
+}
@@ -0,0 +1,425 @@
+package service
+
+import (
+	"common/api"
+	"common/apierror"
+	"common/utils"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Location represents a geographical location with a username, coordinates, and timestamp
+type Location struct {
+	ID        uint      `gorm:"primaryKey;autoIncrement"` // Primary key, auto-incremented
+	Username  string    `gorm:"index"`                    // Indexed username
+	Longitude float64   // Longitude coordinate
+	Latitude  float64   // Latitude coordinate
+	Time      time.Time `gorm:"autoCreateTime;index"` // Timestamp, auto-created on record insertion, indexed for time range queries
+}
+
+// toAPI returns the location as answered by the REST API
+func (l Location) toAPI() api.Location {
+	return api.Location{ID: l.ID, Username: l.Username, Longitude: l.Longitude, Latitude: l.Latitude, Time: l.Time}
+}
+
+// IdempotencyRecord remembers a processed history update so that retries with the same idempotency key are not written again
+type IdempotencyRecord struct {
+	IdempotencyKey string    `gorm:"primaryKey"` // Client generated UUID
+	Username       string    // Username of the original request
+	Longitude      float64   // Longitude of the original request
+	Latitude       float64   // Latitude of the original request
+	CreatedAt      time.Time `gorm:"index"` // Time the key was first seen, indexed for pruning expired keys
+}
+
+const (
+	IDEMPOTENCY_TTL time.Duration = 24 * time.Hour // Time during which a seen idempotency key is remembered
+	PRUNE_INTERVAL  time.Duration = time.Hour      // Interval between two deletions of the expired idempotency records
+)
+
+// ErrNotFound is returned when a user has no recorded location
+var ErrNotFound = errors.New("no recorded locations")
+
+// errIdempotencyKeyReused is returned when an idempotency key is reused with a different request
+var errIdempotencyKeyReused = apierror.New(apierror.IDEMPOTENCY_KEY_REUSED, "idempotency key was already used for a different request")
+
+// matches reports whether the record was created for the same request
+func (record *IdempotencyRecord) matches(username string, longitude float64, latitude float64) bool {
+	return record.Username == username &&
+		record.Longitude == utils.RoundToEightDecimals(longitude) &&
+		record.Latitude == utils.RoundToEightDecimals(latitude)
+}
+
+// BeforeSave GORM hook, executes before every save operation
+// This method rounds the longitude and latitude the same way as the Location they belong to
+func (record *IdempotencyRecord) BeforeSave(tx *gorm.DB) (err error) {
+	record.Longitude = utils.RoundToEightDecimals(record.Longitude)
+	record.Latitude = utils.RoundToEightDecimals(record.Latitude)
+	return
+}
+
+// Area represents a geographical region that locations can be tested against
+type Area interface {
+	Contains(geodesic utils.Geodesic, longitude, latitude float64) bool // Whether the coordinates are inside the area
+	BoundingBox() (minLon, minLat, maxLon, maxLat float64)              // Smallest box containing the whole area
+	postgisCondition(spheroid bool) (string, []any)                     // SQL condition selecting the locations inside the area with PostGIS
+}
+
+// POSTGIS_POINT is the SQL expression of a location as a PostGIS geometry
+const POSTGIS_POINT = "ST_SetSRID(ST_MakePoint(Longitude, Latitude), 4326)"
+
+// Circle is an Area containing every point within Radius meters of its center
+type Circle struct {
+	Longitude float64 // Longitude of the center
+	Latitude  float64 // Latitude of the center
+	Radius    float64 // Radius in meters
+}
+
+// Contains reports whether the coordinates are within the circle, measuring the distance with the geodesic model
+func (circle Circle) Contains(geodesic utils.Geodesic, longitude, latitude float64) bool {
+	return geodesic.Distance(circle.Longitude, circle.Latitude, longitude, latitude) <= circle.Radius
+}
+
+// BoundingBox returns the smallest box containing the circle
+func (circle Circle) BoundingBox() (float64, float64, float64, float64) {
+	return expandBoundingBox(circle.Longitude, circle.Latitude, circle.Longitude, circle.Latitude, circle.Radius)
+}
+
+// postgisCondition selects the locations within the circle, on the WGS84 spheroid or on a sphere
+func (circle Circle) postgisCondition(spheroid bool) (string, []any) {
+	return "ST_DWithin(" + POSTGIS_POINT + "::geography, ST_SetSRID(ST_MakePoint(?, ?), 4326)::geography, ?, ?)",
+		[]any{circle.Longitude, circle.Latitude, circle.Radius, spheroid}
+}
+
+// Polygon is an Area bounded by a closed ring of vertices given as (longitude, latitude) pairs
+// Edges are treated as straight lines in longitude and latitude, polygons crossing the antimeridian are not supported
+type Polygon [][2]float64
+
+// Contains reports whether the coordinates are inside the polygon using the ray casting algorithm
+// The edges are straight lines in longitude and latitude whatever the geodesic model
+func (polygon Polygon) Contains(geodesic utils.Geodesic, longitude, latitude float64) bool {
+	inside := false
+	for i, j := 0, len(polygon)-1; i < len(polygon); j, i = i, i+1 {
+		lonI, latI := polygon[i][0], polygon[i][1]
+		lonJ, latJ := polygon[j][0], polygon[j][1]
+		if (latI > latitude) != (latJ > latitude) &&
+			longitude < (lonJ-lonI)*(latitude-latI)/(latJ-latI)+lonI {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// BoundingBox returns the smallest box containing the polygon
+func (polygon Polygon) BoundingBox() (float64, float64, float64, float64) {
+	minLon, minLat, maxLon, maxLat := 180.0, 90.0, -180.0, -90.0
+	for _, vertex := range polygon {
+		minLon, maxLon = math.Min(minLon, vertex[0]), math.Max(maxLon, vertex[0])
+		minLat, maxLat = math.Min(minLat, vertex[1]), math.Max(maxLat, vertex[1])
+	}
+	return minLon, minLat, maxLon, maxLat
+}
+
+// postgisCondition selects the locations covered by the polygon, edges are planar as in Contains
+func (polygon Polygon) postgisCondition(spheroid bool) (string, []any) {
+	// WKT rings are closed by repeating the first vertex
+	vertices := make([]string, 0, len(polygon)+1)
+	for i := 0; i <= len(polygon); i++ {
+		vertex := polygon[i%len(polygon)]
+		vertices = append(vertices, strconv.FormatFloat(vertex[0], 'f', -1, 64)+" "+strconv.FormatFloat(vertex[1], 'f', -1, 64))
+	}
+	return "ST_Covers(ST_GeomFromText(?, 4326), " + POSTGIS_POINT + ")", []any{"POLYGON((" + strings.Join(vertices, ", ") + "))"}
+}
+
+// Visitor represents a user that was inside an area during a time window
+type Visitor struct {
+	Username  string    // Username of the visitor
+	FirstSeen time.Time // Time of the first point inside the area
+	LastSeen  time.Time // Time of the last point inside the area
+	Points    int       // Number of points inside the area
+}
+
+// LeaderboardEntry represents the distance traveled by a user in a time window and its rank among all users
+// Version 1 of the API returns it with the distance converted to kilometers, version 2 returns a LeaderboardEntryV2
+type LeaderboardEntry struct {
+	Rank     int     // Position in the leaderboard, starting at 1
+	Username string  // Username of the user
+	Distance float64 // Distance traveled in meters, in kilometers in version 1 of the API
+}
+
+// LeaderboardEntryV2 is the leaderboard entry of version 2 of the API, naming the unit of the distance
+type LeaderboardEntryV2 struct {
+	Rank           int     // Position in the leaderboard, starting at 1
+	Username       string  // Username of the user
+	DistanceMeters float64 `json:"distance_meters"` // Distance traveled in meters
+}
+
+// Encounter represents a period during which another user stayed close to the queried user
+type Encounter struct {
+	Username    string    // Username of the other user
+	Start       time.Time // Time of the first point of the encounter
+	End         time.Time // Time of the last point of the encounter
+	MinDistance float64   // Minimum distance between the two users in meters
+	Duration    float64   // Length of the encounter in seconds
+}
+
+// Position represents the location of a user at a requested time
+type Position struct {
+	Username     string    // Username of the user
+	Longitude    float64   // Longitude coordinate
+	Latitude     float64   // Latitude coordinate
+	Time         time.Time // Requested time
+	Interpolated bool      // Whether the position is interpolated between two recorded points
+	Gap          float64   // Time in seconds between the requested time and the nearest recorded point
+}
+
+// String method returns a string representation of the Location struct
+func (loc *Location) String() string {
+	return fmt.Sprintf("Coordinates: (%.8f, %.8f)", loc.Longitude, loc.Latitude)
+}
+
+// BeforeSave GORM hook, executes before every save operation
+// This method rounds the longitude and latitude to eight decimal places before saving
+func (loc *Location) BeforeSave(tx *gorm.DB) (err error) {
+	loc.Longitude = utils.RoundToEightDecimals(loc.Longitude)
+	loc.Latitude = utils.RoundToEightDecimals(loc.Latitude)
+	return
+}
+
+// calculateDistanceByUsername calculates the total distance in meters traveled by a user between two timestamps
+// It retrieves the user's locations from the store and sums up the distances between consecutive points
+func (s *Service) calculateDistanceByUsername(ctx context.Context, username string, startTime time.Time, endTime time.Time) (float64, error) {
+	locations, err := s.store.Track(ctx, username, startTime, endTime)
+	if err != nil {
+		return 0, err
+	}
+
+	if locations == nil || len(locations) < 2 {
+		return 0, nil
+	}
+
+	var totalDistance float64
+	prevLoc := locations[0]
+	for _, currLoc := range locations[1:] {
+		totalDistance += s.geodesic.Distance(prevLoc.Longitude, prevLoc.Latitude, currLoc.Longitude, currLoc.Latitude)
+		prevLoc = currLoc
+	}
+
+	return totalDistance, nil
+}
+
+// getLeaderboard ranks the users by the distance in meters traveled between two timestamps
+// The distances are computed in a single pass over the locations, streamed ordered by username and time
+// Users with equal distances are ordered by username, and at most limit entries are returned
+func (s *Service) getLeaderboard(ctx context.Context, startTime time.Time, endTime time.Time, limit int) ([]LeaderboardEntry, error) {
+	entries := make([]LeaderboardEntry, 0)
+	var prevLoc Location
+	err := s.store.Each(ctx, startTime, endTime, func(currLoc Location) error {
+		// Locations are ordered by username, so a new username starts a new entry
+		if len(entries) == 0 || prevLoc.Username != currLoc.Username {
+			entries = append(entries, LeaderboardEntry{Username: currLoc.Username})
+		} else {
+			entries[len(entries)-1].Distance += s.geodesic.Distance(prevLoc.Longitude, prevLoc.Latitude, currLoc.Longitude, currLoc.Latitude)
+		}
+		prevLoc = currLoc
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Order by distance, breaking ties by username
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Distance != entries[j].Distance {
+			return entries[i].Distance > entries[j].Distance
+		}
+		return entries[i].Username < entries[j].Username
+	})
+
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+
+	for i := range entries {
+		entries[i].Rank = i + 1
+	}
+
+	return entries, nil
+}
+
+// MIN_CURVATURE_RADIUS is the smallest radius of curvature of the earth in meters, the meridional one of the WGS84
+// ellipsoid at the equator, so that a degree is never shorter than on a sphere of this radius whatever the geodesic model
+const MIN_CURVATURE_RADIUS = utils.WGS84_A * (1 - utils.WGS84_F) * (1 - utils.WGS84_F)
+
+// expandBoundingBox expands a bounding box by the given distance in meters on every side
+// Longitude degrees shrink towards the poles, so the full longitude range is used if the box reaches a pole or the antimeridian
+func expandBoundingBox(minLon, minLat, maxLon, maxLat, distance float64) (float64, float64, float64, float64) {
+	latPad := distance / MIN_CURVATURE_RADIUS * 180 / math.Pi
+	minLat, maxLat = minLat-latPad, maxLat+latPad
+	if minLat <= -90 || maxLat >= 90 {
+		return -180, math.Max(minLat, -90), 180, math.Min(maxLat, 90)
+	}
+
+	lonPad := latPad / math.Cos(math.Max(math.Abs(minLat), math.Abs(maxLat))*math.Pi/180)
+	if minLon-lonPad < -180 || maxLon+lonPad > 180 {
+		return -180, minLat, 180, maxLat
+	}
+
+	return minLon - lonPad, minLat, maxLon + lonPad, maxLat
+}
+
+// findEncounters finds the users that were within radius meters of the given user between two timestamps
+// Points of two users are compared only if they were recorded at most tolerance apart. Candidate points are
+// narrowed down by the store with a bounding box around the user's track, and each candidate is matched only against
+// the track points inside its time tolerance, found by binary search over the time ordered track
+func (s *Service) findEncounters(ctx context.Context, username string, radius float64, tolerance time.Duration, startTime time.Time, endTime time.Time) ([]Encounter, error) {
+	track, err := s.store.Track(ctx, username, startTime, endTime)
+	if err != nil {
+		return nil, err
+	}
+
+	encounters := make([]Encounter, 0)
+	if len(track) == 0 {
+		return encounters, nil
+	}
+
+	// Compute the bounding box of the track, expanded by the radius
+	minLon, maxLon, minLat, maxLat := track[0].Longitude, track[0].Longitude, track[0].Latitude, track[0].Latitude
+	for _, loc := range track[1:] {
+		minLon, maxLon = math.Min(minLon, loc.Longitude), math.Max(maxLon, loc.Longitude)
+		minLat, maxLat = math.Min(minLat, loc.Latitude), math.Max(maxLat, loc.Latitude)
+	}
+	minLon, minLat, maxLon, maxLat = expandBoundingBox(minLon, minLat, maxLon, maxLat, radius)
+
+	candidates, err := s.store.InBox(ctx, username, startTime.Add(-tolerance), endTime.Add(tolerance), minLon, minLat, maxLon, maxLat)
+	if err != nil {
+		return nil, err
+	}
+
+	var current *Encounter
+	closeEncounter := func() {
+		if current != nil {
+			current.Duration = current.End.Sub(current.Start).Seconds()
+			encounters = append(encounters, *current)
+			current = nil
+		}
+	}
+
+	for _, loc := range candidates {
+		// A new user or a gap longer than the tolerance ends the current encounter
+		if current != nil && (current.Username != loc.Username || loc.Time.Sub(current.End) > tolerance) {
+			closeEncounter()
+		}
+
+		// Find the first track point that is not older than the candidate point minus the tolerance
+		first := sort.Search(len(track), func(i int) bool {
+			return !track[i].Time.Before(loc.Time.Add(-tolerance))
+		})
+
+		minDistance := math.Inf(1)
+		for i := first; i < len(track) && !track[i].Time.After(loc.Time.Add(tolerance)); i++ {
+			distance := s.geodesic.Distance(loc.Longitude, loc.Latitude, track[i].Longitude, track[i].Latitude)
+			minDistance = math.Min(minDistance, distance)
+		}
+
+		// A candidate point outside the radius means the users have parted ways
+		if minDistance > radius {
+			closeEncounter()
+			continue
+		}
+
+		if current == nil {
+			current = &Encounter{Username: loc.Username, Start: loc.Time, MinDistance: minDistance}
+		}
+		current.End = loc.Time
+		current.MinDistance = math.Min(current.MinDistance, minDistance)
+	}
+	closeEncounter()
+
+	// Order the encounters chronologically
+	sort.SliceStable(encounters, func(i, j int) bool {
+		return encounters[i].Start.Before(encounters[j].Start)
+	})
+
+	return encounters, nil
+}
+
+// getPositionAtTime finds the position of a user at the given time
+// It looks up the recorded points right before and right after that time. If interpolate is set and both exist,
+// the position is interpolated along the great circle between them, otherwise the nearest recorded point is returned
+// If the user has no recorded points, ErrNotFound is returned
+func (s *Service) getPositionAtTime(ctx context.Context, username string, at time.Time, interpolate bool) (Position, error) {
+	beforePtr, afterPtr, err := s.store.Around(ctx, username, at)
+	if err != nil {
+		return Position{}, err
+	}
+	beforeExists, afterExists := beforePtr != nil, afterPtr != nil
+
+	if !beforeExists && !afterExists {
+		return Position{}, ErrNotFound
+	}
+
+	var before, after Location
+	if beforeExists {
+		before = *beforePtr
+	}
+	if afterExists {
+		after = *afterPtr
+	}
+
+	position := Position{Username: username, Time: at}
+	gapBefore := at.Sub(before.Time).Seconds()
+	gapAfter := after.Time.Sub(at).Seconds()
+
+	// Fall back to the nearest recorded point
+	nearest := before
+	position.Gap = gapBefore
+	if !beforeExists || (afterExists && gapAfter < gapBefore) {
+		nearest = after
+		position.Gap = gapAfter
+	}
+	position.Longitude, position.Latitude = nearest.Longitude, nearest.Latitude
+
+	if !interpolate || !beforeExists || !afterExists || position.Gap == 0 {
+		return position, nil
+	}
+
+	// Interpolate between the surrounding points proportionally to the elapsed time
+	fraction := gapBefore / after.Time.Sub(before.Time).Seconds()
+	position.Longitude, position.Latitude = utils.InterpolateGreatCircle(before.Longitude, before.Latitude, after.Longitude, after.Latitude, fraction)
+	position.Longitude = utils.RoundToEightDecimals(position.Longitude)
+	position.Latitude = utils.RoundToEightDecimals(position.Latitude)
+	position.Interpolated = true
+
+	return position, nil
+}
+
+// findVisitors finds the users with at least one recorded point inside the area between two timestamps
+// The visitors are ordered by username
+func (s *Service) findVisitors(ctx context.Context, area Area, startTime time.Time, endTime time.Time) ([]Visitor, error) {
+	locations, err := s.store.InArea(ctx, area, startTime, endTime)
+	if err != nil {
+		return nil, err
+	}
+
+	visitors := make([]Visitor, 0)
+	for _, loc := range locations {
+		// Locations are ordered by username, so a new username starts a new visitor
+		if len(visitors) == 0 || visitors[len(visitors)-1].Username != loc.Username {
+			visitors = append(visitors, Visitor{Username: loc.Username, FirstSeen: loc.Time})
+		}
+
+		visitor := &visitors[len(visitors)-1]
+		visitor.LastSeen = loc.Time
+		visitor.Points++
+	}
+
+	return visitors, nil
+}
@@ -0,0 +1,11 @@
+package service
+
+import (
+	_ "embed"
+)
+
+// openapiSpec is the OpenAPI document of the REST API, served at /openapi.json
+// Every route registered by registerRoutes must be documented, which the tests check
+//
+//go:embed openapi.json
+var openapiSpec []byte
@@ -0,0 +1,519 @@
+package service
+
+import (
+	"common/api"
+	"common/apierror"
+	"common/utils"
+	"common/versioning"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	LAYOUT            string        = time.RFC3339 // Time layout for parsing and formatting
+	DEFAULT_TOLERANCE int           = 60           // Default time tolerance in seconds when matching two tracks
+	MAX_LEADERBOARD   int           = 100          // Maximum number of entries returned by the leaderboard
+	MAX_CLOCK_SKEW    time.Duration = time.Minute  // Tolerance for the times of the imported locations set ahead of the clock of the service
+)
+
+// parseTimeBounds parses the lower and upper time bounds of a query
+// Either both bounds or none must be provided, in which case the last 24 hours are used
+func parseTimeBounds(startTimeStr string, endTimeStr string) (time.Time, time.Time, error) {
+	startExists := (startTimeStr != "")
+	endExists := (endTimeStr != "")
+	// Ensure both start and end times are provided or none at all
+	if (startExists && !endExists) || (!startExists && endExists) {
+		return time.Time{}, time.Time{}, apierror.New(apierror.INVALID_TIME_RANGE, "provide either both lower and upper time bound or none")
+	}
+
+	// Default to the last 24 hours if no time bounds are provided
+	if !startExists {
+		endTime := time.Now()
+		return endTime.AddDate(0, 0, -1), endTime, nil
+	}
+
+	// Replace spaces with plus signs in the time strings
+	startTimeStr = strings.ReplaceAll(startTimeStr, " ", "+")
+	endTimeStr = strings.ReplaceAll(endTimeStr, " ", "+")
+
+	startTime, err := time.Parse(LAYOUT, startTimeStr)
+	if err != nil {
+		return time.Time{}, time.Time{}, apierror.New(apierror.INVALID_TIME_RANGE, "lower time bound of unknown format")
+	}
+
+	endTime, err := time.Parse(LAYOUT, endTimeStr)
+	if err != nil {
+		return time.Time{}, time.Time{}, apierror.New(apierror.INVALID_TIME_RANGE, "upper time bound of unknown format")
+	}
+
+	// Ensure the start time is before the end time
+	if startTime.After(endTime) {
+		return time.Time{}, time.Time{}, apierror.New(apierror.INVALID_TIME_RANGE, "end time is set before start time")
+	}
+
+	return startTime, endTime, nil
+}
+
+// parsePolygon parses a polygon given as a comma separated list of vertices "lon1,lat1,lon2,lat2,..."
+// The polygon must have at least three vertices
+func parsePolygon(polygonStr string) (Polygon, error) {
+	values := strings.Split(polygonStr, ",")
+	if len(values)%2 != 0 {
+		return nil, apierror.New(apierror.INVALID_AREA, "polygon vertices must be given as longitude,latitude pairs")
+	}
+
+	if len(values) < 6 {
+		return nil, apierror.New(apierror.INVALID_AREA, "polygon must have at least three vertices")
+	}
+
+	polygon := make(Polygon, 0, len(values)/2)
+	for i := 0; i < len(values); i += 2 {
+		longitude, errLon := strconv.ParseFloat(strings.TrimSpace(values[i]), 64)
+		latitude, errLat := strconv.ParseFloat(strings.TrimSpace(values[i+1]), 64)
+		if errLon != nil || errLat != nil {
+			return nil, apierror.New(apierror.INVALID_AREA, "polygon vertices must be given as longitude,latitude pairs")
+		}
+
+		// Check if the coordinates are valid
+		if err := utils.CheckCoordinates(longitude, latitude); err != nil {
+			return nil, err
+		}
+
+		polygon = append(polygon, [2]float64{longitude, latitude})
+	}
+
+	return polygon, nil
+}
+
+// radiusParameter returns the name of the query parameter giving a radius in meters in the version of the request
+func radiusParameter(c *gin.Context) string {
+	if versioning.FromContext(c) == "v1" {
+		return "radius"
+	}
+	return "radius_meters"
+}
+
+// getTraveledDistance handles the HTTP GET request to calculate the distance traveled by a user
+// The distance is returned in kilometers in version 1, in meters from version 2 on
+func (s *Service) getTraveledDistance(c *gin.Context) {
+	username := c.Param("username")
+
+	// Check if the username is valid
+	if err := utils.CheckUsername(username); err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+
+	// Struct to bind query parameters
+	data := struct {
+		StartTimeStr string `form:"start"`
+		EndTimeStr   string `form:"end"`
+	}{}
+
+	// Bind the query parameters to the struct
+	if err := c.ShouldBindQuery(&data); err != nil {
+		apierror.Abort(c, apierror.New(apierror.INVALID_ARGUMENT, err.Error()))
+		return
+	}
+
+	// Parse the time bounds, defaulting to the last 24 hours
+	startTime, endTime, err := parseTimeBounds(data.StartTimeStr, data.EndTimeStr)
+	if err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+
+	// Calculate the total distance traveled by the user
+	distance, err := s.calculateDistanceByUsername(c.Request.Context(), username, startTime, endTime)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Could not calculate distance", "error", err)
+		apierror.Abort(c, apierror.Wrap(apierror.INTERNAL, "could not calculate distance", err))
+		return
+	}
+
+	// Return the calculated distance
+	if versioning.FromContext(c) == "v1" {
+		c.JSON(http.StatusOK, api.DistanceResponse{Distance: distance / 1000})
+		return
+	}
+	c.JSON(http.StatusOK, api.DistanceResponseV2{DistanceMeters: distance})
+}
+
+// getEncounters handles the HTTP GET request to find the users that were close to a user
+// It returns every period during which another user stayed within the radius (in meters) of the given user
+// The radius is given by the radius parameter in version 1, by radius_meters from version 2 on
+func (s *Service) getEncounters(c *gin.Context) {
+	username := c.Param("username")
+
+	// Check if the username is valid
+	if err := utils.CheckUsername(username); err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+
+	// Struct to bind query parameters
+	data := struct {
+		Tolerance    *int   `form:"tolerance"`
+		StartTimeStr string `form:"start"`
+		EndTimeStr   string `form:"end"`
+	}{}
+
+	// Bind the query parameters to the struct
+	if err := c.ShouldBindQuery(&data); err != nil {
+		apierror.Abort(c, apierror.New(apierror.INVALID_ARGUMENT, err.Error()))
+		return
+	}
+
+	// Check if the radius is valid
+	radiusName := radiusParameter(c)
+	radius, err := strconv.ParseFloat(c.Query(radiusName), 64)
+	if err != nil || radius <= 0 {
+		apierror.Abort(c, apierror.New(apierror.INVALID_ARGUMENT, radiusName+" must be greater than zero"))
+		return
+	}
+
+	// Check if the tolerance is valid, defaulting to DEFAULT_TOLERANCE seconds
+	tolerance := DEFAULT_TOLERANCE
+	if data.Tolerance != nil {
+		tolerance = *data.Tolerance
+	}
+	if tolerance < 0 {
+		apierror.Abort(c, apierror.New(apierror.INVALID_ARGUMENT, "tolerance can not be negative"))
+		return
+	}
+
+	// Parse the time bounds, defaulting to the last 24 hours
+	startTime, endTime, err := parseTimeBounds(data.StartTimeStr, data.EndTimeStr)
+	if err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+
+	// Find the encounters with other users
+	encounters, err := s.findEncounters(c.Request.Context(), username, radius, time.Duration(tolerance)*time.Second, startTime, endTime)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Could not find encounters", "error", err)
+		apierror.Abort(c, apierror.Wrap(apierror.INTERNAL, "could not find encounters", err))
+		return
+	}
+
+	// Return the encounters
+	c.JSON(http.StatusOK, gin.H{"Encounters": encounters})
+}
+
+// getPosition handles the HTTP GET request to find where a user was at a given time
+// By default the position is interpolated between the surrounding recorded points,
+// setting mode to "nearest" returns the nearest recorded point instead
+func (s *Service) getPosition(c *gin.Context) {
+	username := c.Param("username")
+
+	// Check if the username is valid
+	if err := utils.CheckUsername(username); err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+
+	// Struct to bind query parameters
+	data := struct {
+		AtStr string `form:"at" binding:"required"`
+		Mode  string `form:"mode"`
+	}{}
+
+	// Bind the query parameters to the struct
+	if err := c.ShouldBindQuery(&data); err != nil {
+		apierror.Abort(c, apierror.New(apierror.INVALID_ARGUMENT, err.Error()))
+		return
+	}
+
+	// Check if the mode is valid
+	if data.Mode != "" && data.Mode != "interpolate" && data.Mode != "nearest" {
+		apierror.Abort(c, apierror.New(apierror.INVALID_ARGUMENT, "mode must be either interpolate or nearest"))
+		return
+	}
+
+	// Replace spaces with plus signs in the time string and parse it
+	at, err := time.Parse(LAYOUT, strings.ReplaceAll(data.AtStr, " ", "+"))
+	if err != nil {
+		apierror.Abort(c, apierror.New(apierror.INVALID_TIME_RANGE, "time of unknown format"))
+		return
+	}
+
+	// Find the position of the user at the requested time
+	position, err := s.getPositionAtTime(c.Request.Context(), username, at, data.Mode != "nearest")
+	if errors.Is(err, ErrNotFound) {
+		apierror.Abort(c, apierror.New(apierror.NOT_FOUND, "no recorded locations for the user"))
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Could not find position", "error", err)
+		apierror.Abort(c, apierror.Wrap(apierror.INTERNAL, "could not find position", err))
+		return
+	}
+
+	// Return the position
+	c.JSON(http.StatusOK, position)
+}
+
+// getVisitors handles the HTTP GET request to find the users that were inside an area during a time window
+// The area is either a polygon or a circle given by its center and radius in meters, the radius being given by the
+// radius parameter in version 1 and by radius_meters from version 2 on
+func (s *Service) getVisitors(c *gin.Context) {
+	// Struct to bind query parameters
+	data := struct {
+		PolygonStr   string  `form:"polygon"`
+		Longitude    float64 `form:"longitude"`
+		Latitude     float64 `form:"latitude"`
+		StartTimeStr string  `form:"start"`
+		EndTimeStr   string  `form:"end"`
+	}{}
+
+	// Bind the query parameters to the struct
+	if err := c.ShouldBindQuery(&data); err != nil {
+		apierror.Abort(c, apierror.New(apierror.INVALID_ARGUMENT, err.Error()))
+		return
+	}
+
+	_, longitudeExists := c.GetQuery("longitude")
+	_, latitudeExists := c.GetQuery("latitude")
+	radiusName := radiusParameter(c)
+	radiusStr, radiusExists := c.GetQuery(radiusName)
+	isCircle := longitudeExists || latitudeExists || radiusExists
+	// Ensure exactly one kind of area is provided
+	if (data.PolygonStr == "") == !isCircle {
+		apierror.Abort(c, apierror.New(apierror.INVALID_AREA, "provide either a polygon or a longitude, latitude and "+radiusName))
+		return
+	}
+
+	var area Area
+	if isCircle {
+		if !longitudeExists || !latitudeExists || !radiusExists {
+			apierror.Abort(c, apierror.New(apierror.INVALID_AREA, "a circle needs a longitude, latitude and "+radiusName))
+			return
+		}
+
+		// Check if the coordinates are valid
+		if err := utils.CheckCoordinates(data.Longitude, data.Latitude); err != nil {
+			apierror.Abort(c, err)
+			return
+		}
+
+		// Check if the radius is valid
+		radius, err := strconv.ParseFloat(radiusStr, 64)
+		if err != nil || radius <= 0 {
+			apierror.Abort(c, apierror.New(apierror.INVALID_ARGUMENT, radiusName+" must be greater than zero"))
+			return
+		}
+
+		area = Circle{Longitude: data.Longitude, Latitude: data.Latitude, Radius: radius}
+	} else {
+		polygon, err := parsePolygon(data.PolygonStr)
+		if err != nil {
+			apierror.Abort(c, err)
+			return
+		}
+
+		area = polygon
+	}
+
+	// Parse the time bounds, defaulting to the last 24 hours
+	startTime, endTime, err := parseTimeBounds(data.StartTimeStr, data.EndTimeStr)
+	if err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+
+	// Find the users that visited the area
+	visitors, err := s.findVisitors(c.Request.Context(), area, startTime, endTime)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Could not find visitors", "error", err)
+		apierror.Abort(c, apierror.Wrap(apierror.INTERNAL, "could not find visitors", err))
+		return
+	}
+
+	// Return the visitors
+	c.JSON(http.StatusOK, gin.H{"Visitors": visitors})
+}
+
+// getLeaderboardRanking handles the HTTP GET request to rank the users by the distance traveled in a time window
+// Distances are returned in kilometers in version 1, in meters from version 2 on
+func (s *Service) getLeaderboardRanking(c *gin.Context) {
+	// Struct to bind query parameters
+	data := struct {
+		StartTimeStr string `form:"start"`
+		EndTimeStr   string `form:"end"`
+		Limit        int    `form:"limit,default=10"`
+	}{}
+
+	// Bind the query parameters to the struct
+	if err := c.ShouldBindQuery(&data); err != nil {
+		apierror.Abort(c, apierror.New(apierror.INVALID_ARGUMENT, err.Error()))
+		return
+	}
+
+	// Check if the limit is valid
+	if data.Limit <= 0 || data.Limit > MAX_LEADERBOARD {
+		apierror.Abort(c, apierror.New(apierror.INVALID_ARGUMENT, "limit must be between 1 and "+strconv.Itoa(MAX_LEADERBOARD)))
+		return
+	}
+
+	// Parse the time bounds, defaulting to the last 24 hours
+	startTime, endTime, err := parseTimeBounds(data.StartTimeStr, data.EndTimeStr)
+	if err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+
+	// Rank the users by distance traveled
+	leaderboard, err := s.getLeaderboard(c.Request.Context(), startTime, endTime, data.Limit)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Could not compute leaderboard", "error", err)
+		apierror.Abort(c, apierror.Wrap(apierror.INTERNAL, "could not compute leaderboard", err))
+		return
+	}
+
+	// Return the leaderboard
+	if versioning.FromContext(c) == "v1" {
+		for i := range leaderboard {
+			leaderboard[i].Distance /= 1000
+		}
+		c.JSON(http.StatusOK, gin.H{"Leaderboard": leaderboard})
+		return
+	}
+	entries := make([]LeaderboardEntryV2, len(leaderboard))
+	for i, entry := range leaderboard {
+		entries[i] = LeaderboardEntryV2{Rank: entry.Rank, Username: entry.Username, DistanceMeters: entry.Distance}
+	}
+	c.JSON(http.StatusOK, gin.H{"Leaderboard": entries})
+}
+
+// getHistory handles the HTTP GET request to list the locations recorded for a user in a time window
+// The locations are returned in chronological order
+func (s *Service) getHistory(c *gin.Context) {
+	username := c.Param("username")
+
+	// Check if the username is valid
+	if err := utils.CheckUsername(username); err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+
+	// Struct to bind query parameters
+	data := struct {
+		StartTimeStr string `form:"start"`
+		EndTimeStr   string `form:"end"`
+	}{}
+
+	// Bind the query parameters to the struct
+	if err := c.ShouldBindQuery(&data); err != nil {
+		apierror.Abort(c, apierror.New(apierror.INVALID_ARGUMENT, err.Error()))
+		return
+	}
+
+	// Parse the time bounds, defaulting to the last 24 hours
+	startTime, endTime, err := parseTimeBounds(data.StartTimeStr, data.EndTimeStr)
+	if err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+
+	// Read the locations of the user
+	history, err := s.store.Track(c.Request.Context(), username, startTime, endTime)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Could not read history", "error", err)
+		apierror.Abort(c, apierror.Wrap(apierror.INTERNAL, "could not read history", err))
+		return
+	}
+
+	// Return the locations
+	locations := make([]api.Location, 0, len(history))
+	for _, loc := range history {
+		locations = append(locations, loc.toAPI())
+	}
+	c.JSON(http.StatusOK, api.HistoryResponse{History: locations})
+}
+
+// importHistory handles the HTTP POST request recording a location of a user at the time it was recorded, used by locctl
+// to import a history. Imports carrying an already seen Idempotency-Key header are not recorded again
+func (s *Service) importHistory(c *gin.Context) {
+	username := c.Param("username")
+
+	// Check if the username is valid
+	if err := utils.CheckUsername(username); err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+
+	// Bind the JSON request data, the coordinates may be 0 but not missing
+	var body api.ImportLocationRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		apierror.Abort(c, apierror.New(apierror.INVALID_ARGUMENT, err.Error()))
+		return
+	}
+
+	// Check if the coordinates are valid
+	if err := utils.CheckCoordinates(*body.Longitude, *body.Latitude); err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+
+	// Check the idempotency key, if one is provided
+	idempotencyKey := c.GetHeader("Idempotency-Key")
+	if idempotencyKey != "" {
+		if err := utils.CheckIdempotencyKey(idempotencyKey); err != nil {
+			apierror.Abort(c, err)
+			return
+		}
+	}
+
+	// Check the time, recorded history can be imported but future locations are refused
+	if body.Time.IsZero() {
+		apierror.Abort(c, apierror.New(apierror.INVALID_TIME_RANGE, "time is missing"))
+		return
+	}
+	if body.Time.After(time.Now().Add(MAX_CLOCK_SKEW)) {
+		apierror.Abort(c, apierror.New(apierror.INVALID_TIME_RANGE, "time is in the future"))
+		return
+	}
+
+	// Record the location, retries with a seen idempotency key are not written again
+	loc := Location{Username: username, Longitude: *body.Longitude, Latitude: *body.Latitude, Time: body.Time}
+	if err := s.store.Add(c.Request.Context(), loc, idempotencyKey); err != nil {
+		if errors.Is(err, errIdempotencyKeyReused) {
+			apierror.Abort(c, err)
+			return
+		}
+		slog.ErrorContext(c.Request.Context(), "Could not import location", "error", err)
+		apierror.Abort(c, apierror.Wrap(apierror.INTERNAL, "could not import location", err))
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// deleteHistory handles the HTTP DELETE request of the administrators to erase the location history of a user
+func (s *Service) deleteHistory(c *gin.Context) {
+	username := c.Param("username")
+
+	// Check if the username is valid
+	if err := utils.CheckUsername(username); err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+
+	// Delete the locations of the user
+	deleted, err := s.store.Delete(c.Request.Context(), username)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Could not delete history", "error", err)
+		apierror.Abort(c, apierror.Wrap(apierror.INTERNAL, "could not delete history", err))
+		return
+	}
+
+	slog.InfoContext(c.Request.Context(), "Deleted history", "username", username, "locations", deleted)
+	c.JSON(http.StatusOK, gin.H{"Deleted": deleted})
+}
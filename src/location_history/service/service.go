@@ -0,0 +1,303 @@
+package service
+
+import (
+	"common/admin"
+	"common/config"
+	"common/database"
+	"common/health"
+	"common/lifecycle"
+	"common/logging"
+	"common/metrics"
+	"common/openapi"
+	pb "common/protobuff"
+	"common/tlsutil"
+	"common/tracing"
+	"common/utils"
+	"common/versioning"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"gorm.io/gorm"
+)
+
+// Service is the location history service: the handlers of its REST API, its gRPC server and the store they use
+// Several services can run in one process, each with its own configuration and store
+type Service struct {
+	cfg      config.Config  // Service configuration
+	store    LocationStore  // Recorded locations and idempotency records
+	geodesic utils.Geodesic // Model of the earth measuring the traveled distances and the encounters
+	server   *server        // Implementation of the LocationHistoryService, shared by the gRPC server and the gateway
+	gateway  http.Handler   // REST/JSON routes of the LocationHistoryService
+
+	db           *gorm.DB          // Database of the store, nil unless it is a GormLocationStore
+	uncount      func()            // Stops counting the rows of db, nil unless it is a GormLocationStore
+	certificates *tlsutil.Reloader // Certificates of the gRPC server, nil when TLS is disabled or the service was not opened
+	credentials  grpc.ServerOption // Transport credentials of the gRPC server, nil if the service was not opened
+	close        func()            // Releases what Open acquired, nil for a service created by New
+}
+
+// defaultConfig holds the configuration used for every value not set by the configuration file,
+// an environment variable or a command line flag
+var defaultConfig = config.Config{
+	RestHost:    "localhost",
+	RestPort:    "8000",
+	GrpcHost:    "localhost",
+	GrpcPort:    "50051",
+	DatabaseURL: "location_history.db",
+	LogURL:      "location_history.log",
+	Geodesic:    "haversine",
+
+	ShutdownTimeout: 10 * time.Second,
+	Logging:         config.LoggingConfig{Level: "info", MaxSize: 100},
+	Tracing:         config.TracingConfig{Exporter: "none", SampleRatio: 1},
+	TLS:             config.TLSConfig{ReloadInterval: 10 * time.Second},
+}
+
+// SERVICE_NAME identifies the service in traces
+const SERVICE_NAME = "location_history"
+
+// LoadConfig resolves the service configuration from the defaults, the configuration file,
+// the LOCATION_HISTORY_* environment variables and the command line arguments
+func LoadConfig(args []string) (config.Config, config.Options, error) {
+	return config.Load("LOCATION_HISTORY", defaultConfig, args)
+}
+
+// DefaultConfig returns the configuration of the service when nothing is set
+func DefaultConfig() config.Config {
+	return defaultConfig
+}
+
+// New creates a service around its store, e.g. a MemoryLocationStore in tests
+// The gRPC server of a service created by New is not encrypted, and the migration routes and the health monitor are
+// only used with a GormLocationStore
+func New(cfg config.Config, store LocationStore) (*Service, error) {
+	geodesic, err := utils.NewGeodesic(cfg.Geodesic)
+	if err != nil {
+		return nil, fmt.Errorf("failed to select the geodesic model: %w", err)
+	}
+	srv := newServer(store)
+	gateway, err := newGateway(srv)
+	if err != nil {
+		return nil, err
+	}
+	s := &Service{cfg: cfg, store: store, geodesic: geodesic, server: srv, gateway: gateway}
+	if gormStore, ok := store.(*GormLocationStore); ok {
+		s.db = gormStore.db
+		s.uncount = historyRows.Add(cfg.RestHost+":"+cfg.RestPort, s.db)
+	}
+	return s, nil
+}
+
+// Handler returns the REST API of the service
+func (s *Service) Handler() http.Handler {
+	engine := gin.New()
+	s.registerRoutes(engine)
+	return engine
+}
+
+// registerRoutes registers the API routes with the Gin engine
+func (s *Service) registerRoutes(engine *gin.Engine) {
+	engine.Use(tracing.GinMiddleware(SERVICE_NAME))
+	// The metrics come before the logging, which recovers the panics, so that the failed requests are counted
+	engine.Use(metrics.GinMiddleware())
+	engine.Use(logging.GinMiddleware())
+
+	// Version 1 of the API, also served without prefix until the sunset of the unversioned routes
+	v1 := versioning.Group(engine, "v1")
+	s.registerV1Routes(v1)
+	s.registerV1Routes(versioning.Deprecated(engine, "v1", versioning.UNVERSIONED_DEPRECATION, versioning.UNVERSIONED_SUNSET))
+
+	// Version 2 of the API, measuring every distance in meters
+	s.registerV2Routes(versioning.Group(engine, "v2"))
+
+	// Administration of the service, only under /v1 and behind the admin token
+	s.registerAdminRoutes(v1.Group("/admin", admin.RequireToken(s.cfg.AdminToken)))
+
+	engine.GET("/healthz", gin.WrapH(health.LiveHandler()))
+	engine.GET("/readyz", gin.WrapH(health.ReadyHandler(s.readinessChecks()...)))
+	engine.GET("/metrics", gin.WrapH(metrics.Handler()))
+	engine.GET("/openapi.json", openapi.Handler(openapiSpec))
+}
+
+// registerV1Routes registers the routes of version 1 of the API with a route group
+// A later version gets its own function and group, reusing the handlers of the routes that do not change
+func (s *Service) registerV1Routes(group *gin.RouterGroup) {
+	group.GET("/distance/:username", s.getTraveledDistance)
+	group.GET("/encounters/:username", s.getEncounters)
+	group.GET("/position/:username", s.getPosition)
+	group.GET("/visitors", s.getVisitors)
+	group.GET("/leaderboard", s.getLeaderboardRanking)
+	group.GET("/history/:username", s.getHistory)
+	group.POST("/history/:username", gin.WrapH(s.gateway))
+}
+
+// registerV2Routes registers the routes of version 2 of the API with a route group
+// The distance, encounters, visitors and leaderboard handlers read the version of the request to measure in meters,
+// see versioning.FromContext
+func (s *Service) registerV2Routes(group *gin.RouterGroup) {
+	group.GET("/distance/:username", s.getTraveledDistance)
+	group.GET("/encounters/:username", s.getEncounters)
+	group.GET("/position/:username", s.getPosition)
+	group.GET("/visitors", s.getVisitors)
+	group.GET("/leaderboard", s.getLeaderboardRanking)
+	group.GET("/history/:username", s.getHistory)
+	group.POST("/history/:username", gin.WrapH(s.gateway))
+}
+
+// registerAdminRoutes registers the administration routes, used by locctl, with a route group
+// The migration routes are only registered when the locations are stored in a database
+func (s *Service) registerAdminRoutes(group *gin.RouterGroup) {
+	group.POST("/history/:username", s.importHistory)
+	group.DELETE("/history/:username", s.deleteHistory)
+	if s.db != nil {
+		group.GET("/migrations", func(c *gin.Context) { admin.MigrationStatus(c, s.db, migrations) })
+		group.POST("/migrations", func(c *gin.Context) { admin.Migrate(c, s.db, migrations) })
+	}
+}
+
+// readinessChecks lists the dependencies that must be usable for the service to handle requests
+func (s *Service) readinessChecks() []health.Check {
+	return []health.Check{{Name: "database", Run: s.store.Ping}}
+}
+
+// migrateModels applies the pending schema migrations
+// It fails without changing anything if the database was migrated by a newer version of the service
+func migrateModels(db *gorm.DB) error {
+	_, err := database.MigrateUp(db, migrations)
+	return err
+}
+
+// RunCommand runs a subcommand of the service instead of starting it
+// The only subcommand is "migrate up|down [version]|status"
+func RunCommand(cfg config.Config, args []string) error {
+	if args[0] != "migrate" {
+		return fmt.Errorf("unknown command %q, expected migrate", args[0])
+	}
+
+	db, err := database.New(cfg.DatabaseURL)
+	if err != nil {
+		return err
+	}
+	defer database.Close(db)
+
+	return database.RunMigrateCommand(db, migrations, args[1:], os.Stdout)
+}
+
+// Open loads the certificates of the gRPC server, connects to the database and applies the pending migrations,
+// then creates the service around a GormLocationStore
+func Open(cfg config.Config) (*Service, error) {
+	geodesic, err := utils.NewGeodesic(cfg.Geodesic)
+	if err != nil {
+		return nil, fmt.Errorf("failed to select the geodesic model: %w", err)
+	}
+	certificates, err := tlsutil.NewReloader(cfg.TLS)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the certificates: %w", err)
+	}
+	credentials, err := tlsutil.ServerOption(certificates)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up TLS: %w", err)
+	}
+
+	db, err := database.New(cfg.DatabaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to the database: %w", err)
+	}
+	if err := errors.Join(metrics.InstrumentGORM(db), tracing.InstrumentGORM(db)); err != nil {
+		database.Close(db)
+		return nil, fmt.Errorf("failed to instrument the database: %w", err)
+	}
+	if err := migrateModels(db); err != nil {
+		database.Close(db)
+		return nil, fmt.Errorf("failed to migrate the database: %w", err)
+	}
+
+	s, err := New(cfg, NewGormLocationStore(db, geodesic))
+	if err != nil {
+		database.Close(db)
+		return nil, err
+	}
+	s.certificates, s.credentials = certificates, credentials
+	s.close = func() { database.Close(db) }
+	return s, nil
+}
+
+// Close stops counting the rows of the service and closes the database connection opened by Open
+func (s *Service) Close() {
+	if s.uncount != nil {
+		s.uncount()
+	}
+	if s.close != nil {
+		s.close()
+	}
+}
+
+// Components returns the long running parts of the service, in the order they are stopped: the health monitor,
+// the REST server, the gRPC server, the pruner of the idempotency records and the certificate reloader if TLS is enabled
+// The health monitor ties the gRPC health status to the database and is stopped first, so that clients see the service
+// as not serving while it drains. Without a database the service is reported as serving until it stops. The gRPC
+// server also serves the connections of the listeners, e.g. an in-process bufconn listener when both services run in
+// one process
+func (s *Service) Components(listeners ...net.Listener) []lifecycle.Component {
+	var opts []grpc.ServerOption
+	if s.credentials != nil {
+		opts = append(opts, s.credentials)
+	}
+	healthServer := grpchealth.NewServer()
+	grpcServer := newGRPCServer(s.server, healthServer, opts...)
+
+	var components []lifecycle.Component
+	if s.db != nil {
+		components = append(components, lifecycle.Worker("location history health monitor", func(ctx context.Context) error {
+			return health.WatchDatabase(ctx, s.db, healthServer, HEALTH_INTERVAL, pb.LocationHistoryService_ServiceDesc.ServiceName)
+		}))
+	} else {
+		healthServer.SetServingStatus(pb.LocationHistoryService_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	}
+	components = append(components,
+		lifecycle.HTTPServer("location history REST server", &http.Server{Addr: s.cfg.RestHost + ":" + s.cfg.RestPort, Handler: s.Handler()}),
+		lifecycle.GRPCServer("location history gRPC server", grpcServer, ":"+s.cfg.GrpcPort),
+	)
+	for _, lis := range listeners {
+		components = append(components, lifecycle.GRPCListener("location history in-process gRPC server", grpcServer, lis))
+	}
+	components = append(components, lifecycle.Worker("location history idempotency record pruner", s.pruneIdempotencyRecords))
+	if s.certificates != nil {
+		components = append(components, lifecycle.Worker("location history certificate reloader", s.certificates.Watch))
+	}
+	return components
+}
+
+// pruneIdempotencyRecords deletes the expired idempotency records every PRUNE_INTERVAL until ctx is done
+// Failures are only logged, the records are pruned again at the next interval
+func (s *Service) pruneIdempotencyRecords(ctx context.Context) error {
+	ticker := time.NewTicker(PRUNE_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		pruned, err := s.store.PruneIdempotencyRecords(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				slog.Error("Could not prune idempotency records", "error", err)
+			}
+			continue
+		}
+		slog.Debug("Pruned idempotency records", "count", pruned)
+	}
+}
@@ -0,0 +1,229 @@
+package service
+
+import (
+	"common/database"
+	"common/health"
+	"common/utils"
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// LocationStore reads and writes the recorded locations of the users and the idempotency records of their updates
+// GormLocationStore keeps them in the database of the service, MemoryLocationStore in memory, e.g. for tests
+// The lists of locations are ordered by time then ID for a single user, by username, time then ID otherwise
+type LocationStore interface {
+	// Add records a location, at loc.Time or now if it is zero
+	// If an idempotency key is given and was already seen within IDEMPOTENCY_TTL, nothing is written and the
+	// original result is returned, or errIdempotencyKeyReused if the key was used for a different location
+	Add(ctx context.Context, loc Location, idempotencyKey string) error
+	// Track returns the locations recorded for a user between two timestamps
+	Track(ctx context.Context, username string, startTime time.Time, endTime time.Time) ([]Location, error)
+	// Around returns the last location of a user recorded at or before a time and the first one at or after it,
+	// nil if there is none
+	Around(ctx context.Context, username string, at time.Time) (*Location, *Location, error)
+	// InBox returns the locations of the users other than username recorded inside a bounding box between two timestamps
+	InBox(ctx context.Context, username string, startTime time.Time, endTime time.Time, minLon, minLat, maxLon, maxLat float64) ([]Location, error)
+	// InArea returns the locations recorded inside an area between two timestamps
+	InArea(ctx context.Context, area Area, startTime time.Time, endTime time.Time) ([]Location, error)
+	// Each calls fn with every location recorded between two timestamps, stopping at the first error
+	Each(ctx context.Context, startTime time.Time, endTime time.Time, fn func(Location) error) error
+	// Delete deletes every location recorded for a user and returns the number of deleted locations
+	Delete(ctx context.Context, username string) (int64, error)
+	// PruneIdempotencyRecords deletes the records of the keys seen before IDEMPOTENCY_TTL and returns their number
+	PruneIdempotencyRecords(ctx context.Context) (int64, error)
+	// Ping checks that the store is usable, for the readiness probe
+	Ping(ctx context.Context) error
+}
+
+// GormLocationStore is the LocationStore of a migrated database
+type GormLocationStore struct {
+	db       *gorm.DB
+	postgis  bool           // Whether the area tests are evaluated by PostGIS
+	spheroid bool           // Whether PostGIS measures distances on the WGS84 spheroid instead of a sphere
+	geodesic utils.Geodesic // Model of the earth measuring the distances of the area tests without PostGIS
+}
+
+// NewGormLocationStore returns the store of a migrated database measuring distances with the geodesic model
+// PostGIS measures distances on a sphere for the haversine geodesic model and on the WGS84 spheroid for the others
+func NewGormLocationStore(db *gorm.DB, geodesic utils.Geodesic) *GormLocationStore {
+	return &GormLocationStore{db: db, postgis: database.HasPostGIS(db), spheroid: !utils.IsSpherical(geodesic), geodesic: geodesic}
+}
+
+// Add creates a new Location record, storing the idempotency key in the same transaction if one is given
+// The statements run in ctx, so that they are traced as part of the RPC
+func (s *GormLocationStore) Add(ctx context.Context, loc Location, idempotencyKey string) error {
+	db := s.db.WithContext(ctx)
+
+	// A zero time is replaced by the time of insertion
+	if idempotencyKey == "" {
+		return db.Create(&loc).Error
+	}
+
+	// Store the location and the key together, so that a key is only remembered once its location is written
+	// The key is inserted first: a concurrent update with the same key waits for this transaction, then sees the key
+	return db.Transaction(func(tx *gorm.DB) error {
+		record := IdempotencyRecord{IdempotencyKey: idempotencyKey, Username: loc.Username, Longitude: loc.Longitude, Latitude: loc.Latitude}
+		for {
+			res := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&record)
+			if res.Error != nil {
+				return res.Error
+			}
+			if res.RowsAffected > 0 {
+				return tx.Create(&loc).Error
+			}
+
+			// Forget the key if it expired, then try again
+			res = tx.Where("idempotency_key = ? AND created_at < ?", idempotencyKey, time.Now().Add(-IDEMPOTENCY_TTL)).
+				Delete(&IdempotencyRecord{})
+			if res.Error != nil {
+				return res.Error
+			}
+			if res.RowsAffected > 0 {
+				continue
+			}
+
+			// The key was already seen, return the original result without writing again
+			var seen IdempotencyRecord
+			res = tx.Where("idempotency_key = ?", idempotencyKey).Limit(1).Find(&seen)
+			if res.Error != nil {
+				return res.Error
+			}
+			if res.RowsAffected > 0 {
+				if !seen.matches(loc.Username, loc.Longitude, loc.Latitude) {
+					return errIdempotencyKeyReused
+				}
+				return nil
+			}
+			// The key was pruned in the meantime, try again
+		}
+	})
+}
+
+// Track returns the locations recorded for a user between two timestamps, in chronological order
+func (s *GormLocationStore) Track(ctx context.Context, username string, startTime time.Time, endTime time.Time) ([]Location, error) {
+	locations := make([]Location, 0)
+	res := s.db.WithContext(ctx).Where("Username = ? AND Time BETWEEN ? AND ?", username, startTime, endTime).
+		Order("Time").Order("ID").Find(&locations)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+
+	return locations, nil
+}
+
+// Around looks up the recorded points of a user right before and right after a time
+func (s *GormLocationStore) Around(ctx context.Context, username string, at time.Time) (*Location, *Location, error) {
+	db := s.db.WithContext(ctx)
+
+	var before, after Location
+	res := db.Where("Username = ? AND Time <= ?", username, at).Order("Time DESC").Order("ID DESC").Limit(1).Find(&before)
+	if res.Error != nil {
+		return nil, nil, res.Error
+	}
+	beforeExists := res.RowsAffected > 0
+
+	res = db.Where("Username = ? AND Time >= ?", username, at).Order("Time").Order("ID").Limit(1).Find(&after)
+	if res.Error != nil {
+		return nil, nil, res.Error
+	}
+	afterExists := res.RowsAffected > 0
+
+	var beforePtr, afterPtr *Location
+	if beforeExists {
+		beforePtr = &before
+	}
+	if afterExists {
+		afterPtr = &after
+	}
+	return beforePtr, afterPtr, nil
+}
+
+// InBox returns the locations of the users other than username recorded inside a bounding box between two timestamps
+func (s *GormLocationStore) InBox(ctx context.Context, username string, startTime time.Time, endTime time.Time, minLon, minLat, maxLon, maxLat float64) ([]Location, error) {
+	var locations []Location
+	res := s.db.WithContext(ctx).
+		Where("Username <> ? AND Time BETWEEN ? AND ? AND Longitude BETWEEN ? AND ? AND Latitude BETWEEN ? AND ?",
+			username, startTime, endTime, minLon, maxLon, minLat, maxLat).
+		Order("Username").Order("Time").Order("ID").Find(&locations)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+
+	return locations, nil
+}
+
+// InArea returns the locations recorded inside an area between two timestamps
+// Candidate points are narrowed down in SQL with the bounding box of the area and then tested against the area itself,
+// in SQL as well when PostGIS is available
+func (s *GormLocationStore) InArea(ctx context.Context, area Area, startTime time.Time, endTime time.Time) ([]Location, error) {
+	minLon, minLat, maxLon, maxLat := area.BoundingBox()
+
+	query := s.db.WithContext(ctx).Where("Time BETWEEN ? AND ? AND Longitude BETWEEN ? AND ? AND Latitude BETWEEN ? AND ?",
+		startTime, endTime, minLon, maxLon, minLat, maxLat)
+
+	// With PostGIS the area test is done by the database as well
+	if s.postgis {
+		condition, args := area.postgisCondition(s.spheroid)
+		query = query.Where(condition, args...)
+	}
+
+	var candidates []Location
+	res := query.Order("Username").Order("Time").Order("ID").Find(&candidates)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if s.postgis {
+		return candidates, nil
+	}
+
+	locations := make([]Location, 0, len(candidates))
+	for _, loc := range candidates {
+		if area.Contains(s.geodesic, loc.Longitude, loc.Latitude) {
+			locations = append(locations, loc)
+		}
+	}
+	return locations, nil
+}
+
+// Each streams the locations recorded between two timestamps to fn, without loading them all in memory
+func (s *GormLocationStore) Each(ctx context.Context, startTime time.Time, endTime time.Time, fn func(Location) error) error {
+	db := s.db.WithContext(ctx)
+	rows, err := db.Model(&Location{}).Where("Time BETWEEN ? AND ?", startTime, endTime).
+		Order("Username").Order("Time").Order("ID").Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var loc Location
+		if err := db.ScanRows(rows, &loc); err != nil {
+			return err
+		}
+		if err := fn(loc); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// Delete deletes every location recorded for a user and returns the number of deleted locations
+func (s *GormLocationStore) Delete(ctx context.Context, username string) (int64, error) {
+	res := s.db.WithContext(ctx).Where("Username = ?", username).Delete(&Location{})
+	return res.RowsAffected, res.Error
+}
+
+// PruneIdempotencyRecords deletes the records of the keys seen before IDEMPOTENCY_TTL
+func (s *GormLocationStore) PruneIdempotencyRecords(ctx context.Context) (int64, error) {
+	res := s.db.WithContext(ctx).Where("created_at < ?", time.Now().Add(-IDEMPOTENCY_TTL)).Delete(&IdempotencyRecord{})
+	return res.RowsAffected, res.Error
+}
+
+// Ping checks that the database answers
+func (s *GormLocationStore) Ping(ctx context.Context) error {
+	return health.PingDatabase(ctx, s.db)
+}
@@ -0,0 +1,1356 @@
+package service
+
+import (
+	"common/admin"
+	"common/api"
+	"common/apierror"
+	"common/apierror/apierrortest"
+	"common/config"
+	"common/database"
+	"common/database/databasetest"
+	"common/health"
+	"common/logging"
+	"common/metrics"
+	"common/openapi"
+	pb "common/protobuff"
+	"common/tlsutil"
+	"common/tracing"
+	"common/utils"
+	"common/versioning"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+var (
+	cfg config.Config // Configuration of the tested services
+	db  *gorm.DB      // Database of the tested services
+)
+
+const TEST_ADMIN_TOKEN = "test-admin-token" // Bearer token of the administration routes in the tests
+
+// newTestService creates a service storing the locations in the test database
+// The requests and responses of its routes are checked against the OpenAPI document, failing the test if they don't match
+func newTestService(t *testing.T) (*Service, *gin.Engine) {
+	t.Helper()
+	doc, err := openapi.Load(openapiSpec)
+	if err != nil {
+		t.Fatal("invalid OpenAPI document: ", err)
+	}
+
+	s, err := New(cfg, NewGormLocationStore(db, utils.NewHaversine()))
+	if err != nil {
+		t.Fatal("failed to create the service: ", err)
+	}
+	t.Cleanup(s.Close)
+	router := gin.New()
+	router.Use(openapi.ValidationMiddleware(doc, func(c *gin.Context, err error) {
+		t.Errorf("request or response violating the OpenAPI document: %v", err)
+	}))
+	s.registerRoutes(router)
+	return s, router
+}
+
+// wipeDatabase reverts every migration and applies them again, leaving empty tables
+// Migrating up first adopts tables created before the schema was versioned, so that they are dropped as well
+func wipeDatabase() {
+	err := migrateModels(db)
+	if err == nil {
+		_, err = database.MigrateDown(db, migrations, 0)
+	}
+	if err == nil {
+		err = migrateModels(db)
+	}
+	if err != nil {
+		fmt.Println("failed to wipe the database: ", err)
+		os.Exit(1)
+	}
+}
+
+// newMemoryDB returns a migrated in-memory database of the test, closed when it ends
+func newMemoryDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	memoryDB, err := database.New(database.MEMORY_URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.Close(memoryDB) })
+	if err := migrateModels(memoryDB); err != nil {
+		t.Fatal(err)
+	}
+	return memoryDB
+}
+
+// TestMain sets up the testing environment
+func TestMain(m *testing.M) {
+	// Load the configuration from the defaults and environment variables, enabling the administration routes
+	var err error
+	if cfg, _, err = LoadConfig(nil); err != nil {
+		fmt.Println("invalid configuration: ", err)
+		os.Exit(1)
+	}
+	cfg.AdminToken = TEST_ADMIN_TOKEN
+
+	// Initialize logging
+	logFile, err := logging.Setup(cfg.LogURL, cfg.Logging)
+	if err != nil {
+		fmt.Println("failed to set up logging: ", err)
+		os.Exit(1)
+	}
+	defer logFile.Close()
+
+	// Set Gin's default writer and error writer to the logger
+	gin.DefaultWriter = log.Writer()
+	gin.DefaultErrorWriter = log.Writer()
+
+	// Connect to the database, migrated below
+	db, err = database.New(cfg.DatabaseURL)
+	if err != nil {
+		fmt.Println("failed to connect to the database: ", err)
+		os.Exit(1)
+	}
+	defer database.Close(db)
+	if err := errors.Join(metrics.InstrumentGORM(db), tracing.InstrumentGORM(db)); err != nil {
+		fmt.Println("failed to instrument the database: ", err)
+		os.Exit(1)
+	}
+
+	// Start from empty tables
+	wipeDatabase()
+
+	// Run the tests
+	m.Run()
+}
+
+// TestCalculateDistanceByUsername tests the calculateDistanceByUsername method
+func TestCalculateDistanceByUsername(t *testing.T) {
+	svc, _ := newTestService(t)
+
+	expected := 30507.941089707187 // Meters
+
+	locations := []Location{
+		{Username: "testuser", Longitude: 10.0, Latitude: 20.0, Time: time.Now().Add(-10 * time.Minute)},
+		{Username: "testuser", Longitude: 10.1, Latitude: 20.1, Time: time.Now().Add(-5 * time.Minute)},
+		{Username: "testuser", Longitude: 10.2, Latitude: 20.2, Time: time.Now()},
+	}
+
+	for _, loc := range locations {
+		db.Create(&loc)
+	}
+
+	startTime := time.Now().Add(-15 * time.Minute)
+	endTime := time.Now()
+
+	// Calculate the distance traveled by the user
+	distance, err := svc.calculateDistanceByUsername(context.Background(), "testuser", startTime, endTime)
+
+	assert.NoError(t, err)
+	assert.Equal(t, expected, distance)
+}
+
+// TestUpdateHistoryByUsername tests recording a location in the store
+func TestUpdateHistoryByUsername(t *testing.T) {
+	svc, _ := newTestService(t)
+
+	// Update the location history for the user
+	err := svc.store.Add(context.Background(), Location{Username: "testuser", Longitude: 10.0, Latitude: 20.0}, "")
+	assert.NoError(t, err)
+
+	// Retrieve the location from the database and check its values
+	var location Location
+	db.Where("Username = ?", "testuser").First(&location)
+
+	assert.Equal(t, "testuser", location.Username)
+	assert.Equal(t, 10.0, location.Longitude)
+	assert.Equal(t, 20.0, location.Latitude)
+}
+
+// TestGetTraveledDistance tests the getTraveledDistance endpoint
+func TestGetTraveledDistance(t *testing.T) {
+	_, router := newTestService(t)
+
+	t.Run("Valid Request with Time Bounds", func(t *testing.T) {
+		// The other fixtures are recorded relative to now, this track stays inside the fixed bounds
+		start := time.Date(2024, 7, 8, 10, 0, 0, 0, time.UTC)
+		db.Where("username = ?", "pastuser").Delete(&Location{})
+		db.Create(&[]Location{
+			{Username: "pastuser", Longitude: 10.0, Latitude: 20.0, Time: start},
+			{Username: "pastuser", Longitude: 10.1, Latitude: 20.1, Time: start.Add(5 * time.Minute)},
+			{Username: "pastuser", Longitude: 10.2, Latitude: 20.2, Time: start.Add(10 * time.Minute)},
+		})
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/distance/pastuser?start=2022-07-08T00:00:00Z&end=2025-07-09T00:00:00Z", nil)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"Traveled distance": 30.507941089707188}`, w.Body.String())
+	})
+
+	t.Run("Valid Request without Time Bounds", func(t *testing.T) {
+		// Without bounds the last 24 hours count, the rambler walks the track of the past user an hour ago, recorded by its
+		// own rows so that the test runs alone
+		db.Where("username = ?", "rambler").Delete(&Location{})
+		start := time.Now().Add(-time.Hour)
+		db.Create(&[]Location{
+			{Username: "rambler", Longitude: 10.0, Latitude: 20.0, Time: start},
+			{Username: "rambler", Longitude: 10.1, Latitude: 20.1, Time: start.Add(5 * time.Minute)},
+			{Username: "rambler", Longitude: 10.2, Latitude: 20.2, Time: start.Add(10 * time.Minute)},
+		})
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/distance/rambler", nil)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"Traveled distance": 30.507941089707188}`, w.Body.String())
+	})
+
+	t.Run("Invalid Username", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/distance/invaliduser", nil)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"Traveled distance": 0}`, w.Body.String())
+	})
+
+	t.Run("Invalid Time Bounds", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/distance/testuser?start=invalid&end=2023-07-09T00:00:00Z", nil)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		apierrortest.AssertProblem(t, w, apierror.INVALID_TIME_RANGE, "lower time bound of unknown format")
+	})
+
+	t.Run("Start Time without End Time", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/distance/testuser?start=2023-07-08T00:00:00Z", nil)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		apierrortest.AssertProblem(t, w, apierror.INVALID_TIME_RANGE, "provide either both lower and upper time bound or none")
+	})
+}
+
+// TestFindEncounters tests the findEncounters function
+func TestFindEncounters(t *testing.T) {
+	svc, _ := newTestService(t)
+
+	base := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	locations := []Location{
+		{Username: "walker", Longitude: 20.0, Latitude: 45.0, Time: base},
+		{Username: "walker", Longitude: 20.001, Latitude: 45.0, Time: base.Add(time.Minute)},
+		{Username: "walker", Longitude: 20.002, Latitude: 45.0, Time: base.Add(2 * time.Minute)},
+		{Username: "walker", Longitude: 20.003, Latitude: 45.0, Time: base.Add(3 * time.Minute)},
+		{Username: "buddy", Longitude: 20.0, Latitude: 45.0001, Time: base.Add(10 * time.Second)},
+		{Username: "buddy", Longitude: 20.001, Latitude: 45.0001, Time: base.Add(70 * time.Second)},
+		{Username: "buddy", Longitude: 20.5, Latitude: 45.0, Time: base.Add(130 * time.Second)},
+		{Username: "buddy", Longitude: 20.003, Latitude: 45.0, Time: base.Add(180 * time.Second)},
+		{Username: "stranger", Longitude: 20.0, Latitude: 45.0, Time: base.Add(time.Hour)},
+		{Username: "faraway", Longitude: 25.0, Latitude: 45.0, Time: base},
+	}
+
+	for _, loc := range locations {
+		db.Create(&loc)
+	}
+
+	encounters, err := svc.findEncounters(context.Background(), "walker", 50.0, time.Minute, base.Add(-time.Minute), base.Add(5*time.Minute))
+	assert.NoError(t, err)
+	assert.Len(t, encounters, 2)
+
+	assert.Equal(t, "buddy", encounters[0].Username)
+	assert.True(t, base.Add(10*time.Second).Equal(encounters[0].Start))
+	assert.True(t, base.Add(70*time.Second).Equal(encounters[0].End))
+	assert.Equal(t, 60.0, encounters[0].Duration)
+	assert.InDelta(t, 11.12, encounters[0].MinDistance, 0.01)
+
+	assert.Equal(t, "buddy", encounters[1].Username)
+	assert.Equal(t, 0.0, encounters[1].Duration)
+	assert.InDelta(t, 0.0, encounters[1].MinDistance, 0.01)
+
+	// A user without points in the time window has no encounters
+	encounters, err = svc.findEncounters(context.Background(), "nobody", 50.0, time.Minute, base, base.Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Len(t, encounters, 0)
+}
+
+// TestGetPositionAtTime tests the getPositionAtTime function
+func TestGetPositionAtTime(t *testing.T) {
+	svc, _ := newTestService(t)
+
+	base := time.Date(2020, 2, 1, 12, 0, 0, 0, time.UTC)
+
+	locations := []Location{
+		{Username: "tracked", Longitude: 0.0, Latitude: 0.0, Time: base},
+		{Username: "tracked", Longitude: 10.0, Latitude: 0.0, Time: base.Add(10 * time.Minute)},
+	}
+
+	for _, loc := range locations {
+		db.Create(&loc)
+	}
+
+	t.Run("Interpolated position", func(t *testing.T) {
+		position, err := svc.getPositionAtTime(context.Background(), "tracked", base.Add(4*time.Minute), true)
+		assert.NoError(t, err)
+		assert.True(t, position.Interpolated)
+		assert.InDelta(t, 4.0, position.Longitude, 1e-6)
+		assert.InDelta(t, 0.0, position.Latitude, 1e-6)
+		assert.Equal(t, 240.0, position.Gap)
+	})
+
+	t.Run("Nearest position", func(t *testing.T) {
+		position, err := svc.getPositionAtTime(context.Background(), "tracked", base.Add(7*time.Minute), false)
+		assert.NoError(t, err)
+		assert.False(t, position.Interpolated)
+		assert.Equal(t, 10.0, position.Longitude)
+		assert.Equal(t, 180.0, position.Gap)
+	})
+
+	t.Run("Position after the last point", func(t *testing.T) {
+		position, err := svc.getPositionAtTime(context.Background(), "tracked", base.Add(time.Hour), true)
+		assert.NoError(t, err)
+		assert.False(t, position.Interpolated)
+		assert.Equal(t, 10.0, position.Longitude)
+		assert.Equal(t, 3000.0, position.Gap)
+	})
+
+	t.Run("Unknown user", func(t *testing.T) {
+		_, err := svc.getPositionAtTime(context.Background(), "untracked", base, true)
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+}
+
+// TestGetPosition tests the getPosition endpoint
+func TestGetPosition(t *testing.T) {
+	_, router := newTestService(t)
+
+	// The positioned user walks east for ten minutes, recorded by its own rows so that the test runs alone
+	db.Where("username = ?", "positioned").Delete(&Location{})
+	base := time.Date(2020, 2, 2, 12, 0, 0, 0, time.UTC)
+	db.Create(&[]Location{
+		{Username: "positioned", Longitude: 0.0, Latitude: 0.0, Time: base},
+		{Username: "positioned", Longitude: 10.0, Latitude: 0.0, Time: base.Add(10 * time.Minute)},
+	})
+
+	t.Run("Valid Request", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/position/positioned?at=2020-02-02T12:05:00Z", nil)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"Interpolated":true`)
+	})
+
+	t.Run("Unknown User", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/position/untracked?at=2020-02-02T12:05:00Z", nil)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("Invalid Time", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/position/positioned?at=yesterday", nil)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		apierrortest.AssertProblem(t, w, apierror.INVALID_TIME_RANGE, "time of unknown format")
+	})
+}
+
+// TestFindVisitors tests the findVisitors function
+func TestFindVisitors(t *testing.T) {
+	svc, _ := newTestService(t)
+
+	base := time.Date(2020, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	locations := []Location{
+		{Username: "visitor1", Longitude: -70.5, Latitude: -30.5, Time: base},
+		{Username: "visitor1", Longitude: -70.4, Latitude: -30.4, Time: base.Add(time.Minute)},
+		{Username: "visitor1", Longitude: -69.0, Latitude: -30.4, Time: base.Add(2 * time.Minute)},
+		{Username: "visitor2", Longitude: -70.9, Latitude: -30.1, Time: base.Add(3 * time.Minute)},
+		{Username: "visitor3", Longitude: -70.5, Latitude: -30.5, Time: base.Add(time.Hour)},
+		{Username: "visitor4", Longitude: -71.5, Latitude: -30.5, Time: base},
+	}
+
+	for _, loc := range locations {
+		db.Create(&loc)
+	}
+
+	t.Run("Polygon", func(t *testing.T) {
+		polygon := Polygon{{-71.0, -31.0}, {-70.0, -31.0}, {-70.0, -30.0}, {-71.0, -30.0}}
+		visitors, err := svc.findVisitors(context.Background(), polygon, base, base.Add(10*time.Minute))
+		assert.NoError(t, err)
+		assert.Len(t, visitors, 2)
+
+		assert.Equal(t, "visitor1", visitors[0].Username)
+		assert.Equal(t, 2, visitors[0].Points)
+		assert.True(t, base.Equal(visitors[0].FirstSeen))
+		assert.True(t, base.Add(time.Minute).Equal(visitors[0].LastSeen))
+
+		assert.Equal(t, "visitor2", visitors[1].Username)
+		assert.Equal(t, 1, visitors[1].Points)
+	})
+
+	t.Run("Circle", func(t *testing.T) {
+		circle := Circle{Longitude: -70.5, Latitude: -30.5, Radius: 1000.0}
+		visitors, err := svc.findVisitors(context.Background(), circle, base, base.Add(2*time.Hour))
+		assert.NoError(t, err)
+		assert.Len(t, visitors, 2)
+		assert.Equal(t, "visitor1", visitors[0].Username)
+		assert.Equal(t, 1, visitors[0].Points)
+		assert.Equal(t, "visitor3", visitors[1].Username)
+	})
+}
+
+// TestGetVisitors tests the getVisitors endpoint
+func TestGetVisitors(t *testing.T) {
+	_, router := newTestService(t)
+
+	// One point inside the polygon and one outside, recorded by the test so that it runs alone
+	db.Where("username = ?", "polygonvisitor").Delete(&Location{})
+	base := time.Date(2020, 3, 2, 12, 0, 0, 0, time.UTC)
+	db.Create(&[]Location{
+		{Username: "polygonvisitor", Longitude: -70.5, Latitude: -30.5, Time: base},
+		{Username: "polygonvisitor", Longitude: -69.0, Latitude: -30.5, Time: base.Add(time.Minute)},
+	})
+
+	t.Run("Valid Polygon Request", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/visitors?polygon=-71,-31,-70,-31,-70,-30,-71,-30&start=2020-03-02T00:00:00Z&end=2020-03-03T00:00:00Z", nil)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"Visitors": [{"Username": "polygonvisitor", "FirstSeen": "2020-03-02T12:00:00Z", "LastSeen": "2020-03-02T12:00:00Z", "Points": 1}]}`, w.Body.String())
+	})
+
+	t.Run("Missing Area", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/visitors", nil)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		apierrortest.AssertProblem(t, w, apierror.INVALID_AREA, "provide either a polygon or a longitude, latitude and radius")
+	})
+
+	t.Run("Invalid Polygon", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/visitors?polygon=-71,-31,-70,-31", nil)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		apierrortest.AssertProblem(t, w, apierror.INVALID_AREA, "polygon must have at least three vertices")
+	})
+}
+
+// TestUpdateHistoryIdempotency tests recording locations with idempotency keys
+func TestUpdateHistoryIdempotency(t *testing.T) {
+	svc, _ := newTestService(t)
+
+	key := "3f2b8c1e-9a4d-4e6f-8b7a-1c2d3e4f5a6b"
+
+	countLocations := func() int64 {
+		var count int64
+		db.Model(&Location{}).Where("Username = ?", "retrying").Count(&count)
+		return count
+	}
+
+	t.Run("First request is written", func(t *testing.T) {
+		err := svc.store.Add(context.Background(), Location{Username: "retrying", Longitude: 10.0, Latitude: 20.0}, key)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), countLocations())
+	})
+
+	t.Run("Retry is not written again", func(t *testing.T) {
+		err := svc.store.Add(context.Background(), Location{Username: "retrying", Longitude: 10.0, Latitude: 20.0}, key)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), countLocations())
+	})
+
+	t.Run("Key reused for a different request", func(t *testing.T) {
+		err := svc.store.Add(context.Background(), Location{Username: "retrying", Longitude: 11.0, Latitude: 21.0}, key)
+		assert.ErrorIs(t, err, errIdempotencyKeyReused)
+		assert.Equal(t, int64(1), countLocations())
+	})
+
+	t.Run("Expired key is forgotten", func(t *testing.T) {
+		db.Model(&IdempotencyRecord{}).Where("idempotency_key = ?", key).Update("created_at", time.Now().Add(-2*IDEMPOTENCY_TTL))
+
+		err := svc.store.Add(context.Background(), Location{Username: "retrying", Longitude: 10.0, Latitude: 20.0}, key)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(2), countLocations())
+	})
+}
+
+// TestGetLeaderboard tests the getLeaderboard function
+func TestGetLeaderboard(t *testing.T) {
+	svc, _ := newTestService(t)
+
+	base := time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	locations := []Location{
+		{Username: "runner", Longitude: 0.0, Latitude: 0.0, Time: base},
+		{Username: "runner", Longitude: 0.0, Latitude: 1.0, Time: base.Add(time.Hour)},
+		{Username: "runner", Longitude: 0.0, Latitude: 2.0, Time: base.Add(2 * time.Hour)},
+		{Username: "walkerb", Longitude: 1.0, Latitude: 0.0, Time: base},
+		{Username: "walkerb", Longitude: 1.0, Latitude: 1.0, Time: base.Add(time.Hour)},
+		{Username: "walkera", Longitude: 2.0, Latitude: 0.0, Time: base},
+		{Username: "walkera", Longitude: 2.0, Latitude: 1.0, Time: base.Add(time.Hour)},
+		{Username: "sitter", Longitude: 3.0, Latitude: 0.0, Time: base},
+	}
+
+	for _, loc := range locations {
+		db.Create(&loc)
+	}
+
+	t.Run("Full leaderboard", func(t *testing.T) {
+		leaderboard, err := svc.getLeaderboard(context.Background(), base, base.Add(3*time.Hour), 10)
+		assert.NoError(t, err)
+		assert.Len(t, leaderboard, 4)
+
+		assert.Equal(t, LeaderboardEntry{Rank: 1, Username: "runner", Distance: leaderboard[0].Distance}, leaderboard[0])
+		assert.InDelta(t, 222389.85, leaderboard[0].Distance, 0.01)
+
+		// Ties are broken by username
+		assert.Equal(t, "walkera", leaderboard[1].Username)
+		assert.Equal(t, "walkerb", leaderboard[2].Username)
+		assert.Equal(t, leaderboard[1].Distance, leaderboard[2].Distance)
+
+		assert.Equal(t, LeaderboardEntry{Rank: 4, Username: "sitter", Distance: 0.0}, leaderboard[3])
+	})
+
+	t.Run("Limited leaderboard", func(t *testing.T) {
+		leaderboard, err := svc.getLeaderboard(context.Background(), base, base.Add(3*time.Hour), 2)
+		assert.NoError(t, err)
+		assert.Len(t, leaderboard, 2)
+		assert.Equal(t, 2, leaderboard[1].Rank)
+	})
+
+	t.Run("Matches the traveled distance", func(t *testing.T) {
+		leaderboard, err := svc.getLeaderboard(context.Background(), base, base.Add(3*time.Hour), 1)
+		assert.NoError(t, err)
+
+		distance, err := svc.calculateDistanceByUsername(context.Background(), "runner", base, base.Add(3*time.Hour))
+		assert.NoError(t, err)
+		assert.Equal(t, distance, leaderboard[0].Distance)
+	})
+}
+
+// TestGetLeaderboardRanking tests the getLeaderboardRanking endpoint
+func TestGetLeaderboardRanking(t *testing.T) {
+	_, router := newTestService(t)
+
+	// A sprinter outrunning a stroller, recorded by the test so that it runs alone
+	db.Where("username IN ?", []string{"sprinter", "stroller"}).Delete(&Location{})
+	base := time.Date(2021, 1, 2, 12, 0, 0, 0, time.UTC)
+	db.Create(&[]Location{
+		{Username: "sprinter", Longitude: 0.0, Latitude: 0.0, Time: base},
+		{Username: "sprinter", Longitude: 0.0, Latitude: 1.0, Time: base.Add(time.Hour)},
+		{Username: "stroller", Longitude: 1.0, Latitude: 0.0, Time: base},
+		{Username: "stroller", Longitude: 1.0, Latitude: 0.1, Time: base.Add(time.Hour)},
+	})
+
+	t.Run("Valid Request", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/leaderboard?start=2021-01-02T00:00:00Z&end=2021-01-03T00:00:00Z&limit=1", nil)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var reply struct{ Leaderboard []LeaderboardEntry }
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &reply))
+		if assert.Len(t, reply.Leaderboard, 1) {
+			assert.Equal(t, 1, reply.Leaderboard[0].Rank)
+			assert.Equal(t, "sprinter", reply.Leaderboard[0].Username)
+		}
+	})
+
+	t.Run("Invalid Limit", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/leaderboard?limit=0", nil)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		apierrortest.AssertProblem(t, w, apierror.INVALID_ARGUMENT, "limit must be between 1 and 100")
+	})
+}
+
+// TestDistanceUnits tests that version 1 of the API keeps its units and version 2 measures every distance in meters
+func TestDistanceUnits(t *testing.T) {
+	_, router := newTestService(t)
+
+	// Two users walking 0.01 degree north side by side, about 1112 meters, 50 meters apart
+	start := time.Date(2020, 3, 1, 9, 0, 0, 0, time.UTC)
+	db.Create(&[]Location{
+		{Username: "unitwalker", Longitude: 0.0, Latitude: 0.0, Time: start},
+		{Username: "unitwalker", Longitude: 0.0, Latitude: 0.01, Time: start.Add(10 * time.Minute)},
+		{Username: "unitfriend", Longitude: 0.00045, Latitude: 0.0, Time: start},
+		{Username: "unitfriend", Longitude: 0.00045, Latitude: 0.01, Time: start.Add(10 * time.Minute)},
+	})
+	window := "start=2020-03-01T00:00:00Z&end=2020-03-02T00:00:00Z"
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", path, nil)
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("Distance", func(t *testing.T) {
+		var v1 api.DistanceResponse
+		w := get("/v1/distance/unitwalker?" + window)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &v1))
+		assert.InDelta(t, 1.112, v1.Distance, 0.001)
+
+		var v2 api.DistanceResponseV2
+		w = get("/v2/distance/unitwalker?" + window)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &v2))
+		assert.InDelta(t, v1.Distance*1000, v2.DistanceMeters, 1e-6)
+	})
+
+	t.Run("Leaderboard", func(t *testing.T) {
+		var v1 struct{ Leaderboard []LeaderboardEntry }
+		w := get("/v1/leaderboard?limit=1&" + window)
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &v1))
+		var v2 struct{ Leaderboard []LeaderboardEntryV2 }
+		w = get("/v2/leaderboard?limit=1&" + window)
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &v2))
+		assert.NotContains(t, w.Body.String(), `"Distance"`)
+		if assert.Len(t, v1.Leaderboard, 1) && assert.Len(t, v2.Leaderboard, 1) {
+			assert.Equal(t, v1.Leaderboard[0].Username, v2.Leaderboard[0].Username)
+			assert.InDelta(t, v1.Leaderboard[0].Distance*1000, v2.Leaderboard[0].DistanceMeters, 1e-6)
+		}
+	})
+
+	t.Run("Radius Parameters", func(t *testing.T) {
+		// Version 2 names the radius radius_meters, the radius of version 1 is not read
+		w := get("/v2/encounters/unitwalker?radius_meters=100&" + window)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), "unitfriend")
+
+		w = get("/v2/encounters/unitwalker?radius=100&" + window)
+		apierrortest.AssertProblem(t, w, apierror.INVALID_ARGUMENT, "radius_meters must be greater than zero")
+
+		w = get("/v1/encounters/unitwalker?radius=100&" + window)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), "unitfriend")
+
+		w = get("/v2/visitors?longitude=0&latitude=0&radius_meters=10&" + window)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), "unitwalker")
+		assert.NotContains(t, w.Body.String(), "unitfriend")
+	})
+}
+
+// TestHealth tests the liveness and readiness endpoints and the gRPC health service
+func TestHealth(t *testing.T) {
+	svc, router := newTestService(t)
+
+	t.Run("Alive", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/healthz", nil)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"Status":"ok"}`, w.Body.String())
+	})
+
+	t.Run("Ready", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/readyz", nil)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"Status":"ok","Checks":{"database":"ok"}}`, w.Body.String())
+	})
+
+	t.Run("gRPC Health Service", func(t *testing.T) {
+		healthServer := grpchealth.NewServer()
+		server := newGRPCServer(svc.server, healthServer)
+		assert.Contains(t, server.GetServiceInfo(), "grpc.health.v1.Health")
+
+		// The status follows the database while the monitor runs and is NOT_SERVING once it stops
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error)
+		go func() {
+			done <- health.WatchDatabase(ctx, db, healthServer, HEALTH_INTERVAL, pb.LocationHistoryService_ServiceDesc.ServiceName)
+		}()
+
+		request := &healthpb.HealthCheckRequest{Service: pb.LocationHistoryService_ServiceDesc.ServiceName}
+		assert.Eventually(t, func() bool {
+			res, err := healthServer.Check(context.Background(), request)
+			return err == nil && res.GetStatus() == healthpb.HealthCheckResponse_SERVING
+		}, time.Second, 10*time.Millisecond)
+
+		cancel()
+		<-done
+		res, err := healthServer.Check(context.Background(), request)
+		assert.NoError(t, err)
+		assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, res.GetStatus())
+	})
+}
+
+// TestUpdateHistoryTLS tests that location updates are accepted over mutual TLS from clients with a certificate of the CA
+func TestUpdateHistoryTLS(t *testing.T) {
+	svc, _ := newTestService(t)
+
+	wipeDatabase()
+
+	dir := t.TempDir()
+	if err := tlsutil.GenerateDevCertificates(dir, "localhost"); err != nil {
+		t.Fatal(err)
+	}
+	serverCertificates, err := tlsutil.NewReloader(config.TLSConfig{
+		Enabled:    true,
+		CertFile:   filepath.Join(dir, tlsutil.DEV_SERVER_CERT),
+		KeyFile:    filepath.Join(dir, tlsutil.DEV_SERVER_KEY),
+		CAFile:     filepath.Join(dir, tlsutil.DEV_CA_FILE),
+		ClientAuth: true,
+	})
+	assert.NoError(t, err)
+	credentials, err := tlsutil.ServerOption(serverCertificates)
+	assert.NoError(t, err)
+
+	// Serve the gRPC server as the service does, with TLS
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := newGRPCServer(svc.server, grpchealth.NewServer(), credentials)
+	go server.Serve(lis)
+	defer server.Stop()
+
+	// update sends a location update with the certificates of the client
+	update := func(clientCfg config.TLSConfig) error {
+		clientCertificates, err := tlsutil.NewReloader(clientCfg)
+		if err != nil {
+			return err
+		}
+		conn, err := grpc.NewClient(lis.Addr().String(), tlsutil.DialOption(clientCertificates, "localhost"))
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_, err = pb.NewLocationHistoryServiceClient(conn).UpdateHistory(ctx, &pb.LocationUpdateRequest{Username: "secure", Longitude: 1.0, Latitude: 2.0})
+		return err
+	}
+
+	assert.NoError(t, update(config.TLSConfig{
+		Enabled:  true,
+		CertFile: filepath.Join(dir, tlsutil.DEV_CLIENT_CERT),
+		KeyFile:  filepath.Join(dir, tlsutil.DEV_CLIENT_KEY),
+		CAFile:   filepath.Join(dir, tlsutil.DEV_CA_FILE),
+	}))
+	assert.Error(t, update(config.TLSConfig{Enabled: true, CAFile: filepath.Join(dir, tlsutil.DEV_CA_FILE)}))
+	assert.Error(t, update(config.TLSConfig{}))
+
+	var count int64
+	db.Model(&Location{}).Where("username = ?", "secure").Count(&count)
+	assert.Equal(t, int64(1), count)
+}
+
+// TestUpdateHistoryErrors tests that the failed RPCs carry the status code and the error code of the catalogue
+func TestUpdateHistoryErrors(t *testing.T) {
+	svc, _ := newTestService(t)
+
+	tests := []struct {
+		name     string
+		req      *pb.LocationUpdateRequest
+		grpcCode codes.Code
+		code     apierror.Code
+	}{
+		{"Invalid Username", &pb.LocationUpdateRequest{Username: "ab"}, codes.InvalidArgument, apierror.INVALID_USERNAME},
+		{"Invalid Coordinates", &pb.LocationUpdateRequest{Username: "errors", Latitude: 100}, codes.InvalidArgument, apierror.INVALID_COORDINATES},
+		{"Invalid Idempotency Key", &pb.LocationUpdateRequest{Username: "errors", IdempotencyKey: "abc"}, codes.InvalidArgument, apierror.INVALID_IDEMPOTENCY_KEY},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reply, err := svc.server.UpdateHistory(context.Background(), tt.req)
+			assert.Nil(t, reply)
+
+			// grpc-go sends the status of the error, the client reads the code back from it
+			st := status.Convert(err)
+			assert.Equal(t, tt.grpcCode, st.Code())
+			assert.Equal(t, tt.code, apierror.From(st.Err()).Code)
+		})
+	}
+}
+
+// TestUpdateHistoryGateway tests the REST/JSON gateway of the UpdateHistory RPC
+func TestUpdateHistoryGateway(t *testing.T) {
+	_, router := newTestService(t)
+
+	tests := []struct {
+		name         string
+		path         string
+		body         string
+		expectedCode int
+		expectedBody string
+		problem      apierror.Code
+	}{
+		{"Valid Request", "/history/gateway", `{"longitude": 3.0, "latitude": 4.0}`, http.StatusOK, `{"status": "SUCCESS", "error": ""}`, ""},
+		{"Retry With Idempotency Key", "/history/gateway", `{"longitude": 3.0, "latitude": 4.0, "idempotencyKey": "5b0c3b2e-7d8a-4c9e-9f6a-2b1d4e3c5a70"}`, http.StatusOK, `{"status": "SUCCESS", "error": ""}`, ""},
+		{"Retried Request", "/history/gateway", `{"longitude": 3.0, "latitude": 4.0, "idempotency_key": "5b0c3b2e-7d8a-4c9e-9f6a-2b1d4e3c5a70"}`, http.StatusOK, `{"status": "SUCCESS", "error": ""}`, ""},
+		{"Reused Idempotency Key", "/history/gateway", `{"longitude": 9.0, "latitude": 4.0, "idempotencyKey": "5b0c3b2e-7d8a-4c9e-9f6a-2b1d4e3c5a70"}`, http.StatusUnprocessableEntity, "", apierror.IDEMPOTENCY_KEY_REUSED},
+		{"Invalid Username", "/history/ab", `{"longitude": 3.0, "latitude": 4.0}`, http.StatusBadRequest, "", apierror.INVALID_USERNAME},
+		{"Invalid Coordinates", "/history/gateway", `{"longitude": 300.0, "latitude": 4.0}`, http.StatusBadRequest, "", apierror.INVALID_COORDINATES},
+		{"Invalid Idempotency Key", "/history/gateway", `{"longitude": 3.0, "latitude": 4.0, "idempotencyKey": "abc"}`, http.StatusBadRequest, "", apierror.INVALID_IDEMPOTENCY_KEY},
+		{"Invalid Body", "/history/gateway", `{"longitude": "east"}`, http.StatusBadRequest, "", apierror.INVALID_ARGUMENT},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("POST", tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedCode, w.Code)
+			if tt.problem == "" {
+				assert.JSONEq(t, tt.expectedBody, w.Body.String())
+			} else {
+				var problem apierror.Problem
+				assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &problem))
+				assert.Equal(t, tt.problem, problem.Code)
+			}
+		})
+	}
+
+	// The retry with the same idempotency key is not written again
+	var count int64
+	db.Model(&Location{}).Where("username = ?", "gateway").Count(&count)
+	assert.Equal(t, int64(2), count)
+
+	// The Gin routes are still served next to the gateway
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/distance/gateway", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+// TestGatewayInterceptors tests that the calls of the gateway go through the interceptors in order
+func TestGatewayInterceptors(t *testing.T) {
+	var calls []string
+	record := func(name string) grpc.UnaryServerInterceptor {
+		return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+			calls = append(calls, name+" "+info.FullMethod)
+			return handler(ctx, req)
+		}
+	}
+	gateway := &gatewayServer{server: newServer(NewMemoryLocationStore(utils.NewHaversine())), interceptor: chainUnaryInterceptors(record("outer"), record("inner"))}
+
+	reply, err := gateway.UpdateHistory(context.Background(), &pb.LocationUpdateRequest{Username: "intercepted", Longitude: 1.0, Latitude: 2.0})
+	assert.NoError(t, err)
+	assert.Equal(t, pb.Status_SUCCESS, reply.GetStatus())
+	assert.Equal(t, []string{"outer /LocationHistoryService/UpdateHistory", "inner /LocationHistoryService/UpdateHistory"}, calls)
+
+	// Failures of the server are returned unchanged
+	_, err = gateway.UpdateHistory(context.Background(), &pb.LocationUpdateRequest{Username: "intercepted", Longitude: 200.0, Latitude: 2.0})
+	assert.Equal(t, apierror.INVALID_COORDINATES, apierror.From(err).Code)
+}
+
+// TestGetHistory tests the getHistory endpoint
+func TestGetHistory(t *testing.T) {
+	_, router := newTestService(t)
+
+	now := time.Now().UTC().Truncate(time.Second)
+	db.Create(&Location{Username: "historian", Longitude: 1.0, Latitude: 2.0, Time: now.Add(-3 * time.Hour)})
+	db.Create(&Location{Username: "historian", Longitude: 1.5, Latitude: 2.5, Time: now.Add(-2 * time.Hour)})
+	db.Create(&Location{Username: "historian", Longitude: 3.0, Latitude: 4.0, Time: now.Add(-48 * time.Hour)})
+
+	tests := []struct {
+		name         string
+		path         string
+		expectedCode int
+		expected     []float64 // Longitudes of the returned locations
+	}{
+		{"Last 24 Hours", "/v1/history/historian", http.StatusOK, []float64{1.0, 1.5}},
+		{"Time Window", "/v1/history/historian?start=" + now.Add(-72*time.Hour).Format(LAYOUT) + "&end=" + now.Add(-150*time.Minute).Format(LAYOUT), http.StatusOK, []float64{3.0, 1.0}},
+		{"Unknown User", "/v1/history/nobody", http.StatusOK, []float64{}},
+		{"Invalid Username", "/v1/history/ab", http.StatusBadRequest, nil},
+		{"Missing Bound", "/v1/history/historian?start=" + now.Format(LAYOUT), http.StatusBadRequest, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", tt.path, nil)
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedCode, w.Code)
+			if tt.expected == nil {
+				return
+			}
+
+			var body struct{ History []Location }
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+			longitudes := make([]float64, 0, len(body.History))
+			for _, location := range body.History {
+				longitudes = append(longitudes, location.Longitude)
+			}
+			assert.Equal(t, tt.expected, longitudes)
+		})
+	}
+}
+
+// TestImportHistory tests that recorded history is only imported by the admin route, the public updates are recorded now
+func TestImportHistory(t *testing.T) {
+	svc, router := newTestService(t)
+
+	recorded := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+	post := func(path string, token string, key string, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		if key != "" {
+			req.Header.Set("Idempotency-Key", key)
+		}
+		router.ServeHTTP(w, req)
+		return w
+	}
+	countBefore := func(username string, before time.Time) int64 {
+		var count int64
+		db.Model(&Location{}).Where("Username = ? AND Time < ?", username, before).Count(&count)
+		return count
+	}
+
+	t.Run("Public Updates Are Recorded Now", func(t *testing.T) {
+		reply, err := svc.server.UpdateHistory(context.Background(), &pb.LocationUpdateRequest{Username: "backdater", Longitude: 1.0, Latitude: 2.0})
+		assert.NoError(t, err)
+		assert.Equal(t, pb.Status_SUCCESS, reply.GetStatus())
+
+		w := post("/v1/history/backdater", "", "", `{"longitude": 3.0, "latitude": 4.0}`)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, int64(0), countBefore("backdater", time.Now().Add(-time.Hour)))
+	})
+
+	t.Run("Admin Import", func(t *testing.T) {
+		key := "2b0a3c4d-5e6f-4a1b-8c2d-3e4f5a6b7c8d"
+		body := `{"longitude": 1.0, "latitude": 2.0, "time": "2024-03-01T12:00:00Z"}`
+		w := post("/v1/admin/history/importer", TEST_ADMIN_TOKEN, key, body)
+		assert.Equal(t, http.StatusNoContent, w.Code)
+
+		var location Location
+		db.Where("Username = ?", "importer").First(&location)
+		assert.True(t, recorded.Equal(location.Time), location.Time)
+
+		// A retry is not recorded again
+		w = post("/v1/admin/history/importer", TEST_ADMIN_TOKEN, key, body)
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		assert.Equal(t, int64(1), countBefore("importer", recorded.Add(time.Hour)))
+	})
+
+	t.Run("Invalid Imports", func(t *testing.T) {
+		w := post("/v1/admin/history/importer", "", "", `{"longitude": 1.0, "latitude": 2.0, "time": "2024-03-01T12:00:00Z"}`)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+		// Locations can't be recorded ahead of the clock
+		future := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+		w = post("/v1/admin/history/importer", TEST_ADMIN_TOKEN, "", `{"longitude": 1.0, "latitude": 2.0, "time": "`+future+`"}`)
+		apierrortest.AssertProblem(t, w, apierror.INVALID_TIME_RANGE, "time is in the future")
+
+		w = post("/v1/admin/history/importer", TEST_ADMIN_TOKEN, "", `{"longitude": 1.0, "latitude": 2.0}`)
+		apierrortest.AssertProblem(t, w, apierror.INVALID_TIME_RANGE, "time is missing")
+
+		w = post("/v1/admin/history/importer", TEST_ADMIN_TOKEN, "", `{"longitude": 200.0, "latitude": 2.0, "time": "2024-03-01T12:00:00Z"}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, int64(1), countBefore("importer", recorded.Add(time.Hour)))
+	})
+}
+
+// TestAdminRoutes tests that the administration routes require the admin token
+func TestAdminRoutes(t *testing.T) {
+	_, router := newTestService(t)
+
+	db.Create(&Location{Username: "forgotten", Longitude: 1.0, Latitude: 2.0})
+	db.Create(&Location{Username: "forgotten", Longitude: 1.5, Latitude: 2.5})
+
+	tests := []struct {
+		name         string
+		method       string
+		path         string
+		token        string
+		expectedCode int
+		expectedBody string
+	}{
+		{"Missing Token", "DELETE", "/v1/admin/history/forgotten", "", http.StatusUnauthorized, ""},
+		{"Invalid Token", "DELETE", "/v1/admin/history/forgotten", "guess", http.StatusUnauthorized, ""},
+		{"Delete History", "DELETE", "/v1/admin/history/forgotten", TEST_ADMIN_TOKEN, http.StatusOK, `{"Deleted": 2}`},
+		{"Delete Deleted History", "DELETE", "/v1/admin/history/forgotten", TEST_ADMIN_TOKEN, http.StatusOK, `{"Deleted": 0}`},
+		{"Invalid Username", "DELETE", "/v1/admin/history/ab", TEST_ADMIN_TOKEN, http.StatusBadRequest, ""},
+		{"Unversioned", "DELETE", "/admin/history/forgotten", TEST_ADMIN_TOKEN, http.StatusNotFound, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(tt.method, tt.path, nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedCode, w.Code)
+			if tt.expectedBody != "" {
+				assert.JSONEq(t, tt.expectedBody, w.Body.String())
+			}
+			if tt.expectedCode == http.StatusUnauthorized {
+				apierrortest.AssertProblem(t, w, apierror.UNAUTHENTICATED, "missing or invalid bearer token")
+			}
+		})
+	}
+
+	// The migrations are listed, all applied
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/v1/admin/migrations", nil)
+	req.Header.Set("Authorization", "Bearer "+TEST_ADMIN_TOKEN)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var reply admin.MigrationsReply
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &reply))
+	assert.Equal(t, len(migrations), reply.Version)
+	assert.Len(t, reply.Migrations, len(migrations))
+}
+
+// TestVersionedRoutes tests that the v1 routes are served and that the unversioned routes are deprecated aliases
+func TestVersionedRoutes(t *testing.T) {
+	_, router := newTestService(t)
+
+	// The routes of the gateway record the locations of their own user, counted at the end
+	db.Where("username = ?", "versioned").Delete(&Location{})
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		deprecated bool
+	}{
+		{"Versioned Query", "GET", "/v1/distance/versioned", "", false},
+		{"Unversioned Query", "GET", "/distance/versioned", "", true},
+		{"Versioned Gateway", "POST", "/v1/history/versioned", `{"longitude": 5.0, "latitude": 6.0}`, false},
+		{"Unversioned Gateway", "POST", "/history/versioned", `{"longitude": 7.0, "latitude": 8.0}`, true},
+		{"Version 2 Query", "GET", "/v2/distance/versioned", "", false},
+		{"Version 2 Gateway", "POST", "/v2/history/versioned", `{"longitude": 9.0, "latitude": 10.0}`, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			if tt.deprecated {
+				assert.Equal(t, fmt.Sprintf("@%d", versioning.UNVERSIONED_DEPRECATION.Unix()), w.Header().Get("Deprecation"))
+				assert.Equal(t, versioning.UNVERSIONED_SUNSET.Format(http.TimeFormat), w.Header().Get("Sunset"))
+				assert.Equal(t, `</v1`+tt.path+`>; rel="successor-version"`, w.Header().Get("Link"))
+			} else {
+				assert.Empty(t, w.Header().Get("Deprecation"))
+				assert.Empty(t, w.Header().Get("Sunset"))
+			}
+		})
+	}
+
+	// Every route of the gateway recorded a location
+	var count int64
+	db.Model(&Location{}).Where("username = ?", "versioned").Count(&count)
+	assert.Equal(t, int64(3), count)
+}
+
+// TestOpenAPI tests that the OpenAPI document is served and documents exactly the registered routes and error codes
+func TestOpenAPI(t *testing.T) {
+	_, router := newTestService(t)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/openapi.json", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	doc, err := openapi.Load(w.Body.Bytes())
+	if assert.NoError(t, err) {
+		assert.NoError(t, openapi.CheckRoutes(doc, router.Routes()))
+
+		// Every code of the error catalogue is documented
+		var documented []apierror.Code
+		for _, code := range doc.Components.Schemas["ErrorCode"].Value.Enum {
+			documented = append(documented, apierror.Code(code.(string)))
+		}
+		assert.ElementsMatch(t, apierror.Codes(), documented)
+	}
+}
+
+// TestMetrics tests that the metrics endpoint exposes the request and domain metrics
+func TestMetrics(t *testing.T) {
+	// The rows are counted when the service starts, then only every ROW_COUNT_INTERVAL
+	var rows int64
+	db.Model(&Location{}).Count(&rows)
+	s, router := newTestService(t)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/leaderboard?limit=1", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// A panicking handler, outside of the OpenAPI document, is answered by the logging middleware and still counted
+	engine := gin.New()
+	s.registerRoutes(engine)
+	engine.GET("/panicking", func(c *gin.Context) { panic("test panic") })
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/panicking", nil)
+	engine.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/metrics", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `http_requests_total{code="200",method="GET",route="/leaderboard"}`)
+	assert.Contains(t, w.Body.String(), `http_requests_total{code="500",method="GET",route="/panicking"}`)
+	assert.Contains(t, w.Body.String(), fmt.Sprintf(`location_history_rows{address="%s:%s"} %d`, cfg.RestHost, cfg.RestPort, rows))
+	assert.Contains(t, w.Body.String(), `db_query_duration_seconds_count{operation="query",table="locations"}`)
+}
+
+// TestMigrations tests that every migration can be reverted and applied again
+// It migrates its own in-memory database, so that the tables of the other tests are kept
+func TestMigrations(t *testing.T) {
+	memoryDB := newMemoryDB(t)
+
+	reverted, err := database.MigrateDown(memoryDB, migrations, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, len(migrations), reverted)
+	assert.False(t, memoryDB.Migrator().HasTable(&Location{}))
+	assert.False(t, memoryDB.Migrator().HasTable(&IdempotencyRecord{}))
+
+	assert.NoError(t, migrateModels(memoryDB))
+	assert.True(t, memoryDB.Migrator().HasIndex(&Location{}, "Time"))
+
+	// The migrated schema matches the models
+	assert.NoError(t, NewGormLocationStore(memoryDB, utils.NewHaversine()).Add(context.Background(), Location{Username: "migrated", Longitude: 1.0, Latitude: 2.0}, "5f0c7d4e-9a8b-4c3d-8e2f-1a2b3c4d5e6f"))
+
+	// A database migrated by a newer version of the service is refused
+	assert.NoError(t, memoryDB.Create(&database.SchemaVersion{Version: len(migrations) + 1}).Error)
+	assert.ErrorIs(t, migrateModels(memoryDB), database.ErrSchemaTooNew)
+	assert.NoError(t, memoryDB.Delete(&database.SchemaVersion{Version: len(migrations) + 1}).Error)
+}
+
+// TestLocationStores tests that the GORM and in-memory stores behave the same
+// The GORM store uses its own in-memory database, so that the state shared by the other tests is kept. The PostgreSQL
+// store tests the areas with PostGIS in its own schema of the server of TEST_POSTGRES_URL, and is skipped without one
+func TestLocationStores(t *testing.T) {
+	stores := []struct {
+		name  string
+		store func(t *testing.T) LocationStore
+	}{
+		{"GORM", func(t *testing.T) LocationStore { return NewGormLocationStore(newMemoryDB(t), utils.NewHaversine()) }},
+		{"PostgreSQL", func(t *testing.T) LocationStore {
+			postgres := databasetest.Postgres(t)
+			if err := migrateModels(postgres); err != nil {
+				t.Fatal(err)
+			}
+			return NewGormLocationStore(postgres, utils.NewHaversine())
+		}},
+		{"Memory", func(t *testing.T) LocationStore { return NewMemoryLocationStore(utils.NewHaversine()) }},
+	}
+
+	base := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	key := "7e4f1c2a-9b3d-4e8f-a1b2-c3d4e5f6a7b8"
+	for _, tt := range stores {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := tt.store(t)
+
+			// Locations recorded out of order, one of them twice with an idempotency key
+			assert.NoError(t, store.Add(ctx, Location{Username: "alice", Longitude: 2.0, Latitude: 48.0, Time: base.Add(2 * time.Minute)}, ""))
+			assert.NoError(t, store.Add(ctx, Location{Username: "alice", Longitude: 2.000000001, Latitude: 48.0, Time: base}, key))
+			assert.NoError(t, store.Add(ctx, Location{Username: "alice", Longitude: 2.000000001, Latitude: 48.0, Time: base}, key))
+			assert.ErrorIs(t, store.Add(ctx, Location{Username: "alice", Longitude: 3.0, Latitude: 48.0, Time: base}, key), errIdempotencyKeyReused)
+			assert.NoError(t, store.Add(ctx, Location{Username: "bob", Longitude: 2.001, Latitude: 48.001, Time: base.Add(time.Minute)}, ""))
+			assert.NoError(t, store.Add(ctx, Location{Username: "carol", Longitude: 20.0, Latitude: 10.0, Time: base.Add(time.Minute)}, ""))
+
+			// A zero time is replaced by the time of insertion
+			before := time.Now()
+			assert.NoError(t, store.Add(ctx, Location{Username: "dave", Longitude: 1.0, Latitude: 1.0}, ""))
+			recent, err := store.Track(ctx, "dave", before.Add(-time.Second), time.Now().Add(time.Second))
+			assert.NoError(t, err)
+			assert.Len(t, recent, 1)
+
+			track, err := store.Track(ctx, "alice", base, base.Add(time.Hour))
+			if assert.NoError(t, err) && assert.Len(t, track, 2) {
+				assert.Equal(t, 2.0, track[0].Longitude)
+				assert.True(t, track[0].Time.Equal(base))
+				assert.True(t, track[1].Time.Equal(base.Add(2*time.Minute)))
+			}
+
+			earlier, later, err := store.Around(ctx, "alice", base.Add(time.Minute))
+			if assert.NoError(t, err) && assert.NotNil(t, earlier) && assert.NotNil(t, later) {
+				assert.True(t, earlier.Time.Equal(base))
+				assert.True(t, later.Time.Equal(base.Add(2*time.Minute)))
+			}
+			earlier, later, err = store.Around(ctx, "alice", base.Add(time.Hour))
+			assert.NoError(t, err)
+			assert.NotNil(t, earlier)
+			assert.Nil(t, later)
+
+			box, err := store.InBox(ctx, "alice", base, base.Add(time.Hour), 1.9, 47.9, 2.1, 48.1)
+			if assert.NoError(t, err) && assert.Len(t, box, 1) {
+				assert.Equal(t, "bob", box[0].Username)
+			}
+
+			area, err := store.InArea(ctx, Circle{Longitude: 2.0, Latitude: 48.0, Radius: 1000}, base, base.Add(time.Hour))
+			assert.NoError(t, err)
+			var visitors []string
+			for _, loc := range area {
+				visitors = append(visitors, loc.Username)
+			}
+			assert.Equal(t, []string{"alice", "alice", "bob"}, visitors)
+
+			var order []string
+			assert.NoError(t, store.Each(ctx, base, base.Add(time.Hour), func(loc Location) error {
+				order = append(order, loc.Username+"@"+loc.Time.UTC().Format("15:04"))
+				return nil
+			}))
+			assert.Equal(t, []string{"alice@12:00", "alice@12:02", "bob@12:01", "carol@12:01"}, order)
+
+			deleted, err := store.Delete(ctx, "alice")
+			assert.NoError(t, err)
+			assert.Equal(t, int64(2), deleted)
+			track, err = store.Track(ctx, "alice", base, base.Add(time.Hour))
+			assert.NoError(t, err)
+			assert.Empty(t, track)
+
+			// Concurrent updates with the same key are all successful and written once
+			var wg sync.WaitGroup
+			for i := 0; i < 8; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					assert.NoError(t, store.Add(ctx, Location{Username: "erin", Longitude: 5.0, Latitude: 5.0, Time: base}, "2c3d4e5f-6a7b-4c8d-9e0f-1a2b3c4d5e6f"))
+				}()
+			}
+			wg.Wait()
+			track, err = store.Track(ctx, "erin", base, base.Add(time.Hour))
+			assert.NoError(t, err)
+			assert.Len(t, track, 1)
+
+			// Only the expired keys are pruned
+			pruned, err := store.PruneIdempotencyRecords(ctx)
+			assert.NoError(t, err)
+			assert.Zero(t, pruned)
+			assert.ErrorIs(t, store.Add(ctx, Location{Username: "alice", Longitude: 3.0, Latitude: 48.0, Time: base}, key), errIdempotencyKeyReused)
+			assert.NoError(t, store.Ping(ctx))
+		})
+	}
+}
+
+// TestMemoryStore tests a service running on an in-memory store
+func TestMemoryStore(t *testing.T) {
+	memory, err := New(cfg, NewMemoryLocationStore(utils.NewHaversine()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := memory.Handler()
+
+	// Through the gateway and the gRPC server
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/v1/history/inmemory", strings.NewReader(`{"longitude": 1.0, "latitude": 2.0}`))
+	req.Header.Set("Content-Type", "application/json")
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	_, err = memory.server.UpdateHistory(context.Background(), &pb.LocationUpdateRequest{Username: "inmemory", Longitude: 1.5, Latitude: 2.5})
+	assert.NoError(t, err)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/v1/history/inmemory", nil)
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var reply struct{ History []Location }
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &reply))
+	assert.Len(t, reply.History, 2)
+
+	// The test database is untouched and the migration routes are not registered
+	var count int64
+	db.Model(&Location{}).Where("Username = ?", "inmemory").Count(&count)
+	assert.Equal(t, int64(0), count)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/v1/admin/migrations", nil)
+	req.Header.Set("Authorization", "Bearer "+TEST_ADMIN_TOKEN)
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+// TestGeodesicModels tests that each service measures distances with the geodesic model of its configuration
+// Along the equator's meridian, 0.01 degree is about 1112 meters on the sphere but only about 1106 on the WGS84 ellipsoid
+func TestGeodesicModels(t *testing.T) {
+	start := time.Date(2020, 4, 1, 9, 0, 0, 0, time.UTC)
+	circle := Circle{Longitude: 0.0, Latitude: 0.0, Radius: 1109.0}
+
+	for _, model := range []struct {
+		name     string
+		distance float64 // Meters between the two points
+		visitors int     // Visitors of the circle, reaching its edge only on the ellipsoid
+	}{
+		{"haversine", 1111.95, 0},
+		{"karney", 1105.75, 1},
+	} {
+		t.Run(model.name, func(t *testing.T) {
+			geodesic, err := utils.NewGeodesic(model.name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			modelCfg := cfg
+			modelCfg.Geodesic = model.name
+			s, err := New(modelCfg, NewMemoryLocationStore(geodesic))
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx := context.Background()
+			assert.NoError(t, s.store.Add(ctx, Location{Username: "geodesic", Longitude: 0.0, Latitude: 0.01, Time: start}, ""))
+			assert.NoError(t, s.store.Add(ctx, Location{Username: "geodesic", Longitude: 0.0, Latitude: 0.0, Time: start.Add(time.Minute)}, ""))
+
+			distance, err := s.calculateDistanceByUsername(ctx, "geodesic", start, start.Add(time.Hour))
+			assert.NoError(t, err)
+			assert.InDelta(t, model.distance, distance, 0.01)
+
+			visitors, err := s.findVisitors(ctx, circle, start, start.Add(30*time.Second))
+			assert.NoError(t, err)
+			assert.Len(t, visitors, model.visitors)
+		})
+	}
+}
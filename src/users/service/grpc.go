@@ -0,0 +1,70 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"common/health"
+	"common/logging"
+	"common/metrics"
+	pb "common/protobuff" // Importing the protobuf generated code
+	"common/tracing"
+
+	"google.golang.org/grpc"
+)
+
+// LocationNotifier forwards the location updates of the users to the location history service
+// The idempotency key, if not empty, lets the location history service recognize retried updates
+type LocationNotifier interface {
+	NotifyLocation(ctx context.Context, username string, longitude float64, latitude float64, idempotencyKey string) error
+}
+
+// NotifierFunc adapts a function to the LocationNotifier interface, e.g. to replace the location history service in tests
+type NotifierFunc func(ctx context.Context, username string, longitude float64, latitude float64, idempotencyKey string) error
+
+// NotifyLocation calls f
+func (f NotifierFunc) NotifyLocation(ctx context.Context, username string, longitude float64, latitude float64, idempotencyKey string) error {
+	return f(ctx, username, longitude, latitude, idempotencyKey)
+}
+
+// GRPCNotifier sends the location updates to the gRPC server of the location history service
+type GRPCNotifier struct {
+	address string            // Address of the gRPC server, host:port
+	opts    []grpc.DialOption // Transport options of the connections, e.g. the credentials
+}
+
+// NewGRPCNotifier returns a notifier connecting to the gRPC server at address with the transport options,
+// e.g. the credentials of the link or a dialer reaching an in-process server
+func NewGRPCNotifier(address string, opts ...grpc.DialOption) *GRPCNotifier {
+	return &GRPCNotifier{address: address, opts: opts}
+}
+
+// NotifyLocation sends a location update to the gRPC location history service
+// The request ID and the trace context of ctx are propagated to the location history service
+func (n *GRPCNotifier) NotifyLocation(ctx context.Context, username string, longitude float64, latitude float64, idempotencyKey string) error {
+	// Set a timeout for the context, covering the connection as well since a failed TLS handshake is retried
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+
+	// Dial the gRPC server, with TLS if it is enabled
+	opts := append(append([]grpc.DialOption{}, n.opts...), grpc.WithBlock(), grpc.WithReturnConnectionError(),
+		grpc.WithChainUnaryInterceptor(metrics.UnaryClientInterceptor(), logging.UnaryClientInterceptor()), tracing.GRPCDialOption())
+	conn, err := grpc.DialContext(ctx, n.address, opts...)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// Create a new client for the LocationHistoryService
+	c := pb.NewLocationHistoryServiceClient(conn)
+
+	// Send the UpdateHistory request with the username, longitude, latitude, and idempotency key
+	// Failures carry the code of the catalogue, see apierror.From
+	_, err = c.UpdateHistory(ctx, &pb.LocationUpdateRequest{Username: username, Longitude: longitude, Latitude: latitude, IdempotencyKey: idempotencyKey})
+	return err
+}
+
+// Check reports whether the location history service is serving, for the readiness probe
+func (n *GRPCNotifier) Check(ctx context.Context) error {
+	return health.CheckGRPCService(ctx, n.address, pb.LocationHistoryService_ServiceDesc.ServiceName, n.opts...)
+}
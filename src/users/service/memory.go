@@ -0,0 +1,167 @@
+package service
+
+import (
+	"common/utils"
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryUserStore is a UserStore keeping the users in memory, e.g. for tests or to embed the service without a database
+// It behaves like GormUserStore without PostGIS: user IDs are assigned from 1 and coordinates are rounded to eight decimals
+type MemoryUserStore struct {
+	mu       sync.Mutex
+	users    []User                       // Users ordered by ID
+	nextID   uint                         // ID of the next created user
+	records  map[string]IdempotencyRecord // Idempotency records by key
+	geodesic utils.Geodesic               // Model of the earth measuring the distances of the nearby search
+}
+
+// NewMemoryUserStore returns an empty in-memory store measuring distances with the geodesic model
+func NewMemoryUserStore(geodesic utils.Geodesic) *MemoryUserStore {
+	return &MemoryUserStore{nextID: 1, records: make(map[string]IdempotencyRecord), geodesic: geodesic}
+}
+
+// UpdateLocation sets the location of a user, creating the user if needed
+func (s *MemoryUserStore) UpdateLocation(ctx context.Context, username string, longitude float64, latitude float64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	longitude, latitude = utils.RoundToEightDecimals(longitude), utils.RoundToEightDecimals(latitude)
+	if i := s.find(username); i >= 0 {
+		s.users[i].Longitude, s.users[i].Latitude = longitude, latitude
+		return nil
+	}
+
+	s.users = append(s.users, User{ID: s.nextID, Name: username, Longitude: longitude, Latitude: latitude})
+	s.nextID++
+	return nil
+}
+
+// Nearby returns a page of the users within radius meters from the given coordinates, ordered by user ID
+func (s *MemoryUserStore) Nearby(ctx context.Context, longitude float64, latitude float64, radius float64, page int) ([]User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return nearbyPage(s.geodesic, s.users, longitude, latitude, radius, page), nil
+}
+
+// List returns a page of every user, ordered by user ID
+func (s *MemoryUserStore) List(ctx context.Context, page int) ([]User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	users := make([]User, 0, LIST_PAGE_SIZE)
+	for i := (page - 1) * LIST_PAGE_SIZE; i < len(s.users) && len(users) < LIST_PAGE_SIZE; i++ {
+		users = append(users, s.users[i])
+	}
+	return users, nil
+}
+
+// Get returns the user identified by their username, or ErrNotFound if there is none
+func (s *MemoryUserStore) Get(ctx context.Context, username string) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.find(username)
+	if i < 0 {
+		return User{}, ErrNotFound
+	}
+	return s.users[i], nil
+}
+
+// Delete deletes a user together with their idempotency records, or returns ErrNotFound if there is no such user
+func (s *MemoryUserStore) Delete(ctx context.Context, username string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.find(username)
+	if i < 0 {
+		return ErrNotFound
+	}
+	s.users = append(s.users[:i], s.users[i+1:]...)
+
+	for key, record := range s.records {
+		if record.Username == username {
+			delete(s.records, key)
+		}
+	}
+	return nil
+}
+
+// ReserveIdempotencyKey reserves the key of record for its request, or returns the record of the key if it was seen
+// within IDEMPOTENCY_TTL, see UserStore
+func (s *MemoryUserStore) ReserveIdempotencyKey(ctx context.Context, record IdempotencyRecord) (*IdempotencyRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if seen, ok := s.records[record.IdempotencyKey]; ok {
+		ttl := IDEMPOTENCY_TTL
+		if !seen.Completed {
+			ttl = RESERVATION_TTL
+		}
+		if !seen.CreatedAt.Before(time.Now().Add(-ttl)) {
+			return &seen, nil
+		}
+	}
+
+	record.Completed = false
+	if record.CreatedAt.IsZero() {
+		record.CreatedAt = time.Now()
+	}
+	s.records[record.IdempotencyKey] = record
+	return nil, nil
+}
+
+// CompleteIdempotencyKey marks the request reserved under an idempotency key as successfully processed
+func (s *MemoryUserStore) CompleteIdempotencyKey(ctx context.Context, idempotencyKey string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if record, ok := s.records[idempotencyKey]; ok {
+		record.Completed = true
+		s.records[idempotencyKey] = record
+	}
+	return nil
+}
+
+// ReleaseIdempotencyKey deletes the record of an idempotency key, unless its request was completed
+func (s *MemoryUserStore) ReleaseIdempotencyKey(ctx context.Context, idempotencyKey string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if record, ok := s.records[idempotencyKey]; ok && !record.Completed {
+		delete(s.records, idempotencyKey)
+	}
+	return nil
+}
+
+// PruneIdempotencyRecords deletes the records of the keys seen before IDEMPOTENCY_TTL
+func (s *MemoryUserStore) PruneIdempotencyRecords(ctx context.Context) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var pruned int64
+	expiry := time.Now().Add(-IDEMPOTENCY_TTL)
+	for key, record := range s.records {
+		if record.CreatedAt.Before(expiry) {
+			delete(s.records, key)
+			pruned++
+		}
+	}
+	return pruned, nil
+}
+
+// Ping always succeeds, the memory is always usable
+func (s *MemoryUserStore) Ping(ctx context.Context) error {
+	return nil
+}
+
+// find returns the index of the user identified by their username, -1 if there is none
+func (s *MemoryUserStore) find(username string) int {
+	for i, user := range s.users {
+		if user.Name == username {
+			return i
+		}
+	}
+	return -1
+}
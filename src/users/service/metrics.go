@@ -0,0 +1,20 @@
+package service
+
+import (
+	"common/metrics"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// nearbyPageSize records how many users each page returned by the nearby search holds
+	nearbyPageSize = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "users_nearby_page_size",
+		Help:    "Number of users in the pages returned by the nearby search",
+		Buckets: prometheus.LinearBuckets(0, 1, PAGE_SIZE+1),
+	})
+
+	// userCount reports the number of users stored by every service on a GORM store
+	userCount = metrics.NewRowCounter("users_count", "Number of stored users", &User{}, metrics.ROW_COUNT_INTERVAL)
+)
@@ -0,0 +1,64 @@
+package service
+
+import (
+	"common/database"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// migrations lists the schema changes of the service in order
+// Every migration declares the models as they were at its version, so that it keeps working when the models change
+// The first migrations use AutoMigrate so that databases created before migrations existed are adopted as they are
+var migrations = []database.Migration{
+	{
+		Version:     1,
+		Description: "Create users",
+		Up: func(tx *gorm.DB) error {
+			type user struct {
+				ID        uint   `gorm:"primaryKey;autoIncrement"`
+				Name      string `gorm:"size:16;not null"`
+				Longitude float64
+				Latitude  float64
+			}
+			return tx.Table("users").AutoMigrate(&user{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable("users")
+		},
+	},
+	{
+		Version:     2,
+		Description: "Create idempotency records",
+		Up: func(tx *gorm.DB) error {
+			type idempotencyRecord struct {
+				IdempotencyKey string `gorm:"primaryKey"`
+				Username       string
+				Longitude      float64
+				Latitude       float64
+				CreatedAt      time.Time `gorm:"index"`
+			}
+			return tx.Table("idempotency_records").AutoMigrate(&idempotencyRecord{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable("idempotency_records")
+		},
+	},
+	{
+		Version:     3,
+		Description: "Reserve idempotency keys",
+		Up: func(tx *gorm.DB) error {
+			// The records saved so far were all completed requests
+			type idempotencyRecord struct {
+				Completed bool `gorm:"not null;default:true"`
+			}
+			return tx.Table("idempotency_records").Migrator().AddColumn(&idempotencyRecord{}, "Completed")
+		},
+		Down: func(tx *gorm.DB) error {
+			type idempotencyRecord struct {
+				Completed bool
+			}
+			return tx.Table("idempotency_records").Migrator().DropColumn(&idempotencyRecord{}, "Completed")
+		},
+	},
+}
@@ -0,0 +1,90 @@
+package service
+
+import (
+	"common/api"
+	"common/apierror"
+	"common/utils"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	PAGE_SIZE       int           = 3              // Constant to define the number of users per page for pagination
+	LIST_PAGE_SIZE  int           = 50             // Number of users per page of the administration listing
+	IDEMPOTENCY_TTL time.Duration = 24 * time.Hour // Time during which a seen idempotency key is remembered
+	RESERVATION_TTL time.Duration = time.Minute    // Time after which the key reserved by an interrupted request is taken over
+	PRUNE_INTERVAL  time.Duration = time.Hour      // Interval between two deletions of the expired idempotency records
+)
+
+// User struct represents a user in the system with their ID, Name, Longitude, and Latitude
+type User struct {
+	ID        uint    `gorm:"primaryKey;autoIncrement"` // User ID, primary key, auto-incremented
+	Name      string  `gorm:"size:16;not null"`        // User Name, size limited to 16 characters, cannot be null
+	Longitude float64 // User's longitude coordinate
+	Latitude  float64 // User's latitude coordinate
+}
+
+// toAPI returns the user as answered by the REST API
+func (u User) toAPI() api.User {
+	return api.User{ID: u.ID, Name: u.Name, Longitude: u.Longitude, Latitude: u.Latitude}
+}
+
+// IdempotencyRecord reserves an idempotency key for a location update, then remembers the successful update so that
+// retries with the same key are not applied again
+type IdempotencyRecord struct {
+	IdempotencyKey string    `gorm:"primaryKey"` // Client generated UUID
+	Username       string    // Username of the original request
+	Longitude      float64   // Longitude of the original request
+	Latitude       float64   // Latitude of the original request
+	CreatedAt      time.Time `gorm:"index"` // Time the key was first seen, indexed for pruning expired keys
+	Completed      bool      // Whether the request was successfully processed, the key is reserved while it is not
+}
+
+// errIdempotencyKeyReused is returned when an idempotency key is reused with a different request
+var errIdempotencyKeyReused = apierror.New(apierror.IDEMPOTENCY_KEY_REUSED, "idempotency key was already used for a different request")
+
+// errIdempotencyKeyInUse is returned when a request with the same idempotency key is still being processed
+var errIdempotencyKeyInUse = apierror.New(apierror.IDEMPOTENCY_KEY_IN_USE, "a request with the same idempotency key is still being processed")
+
+// matches reports whether the record was created for the same request
+func (record *IdempotencyRecord) matches(username string, longitude float64, latitude float64) bool {
+	return record.Username == username && record.Longitude == longitude && record.Latitude == latitude
+}
+
+// String method returns a string representation of the User struct
+func (user *User) String() string {
+	return fmt.Sprintf("User[Name: %s, Coordinates: (%.8f, %.8f)]", user.Name, user.Longitude, user.Latitude)
+}
+
+// BeforeSave GORM hook, executes before each save operation
+// This method rounds the user's longitude and latitude to eight decimal places before saving
+func (user *User) BeforeSave(tx *gorm.DB) (err error) {
+	user.Longitude = utils.RoundToEightDecimals(user.Longitude)
+	user.Latitude = utils.RoundToEightDecimals(user.Latitude)
+	return
+}
+
+// nearbyPage returns a page of the users within radius meters from the given coordinates, keeping their order
+// The distances are measured with the geodesic model
+func nearbyPage(geodesic utils.Geodesic, users []User, longitude float64, latitude float64, radius float64, page int) []User {
+	// Filter users within the specified radius
+	closeUsers := make([]User, 0, len(users))
+	for _, user := range users {
+		if geodesic.Distance(longitude, latitude, user.Longitude, user.Latitude) <= radius {
+			closeUsers = append(closeUsers, user)
+		}
+	}
+
+	// Paginate the filtered users
+	pagedUsers := make([]User, 0, PAGE_SIZE)
+	firstOnPage := (page - 1) * PAGE_SIZE
+
+	// Populate the paginated list with the appropriate users, none if the page is beyond the available users
+	for i := firstOnPage; i < len(closeUsers) && i < firstOnPage+PAGE_SIZE; i++ {
+		pagedUsers = append(pagedUsers, closeUsers[i])
+	}
+
+	return pagedUsers
+}
@@ -0,0 +1,254 @@
+package service
+
+import (
+	"common/api"
+	"common/apierror"
+	"common/utils"
+	"common/versioning"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// updateLocation handles the HTTP POST request to update a user's location.
+// It validates the request parameters, updates the user's location in the database,
+// and notifies the location history service.
+// Requests carrying an already seen Idempotency-Key header return the original result without being applied again.
+func (s *Service) updateLocation(c *gin.Context) {
+	// Get the username from the URL parameter
+	username := c.Param("username")
+
+	// Bind the JSON request data, the coordinates may be 0 but not missing
+	var body api.UpdateLocationRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		apierror.Abort(c, apierror.New(apierror.INVALID_ARGUMENT, err.Error()))
+		return
+	}
+	data := api.UpdateLocationResponse{Username: username, Longitude: *body.Longitude, Latitude: *body.Latitude}
+
+	// Check if the username is valid
+	if err := utils.CheckUsername(username); err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+
+	// Check if the coordinates are valid
+	if err := utils.CheckCoordinates(data.Longitude, data.Latitude); err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+
+	// Reserve the idempotency key, unless a request was already processed or is being processed under it
+	idempotencyKey := c.GetHeader("Idempotency-Key")
+	if idempotencyKey != "" {
+		if err := utils.CheckIdempotencyKey(idempotencyKey); err != nil {
+			apierror.Abort(c, err)
+			return
+		}
+
+		reservation := IdempotencyRecord{IdempotencyKey: idempotencyKey, Username: username, Longitude: data.Longitude, Latitude: data.Latitude}
+		record, err := s.store.ReserveIdempotencyKey(c.Request.Context(), reservation)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Could not reserve idempotency key", "error", err)
+			apierror.Abort(c, apierror.Wrap(apierror.INTERNAL, "could not update user location and history", err))
+			return
+		}
+
+		if record != nil {
+			// The key was used for a different request
+			if !record.matches(username, data.Longitude, data.Latitude) {
+				apierror.Abort(c, errIdempotencyKeyReused)
+				return
+			}
+
+			// The original request is still being processed, the client retries later
+			if !record.Completed {
+				apierror.Abort(c, errIdempotencyKeyInUse)
+				return
+			}
+
+			// Return the original result
+			c.JSON(http.StatusOK, api.UpdateLocationResponse{Username: record.Username, Longitude: record.Longitude, Latitude: record.Latitude})
+			return
+		}
+	}
+
+	// Notify the location history service first, so that a location it rejects is not stored either
+	if err := s.notifier.NotifyLocation(c.Request.Context(), username, data.Longitude, data.Latitude, idempotencyKey); err != nil {
+		slog.ErrorContext(c.Request.Context(), "Could not notify the location history service", "error", err)
+		s.releaseIdempotencyKey(c.Request.Context(), idempotencyKey)
+
+		// Rejections of the request keep their code, any other failure is reported as the service's
+		if apiErr := apierror.From(err); apiErr.HTTPStatus() < http.StatusInternalServerError {
+			apierror.Abort(c, apiErr)
+		} else {
+			apierror.Abort(c, apierror.Wrap(apierror.UPSTREAM_UNAVAILABLE, "could not update location history", err))
+		}
+		return
+	}
+
+	// Update the user's location in the database
+	if err := s.store.UpdateLocation(c.Request.Context(), username, data.Longitude, data.Latitude); err != nil {
+		slog.ErrorContext(c.Request.Context(), "Could not update user location", "error", err)
+		s.releaseIdempotencyKey(c.Request.Context(), idempotencyKey)
+		apierror.Abort(c, apierror.Wrap(apierror.INTERNAL, "could not update user location and history", err))
+		return
+	}
+
+	// Remember the successful request, the retries with the same key now return its result
+	if idempotencyKey != "" {
+		if err := s.store.CompleteIdempotencyKey(c.Request.Context(), idempotencyKey); err != nil {
+			slog.ErrorContext(c.Request.Context(), "Could not complete idempotency key", "error", err)
+		}
+	}
+
+	// Return a successful response
+	c.JSON(http.StatusOK, data)
+}
+
+// releaseIdempotencyKey forgets the reservation of an idempotency key by a failed request, if one was given
+// A reservation that cannot be released is taken over by a retry after RESERVATION_TTL
+func (s *Service) releaseIdempotencyKey(ctx context.Context, idempotencyKey string) {
+	if idempotencyKey == "" {
+		return
+	}
+	// The key is released even if the request was cancelled
+	if err := s.store.ReleaseIdempotencyKey(context.WithoutCancel(ctx), idempotencyKey); err != nil {
+		slog.ErrorContext(ctx, "Could not release idempotency key", "error", err)
+	}
+}
+
+// findNearby handles the HTTP GET request to find nearby users.
+// It validates the request parameters, retrieves the users within the radius from the database,
+// and returns the results.
+// The radius is given in kilometers by the radius parameter in version 1, in meters by radius_meters from version 2 on
+func (s *Service) findNearby(c *gin.Context) {
+	// Bind the query parameters, the coordinates may be 0 but not missing
+	var data api.NearbyQueryV2
+	if versioning.FromContext(c) == "v1" {
+		var v1 api.NearbyQuery
+		if err := c.ShouldBindQuery(&v1); err != nil {
+			apierror.Abort(c, apierror.New(apierror.INVALID_ARGUMENT, err.Error()))
+			return
+		}
+		data = api.NearbyQueryV2{Longitude: v1.Longitude, Latitude: v1.Latitude, RadiusMeters: v1.Radius * 1000, Page: v1.Page}
+	} else if err := c.ShouldBindQuery(&data); err != nil {
+		apierror.Abort(c, apierror.New(apierror.INVALID_ARGUMENT, err.Error()))
+		return
+	}
+
+	// Check if the page number is valid
+	if data.Page <= 0 {
+		apierror.Abort(c, apierror.New(apierror.INVALID_ARGUMENT, "page number must be greater than zero"))
+		return
+	}
+
+	// Check if the coordinates are valid
+	if err := utils.CheckCoordinates(*data.Longitude, *data.Latitude); err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+
+	// Get the nearby users from the database
+	users, err := s.store.Nearby(c.Request.Context(), *data.Longitude, *data.Latitude, data.RadiusMeters, data.Page)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Could not find nearby users", "error", err)
+		apierror.Abort(c, apierror.Wrap(apierror.INTERNAL, "could not find nearby users", err))
+		return
+	}
+	nearbyPageSize.Observe(float64(len(users)))
+
+	// Return the list of nearby users
+	closeby := make([]api.User, 0, len(users))
+	for _, user := range users {
+		closeby = append(closeby, user.toAPI())
+	}
+	c.JSON(http.StatusOK, api.NearbyResponse{Closeby: closeby})
+}
+
+// listUsers handles the HTTP GET request of the administrators to list every user, by pages ordered by user ID
+func (s *Service) listUsers(c *gin.Context) {
+	// Struct to bind query parameters
+	data := struct {
+		Page int `form:"page,default=1"`
+	}{}
+
+	// Bind the query parameters to the struct
+	if err := c.ShouldBindQuery(&data); err != nil {
+		apierror.Abort(c, apierror.New(apierror.INVALID_ARGUMENT, err.Error()))
+		return
+	}
+
+	// Check if the page number is valid
+	if data.Page <= 0 {
+		apierror.Abort(c, apierror.New(apierror.INVALID_ARGUMENT, "page number must be greater than zero"))
+		return
+	}
+
+	// Get the users of the page from the database
+	users, err := s.store.List(c.Request.Context(), data.Page)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Could not list users", "error", err)
+		apierror.Abort(c, apierror.Wrap(apierror.INTERNAL, "could not list users", err))
+		return
+	}
+
+	// Return the users of the page
+	c.JSON(http.StatusOK, gin.H{"Users": users})
+}
+
+// getUser handles the HTTP GET request of the administrators to read a user and their current location
+func (s *Service) getUser(c *gin.Context) {
+	username := c.Param("username")
+
+	// Check if the username is valid
+	if err := utils.CheckUsername(username); err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+
+	// Get the user from the database
+	user, err := s.store.Get(c.Request.Context(), username)
+	if errors.Is(err, ErrNotFound) {
+		apierror.Abort(c, apierror.New(apierror.NOT_FOUND, "user not found"))
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Could not read user", "error", err)
+		apierror.Abort(c, apierror.Wrap(apierror.INTERNAL, "could not read user", err))
+		return
+	}
+
+	// Return the user
+	c.JSON(http.StatusOK, user)
+}
+
+// deleteUser handles the HTTP DELETE request of the administrators to delete a user
+// The location history of the user is kept by the location history service, see locctl user delete --history
+func (s *Service) deleteUser(c *gin.Context) {
+	username := c.Param("username")
+
+	// Check if the username is valid
+	if err := utils.CheckUsername(username); err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+
+	// Delete the user from the database
+	err := s.store.Delete(c.Request.Context(), username)
+	if errors.Is(err, ErrNotFound) {
+		apierror.Abort(c, apierror.New(apierror.NOT_FOUND, "user not found"))
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Could not delete user", "error", err)
+		apierror.Abort(c, apierror.Wrap(apierror.INTERNAL, "could not delete user", err))
+		return
+	}
+
+	slog.InfoContext(c.Request.Context(), "Deleted user", "username", username)
+	c.Status(http.StatusNoContent)
+}
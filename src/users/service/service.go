@@ -0,0 +1,253 @@
+package service
+
+import (
+	"common/admin"
+	"common/config"
+	"common/database"
+	"common/health"
+	"common/lifecycle"
+	"common/logging"
+	"common/metrics"
+	"common/openapi"
+	"common/tlsutil"
+	"common/tracing"
+	"common/utils"
+	"common/versioning"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
+	"gorm.io/gorm"
+)
+
+// Service is the users service: the handlers of its REST API and the dependencies they use
+// Several services can run in one process, each with its own configuration, store and notifier
+type Service struct {
+	cfg      config.Config    // Service configuration
+	store    UserStore        // Users and idempotency records
+	notifier LocationNotifier // Link to the location history service
+
+	db           *gorm.DB          // Database of the store, nil unless it is a GormUserStore
+	uncount      func()            // Stops counting the users of db, nil unless it is a GormUserStore
+	certificates *tlsutil.Reloader // Certificates of the gRPC link, nil when TLS is disabled or the service was not opened
+	close        func()            // Releases what Open acquired, nil for a service created by New
+}
+
+// defaultConfig holds the configuration used for every value not set by the configuration file,
+// an environment variable or a command line flag
+var defaultConfig = config.Config{
+	RestHost:    "localhost",
+	RestPort:    "8001",
+	GrpcHost:    "localhost",
+	GrpcPort:    "50051",
+	DatabaseURL: "users.db",
+	LogURL:      "users.log",
+	Geodesic:    "haversine",
+
+	ShutdownTimeout: 10 * time.Second,
+	Logging:         config.LoggingConfig{Level: "info", MaxSize: 100},
+	Tracing:         config.TracingConfig{Exporter: "none", SampleRatio: 1},
+	TLS:             config.TLSConfig{ReloadInterval: 10 * time.Second},
+}
+
+// SERVICE_NAME identifies the service in traces
+const SERVICE_NAME = "users"
+
+// LoadConfig resolves the service configuration from the defaults, the configuration file,
+// the USERS_* environment variables and the command line arguments
+func LoadConfig(args []string) (config.Config, config.Options, error) {
+	return config.Load("USERS", defaultConfig, args)
+}
+
+// DefaultConfig returns the configuration of the service when nothing is set
+func DefaultConfig() config.Config {
+	return defaultConfig
+}
+
+// New creates a service around its dependencies, e.g. a MemoryUserStore and a NotifierFunc in tests
+// The migration routes are only registered for a GormUserStore
+func New(cfg config.Config, store UserStore, notifier LocationNotifier) *Service {
+	s := &Service{cfg: cfg, store: store, notifier: notifier}
+	if gormStore, ok := store.(*GormUserStore); ok {
+		s.db = gormStore.db
+		s.uncount = userCount.Add(cfg.RestHost+":"+cfg.RestPort, s.db)
+	}
+	return s
+}
+
+// Handler returns the REST API of the service
+func (s *Service) Handler() http.Handler {
+	engine := gin.New()
+	s.registerRoutes(engine)
+	return engine
+}
+
+// registerRoutes registers the API routes with the Gin engine
+func (s *Service) registerRoutes(engine *gin.Engine) {
+	engine.Use(tracing.GinMiddleware(SERVICE_NAME))
+	// The metrics come before the logging, which recovers the panics, so that the failed requests are counted
+	engine.Use(metrics.GinMiddleware())
+	engine.Use(logging.GinMiddleware())
+
+	// Version 1 of the API, also served without prefix until the sunset of the unversioned routes
+	v1 := versioning.Group(engine, "v1")
+	s.registerV1Routes(v1)
+	s.registerV1Routes(versioning.Deprecated(engine, "v1", versioning.UNVERSIONED_DEPRECATION, versioning.UNVERSIONED_SUNSET))
+
+	// Version 2 of the API, measuring every distance in meters
+	s.registerV2Routes(versioning.Group(engine, "v2"))
+
+	// Administration of the service, only under /v1 and behind the admin token
+	s.registerAdminRoutes(v1.Group("/admin", admin.RequireToken(s.cfg.AdminToken)))
+
+	engine.GET("/healthz", gin.WrapH(health.LiveHandler()))
+	engine.GET("/readyz", gin.WrapH(health.ReadyHandler(s.readinessChecks()...)))
+	engine.GET("/metrics", gin.WrapH(metrics.Handler()))
+	engine.GET("/openapi.json", openapi.Handler(openapiSpec))
+}
+
+// registerV1Routes registers the routes of version 1 of the API with a route group
+// A later version gets its own function and group, reusing the handlers of the routes that do not change
+func (s *Service) registerV1Routes(group *gin.RouterGroup) {
+	group.POST("/update/:username", s.updateLocation)
+	group.GET("/nearby", s.findNearby)
+}
+
+// registerV2Routes registers the routes of version 2 of the API with a route group
+// The nearby search reads the version of the request to take its radius in meters, see versioning.FromContext
+func (s *Service) registerV2Routes(group *gin.RouterGroup) {
+	group.POST("/update/:username", s.updateLocation)
+	group.GET("/nearby", s.findNearby)
+}
+
+// registerAdminRoutes registers the administration routes, used by locctl, with a route group
+// The migration routes are only registered when the users are stored in a database
+func (s *Service) registerAdminRoutes(group *gin.RouterGroup) {
+	group.GET("/users", s.listUsers)
+	group.GET("/users/:username", s.getUser)
+	group.DELETE("/users/:username", s.deleteUser)
+	if s.db != nil {
+		group.GET("/migrations", func(c *gin.Context) { admin.MigrationStatus(c, s.db, migrations) })
+		group.POST("/migrations", func(c *gin.Context) { admin.Migrate(c, s.db, migrations) })
+	}
+}
+
+// readinessChecks lists the dependencies that must be usable for the service to handle requests
+// Location updates are forwarded to the location history service, so it must be serving as well when the notifier
+// can check it
+func (s *Service) readinessChecks() []health.Check {
+	checks := []health.Check{{Name: "database", Run: s.store.Ping}}
+	if checker, ok := s.notifier.(interface{ Check(context.Context) error }); ok {
+		checks = append(checks, health.Check{Name: "location_history", Run: checker.Check})
+	}
+	return checks
+}
+
+// migrateModels applies the pending schema migrations
+// It fails without changing anything if the database was migrated by a newer version of the service
+func migrateModels(db *gorm.DB) error {
+	_, err := database.MigrateUp(db, migrations)
+	return err
+}
+
+// RunCommand runs a subcommand of the service instead of starting it
+// The only subcommand is "migrate up|down [version]|status"
+func RunCommand(cfg config.Config, args []string) error {
+	if args[0] != "migrate" {
+		return fmt.Errorf("unknown command %q, expected migrate", args[0])
+	}
+
+	db, err := database.New(cfg.DatabaseURL)
+	if err != nil {
+		return err
+	}
+	defer database.Close(db)
+
+	return database.RunMigrateCommand(db, migrations, args[1:], os.Stdout)
+}
+
+// Open loads the certificates of the gRPC link to the location history service, connects to the database
+// and applies the pending migrations, then creates the service around a GormUserStore and a GRPCNotifier
+// The dial options are added to those of the link, e.g. a dialer reaching an in-process gRPC server
+func Open(cfg config.Config, opts ...grpc.DialOption) (*Service, error) {
+	geodesic, err := utils.NewGeodesic(cfg.Geodesic)
+	if err != nil {
+		return nil, fmt.Errorf("failed to select the geodesic model: %w", err)
+	}
+	certificates, err := tlsutil.NewReloader(cfg.TLS)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the certificates: %w", err)
+	}
+
+	db, err := database.New(cfg.DatabaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to the database: %w", err)
+	}
+	if err := errors.Join(metrics.InstrumentGORM(db), tracing.InstrumentGORM(db)); err != nil {
+		database.Close(db)
+		return nil, fmt.Errorf("failed to instrument the database: %w", err)
+	}
+	if err := migrateModels(db); err != nil {
+		database.Close(db)
+		return nil, fmt.Errorf("failed to migrate the database: %w", err)
+	}
+
+	opts = append([]grpc.DialOption{tlsutil.DialOption(certificates, cfg.GrpcHost)}, opts...)
+	s := New(cfg, NewGormUserStore(db, geodesic), NewGRPCNotifier(cfg.GrpcHost+":"+cfg.GrpcPort, opts...))
+	s.certificates = certificates
+	s.close = func() { database.Close(db) }
+	return s, nil
+}
+
+// Close stops counting the users of the service and closes the database connection opened by Open
+func (s *Service) Close() {
+	if s.uncount != nil {
+		s.uncount()
+	}
+	if s.close != nil {
+		s.close()
+	}
+}
+
+// Components returns the long running parts of the service, in the order they are stopped:
+// the REST server, the pruner of the idempotency records, and the certificate reloader if TLS is enabled
+func (s *Service) Components() []lifecycle.Component {
+	components := []lifecycle.Component{
+		lifecycle.HTTPServer("users REST server", &http.Server{Addr: s.cfg.RestHost + ":" + s.cfg.RestPort, Handler: s.Handler()}),
+		lifecycle.Worker("users idempotency record pruner", s.pruneIdempotencyRecords),
+	}
+	if s.certificates != nil {
+		components = append(components, lifecycle.Worker("users certificate reloader", s.certificates.Watch))
+	}
+	return components
+}
+
+// pruneIdempotencyRecords deletes the expired idempotency records every PRUNE_INTERVAL until ctx is done
+// Failures are only logged, the records are pruned again at the next interval
+func (s *Service) pruneIdempotencyRecords(ctx context.Context) error {
+	ticker := time.NewTicker(PRUNE_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		pruned, err := s.store.PruneIdempotencyRecords(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				slog.Error("Could not prune idempotency records", "error", err)
+			}
+			continue
+		}
+		slog.Debug("Pruned idempotency records", "count", pruned)
+	}
+}
@@ -0,0 +1,216 @@
+package service
+
+import (
+	"common/database"
+	"common/health"
+	"common/utils"
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrNotFound is returned by the stores when the requested user does not exist
+var ErrNotFound = errors.New("user not found")
+
+// UserStore reads and writes the users of the service and the idempotency records of their location updates
+// GormUserStore keeps them in the database of the service, MemoryUserStore in memory, e.g. for tests
+type UserStore interface {
+	// UpdateLocation sets the location of a user, creating the user if needed
+	UpdateLocation(ctx context.Context, username string, longitude float64, latitude float64) error
+	// Nearby returns a page of the users within radius meters from the given coordinates, ordered by user ID
+	Nearby(ctx context.Context, longitude float64, latitude float64, radius float64, page int) ([]User, error)
+	// List returns a page of every user, ordered by user ID
+	List(ctx context.Context, page int) ([]User, error)
+	// Get returns the user identified by their username, or ErrNotFound if there is none
+	Get(ctx context.Context, username string) (User, error)
+	// Delete deletes a user together with their idempotency records, or returns ErrNotFound if there is no such user
+	Delete(ctx context.Context, username string) error
+	// ReserveIdempotencyKey atomically reserves the key of record for its request, unless the key was seen within
+	// IDEMPOTENCY_TTL, in which case the record of the key is returned instead. It returns nil once the key is reserved.
+	// The reservation of a request that was neither completed nor released within RESERVATION_TTL is taken over
+	ReserveIdempotencyKey(ctx context.Context, record IdempotencyRecord) (*IdempotencyRecord, error)
+	// CompleteIdempotencyKey marks the request reserved under an idempotency key as successfully processed
+	CompleteIdempotencyKey(ctx context.Context, idempotencyKey string) error
+	// ReleaseIdempotencyKey forgets the reservation of a failed request, so that it can be retried with the same key
+	ReleaseIdempotencyKey(ctx context.Context, idempotencyKey string) error
+	// PruneIdempotencyRecords deletes the records of the keys seen before IDEMPOTENCY_TTL and returns their number
+	PruneIdempotencyRecords(ctx context.Context) (int64, error)
+	// Ping checks that the store is usable, for the readiness probe
+	Ping(ctx context.Context) error
+}
+
+// GormUserStore is the UserStore of a migrated database
+type GormUserStore struct {
+	db       *gorm.DB
+	postgis  bool           // Whether the nearby search is evaluated by PostGIS
+	spheroid bool           // Whether PostGIS measures distances on the WGS84 spheroid instead of a sphere
+	geodesic utils.Geodesic // Model of the earth measuring the distances of the nearby search without PostGIS
+}
+
+// NewGormUserStore returns the store of a migrated database measuring distances with the geodesic model
+// PostGIS measures distances on a sphere for the haversine geodesic model and on the WGS84 spheroid for the others
+func NewGormUserStore(db *gorm.DB, geodesic utils.Geodesic) *GormUserStore {
+	return &GormUserStore{db: db, postgis: database.HasPostGIS(db), spheroid: !utils.IsSpherical(geodesic), geodesic: geodesic}
+}
+
+// UpdateLocation updates the location of a user identified by their username
+// If the user exists, it updates their longitude and latitude
+// If the user does not exist, it creates a new user with the provided username, longitude, and latitude
+// The statements run in ctx, so that they are traced as part of the request
+func (s *GormUserStore) UpdateLocation(ctx context.Context, username string, longitude float64, latitude float64) error {
+	db := s.db.WithContext(ctx)
+
+	var user User
+	res := db.Where("Name = ?", username).First(&user)
+
+	// If user exists, update their location
+	if res.Error == nil {
+		user.Longitude = longitude
+		user.Latitude = latitude
+		return db.Save(&user).Error
+	}
+
+	// If there is an error other than record not found, return the error
+	if !errors.Is(res.Error, gorm.ErrRecordNotFound) {
+		return res.Error
+	}
+
+	// If user does not exist, create a new user
+	user = User{Name: username, Longitude: longitude, Latitude: latitude}
+	return db.Create(&user).Error
+}
+
+// Nearby finds users within a certain radius in meters from the given coordinates
+// It returns a paginated list of users that are within the specified radius
+func (s *GormUserStore) Nearby(ctx context.Context, longitude float64, latitude float64, radius float64, page int) ([]User, error) {
+	if s.postgis {
+		return s.nearbyPostGIS(ctx, longitude, latitude, radius, page)
+	}
+
+	var users []User
+	res := s.db.WithContext(ctx).Order("ID").Find(&users)
+
+	// If there is an error while fetching users, return the error
+	if res.Error != nil {
+		return nil, res.Error
+	}
+
+	return nearbyPage(s.geodesic, users, longitude, latitude, radius, page), nil
+}
+
+// nearbyPostGIS is Nearby evaluated by PostGIS
+// The radius filter and the pagination run in the database
+func (s *GormUserStore) nearbyPostGIS(ctx context.Context, longitude float64, latitude float64, radius float64, page int) ([]User, error) {
+	pagedUsers := make([]User, 0, PAGE_SIZE)
+	res := s.db.WithContext(ctx).Where("ST_DWithin(ST_SetSRID(ST_MakePoint(Longitude, Latitude), 4326)::geography, "+
+		"ST_SetSRID(ST_MakePoint(?, ?), 4326)::geography, ?, ?)", longitude, latitude, radius, s.spheroid).
+		Order("ID").Offset((page - 1) * PAGE_SIZE).Limit(PAGE_SIZE).Find(&pagedUsers)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+
+	return pagedUsers, nil
+}
+
+// List returns a page of every user, ordered by user ID
+func (s *GormUserStore) List(ctx context.Context, page int) ([]User, error) {
+	users := make([]User, 0, LIST_PAGE_SIZE)
+	res := s.db.WithContext(ctx).Order("ID").Offset((page - 1) * LIST_PAGE_SIZE).Limit(LIST_PAGE_SIZE).Find(&users)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+
+	return users, nil
+}
+
+// Get returns the user identified by their username, or ErrNotFound if there is none
+func (s *GormUserStore) Get(ctx context.Context, username string) (User, error) {
+	var user User
+	res := s.db.WithContext(ctx).Where("Name = ?", username).First(&user)
+	if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+		return user, ErrNotFound
+	}
+	return user, res.Error
+}
+
+// Delete deletes the user identified by their username together with their idempotency records
+// It returns ErrNotFound if there is no such user
+func (s *GormUserStore) Delete(ctx context.Context, username string) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		res := tx.Where("Name = ?", username).Delete(&User{})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return ErrNotFound
+		}
+
+		return tx.Where("Username = ?", username).Delete(&IdempotencyRecord{}).Error
+	})
+}
+
+// ReserveIdempotencyKey inserts the record of an idempotency key unless the key is taken, in which case it returns
+// the record of the key. A record older than IDEMPOTENCY_TTL is replaced, as if it had already been pruned,
+// and so is a reservation older than RESERVATION_TTL, left by a request that was interrupted
+func (s *GormUserStore) ReserveIdempotencyKey(ctx context.Context, record IdempotencyRecord) (*IdempotencyRecord, error) {
+	db := s.db.WithContext(ctx)
+	record.Completed = false
+
+	// Every step is a single statement, a concurrent request with the same key either takes the key or sees it taken
+	for {
+		res := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&record)
+		if res.Error != nil {
+			return nil, res.Error
+		}
+		if res.RowsAffected > 0 {
+			return nil, nil
+		}
+
+		// Forget the key if it expired or if its request was interrupted, then try again
+		now := time.Now()
+		res = db.Where("idempotency_key = ? AND (created_at < ? OR (completed = ? AND created_at < ?))",
+			record.IdempotencyKey, now.Add(-IDEMPOTENCY_TTL), false, now.Add(-RESERVATION_TTL)).Delete(&IdempotencyRecord{})
+		if res.Error != nil {
+			return nil, res.Error
+		}
+		if res.RowsAffected > 0 {
+			continue
+		}
+
+		var seen IdempotencyRecord
+		res = db.Where("idempotency_key = ?", record.IdempotencyKey).Limit(1).Find(&seen)
+		if res.Error != nil {
+			return nil, res.Error
+		}
+		if res.RowsAffected > 0 {
+			return &seen, nil
+		}
+		// The key was released or pruned in the meantime, try again
+	}
+}
+
+// CompleteIdempotencyKey marks the request reserved under an idempotency key as successfully processed
+func (s *GormUserStore) CompleteIdempotencyKey(ctx context.Context, idempotencyKey string) error {
+	return s.db.WithContext(ctx).Model(&IdempotencyRecord{}).Where("idempotency_key = ?", idempotencyKey).
+		Update("completed", true).Error
+}
+
+// ReleaseIdempotencyKey deletes the record of an idempotency key, unless its request was completed
+func (s *GormUserStore) ReleaseIdempotencyKey(ctx context.Context, idempotencyKey string) error {
+	return s.db.WithContext(ctx).Where("idempotency_key = ? AND completed = ?", idempotencyKey, false).
+		Delete(&IdempotencyRecord{}).Error
+}
+
+// PruneIdempotencyRecords deletes the records of the keys seen before IDEMPOTENCY_TTL
+func (s *GormUserStore) PruneIdempotencyRecords(ctx context.Context) (int64, error) {
+	res := s.db.WithContext(ctx).Where("created_at < ?", time.Now().Add(-IDEMPOTENCY_TTL)).Delete(&IdempotencyRecord{})
+	return res.RowsAffected, res.Error
+}
+
+// Ping checks that the database answers
+func (s *GormUserStore) Ping(ctx context.Context) error {
+	return health.PingDatabase(ctx, s.db)
+}
@@ -0,0 +1,946 @@
+package service
+
+import (
+	"common/admin"
+	"common/api"
+	"common/apierror"
+	"common/apierror/apierrortest"
+	"common/config"
+	"common/database"
+	"common/database/databasetest"
+	"common/logging"
+	"common/metrics"
+	"common/openapi"
+	pb "common/protobuff"
+	"common/tlsutil"
+	"common/tracing"
+	"common/utils"
+	"common/versioning"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"gorm.io/gorm"
+)
+
+var (
+	cfg config.Config // Configuration of the tested services
+	db  *gorm.DB      // Database of the tested services running on a GORM store
+)
+
+const TEST_ADMIN_TOKEN = "test-admin-token" // Bearer token of the administration routes in the tests
+
+// ignoreNotifications replaces the location history service of the tests that don't check the notifications
+func ignoreNotifications(ctx context.Context, username string, longitude, latitude float64, idempotencyKey string) error {
+	return nil
+}
+
+// newTestService creates a service on a store, notifying the mock of the test instead of the location history service
+// The requests and responses of its routes are checked against the OpenAPI document, failing the test if they don't match
+func newTestService(t *testing.T, store UserStore, notify NotifierFunc) (*Service, *gin.Engine) {
+	t.Helper()
+	doc, err := openapi.Load(openapiSpec)
+	if err != nil {
+		t.Fatal("invalid OpenAPI document: ", err)
+	}
+
+	s := New(cfg, store, notify)
+	t.Cleanup(s.Close)
+	router := gin.New()
+	router.Use(openapi.ValidationMiddleware(doc, func(c *gin.Context, err error) {
+		t.Errorf("request or response violating the OpenAPI document: %v", err)
+	}))
+	s.registerRoutes(router)
+	return s, router
+}
+
+// newGormStore returns a store of the test database, emptied first
+func newGormStore() UserStore {
+	wipeDatabase()
+	return NewGormUserStore(db, utils.NewHaversine())
+}
+
+// fakeLocationHistoryServer records the usernames of the location updates it receives
+type fakeLocationHistoryServer struct {
+	pb.UnimplementedLocationHistoryServiceServer
+	usernames []string
+}
+
+// UpdateHistory records the username of the update
+func (s *fakeLocationHistoryServer) UpdateHistory(ctx context.Context, req *pb.LocationUpdateRequest) (*pb.LocationUpdateReply, error) {
+	s.usernames = append(s.usernames, req.GetUsername())
+	return &pb.LocationUpdateReply{Status: pb.Status_SUCCESS}, nil
+}
+
+// wipeDatabase reverts every migration and applies them again, leaving empty tables
+// Migrating up first adopts tables created before the schema was versioned, so that they are dropped as well
+func wipeDatabase() {
+	err := migrateModels(db)
+	if err == nil {
+		_, err = database.MigrateDown(db, migrations, 0)
+	}
+	if err == nil {
+		err = migrateModels(db)
+	}
+	if err != nil {
+		fmt.Println("failed to wipe the database: ", err)
+		os.Exit(1)
+	}
+}
+
+// TestMain sets up the testing environment
+func TestMain(m *testing.M) {
+	// Load the configuration from the defaults and environment variables, enabling the administration routes
+	var err error
+	if cfg, _, err = LoadConfig(nil); err != nil {
+		fmt.Println("invalid configuration: ", err)
+		os.Exit(1)
+	}
+	cfg.AdminToken = TEST_ADMIN_TOKEN
+
+	// Initialize logging
+	logFile, err := logging.Setup(cfg.LogURL, cfg.Logging)
+	if err != nil {
+		fmt.Println("failed to set up logging: ", err)
+		os.Exit(1)
+	}
+	defer logFile.Close()
+
+	// Install the tracer provider so that trace contexts are propagated
+	if _, err := tracing.Setup(context.Background(), SERVICE_NAME, cfg.Tracing); err != nil {
+		fmt.Println("failed to set up tracing: ", err)
+		os.Exit(1)
+	}
+
+	// Set Gin's default writer and error writer to the logger
+	gin.DefaultWriter = log.Writer()
+	gin.DefaultErrorWriter = log.Writer()
+
+	// Connect to the database, the tests migrate it
+	db, err = database.New(cfg.DatabaseURL)
+	if err != nil {
+		fmt.Println("failed to connect to the database: ", err)
+		os.Exit(1)
+	}
+	defer database.Close(db)
+	if err := errors.Join(metrics.InstrumentGORM(db), tracing.InstrumentGORM(db)); err != nil {
+		fmt.Println("failed to instrument the database: ", err)
+		os.Exit(1)
+	}
+
+	// Run the tests
+	m.Run()
+}
+
+// TestUserStores tests that the GORM and in-memory stores behave the same
+// The PostgreSQL store searches with PostGIS in its own schema of the server of TEST_POSTGRES_URL, and is skipped without one
+func TestUserStores(t *testing.T) {
+	stores := []struct {
+		name  string
+		store func(t *testing.T) UserStore
+	}{
+		{"GORM", func(t *testing.T) UserStore { return newGormStore() }},
+		{"PostgreSQL", func(t *testing.T) UserStore {
+			postgres := databasetest.Postgres(t)
+			if err := migrateModels(postgres); err != nil {
+				t.Fatal(err)
+			}
+			return NewGormUserStore(postgres, utils.NewHaversine())
+		}},
+		{"Memory", func(t *testing.T) UserStore { return NewMemoryUserStore(utils.NewHaversine()) }},
+	}
+
+	for _, tt := range stores {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			t.Run("Update Location", func(t *testing.T) {
+				store := tt.store(t)
+				assert.NoError(t, store.UpdateLocation(ctx, "testuser", 10.0, 20.0))
+				assert.NoError(t, store.UpdateLocation(ctx, "testuser", 30.123456789, 40.0))
+				assert.NoError(t, store.UpdateLocation(ctx, "newuser", 50.0, 60.0))
+
+				user, err := store.Get(ctx, "testuser")
+				assert.NoError(t, err)
+				assert.Equal(t, User{ID: 1, Name: "testuser", Longitude: 30.12345679, Latitude: 40.0}, user)
+
+				user, err = store.Get(ctx, "newuser")
+				assert.NoError(t, err)
+				assert.Equal(t, User{ID: 2, Name: "newuser", Longitude: 50.0, Latitude: 60.0}, user)
+
+				_, err = store.Get(ctx, "nobody")
+				assert.ErrorIs(t, err, ErrNotFound)
+			})
+
+			t.Run("Nearby", func(t *testing.T) {
+				store := tt.store(t)
+				for i := 1; i <= 4; i++ {
+					assert.NoError(t, store.UpdateLocation(ctx, fmt.Sprintf("user%d", i), float64(i*10), float64(i*10)))
+				}
+
+				// Users within radius
+				nearbyUsers, err := store.Nearby(ctx, 15.0, 15.0, 2000000.0, 1)
+				assert.NoError(t, err)
+				assert.Equal(t, []User{{ID: 1, Name: "user1", Longitude: 10, Latitude: 10}, {ID: 2, Name: "user2", Longitude: 20, Latitude: 20}}, nearbyUsers)
+
+				// No users within radius
+				nearbyUsers, err = store.Nearby(ctx, 0.0, 0.0, 5000.0, 1)
+				assert.NoError(t, err)
+				assert.Len(t, nearbyUsers, 0)
+
+				// Pagination
+				nearbyUsers, err = store.Nearby(ctx, 15.0, 15.0, 100000000.0, 2)
+				assert.NoError(t, err)
+				assert.Len(t, nearbyUsers, 1)
+				nearbyUsers, err = store.Nearby(ctx, 15.0, 15.0, 100000000.0, 3)
+				assert.NoError(t, err)
+				assert.Len(t, nearbyUsers, 0)
+			})
+
+			t.Run("List And Delete", func(t *testing.T) {
+				store := tt.store(t)
+				for i := 1; i <= LIST_PAGE_SIZE+1; i++ {
+					assert.NoError(t, store.UpdateLocation(ctx, fmt.Sprintf("user%d", i), 1.0, 2.0))
+				}
+				record := IdempotencyRecord{IdempotencyKey: "0b7c8e1a-3f2d-4c5b-9a6e-7d8f9a0b1c2d", Username: "user1", Longitude: 1.0, Latitude: 2.0}
+				found, err := store.ReserveIdempotencyKey(ctx, record)
+				assert.NoError(t, err)
+				assert.Nil(t, found)
+
+				users, err := store.List(ctx, 2)
+				assert.NoError(t, err)
+				assert.Equal(t, []User{{ID: uint(LIST_PAGE_SIZE + 1), Name: fmt.Sprintf("user%d", LIST_PAGE_SIZE+1), Longitude: 1.0, Latitude: 2.0}}, users)
+
+				assert.NoError(t, store.Delete(ctx, "user1"))
+				assert.ErrorIs(t, store.Delete(ctx, "user1"), ErrNotFound)
+				users, err = store.List(ctx, 1)
+				assert.NoError(t, err)
+				assert.Len(t, users, LIST_PAGE_SIZE)
+				assert.Equal(t, "user2", users[0].Name)
+
+				// The idempotency records of the deleted user are gone, their keys can be reserved again
+				found, err = store.ReserveIdempotencyKey(ctx, record)
+				assert.NoError(t, err)
+				assert.Nil(t, found)
+			})
+
+			t.Run("Idempotency Records", func(t *testing.T) {
+				store := tt.store(t)
+				record := IdempotencyRecord{IdempotencyKey: "3f2b8c1e-9a4d-4e6f-8b7a-1c2d3e4f5a6b", Username: "testuser", Longitude: 10.0, Latitude: 20.0}
+				found, err := store.ReserveIdempotencyKey(ctx, record)
+				assert.NoError(t, err)
+				assert.Nil(t, found)
+
+				// The key is reserved until its request completes
+				found, err = store.ReserveIdempotencyKey(ctx, record)
+				if assert.NoError(t, err) && assert.NotNil(t, found) {
+					assert.True(t, found.matches("testuser", 10.0, 20.0))
+					assert.False(t, found.Completed)
+				}
+				assert.NoError(t, store.CompleteIdempotencyKey(ctx, record.IdempotencyKey))
+				assert.NoError(t, store.ReleaseIdempotencyKey(ctx, record.IdempotencyKey))
+				found, err = store.ReserveIdempotencyKey(ctx, record)
+				if assert.NoError(t, err) && assert.NotNil(t, found) {
+					assert.True(t, found.Completed)
+				}
+
+				// A released key can be reserved again
+				released := IdempotencyRecord{IdempotencyKey: "5e6f7a8b-9c0d-4e1f-8a2b-3c4d5e6f7a8b", Username: "testuser"}
+				_, err = store.ReserveIdempotencyKey(ctx, released)
+				assert.NoError(t, err)
+				assert.NoError(t, store.ReleaseIdempotencyKey(ctx, released.IdempotencyKey))
+				found, err = store.ReserveIdempotencyKey(ctx, released)
+				assert.NoError(t, err)
+				assert.Nil(t, found)
+
+				// Expired keys and interrupted reservations are taken over
+				expired := IdempotencyRecord{IdempotencyKey: "9c1d2e3f-4a5b-4c6d-8e7f-0a1b2c3d4e5f", Username: "testuser", CreatedAt: time.Now().Add(-IDEMPOTENCY_TTL - time.Minute)}
+				for _, createdAt := range []time.Time{expired.CreatedAt, time.Now().Add(-RESERVATION_TTL - time.Minute)} {
+					expired.CreatedAt = createdAt
+					_, err = store.ReserveIdempotencyKey(ctx, expired)
+					assert.NoError(t, err)
+					if createdAt.Before(time.Now().Add(-IDEMPOTENCY_TTL)) {
+						assert.NoError(t, store.CompleteIdempotencyKey(ctx, expired.IdempotencyKey))
+					}
+					expired.CreatedAt = time.Time{}
+					found, err = store.ReserveIdempotencyKey(ctx, expired)
+					assert.NoError(t, err)
+					assert.Nil(t, found)
+					assert.NoError(t, store.ReleaseIdempotencyKey(ctx, expired.IdempotencyKey))
+				}
+
+				// Only the expired keys are pruned
+				expired.CreatedAt = time.Now().Add(-IDEMPOTENCY_TTL - time.Minute)
+				_, err = store.ReserveIdempotencyKey(ctx, expired)
+				assert.NoError(t, err)
+				pruned, err := store.PruneIdempotencyRecords(ctx)
+				assert.NoError(t, err)
+				assert.Equal(t, int64(1), pruned)
+				found, err = store.ReserveIdempotencyKey(ctx, record)
+				assert.NoError(t, err)
+				assert.NotNil(t, found)
+
+				// Concurrent requests with the same key reserve it once
+				concurrent := IdempotencyRecord{IdempotencyKey: "7a8b9c0d-1e2f-4a3b-8c4d-5e6f7a8b9c0d", Username: "testuser"}
+				var reserved atomic.Int32
+				var wg sync.WaitGroup
+				for i := 0; i < 8; i++ {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						found, err := store.ReserveIdempotencyKey(ctx, concurrent)
+						if assert.NoError(t, err) && found == nil {
+							reserved.Add(1)
+						}
+					}()
+				}
+				wg.Wait()
+				assert.Equal(t, int32(1), reserved.Load())
+
+				assert.NoError(t, store.Ping(ctx))
+			})
+		})
+	}
+}
+
+// TestMemoryStore tests a service running on an in-memory store
+func TestMemoryStore(t *testing.T) {
+	var notified []string
+	memory := New(cfg, NewMemoryUserStore(utils.NewHaversine()), NotifierFunc(func(ctx context.Context, username string, longitude, latitude float64, idempotencyKey string) error {
+		notified = append(notified, username)
+		return nil
+	}))
+	handler := memory.Handler()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/v1/update/inmemory", strings.NewReader(`{"longitude": 10.0, "latitude": 20.0}`))
+	req.Header.Set("Content-Type", "application/json")
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []string{"inmemory"}, notified)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/v1/nearby?longitude=10&latitude=20&radius=1&page=1", nil)
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"Closeby": [{"ID": 1, "Name": "inmemory", "Longitude": 10.0, "Latitude": 20.0}]}`, w.Body.String())
+
+	// The database is untouched and the migration routes are not registered
+	var count int64
+	assert.NoError(t, db.Model(&User{}).Where("name = ?", "inmemory").Count(&count).Error)
+	assert.Zero(t, count)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/v1/admin/migrations", nil)
+	req.Header.Set("Authorization", "Bearer "+TEST_ADMIN_TOKEN)
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+// TestUpdateLocation tests the updateLocation endpoint
+func TestUpdateLocation(t *testing.T) {
+	s, router := newTestService(t, NewMemoryUserStore(utils.NewHaversine()), ignoreNotifications)
+	assert.NoError(t, s.store.UpdateLocation(context.Background(), "testuser", 0.0, 10.0))
+
+	t.Run("Valid Request", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/update/testuser", strings.NewReader(`{"longitude": 10.0, "latitude": 20.0}`))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"Username": "testuser", "Longitude": 10.0, "Latitude": 20.0}`, w.Body.String())
+	})
+
+	t.Run("Invalid Username", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/update/", strings.NewReader(`{"longitude": 10.0, "latitude": 20.0}`))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("Invalid Coordinates", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/update/testuser", strings.NewReader(`{"longitude": 200.0, "latitude": 100.0}`))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		apierrortest.AssertProblem(t, w, apierror.INVALID_COORDINATES, "longitude must be between -180 and 180")
+	})
+
+	t.Run("Zero Coordinates", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/v1/update/nullisland", strings.NewReader(`{"longitude": 0, "latitude": 0.0}`))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"Username": "nullisland", "Longitude": 0, "Latitude": 0}`, w.Body.String())
+
+		// The nearby search accepts a center at 0 as well
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("GET", "/v1/nearby?longitude=0&latitude=0&radius=1&page=1", nil)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var reply api.NearbyResponse
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &reply))
+		if assert.Len(t, reply.Closeby, 1) {
+			assert.Equal(t, "nullisland", reply.Closeby[0].Name)
+		}
+	})
+
+	t.Run("Missing Coordinates", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/v1/update/testuser", strings.NewReader(`{"longitude": 10.0}`))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		var problem apierror.Problem
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &problem))
+		assert.Equal(t, apierror.INVALID_ARGUMENT, problem.Code)
+
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("GET", "/v1/nearby?longitude=0&radius=1&page=1", nil)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &problem))
+		assert.Equal(t, apierror.INVALID_ARGUMENT, problem.Code)
+	})
+}
+
+// TestUpdateLocationIdempotency tests the updateLocation endpoint with idempotency keys
+func TestUpdateLocationIdempotency(t *testing.T) {
+	// Count the notifications, failing them while the location history service is unavailable
+	notifications := 0
+	unavailable := false
+	s, router := newTestService(t, NewMemoryUserStore(utils.NewHaversine()), func(ctx context.Context, username string, longitude, latitude float64, idempotencyKey string) error {
+		if unavailable {
+			return status.Error(codes.Unavailable, "connection refused")
+		}
+		notifications++
+		return nil
+	})
+
+	sendUpdate := func(body string, idempotencyKey string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/update/testuser", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Idempotency-Key", idempotencyKey)
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	key := "3f2b8c1e-9a4d-4e6f-8b7a-1c2d3e4f5a6b"
+
+	t.Run("First Request", func(t *testing.T) {
+		w := sendUpdate(`{"longitude": 10.0, "latitude": 20.0}`, key)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, 1, notifications)
+	})
+
+	t.Run("Retried Request", func(t *testing.T) {
+		w := sendUpdate(`{"longitude": 10.0, "latitude": 20.0}`, key)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"Username": "testuser", "Longitude": 10.0, "Latitude": 20.0}`, w.Body.String())
+		assert.Equal(t, 1, notifications)
+	})
+
+	t.Run("Key Reused For Different Request", func(t *testing.T) {
+		w := sendUpdate(`{"longitude": 11.0, "latitude": 21.0}`, key)
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+		assert.Equal(t, 1, notifications)
+	})
+
+	t.Run("Request In Progress", func(t *testing.T) {
+		pending := IdempotencyRecord{IdempotencyKey: "6d7e8f9a-0b1c-4d2e-8f3a-4b5c6d7e8f9a", Username: "testuser", Longitude: 10.0, Latitude: 20.0}
+		_, err := s.store.ReserveIdempotencyKey(context.Background(), pending)
+		assert.NoError(t, err)
+		w := sendUpdate(`{"longitude": 10.0, "latitude": 20.0}`, pending.IdempotencyKey)
+		assert.Equal(t, http.StatusConflict, w.Code)
+		apierrortest.AssertProblem(t, w, apierror.IDEMPOTENCY_KEY_IN_USE, "a request with the same idempotency key is still being processed")
+		assert.Equal(t, 1, notifications)
+	})
+
+	t.Run("Failed Request Retried", func(t *testing.T) {
+		failedKey := "8b9c0d1e-2f3a-4b4c-9d5e-6f7a8b9c0d1e"
+		unavailable = true
+		assert.Equal(t, http.StatusServiceUnavailable, sendUpdate(`{"longitude": 12.0, "latitude": 22.0}`, failedKey).Code)
+
+		// The key of the failed request was released
+		unavailable = false
+		assert.Equal(t, http.StatusOK, sendUpdate(`{"longitude": 12.0, "latitude": 22.0}`, failedKey).Code)
+		assert.Equal(t, 2, notifications)
+	})
+
+	t.Run("Invalid Key", func(t *testing.T) {
+		w := sendUpdate(`{"longitude": 10.0, "latitude": 20.0}`, "retry-1")
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		apierrortest.AssertProblem(t, w, apierror.INVALID_IDEMPOTENCY_KEY, "idempotency key must be a UUID")
+	})
+}
+
+// TestUpdateLocationUpstreamErrors tests that the failures of the location history service are reported with their code
+// and that the location is then not stored
+func TestUpdateLocationUpstreamErrors(t *testing.T) {
+	var upstream error // Error of the location history service in the running subtest
+	_, router := newTestService(t, newGormStore(), func(ctx context.Context, username string, longitude, latitude float64, idempotencyKey string) error {
+		return upstream
+	})
+
+	tests := []struct {
+		name         string
+		err          error
+		expectedCode int
+		problem      apierror.Code
+	}{
+		{"Rejected Request", apierror.New(apierror.IDEMPOTENCY_KEY_REUSED, "idempotency key was already used for a different request").GRPCStatus().Err(), http.StatusUnprocessableEntity, apierror.IDEMPOTENCY_KEY_REUSED},
+		{"Unreachable Service", status.Error(codes.Unavailable, "connection refused"), http.StatusServiceUnavailable, apierror.UPSTREAM_UNAVAILABLE},
+		{"Failed Service", apierror.New(apierror.INTERNAL, "could not update location history").GRPCStatus().Err(), http.StatusServiceUnavailable, apierror.UPSTREAM_UNAVAILABLE},
+		{"Timeout", context.DeadlineExceeded, http.StatusServiceUnavailable, apierror.UPSTREAM_UNAVAILABLE},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream = tt.err
+
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("POST", "/v1/update/upstream", strings.NewReader(`{"longitude": 10.0, "latitude": 20.0}`))
+			req.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedCode, w.Code)
+			var problem apierror.Problem
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &problem))
+			assert.Equal(t, tt.problem, problem.Code)
+			assert.NotContains(t, w.Body.String(), "connection refused")
+		})
+	}
+
+	// The location is not stored when the location history service does not record it
+	var count int64
+	assert.NoError(t, db.Model(&User{}).Where("name = ?", "upstream").Count(&count).Error)
+	assert.Zero(t, count)
+}
+
+// TestUpdateLocationTracing tests that the trace of the caller is propagated to the location history service
+func TestUpdateLocationTracing(t *testing.T) {
+	var notified trace.SpanContext
+	_, router := newTestService(t, NewMemoryUserStore(utils.NewHaversine()), func(ctx context.Context, username string, longitude, latitude float64, idempotencyKey string) error {
+		notified = trace.SpanContextFromContext(ctx)
+		return nil
+	})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/update/traced", strings.NewReader(`{"longitude": 10.0, "latitude": 20.0}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", notified.TraceID().String())
+	assert.NotEqual(t, "00f067aa0ba902b7", notified.SpanID().String(), "Expected the span of the request, not of the caller")
+}
+
+// TestUpdateLocationRequestID tests that the request ID of the caller is returned and propagated to the location history service
+func TestUpdateLocationRequestID(t *testing.T) {
+	var notified string
+	_, router := newTestService(t, NewMemoryUserStore(utils.NewHaversine()), func(ctx context.Context, username string, longitude, latitude float64, idempotencyKey string) error {
+		notified = logging.RequestID(ctx)
+		return nil
+	})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/update/traced", strings.NewReader(`{"longitude": 10.0, "latitude": 20.0}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(logging.REQUEST_ID_HEADER, "caller-request")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "caller-request", w.Header().Get(logging.REQUEST_ID_HEADER))
+	assert.Equal(t, "caller-request", notified)
+}
+
+// TestVersionedRoutes tests that the v1 and v2 routes are served and that the unversioned routes are deprecated aliases
+func TestVersionedRoutes(t *testing.T) {
+	_, router := newTestService(t, NewMemoryUserStore(utils.NewHaversine()), ignoreNotifications)
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		deprecated bool
+	}{
+		{"Versioned Update", "POST", "/v1/update/versioned", `{"longitude": 10.0, "latitude": 20.0}`, false},
+		{"Unversioned Update", "POST", "/update/versioned", `{"longitude": 10.0, "latitude": 20.0}`, true},
+		{"Versioned Nearby", "GET", "/v1/nearby?longitude=10&latitude=20&radius=1&page=1", "", false},
+		{"Unversioned Nearby", "GET", "/nearby?longitude=10&latitude=20&radius=1&page=1", "", true},
+		{"Version 2 Update", "POST", "/v2/update/versioned", `{"longitude": 10.0, "latitude": 20.0}`, false},
+		{"Version 2 Nearby", "GET", "/v2/nearby?longitude=10&latitude=20&radius_meters=1000&page=1", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Contains(t, w.Body.String(), "versioned")
+			if tt.deprecated {
+				assert.Equal(t, fmt.Sprintf("@%d", versioning.UNVERSIONED_DEPRECATION.Unix()), w.Header().Get("Deprecation"))
+				assert.Equal(t, versioning.UNVERSIONED_SUNSET.Format(http.TimeFormat), w.Header().Get("Sunset"))
+				assert.Contains(t, w.Header().Get("Link"), "</v1"+req.URL.Path+">")
+			} else {
+				assert.Empty(t, w.Header().Get("Deprecation"))
+				assert.Empty(t, w.Header().Get("Sunset"))
+			}
+		})
+	}
+}
+
+// TestNearbyUnits tests that the radius of the nearby search is in kilometers in version 1 and in meters in version 2
+func TestNearbyUnits(t *testing.T) {
+	handler := New(cfg, NewMemoryUserStore(utils.NewHaversine()), NotifierFunc(ignoreNotifications)).Handler()
+
+	// The second user is 556 meters north of the first one
+	for username, body := range map[string]string{"center": `{"longitude": 0, "latitude": 0}`, "north": `{"longitude": 0, "latitude": 0.005}`} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/v2/update/"+username, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+
+	tests := []struct {
+		path     string
+		status   int
+		expected int
+	}{
+		{"/v1/nearby?longitude=0&latitude=0&radius=1&page=1", http.StatusOK, 2},
+		{"/v1/nearby?longitude=0&latitude=0&radius=0.1&page=1", http.StatusOK, 1},
+		{"/v2/nearby?longitude=0&latitude=0&radius_meters=1000&page=1", http.StatusOK, 2},
+		{"/v2/nearby?longitude=0&latitude=0&radius_meters=100&page=1", http.StatusOK, 1},
+		{"/v2/nearby?longitude=0&latitude=0&radius=1&page=1", http.StatusBadRequest, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", tt.path, nil)
+			handler.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.status, w.Code)
+			var reply api.NearbyResponse
+			if tt.status == http.StatusOK && assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &reply)) {
+				assert.Len(t, reply.Closeby, tt.expected)
+			}
+		})
+	}
+}
+
+// TestGRPCNotifierTLS tests that location updates are sent over mutual TLS when it is enabled
+func TestGRPCNotifierTLS(t *testing.T) {
+	dir := t.TempDir()
+	if err := tlsutil.GenerateDevCertificates(dir, "localhost"); err != nil {
+		t.Fatal(err)
+	}
+	serverCertificates, err := tlsutil.NewReloader(config.TLSConfig{
+		Enabled:    true,
+		CertFile:   filepath.Join(dir, tlsutil.DEV_SERVER_CERT),
+		KeyFile:    filepath.Join(dir, tlsutil.DEV_SERVER_KEY),
+		CAFile:     filepath.Join(dir, tlsutil.DEV_CA_FILE),
+		ClientAuth: true,
+	})
+	assert.NoError(t, err)
+	credentials, err := tlsutil.ServerOption(serverCertificates)
+	assert.NoError(t, err)
+
+	// Replace the location history service by a local gRPC server requiring client certificates
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := &fakeLocationHistoryServer{}
+	server := grpc.NewServer(credentials)
+	pb.RegisterLocationHistoryServiceServer(server, fake)
+	go server.Serve(lis)
+	defer server.Stop()
+
+	address := "localhost:" + strings.Split(lis.Addr().String(), ":")[1]
+
+	// With the certificate of the client
+	certificates, err := tlsutil.NewReloader(config.TLSConfig{
+		Enabled:  true,
+		CertFile: filepath.Join(dir, tlsutil.DEV_CLIENT_CERT),
+		KeyFile:  filepath.Join(dir, tlsutil.DEV_CLIENT_KEY),
+		CAFile:   filepath.Join(dir, tlsutil.DEV_CA_FILE),
+	})
+	assert.NoError(t, err)
+	notifier := NewGRPCNotifier(address, tlsutil.DialOption(certificates, "localhost"))
+	assert.NoError(t, notifier.NotifyLocation(context.Background(), "secure", 1.0, 2.0, ""))
+	assert.Equal(t, []string{"secure"}, fake.usernames)
+
+	// Without a client certificate
+	certificates, err = tlsutil.NewReloader(config.TLSConfig{Enabled: true, CAFile: filepath.Join(dir, tlsutil.DEV_CA_FILE)})
+	assert.NoError(t, err)
+	notifier = NewGRPCNotifier(address, tlsutil.DialOption(certificates, "localhost"))
+	assert.Error(t, notifier.NotifyLocation(context.Background(), "anonymous", 1.0, 2.0, ""))
+	assert.Equal(t, []string{"secure"}, fake.usernames)
+}
+
+// TestGRPCNotifierDialer tests that location updates and readiness checks go through the dialer when one is set
+func TestGRPCNotifierDialer(t *testing.T) {
+	// Replace the location history service by a gRPC server on an in-process listener
+	lis := bufconn.Listen(1 << 20)
+	fake := &fakeLocationHistoryServer{}
+	server := grpc.NewServer()
+	pb.RegisterLocationHistoryServiceServer(server, fake)
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus(pb.LocationHistoryService_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(server, healthServer)
+	go server.Serve(lis)
+	defer server.Stop()
+
+	// Nothing listens on the address, so only the dialer reaches the server
+	notifier := NewGRPCNotifier("localhost:1", grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(func(ctx context.Context, address string) (net.Conn, error) { return lis.DialContext(ctx) }))
+
+	assert.NoError(t, notifier.NotifyLocation(context.Background(), "inprocess", 1.0, 2.0, ""))
+	assert.Equal(t, []string{"inprocess"}, fake.usernames)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/readyz", nil)
+	New(cfg, NewMemoryUserStore(utils.NewHaversine()), notifier).Handler().ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+}
+
+// TestAdminRoutes tests listing, reading and deleting users through the administration routes
+func TestAdminRoutes(t *testing.T) {
+	s, router := newTestService(t, newGormStore(), ignoreNotifications)
+	for i := 1; i <= LIST_PAGE_SIZE+1; i++ {
+		db.Create(&User{Name: fmt.Sprintf("user%d", i), Longitude: 1.0, Latitude: 2.0})
+	}
+	db.Create(&IdempotencyRecord{IdempotencyKey: "0b7c8e1a-3f2d-4c5b-9a6e-7d8f9a0b1c2d", Username: "user1"})
+
+	tests := []struct {
+		name         string
+		method       string
+		path         string
+		token        string
+		expectedCode int
+		expectedBody string
+		problem      apierror.Code
+	}{
+		{"Missing Token", "GET", "/v1/admin/users", "", http.StatusUnauthorized, "", apierror.UNAUTHENTICATED},
+		{"Invalid Token", "GET", "/v1/admin/users", "guess", http.StatusUnauthorized, "", apierror.UNAUTHENTICATED},
+		{"Get User", "GET", "/v1/admin/users/user2", TEST_ADMIN_TOKEN, http.StatusOK, `{"ID": 2, "Name": "user2", "Longitude": 1.0, "Latitude": 2.0}`, ""},
+		{"Get Unknown User", "GET", "/v1/admin/users/nobody", TEST_ADMIN_TOKEN, http.StatusNotFound, "", apierror.NOT_FOUND},
+		{"Get Invalid Username", "GET", "/v1/admin/users/ab", TEST_ADMIN_TOKEN, http.StatusBadRequest, "", apierror.INVALID_USERNAME},
+		{"Second Page", "GET", "/v1/admin/users?page=2", TEST_ADMIN_TOKEN, http.StatusOK, fmt.Sprintf(`{"Users": [{"ID": %d, "Name": "user%d", "Longitude": 1.0, "Latitude": 2.0}]}`, LIST_PAGE_SIZE+1, LIST_PAGE_SIZE+1), ""},
+		{"Invalid Page", "GET", "/v1/admin/users?page=0", TEST_ADMIN_TOKEN, http.StatusBadRequest, "", apierror.INVALID_ARGUMENT},
+		{"Delete User", "DELETE", "/v1/admin/users/user1", TEST_ADMIN_TOKEN, http.StatusNoContent, "", ""},
+		{"Delete Deleted User", "DELETE", "/v1/admin/users/user1", TEST_ADMIN_TOKEN, http.StatusNotFound, "", apierror.NOT_FOUND},
+		{"Unversioned", "GET", "/admin/users", TEST_ADMIN_TOKEN, http.StatusNotFound, "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(tt.method, tt.path, nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedCode, w.Code)
+			if tt.expectedBody != "" {
+				assert.JSONEq(t, tt.expectedBody, w.Body.String())
+			}
+			if tt.problem != "" {
+				var problem apierror.Problem
+				assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &problem))
+				assert.Equal(t, tt.problem, problem.Code)
+			}
+		})
+	}
+
+	// The first page is full and the idempotency records of the deleted user are gone
+	users, err := s.store.List(context.Background(), 1)
+	assert.NoError(t, err)
+	assert.Len(t, users, LIST_PAGE_SIZE)
+
+	var records int64
+	db.Model(&IdempotencyRecord{}).Where("Username = ?", "user1").Count(&records)
+	assert.Equal(t, int64(0), records)
+
+	// The migrations are listed, all applied
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/v1/admin/migrations", nil)
+	req.Header.Set("Authorization", "Bearer "+TEST_ADMIN_TOKEN)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var reply admin.MigrationsReply
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &reply))
+	assert.Equal(t, len(migrations), reply.Version)
+}
+
+// TestOpenAPI tests that the OpenAPI document is served and documents exactly the registered routes and error codes
+func TestOpenAPI(t *testing.T) {
+	_, router := newTestService(t, newGormStore(), ignoreNotifications)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/openapi.json", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	doc, err := openapi.Load(w.Body.Bytes())
+	if assert.NoError(t, err) {
+		assert.NoError(t, openapi.CheckRoutes(doc, router.Routes()))
+
+		// Every code of the error catalogue is documented
+		var documented []apierror.Code
+		for _, code := range doc.Components.Schemas["ErrorCode"].Value.Enum {
+			documented = append(documented, apierror.Code(code.(string)))
+		}
+		assert.ElementsMatch(t, apierror.Codes(), documented)
+	}
+}
+
+// TestMigrations tests that every migration can be reverted and applied again
+func TestMigrations(t *testing.T) {
+	wipeDatabase()
+
+	reverted, err := database.MigrateDown(db, migrations, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, len(migrations), reverted)
+	assert.False(t, db.Migrator().HasTable(&User{}))
+	assert.False(t, db.Migrator().HasTable(&IdempotencyRecord{}))
+
+	assert.NoError(t, migrateModels(db))
+	assert.True(t, db.Migrator().HasTable(&User{}))
+	assert.True(t, db.Migrator().HasTable(&IdempotencyRecord{}))
+
+	// The migrated schema matches the models
+	assert.NoError(t, db.Create(&User{Name: "migrated", Longitude: 1.0, Latitude: 2.0}).Error)
+	assert.NoError(t, db.Create(&IdempotencyRecord{IdempotencyKey: "key", Username: "migrated"}).Error)
+
+	// A database migrated by a newer version of the service is refused
+	assert.NoError(t, db.Create(&database.SchemaVersion{Version: len(migrations) + 1}).Error)
+	assert.ErrorIs(t, migrateModels(db), database.ErrSchemaTooNew)
+	assert.NoError(t, db.Delete(&database.SchemaVersion{Version: len(migrations) + 1}).Error)
+}
+
+// TestHealth tests the liveness and readiness endpoints
+// Readiness depends on the location history service, which is replaced by a local gRPC health server
+func TestHealth(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	healthServer := health.NewServer()
+	healthpb.RegisterHealthServer(server, healthServer)
+	go server.Serve(lis)
+	defer server.Stop()
+
+	// Notify the local gRPC server
+	svc := New(cfg, newGormStore(), NewGRPCNotifier(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials())))
+	defer svc.Close()
+	handler := svc.Handler()
+
+	t.Run("Alive", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/healthz", nil)
+		handler.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"Status":"ok"}`, w.Body.String())
+	})
+
+	t.Run("Ready", func(t *testing.T) {
+		healthServer.SetServingStatus(pb.LocationHistoryService_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/readyz", nil)
+		handler.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"Status":"ok","Checks":{"database":"ok","location_history":"ok"}}`, w.Body.String())
+	})
+
+	t.Run("Location History Not Serving", func(t *testing.T) {
+		healthServer.SetServingStatus(pb.LocationHistoryService_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_NOT_SERVING)
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/readyz", nil)
+		handler.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		assert.Contains(t, w.Body.String(), `"Status":"unavailable"`)
+		assert.Contains(t, w.Body.String(), `"database":"ok"`)
+		assert.Contains(t, w.Body.String(), "NOT_SERVING")
+	})
+}
+
+// TestMetrics tests that the metrics endpoint exposes the request and domain metrics
+func TestMetrics(t *testing.T) {
+	// The users are counted when the service starts, then only every ROW_COUNT_INTERVAL
+	store := newGormStore()
+	db.Create(&User{Name: "counted", Longitude: 10.0, Latitude: 10.0})
+	s, router := newTestService(t, store, ignoreNotifications)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/nearby?longitude=10.0&latitude=10.0&radius=1&page=1", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// A panicking handler, outside of the OpenAPI document, is answered by the logging middleware and still counted
+	engine := gin.New()
+	s.registerRoutes(engine)
+	engine.GET("/panicking", func(c *gin.Context) { panic("test panic") })
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/panicking", nil)
+	engine.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/metrics", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `http_requests_total{code="200",method="GET",route="/nearby"}`)
+	assert.Contains(t, w.Body.String(), `http_requests_total{code="500",method="GET",route="/panicking"}`)
+	assert.Contains(t, w.Body.String(), fmt.Sprintf(`users_count{address="%s:%s"} 1`, cfg.RestHost, cfg.RestPort))
+	assert.Contains(t, w.Body.String(), `users_nearby_page_size_bucket{le="1"}`)
+	assert.Contains(t, w.Body.String(), `db_query_duration_seconds_count{operation="query",table="users"}`)
+}